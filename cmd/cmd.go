@@ -2,16 +2,20 @@
 package cmd
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql" // load mysql driver
 
 	"github.com/sepetrov/prepaidcard/pkg/api"
+	"github.com/sepetrov/prepaidcard/pkg/service/fxrate"
+	"github.com/sepetrov/prepaidcard/pkg/service/outbox"
 	"github.com/sepetrov/prepaidcard/pkg/service/repository"
 )
 
@@ -19,7 +23,7 @@ var (
 	port = flag.String("port", os.Getenv("API_PORT"), "The port number")
 	dsn  = flag.String(
 		"dsn", fmt.Sprintf(
-			"%s:%s@tcp(%s:%s)/%s",
+			"%s:%s@tcp(%s:%s)/%s?parseTime=true",
 			os.Getenv("DB_USER"),
 			os.Getenv("DB_PASSWORD"),
 			os.Getenv("DB_HOST"),
@@ -28,47 +32,157 @@ var (
 		),
 		"The database DSN",
 	)
+	fxRates     = flag.String("fx-rates", os.Getenv("FX_RATES"), "The JSON file with exchange rates, e.g. {\"EUR/GBP\": \"0.8523\"}")
+	fxMarkup    = flag.String("fx-markup", "0", "The markup added to the exchange rates, e.g. 0.0275 for 2.75%")
+	jwtSecret   = flag.String("jwt-secret", os.Getenv("JWT_SECRET"), "The secret for JWTs signed with HMAC-SHA256; without it only API keys are accepted")
+	timeout     = flag.Duration("timeout", 30*time.Second, "The timeout of the requests; 0 means no timeout")
+	corsOrigin  = flag.String("cors-origin", os.Getenv("CORS_ALLOWED_ORIGIN"), "The origin allowed to send cross-origin requests, e.g. http://localhost:8081")
+	outboxPoll  = flag.Duration("outbox-interval", outbox.DefaultInterval, "The interval between the polls of the event outbox")
+	webhookPoll = flag.Duration("webhook-interval", time.Second, "The interval between the polls of the pending webhook deliveries")
+	expiryPoll  = flag.Duration("expiry-interval", time.Minute, "The interval between the releases of the expired authorization requests")
+	holdPeriods = flag.String("hold-periods", os.Getenv("HOLD_PERIODS"), "The hold periods of the authorization requests by merchant category code, e.g. 7011=720h; the default is 168h")
+	limits      = flag.String("limits", os.Getenv("CARD_LIMITS"), "The JSON file with the default limits of the card products, e.g. {\"standard\": {\"daily\": \"50000\"}}")
+	sourced     = flag.Bool("event-sourced", false, "Fold the cards and the authorization requests from their events instead of reading their tables")
+	eventSecret = flag.String("event-secret", os.Getenv("EVENT_SECRET"), "The secret for the HMAC-SHA256 hashes of the events and the signatures of their snapshots")
 )
 
 // setCorsHeaders adds CORS headers to response writer w.
+// Cross-origin requests are allowed only from the origin set with the flag -cors-origin.
 func setCorsHeaders(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	if *corsOrigin == "" {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS, POST")
-	w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: be more strict
+	w.Header().Set("Access-Control-Allow-Origin", *corsOrigin)
+	w.Header().Set("Vary", "Origin")
 }
 
 // Main is the entry point for the application.
+//
+// Without arguments it starts the API server. The subcommand "verify-ledger"
+// verifies the ledger and exits with non-zero status if it has discrepancies.
+// The subcommand "replay" rebuilds the card table from the events of the cards
+// and exits with non-zero status if a card cannot be rebuilt.
+// The subcommand "api-key" creates, lists and revokes API keys:
+//
+//	api-key create <bank|user|merchant> [card or merchant UUID]
+//	api-key list
+//	api-key revoke <API key UUID>
 func Main() {
 	flag.Parse()
 	logger := log.New(os.Stderr, "", log.LstdFlags)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		logger.Printf("%s %s", r.Method, r.URL)
-		setCorsHeaders(w)
-		w.WriteHeader(http.StatusNotFound)
-	})
-
 	db, err := sql.Open("mysql", *dsn)
 	if err != nil {
 		logger.Fatal(err)
 	}
 	defer db.Close()
 
-	api, err := api.New(
+	if *eventSecret == "" {
+		logger.Fatal("the event secret is required")
+	}
+	repoOptions := []repository.Option{repository.EventKeyOption([]byte(*eventSecret))}
+	if *sourced {
+		repoOptions = append(repoOptions, repository.EventSourcedOption())
+	}
+	repo := repository.New(db, repoOptions...)
+	authenticate := api.AuthenticationMiddleware(repo, []byte(*jwtSecret))
+	options := []api.Option{
 		api.LoggerOption(logger),
 		api.MiddlewareOption(func(h api.Handler) api.Handler {
+			h = authenticate(h)
 			return api.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
 				logger.Printf("%s %s", r.Method, r.URL)
 				setCorsHeaders(w)
 				return h.Handle(w, r)
 			})
 		}),
-		api.RepositoryOption(repository.New(db)),
-	)
+		api.RepositoryOption(repo),
+		api.TimeoutOption(*timeout),
+		api.HoldPeriodsOption(*holdPeriods),
+	}
+	if *limits != "" {
+		options = append(options, api.ProductLimitsOption(*limits))
+	}
+	if *fxRates != "" {
+		rates, err := fxrate.Load(*fxRates)
+		if err != nil {
+			logger.Fatalf("cannot load exchange rates: %v", err)
+		}
+		options = append(options, api.FXRateProviderOption(rates, *fxMarkup))
+	}
+	api, err := api.New(options...)
 	if err != nil {
 		logger.Fatalf("cannot create an API instance: %v", err)
 	}
-	api.Attach(http.DefaultServeMux)
+
+	switch flag.Arg(0) {
+	case "":
+		relay := outbox.NewRelay(repo, outbox.MultiPublisher(outbox.LogPublisher(logger), api.WebhookPublisher()), logger)
+		relay.Interval = *outboxPoll
+		go relay.Run(context.Background())
+		go api.SendWebhooks(context.Background(), *webhookPoll)
+		go api.ExpireAuthorizationRequests(context.Background(), *expiryPoll)
+		serve(logger, api)
+	case "verify-ledger":
+		if err := api.VerifyLedger(os.Stdout); err != nil {
+			logger.Fatalf("cannot verify the ledger: %v", err)
+		}
+	case "replay":
+		if err := api.ReplayCards(os.Stdout); err != nil {
+			logger.Fatalf("cannot replay the cards: %v", err)
+		}
+	case "api-key":
+		apiKey(logger, api, flag.Args()[1:])
+	default:
+		logger.Fatalf("unknown subcommand %q", flag.Arg(0))
+	}
+}
+
+// serve attaches the handlers of a to the default HTTP mux and starts the server.
+func serve(logger *log.Logger, a *api.API) {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		logger.Printf("%s %s", r.Method, r.URL)
+		setCorsHeaders(w)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	a.Attach(http.DefaultServeMux)
 	logger.Printf("Listenging on port %s", *port)
 	logger.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", *port), nil))
 }
+
+// apiKey runs the subcommand "api-key" of a with args.
+func apiKey(logger *log.Logger, a *api.API, args []string) {
+	if len(args) == 0 {
+		logger.Fatal("missing api-key subcommand, want create, list or revoke")
+	}
+	switch args[0] {
+	case "create":
+		if len(args) < 2 || len(args) > 3 {
+			logger.Fatal("usage: api-key create <bank|user|merchant> [card or merchant UUID]")
+		}
+		subject := ""
+		if len(args) == 3 {
+			subject = args[2]
+		}
+		id, key, err := a.CreateAPIKey(args[1], subject)
+		if err != nil {
+			logger.Fatalf("cannot create API key: %v", err)
+		}
+		fmt.Printf("UUID: %s\nKey:  %s\n", id, key)
+	case "list":
+		if err := a.ListAPIKeys(os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			logger.Fatal("usage: api-key revoke <API key UUID>")
+		}
+		if err := a.RevokeAPIKey(args[1]); err != nil {
+			logger.Fatalf("cannot revoke API key: %v", err)
+		}
+	default:
+		logger.Fatalf("unknown api-key subcommand %q", args[0])
+	}
+}
@@ -1,5 +1,213 @@
 CREATE TABLE card (
     uuid CHAR(128) NOT NULL PRIMARY KEY,
+    currency CHAR(3) NOT NULL DEFAULT 'GBP',
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
     available_balance BIGINT UNSIGNED NOT NULL,
-    blocked_balance BIGINT UNSIGNED NOT NULL
-)
\ No newline at end of file
+    blocked_balance BIGINT UNSIGNED NOT NULL,
+    version BIGINT UNSIGNED NOT NULL DEFAULT 1
+);
+
+CREATE TABLE card_limits (
+    card_uuid CHAR(128) NOT NULL PRIMARY KEY,
+    product VARCHAR(64) NOT NULL,
+    per_transaction BIGINT UNSIGNED NOT NULL,
+    daily BIGINT UNSIGNED NOT NULL,
+    monthly BIGINT UNSIGNED NOT NULL,
+    authorizations BIGINT UNSIGNED NOT NULL,
+    authorization_window BIGINT NOT NULL COMMENT 'nanoseconds',
+    daily_load BIGINT UNSIGNED NOT NULL,
+    FOREIGN KEY (card_uuid) REFERENCES card (uuid)
+);
+
+CREATE TABLE card_merchant_category (
+    card_uuid CHAR(128) NOT NULL,
+    category CHAR(4) NOT NULL,
+    allowed BOOLEAN NOT NULL COMMENT 'allowed or blocked',
+    PRIMARY KEY (card_uuid, category),
+    FOREIGN KEY (card_uuid) REFERENCES card (uuid)
+);
+
+CREATE TABLE merchant (
+    uuid CHAR(128) NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    category CHAR(4) NOT NULL,
+    country CHAR(2) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'active',
+    settlement_account VARCHAR(64) NOT NULL
+);
+
+CREATE TABLE card_transaction (
+    uuid CHAR(128) NOT NULL PRIMARY KEY,
+    card_uuid CHAR(128) NOT NULL,
+    event_uuid CHAR(128) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    authorization_request_uuid CHAR(128) NULL,
+    date DATETIME(6) NOT NULL,
+    amount BIGINT UNSIGNED NOT NULL,
+    available_balance BIGINT UNSIGNED NOT NULL,
+    blocked_balance BIGINT UNSIGNED NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    INDEX card_transaction_card_uuid_date (card_uuid, date),
+    FOREIGN KEY (card_uuid) REFERENCES card (uuid)
+)
+;
+
+CREATE TABLE authorization_request (
+    uuid CHAR(128) NOT NULL PRIMARY KEY,
+    card_uuid CHAR(128) NOT NULL,
+    merchant_uuid CHAR(128) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    card_currency CHAR(3) NOT NULL,
+    rate VARCHAR(32) NOT NULL,
+    original_amount BIGINT UNSIGNED NOT NULL,
+    converted_amount BIGINT UNSIGNED NOT NULL,
+    blocked_amount BIGINT UNSIGNED NOT NULL,
+    captured_amount BIGINT UNSIGNED NOT NULL,
+    refunded_amount BIGINT UNSIGNED NOT NULL,
+    original_blocked_amount BIGINT UNSIGNED NOT NULL,
+    original_captured_amount BIGINT UNSIGNED NOT NULL,
+    original_refunded_amount BIGINT UNSIGNED NOT NULL,
+    expires_at DATETIME(6) NOT NULL,
+    INDEX authorization_request_card_uuid (card_uuid),
+    INDEX authorization_request_merchant_uuid (merchant_uuid),
+    INDEX authorization_request_expires_at (expires_at),
+    FOREIGN KEY (card_uuid) REFERENCES card (uuid)
+);
+
+CREATE TABLE authorization_request_snapshot (
+    uuid CHAR(128) NOT NULL PRIMARY KEY,
+    authorization_request_uuid CHAR(128) NOT NULL,
+    position INT UNSIGNED NOT NULL,
+    currency CHAR(3) NOT NULL,
+    card_currency CHAR(3) NOT NULL,
+    rate VARCHAR(32) NOT NULL,
+    blocked_amount BIGINT UNSIGNED NOT NULL,
+    captured_amount BIGINT UNSIGNED NOT NULL,
+    refunded_amount BIGINT UNSIGNED NOT NULL,
+    original_blocked_amount BIGINT UNSIGNED NOT NULL,
+    original_captured_amount BIGINT UNSIGNED NOT NULL,
+    original_refunded_amount BIGINT UNSIGNED NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    UNIQUE INDEX authorization_request_snapshot_position (authorization_request_uuid, position),
+    FOREIGN KEY (authorization_request_uuid) REFERENCES authorization_request (uuid)
+);
+
+CREATE TABLE ledger_posting (
+    transaction_uuid CHAR(128) NOT NULL,
+    position INT UNSIGNED NOT NULL,
+    account_type VARCHAR(32) NOT NULL,
+    account_owner_uuid CHAR(128) NOT NULL,
+    currency CHAR(3) NOT NULL,
+    direction ENUM('debit', 'credit') NOT NULL,
+    amount BIGINT UNSIGNED NOT NULL,
+    PRIMARY KEY (transaction_uuid, position),
+    INDEX ledger_posting_account (account_type, account_owner_uuid, currency),
+    FOREIGN KEY (transaction_uuid) REFERENCES card_transaction (uuid)
+);
+
+CREATE TABLE idempotent_request (
+    caller CHAR(64) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    completed BOOLEAN NOT NULL,
+    status_code SMALLINT UNSIGNED NOT NULL,
+    header TEXT NOT NULL,
+    body MEDIUMBLOB NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (caller, idempotency_key)
+);
+
+CREATE TABLE api_key (
+    uuid CHAR(128) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    subject CHAR(128) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (uuid),
+    UNIQUE KEY api_key_hash (hash)
+);
+
+CREATE TABLE outbox_message (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    uuid CHAR(128) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    schema_version SMALLINT UNSIGNED NOT NULL,
+    occurred_at DATETIME(6) NOT NULL,
+    payload TEXT NOT NULL,
+    status ENUM('pending', 'sent', 'dead') NOT NULL,
+    attempts INT UNSIGNED NOT NULL,
+    next_attempt_at DATETIME(6) NOT NULL,
+    last_error TEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    sent_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY outbox_message_uuid (uuid),
+    INDEX outbox_message_pending (status, next_attempt_at)
+);
+
+CREATE TABLE webhook (
+    uuid CHAR(128) NOT NULL,
+    merchant_uuid CHAR(128) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    event_types VARCHAR(255) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (uuid),
+    INDEX webhook_merchant (merchant_uuid)
+);
+
+CREATE TABLE webhook_delivery (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    uuid CHAR(128) NOT NULL,
+    webhook_uuid CHAR(128) NOT NULL,
+    event_uuid CHAR(128) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload TEXT NOT NULL,
+    status ENUM('pending', 'succeeded', 'failed') NOT NULL,
+    attempts INT UNSIGNED NOT NULL,
+    response_status SMALLINT UNSIGNED NOT NULL,
+    last_error TEXT NOT NULL,
+    next_attempt_at DATETIME(6) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    delivered_at DATETIME(6) NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY webhook_delivery_uuid (uuid),
+    UNIQUE KEY webhook_delivery_event (webhook_uuid, event_uuid),
+    INDEX webhook_delivery_pending (status, next_attempt_at)
+);
+
+CREATE TABLE webhook_delivery_attempt (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    delivery_uuid CHAR(128) NOT NULL,
+    number INT UNSIGNED NOT NULL,
+    status ENUM('succeeded', 'failed') NOT NULL,
+    response_status SMALLINT UNSIGNED NOT NULL,
+    error TEXT NOT NULL,
+    attempted_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY webhook_delivery_attempt_number (delivery_uuid, number)
+);
+
+CREATE TABLE aggregate_event (
+    aggregate_uuid CHAR(128) NOT NULL,
+    sequence BIGINT UNSIGNED NOT NULL,
+    aggregate_type VARCHAR(32) NOT NULL,
+    event_uuid CHAR(128) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    schema_version SMALLINT UNSIGNED NOT NULL,
+    occurred_at DATETIME(6) NOT NULL,
+    payload TEXT NOT NULL,
+    hash CHAR(64) NOT NULL,
+    PRIMARY KEY (aggregate_uuid, sequence),
+    INDEX aggregate_event_type (aggregate_type, sequence)
+);
+
+CREATE TABLE aggregate_snapshot (
+    aggregate_uuid CHAR(128) NOT NULL,
+    sequence BIGINT UNSIGNED NOT NULL,
+    hash CHAR(64) NOT NULL,
+    state MEDIUMTEXT NOT NULL,
+    signature CHAR(64) NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    PRIMARY KEY (aggregate_uuid, sequence)
+);
@@ -2,28 +2,61 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
 	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
 	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
 	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/capture"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardcategories"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardlimits"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardstatus"
 	"github.com/sepetrov/prepaidcard/pkg/internal/service/createcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/expire"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/getcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/increment"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listdeliveries"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/loadcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/merchant"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/refund"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/registerwebhook"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/replay"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/reverse"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/verifyledger"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+	"github.com/sepetrov/prepaidcard/pkg/service/outbox"
 )
 
 const basePath = "/api"
 
+// defaultIdempotencyTTL is the minimum time, after which the key of a request in progress is taken over by its retry.
+const defaultIdempotencyTTL = 5 * time.Minute
+
 // API is the prepaid card application.
 type API struct {
-	dispatcher createcard.Dispatcher
+	fxRates    model.FXRateProvider
+	holds      model.HoldPeriods
+	limits     model.ProductLimits
 	logger     *log.Logger
 	middleware Middleware
 	repository Repository
+	timeout    time.Duration
 	version    string
 }
 
@@ -51,7 +84,15 @@ var noopMiddleware Middleware = func(h Handler) Handler {
 
 // Repository is an interface that satisfies the individual services' (handlers') repositories.
 type Repository interface {
-	createcard.Saver
+	getcard.Getter
+	service.UnitOfWork
+	listtransactions.Lister
+	verifyledger.Reader
+	middleware.IdempotencyStore
+	auth.KeyStore
+	webhook.Store
+	GetAuthorizationRequest(context.Context, uuid.UUID) (*model.AuthorizationRequest, error)
+	ListExpiredAuthorizationRequests(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
 }
 
 // Option configures an API instance.
@@ -81,6 +122,66 @@ func MiddlewareOption(middleware Middleware) Option {
 	}
 }
 
+// FXRateProviderOption returns new option for setting the provider of the exchange rates for
+// authorization requests in currencies different from the currencies of the cards.
+// The rates of provider are increased with markup, e.g. "0.0275" for 2.75%.
+// Without this option only authorization requests in the currencies of the cards are authorized.
+func FXRateProviderOption(provider model.FXRateProvider, markup string) Option {
+	return func(api *API) (*API, error) {
+		p, err := model.WithMarkup(provider, markup)
+		if err != nil {
+			return api, fmt.Errorf("invalid FX markup: %v", err)
+		}
+		api.fxRates = p
+		return api, nil
+	}
+}
+
+// HoldPeriodsOption returns new option for setting the hold periods of the authorization requests by
+// merchant category code, e.g. "7011=720h,7512=720h". Without this option or for the other merchant
+// categories the blocked amounts expire after model.DefaultHoldPeriod.
+func HoldPeriodsOption(periods string) Option {
+	return func(api *API) (*API, error) {
+		p, err := model.ParseHoldPeriods(periods)
+		if err != nil {
+			return api, fmt.Errorf("invalid hold periods: %v", err)
+		}
+		api.holds = p
+		return api, nil
+	}
+}
+
+// ProductLimitsOption returns new option for setting the default limits of the card products from the JSON file
+// with path, e.g. {"standard": {"daily": "50000", "authorizations": "10", "authorizationWindow": "1h"}}.
+// Without this option or for the other products only the own limits of the cards are enforced.
+func ProductLimitsOption(path string) Option {
+	return func(api *API) (*API, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return api, fmt.Errorf("cannot read product limits: %v", err)
+		}
+		p, err := cardlimits.ParseProducts(b)
+		if err != nil {
+			return api, fmt.Errorf("invalid product limits: %v", err)
+		}
+		api.limits = p
+		return api, nil
+	}
+}
+
+// TimeoutOption returns new option for setting the timeout of the requests.
+// The context of a request is cancelled after timeout, which cancels its database queries,
+// and the request fails with 503 error response. Without this option the requests have no timeout.
+func TimeoutOption(timeout time.Duration) Option {
+	return func(api *API) (*API, error) {
+		if timeout < 0 {
+			return api, fmt.Errorf("invalid timeout %v", timeout)
+		}
+		api.timeout = timeout
+		return api, nil
+	}
+}
+
 // RepositoryOption returns new option for setting a repository.
 func RepositoryOption(repository Repository) Option {
 	return func(api *API) (*API, error) {
@@ -92,7 +193,6 @@ func RepositoryOption(repository Repository) Option {
 // New returns new API configured with options.
 func New(options ...Option) (*API, error) {
 	api := &API{
-		dispatcher: &dispatcher{},
 		middleware: noopMiddleware,
 		version:    Version,
 	}
@@ -114,20 +214,72 @@ func New(options ...Option) (*API, error) {
 }
 
 // withMiddleware wraps handler h with middleware.
-func (api *API) withMiddleware(h Handler) Handler {
-	return api.middleware(
-		middleware.ErrorLog(api.logger)(
-			middleware.Error()(
-				h,
+// If policy is not nil, only the requests of the principals authorized by policy are handled.
+func (api *API) withMiddleware(h Handler, policy middleware.Policy) Handler {
+	h = middleware.Idempotency(api.repository, idempotencyCaller, api.idempotencyTTL())(h)
+	if policy != nil {
+		h = middleware.Authorize(policy)(h)
+	}
+	return middleware.Timeout(api.timeout)(
+		api.middleware(
+			middleware.ErrorLog(api.logger)(
+				middleware.Error()(
+					h,
+				),
 			),
 		),
 	)
 }
 
+// idempotencyTTL returns the time, after which the key of a request in progress is taken over by its retry.
+// It is twice the timeout of the requests, but at least defaultIdempotencyTTL, so only the keys of the requests,
+// which were abandoned, e.g. because the server crashed, are taken over.
+func (api *API) idempotencyTTL() time.Duration {
+	if 2*api.timeout > defaultIdempotencyTTL {
+		return 2 * api.timeout
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotencyCaller returns the hash of the role and the subject of the principal of r,
+// which scopes the idempotency keys to the caller, so the keys outlive the re-issued credentials.
+func idempotencyCaller(r *http.Request) string {
+	var caller string
+	if p, ok := auth.PrincipalFromContext(r.Context()); ok {
+		caller = fmt.Sprintf("%s:%s", p.Role, p.Subject)
+	}
+	sum := sha256.Sum256([]byte(caller))
+	return hex.EncodeToString(sum[:])
+}
+
 // Attach attaches the API handlers to mux.
 func (api *API) Attach(mux *http.ServeMux) {
 	mux.Handle(fmt.Sprintf("%s/card", basePath), handlerAdapter(api.CreateCardHandler()))
 	mux.Handle(fmt.Sprintf("%s/version", basePath), handlerAdapter(api.VersionHandler()))
+
+	rt := &router{api: api}
+	rt.handle(http.MethodGet, fmt.Sprintf("%s/card/{uuid}", basePath), api.GetCardHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/load", basePath), api.LoadCardHandler())
+	rt.handle(http.MethodGet, fmt.Sprintf("%s/card/{uuid}/transactions", basePath), api.ListTransactionsHandler())
+	rt.handle(http.MethodGet, fmt.Sprintf("%s/card/{uuid}/limits", basePath), api.GetCardLimitsHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/limits", basePath), api.SetCardLimitsHandler())
+	rt.handle(http.MethodGet, fmt.Sprintf("%s/card/{uuid}/merchant-categories", basePath), api.GetCardCategoriesHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/merchant-categories", basePath), api.SetCardCategoriesHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/freeze", basePath), api.FreezeCardHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/unfreeze", basePath), api.UnfreezeCardHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/block", basePath), api.BlockCardHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/card/{uuid}/close", basePath), api.CloseCardHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/authorization-request", basePath), api.AuthorizeHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/authorization-request/{uuid}/increment", basePath), api.IncrementHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/authorization-request/{uuid}/reverse", basePath), api.ReverseHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/authorization-request/{uuid}/capture", basePath), api.CaptureHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/authorization-request/{uuid}/refund", basePath), api.RefundHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/merchants", basePath), api.CreateMerchantHandler())
+	rt.handle(http.MethodGet, fmt.Sprintf("%s/merchants/{uuid}", basePath), api.GetMerchantHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/merchants/{uuid}", basePath), api.UpdateMerchantHandler())
+	rt.handle(http.MethodPost, fmt.Sprintf("%s/webhooks", basePath), api.RegisterWebhookHandler())
+	rt.handle(http.MethodGet, fmt.Sprintf("%s/webhooks/{uuid}/deliveries", basePath), api.ListDeliveriesHandler())
+	mux.Handle(fmt.Sprintf("%s/", basePath), rt)
 }
 
 // VersionHandler returns the handler for API version.
@@ -141,31 +293,231 @@ func (api *API) VersionHandler() Handler {
 		})
 		return nil
 	})
-	return api.withMiddleware(h)
+	return api.withMiddleware(h, nil)
 }
 
 // CreateCardHandler returns the handler for registration of new cards.
 func (api *API) CreateCardHandler() Handler {
-	h := handler.NewCreateCard(createcard.New(api.repository.(createcard.Saver), api.dispatcher))
-	return api.withMiddleware(h)
+	h := handler.NewCreateCard(createcard.New(api.repository, api.limits))
+	return api.withMiddleware(h, bank)
 }
 
-func handlerAdapter(h Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h.Handle(w, r)
-	})
+// GetCardHandler returns the handler for card details.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) GetCardHandler() Handler {
+	h := handler.NewGetCard(getcard.New(api.repository.(getcard.Getter)))
+	return api.withMiddleware(h, bankOrCardholder)
 }
 
-// no-operational implementations
+// LoadCardHandler returns the handler for loading money onto cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) LoadCardHandler() Handler {
+	h := handler.NewLoadCard(loadcard.New(api.repository, api.limits))
+	return api.withMiddleware(h, bankOrCardholder)
+}
 
-type saver struct{}
+// ListTransactionsHandler returns the handler for card transactions.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) ListTransactionsHandler() Handler {
+	h := handler.NewListTransactions(listtransactions.New(
+		api.repository.(listtransactions.Getter),
+		api.repository.(listtransactions.Lister),
+	))
+	return api.withMiddleware(h, bankOrCardholder)
+}
 
-var _ createcard.Saver = &saver{}
+// GetCardLimitsHandler returns the handler for the product and the limits of cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) GetCardLimitsHandler() Handler {
+	h := handler.NewGetCardLimits(cardlimits.New(api.repository, api.limits))
+	return api.withMiddleware(h, bankOrCardholder)
+}
 
-func (s *saver) SaveCard(_ *model.Card) error { return nil }
+// SetCardLimitsHandler returns the handler for setting the product and the limits of cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) SetCardLimitsHandler() Handler {
+	h := handler.NewSetCardLimits(cardlimits.New(api.repository, api.limits))
+	return api.withMiddleware(h, bank)
+}
+
+// GetCardCategoriesHandler returns the handler for the merchant category restrictions of cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) GetCardCategoriesHandler() Handler {
+	h := handler.NewGetCardCategories(cardcategories.New(api.repository))
+	return api.withMiddleware(h, bankOrCardholder)
+}
 
-type dispatcher struct{}
+// SetCardCategoriesHandler returns the handler for setting the merchant category restrictions of cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) SetCardCategoriesHandler() Handler {
+	h := handler.NewSetCardCategories(cardcategories.New(api.repository))
+	return api.withMiddleware(h, bank)
+}
 
-var _ createcard.Dispatcher = &dispatcher{}
+// FreezeCardHandler returns the handler for freezing cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) FreezeCardHandler() Handler {
+	return api.withMiddleware(handler.NewFreezeCard(api.cardStatusService()), bankOrCardholder)
+}
 
-func (d *dispatcher) DispatchCardCreated(_ event.CardCreated) {}
+// UnfreezeCardHandler returns the handler for unfreezing cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) UnfreezeCardHandler() Handler {
+	return api.withMiddleware(handler.NewUnfreezeCard(api.cardStatusService()), bankOrCardholder)
+}
+
+// BlockCardHandler returns the handler for blocking cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) BlockCardHandler() Handler {
+	return api.withMiddleware(handler.NewBlockCard(api.cardStatusService()), bank)
+}
+
+// CloseCardHandler returns the handler for closing cards.
+// The card UUID is read from the path parameter "uuid".
+func (api *API) CloseCardHandler() Handler {
+	return api.withMiddleware(handler.NewCloseCard(api.cardStatusService()), bankOrCardholder)
+}
+
+func (api *API) cardStatusService() *cardstatus.Service {
+	return cardstatus.New(api.repository)
+}
+
+// AuthorizeHandler returns the handler for merchant authorization requests.
+func (api *API) AuthorizeHandler() Handler {
+	h := handler.NewAuthorize(authorize.New(api.repository, api.fxRates, api.holds, api.limits))
+	return api.withMiddleware(h, requestingMerchant)
+}
+
+// IncrementHandler returns the handler for incrementing authorization requests.
+// The authorization request UUID is read from the path parameter "uuid".
+func (api *API) IncrementHandler() Handler {
+	h := handler.NewIncrement(increment.New(api.repository, api.limits))
+	return api.withMiddleware(h, api.authorizationRequestMerchant)
+}
+
+// ReverseHandler returns the handler for reversing authorization requests.
+// The authorization request UUID is read from the path parameter "uuid".
+func (api *API) ReverseHandler() Handler {
+	h := handler.NewReverse(reverse.New(api.repository))
+	return api.withMiddleware(h, api.authorizationRequestMerchant)
+}
+
+// CaptureHandler returns the handler for capturing transactions of authorization requests.
+// The authorization request UUID is read from the path parameter "uuid".
+func (api *API) CaptureHandler() Handler {
+	h := handler.NewCapture(capture.New(api.repository))
+	return api.withMiddleware(h, api.authorizationRequestMerchant)
+}
+
+// RefundHandler returns the handler for refunding captured transactions.
+// The authorization request UUID is read from the path parameter "uuid".
+func (api *API) RefundHandler() Handler {
+	h := handler.NewRefund(refund.New(api.repository))
+	return api.withMiddleware(h, api.authorizationRequestMerchant)
+}
+
+// CreateMerchantHandler returns the handler for registration of merchants.
+func (api *API) CreateMerchantHandler() Handler {
+	h := handler.NewCreateMerchant(merchant.New(api.repository))
+	return api.withMiddleware(h, bank)
+}
+
+// GetMerchantHandler returns the handler for merchant details.
+// The merchant UUID is read from the path parameter "uuid".
+func (api *API) GetMerchantHandler() Handler {
+	h := handler.NewGetMerchant(merchant.New(api.repository))
+	return api.withMiddleware(h, bank)
+}
+
+// UpdateMerchantHandler returns the handler for updates of merchants.
+// The merchant UUID is read from the path parameter "uuid".
+func (api *API) UpdateMerchantHandler() Handler {
+	h := handler.NewUpdateMerchant(merchant.New(api.repository))
+	return api.withMiddleware(h, bank)
+}
+
+// RegisterWebhookHandler returns the handler for registration of merchant webhooks.
+func (api *API) RegisterWebhookHandler() Handler {
+	h := handler.NewRegisterWebhook(registerwebhook.New(api.repository))
+	return api.withMiddleware(h, requestingMerchant)
+}
+
+// ListDeliveriesHandler returns the handler for the deliveries of webhooks.
+// The webhook UUID is read from the path parameter "uuid".
+func (api *API) ListDeliveriesHandler() Handler {
+	h := handler.NewListDeliveries(listdeliveries.New(api.repository, api.repository))
+	return api.withMiddleware(h, api.webhookMerchant)
+}
+
+// WebhookPublisher returns publisher of the outbox, which schedules the deliveries of the events
+// to the webhooks of their merchants.
+func (api *API) WebhookPublisher() outbox.Publisher {
+	return webhook.NewDispatcher(api.repository)
+}
+
+// SendWebhooks sends the scheduled deliveries to the webhooks every interval until ctx is done.
+// The failed deliveries are retried with exponential backoff.
+func (api *API) SendWebhooks(ctx context.Context, interval time.Duration) {
+	s := webhook.NewSender(api.repository, nil, api.logger)
+	s.Interval = interval
+	s.Run(ctx)
+}
+
+// ExpireAuthorizationRequests releases the blocked amounts of the expired authorization requests
+// every interval until ctx is done.
+func (api *API) ExpireAuthorizationRequests(ctx context.Context, interval time.Duration) {
+	s := expire.NewSweeper(api.repository, api.logger)
+	s.Interval = interval
+	s.Run(ctx)
+}
+
+// VerifyLedger verifies that the sum of all ledger postings is zero and that the
+// balances of each card match its ledger accounts. It writes the report to w and
+// returns error if the ledger cannot be verified or it has discrepancies.
+func (api *API) VerifyLedger(w io.Writer) error {
+	res, err := verifyledger.New(api.repository.(verifyledger.Reader)).VerifyLedger(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Verified %d ledger accounts and %d cards.\n", res.Accounts, res.Cards)
+	for _, d := range res.Discrepancies {
+		fmt.Fprintln(w, d)
+	}
+	if !res.OK() {
+		return fmt.Errorf("the ledger has %d discrepancies", len(res.Discrepancies))
+	}
+	return nil
+}
+
+// ReplayCards rebuilds the card projection from the events of the cards. It writes the report to w
+// and returns error if the repository has no events or a card cannot be rebuilt.
+func (api *API) ReplayCards(w io.Writer) error {
+	store, ok := api.repository.(replay.Store)
+	if !ok {
+		return errors.New("the repository does not store the events of the cards")
+	}
+	res, err := replay.New(store).Replay(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Replayed %d cards, replaced %d cards, migrated %d cards.\n", res.Cards, len(res.Changes), len(res.Migrated))
+	for _, c := range res.Changes {
+		fmt.Fprintln(w, c)
+	}
+	for _, m := range res.Migrated {
+		fmt.Fprintln(w, m)
+	}
+	for _, f := range res.Failures {
+		fmt.Fprintln(w, f)
+	}
+	if !res.OK() {
+		return fmt.Errorf("%d cards cannot be rebuilt", len(res.Failures))
+	}
+	return nil
+}
+
+func handlerAdapter(h Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.Handle(w, r)
+	})
+}
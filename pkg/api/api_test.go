@@ -3,14 +3,28 @@
 package api_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
 
 	"github.com/sepetrov/prepaidcard/pkg/api"
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
 	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+	"github.com/sepetrov/prepaidcard/pkg/service/fxrate"
 )
 
 func TestVersionHandler(t *testing.T) {
@@ -70,3 +84,414 @@ func TestMiddlewareOption(t *testing.T) {
 		t.Error("middeware not used")
 	}
 }
+
+func TestFXRateProviderOption(t *testing.T) {
+	rates, err := fxrate.New(map[string]string{"EUR/GBP": "0.8"})
+	if err != nil {
+		t.Fatalf("cannot create rates: %v", err)
+	}
+	t.Run("converts authorization requests with markup", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		if err != nil {
+			t.Fatalf("cannot create card: %v", err)
+		}
+		if err := c.LoadMoney(2000); err != nil {
+			t.Fatalf("cannot load card: %v", err)
+		}
+		m, err := model.NewMerchant("Test Merchant", "5411", "GB", "GB29NWBK60161331926819")
+		if err != nil {
+			t.Fatalf("cannot create merchant: %v", err)
+		}
+		repo := &assert.Repository{Card: c, Merchant: m}
+		a, err := api.New(
+			api.FXRateProviderOption(rates, "0.25"),
+			api.RepositoryOption(repo),
+		)
+		if err != nil {
+			t.Fatalf("cannot create API: %v", err)
+		}
+		merchant := m.UUID()
+		body := fmt.Sprintf(`{"merchantUUID":"%s","cardUUID":"%s","amount":"1000","currency":"EUR"}`, merchant, c.UUID())
+		r := httptest.NewRequest("POST", "http://example.com/api/authorization-request", strings.NewReader(body))
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleMerchant, Subject: merchant}))
+		if err := a.AuthorizeHandler().Handle(httptest.NewRecorder(), r); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if repo.Card.BlockedBalance() != 1000 {
+			t.Errorf("got blocked balance %d, want 1000", repo.Card.BlockedBalance())
+		}
+	})
+	t.Run("returns error if the markup is invalid", func(t *testing.T) {
+		if _, err := api.New(api.FXRateProviderOption(rates, "foo"), api.RepositoryOption(&assert.Repository{})); err == nil {
+			t.Error("got nil error, want error")
+		}
+	})
+}
+
+func TestTimeoutOption(t *testing.T) {
+	t.Run("cancels the requests after the timeout", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		a, err := api.New(
+			api.RepositoryOption(&slowRepository{&assert.Repository{Card: c}}),
+			api.TimeoutOption(time.Millisecond),
+		)
+		assert.MustNotErr(t, err, "cannot create API: %v")
+		r := request("GET", "/api/card/"+c.UUID().String(), "")
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleBank}))
+		w := httptest.NewRecorder()
+		a.GetCardHandler().Handle(w, handler.WithParams(r, map[string]string{"uuid": c.UUID().String()}))
+		assert.MustE(t, w.Code, 503, "got status code %d, want %d")
+	})
+	t.Run("writes one error response if the middleware renders errors", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		a, err := api.New(
+			api.RepositoryOption(&slowRepository{&assert.Repository{Card: c}}),
+			api.TimeoutOption(time.Millisecond),
+			api.MiddlewareOption(func(h api.Handler) api.Handler {
+				return middleware.Error()(h)
+			}),
+		)
+		assert.MustNotErr(t, err, "cannot create API: %v")
+		r := request("GET", "/api/card/"+c.UUID().String(), "")
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleBank}))
+		w := httptest.NewRecorder()
+		a.GetCardHandler().Handle(w, handler.WithParams(r, map[string]string{"uuid": c.UUID().String()}))
+		assert.MustE(t, w.Code, 503, "got status code %d, want %d")
+		mustProblem(t, w.Body.Bytes(), 503)
+	})
+	t.Run("returns error if the timeout is negative", func(t *testing.T) {
+		_, err := api.New(api.TimeoutOption(-time.Second), api.RepositoryOption(&assert.Repository{}))
+		assert.MustErr(t, err, "got nil error, want error")
+	})
+}
+
+func TestRepositoryErrors(t *testing.T) {
+	t.Run("writes one error response if the repository is unavailable", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		repo := &assert.Repository{Card: c, Err: service.NewError(service.ErrUnavailable, errors.New("test repository unavailable"))}
+		a, err := api.New(
+			api.RepositoryOption(repo),
+			api.MiddlewareOption(func(h api.Handler) api.Handler {
+				return middleware.Error()(h)
+			}),
+		)
+		assert.MustNotErr(t, err, "cannot create API: %v")
+		r := request("GET", "/api/card/"+c.UUID().String(), "")
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Role: auth.RoleBank}))
+		w := httptest.NewRecorder()
+		a.GetCardHandler().Handle(w, handler.WithParams(r, map[string]string{"uuid": c.UUID().String()}))
+		assert.MustE(t, w.Code, 503, "got status code %d, want %d")
+		assert.MustE(t, w.Header().Get("Retry-After"), "5", "got Retry-After %q, want %q")
+		mustProblem(t, w.Body.Bytes(), 503)
+	})
+}
+
+// slowRepository is the repository, which waits for the cancellation of the context of GetCard.
+type slowRepository struct {
+	*assert.Repository
+}
+
+func (r *slowRepository) GetCard(ctx context.Context, _ uuid.UUID) (*model.Card, error) {
+	<-ctx.Done()
+	return &model.Card{}, ctx.Err()
+}
+
+func TestAttach(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	assert.MustNotErr(t, err, "%v")
+	repo := &assert.Repository{Card: c}
+	a, err := api.New(
+		api.MiddlewareOption(api.AuthenticationMiddleware(repo, nil)),
+		api.RepositoryOption(repo),
+	)
+	if err != nil {
+		t.Fatalf("cannot create API: %v", err)
+	}
+	_, key, err := a.CreateAPIKey("bank", "")
+	assert.MustNotErr(t, err, "a.CreateAPIKey() %v; want nil")
+	mux := http.NewServeMux()
+	a.Attach(mux)
+
+	t.Run("GET /api/card/{uuid} returns the card", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request("GET", "/api/card/"+c.UUID().String(), key))
+		assert.MustE(t, w.Code, 200, "")
+		res := struct {
+			UUID string `json:"uuid"`
+		}{}
+		assert.MustNotErr(t, json.Unmarshal(w.Body.Bytes(), &res), "got JSON-decoding error; %v")
+		assert.MustE(t, res.UUID, c.UUID().String(), "")
+	})
+	t.Run("GET /api/card/{uuid} returns 404 if the card does not exist", func(t *testing.T) {
+		p := "/api/card/" + uuid.Must(uuid.NewV4()).String()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request("GET", p, key))
+		assert.MustE(t, w.Code, 404, "")
+		assert.MustE(t, w.Header().Get("Content-Type"), "application/problem+json", "")
+		res := struct {
+			Status   int    `json:"status"`
+			Instance string `json:"instance"`
+		}{}
+		assert.MustNotErr(t, json.Unmarshal(w.Body.Bytes(), &res), "got JSON-decoding error; %v")
+		assert.MustE(t, res.Status, 404, "")
+		assert.MustE(t, res.Instance, p, "")
+	})
+	t.Run("POST /api/card/{uuid}/freeze freezes the card", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request("POST", "/api/card/"+c.UUID().String()+"/freeze", key))
+		assert.MustE(t, w.Code, 200, "")
+		res := struct {
+			Status string `json:"status"`
+		}{}
+		assert.MustNotErr(t, json.Unmarshal(w.Body.Bytes(), &res), "got JSON-decoding error; %v")
+		assert.MustE(t, res.Status, "frozen", "")
+	})
+	t.Run("POST /api/card replays the response for the same Idempotency-Key", func(t *testing.T) {
+		var uuids []string
+		for i := 0; i < 2; i++ {
+			r := request("POST", "/api/card", key)
+			r.Header.Set("Idempotency-Key", "test-key")
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assert.MustE(t, w.Code, 201, "")
+			res := struct {
+				UUID string `json:"uuid"`
+			}{}
+			assert.MustNotErr(t, json.Unmarshal(w.Body.Bytes(), &res), "got JSON-decoding error; %v")
+			uuids = append(uuids, res.UUID)
+		}
+		assert.MustE(t, uuids[1], uuids[0], "got card UUID %q of retry, want %q")
+	})
+	t.Run("writes the error response of failed requests once", func(t *testing.T) {
+		repo.CommitErr = errors.New("test commit failed")
+		defer func() { repo.CommitErr = nil }()
+		for _, idempotencyKey := range []string{"", "failing-key"} {
+			r := request("POST", "/api/card/"+repo.Card.UUID().String()+"/freeze", key)
+			r.Header.Set("Idempotency-Key", idempotencyKey)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assert.MustE(t, w.Code, 500, "got status code %d, want %d")
+			assert.MustE(t, w.Body.String(), "Internal Server Error\n", "got body %q, want %q")
+		}
+	})
+	t.Run("returns 404 for unknown paths", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/api/foo", nil))
+		assert.MustE(t, w.Code, 404, "")
+	})
+	t.Run("returns 405 for unsupported methods", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, request("DELETE", "/api/card/"+c.UUID().String(), key))
+		assert.MustE(t, w.Code, 405, "")
+	})
+}
+
+func TestIdempotency(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	assert.MustNotErr(t, err, "%v")
+	repo := &assert.Repository{Card: c}
+	a, err := api.New(
+		api.MiddlewareOption(api.AuthenticationMiddleware(repo, []byte("secret"))),
+		api.RepositoryOption(repo),
+	)
+	assert.MustNotErr(t, err, "cannot create API: %v")
+	mux := http.NewServeMux()
+	a.Attach(mux)
+
+	load := func(p auth.Principal, exp time.Time) *httptest.ResponseRecorder {
+		t.Helper()
+		jwt, err := auth.SignJWT(p, []byte("secret"), exp)
+		assert.MustNotErr(t, err, "auth.SignJWT() %v; want nil")
+		r := request("POST", "/api/card/"+c.UUID().String()+"/load", jwt)
+		r.Body = ioutil.NopCloser(strings.NewReader(`{"amount":"100","currency":"GBP"}`))
+		r.Header.Set("Idempotency-Key", "load-key")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("replays the response for the re-issued token of the principal", func(t *testing.T) {
+		holder := auth.Principal{Role: auth.RoleUser, Subject: c.UUID()}
+		first := load(holder, time.Now().Add(time.Hour))
+		second := load(holder, time.Now().Add(2*time.Hour))
+		assert.MustE(t, first.Code, 201, "got status code %d, want %d")
+		assert.MustE(t, second.Code, 201, "got status code %d of retry, want %d")
+		assert.MustE(t, second.Header().Get("Idempotent-Replayed"), "true", "got replayed header %q, want %q")
+		assert.MustE(t, repo.Card.AvailableBalance(), uint64(100), "got available balance %d, want %d")
+	})
+	t.Run("does not replay the response for other principals", func(t *testing.T) {
+		w := load(auth.Principal{Role: auth.RoleBank}, time.Now().Add(time.Hour))
+		assert.MustE(t, w.Code, 201, "got status code %d, want %d")
+		assert.MustE(t, w.Header().Get("Idempotent-Replayed"), "", "got replayed header %q, want %q")
+		assert.MustE(t, repo.Card.AvailableBalance(), uint64(200), "got available balance %d, want %d")
+	})
+}
+
+func TestAuthorization(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	assert.MustNotErr(t, err, "%v")
+	assert.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney(100) %v; want nil")
+	m, err := model.NewMerchant("Test Merchant", "5411", "GB", "GB29NWBK60161331926819")
+	assert.MustNotErr(t, err, "%v")
+	merchant := m.UUID()
+	req, err := model.NewAuthorizationRequest(c, merchant, model.NewMoney(50, model.GBP), nil, time.Now())
+	assert.MustNotErr(t, err, "%v")
+	repo := &assert.Repository{Card: c, AuthorizationRequest: req, Merchant: m}
+	a, err := api.New(
+		api.MiddlewareOption(api.AuthenticationMiddleware(repo, []byte("secret"))),
+		api.RepositoryOption(repo),
+	)
+	assert.MustNotErr(t, err, "cannot create API: %v")
+	mux := http.NewServeMux()
+	a.Attach(mux)
+
+	mustKey := func(role, subject string) string {
+		t.Helper()
+		_, key, err := a.CreateAPIKey(role, subject)
+		assert.MustNotErr(t, err, "a.CreateAPIKey() %v; want nil")
+		return key
+	}
+	bank := mustKey("bank", "")
+	holder := mustKey("user", c.UUID().String())
+	other := mustKey("user", uuid.Must(uuid.NewV4()).String())
+	owner := mustKey("merchant", merchant.String())
+	stranger := mustKey("merchant", uuid.Must(uuid.NewV4()).String())
+	jwt, err := auth.SignJWT(auth.Principal{Role: auth.RoleUser, Subject: c.UUID()}, []byte("secret"), time.Now().Add(time.Hour))
+	assert.MustNotErr(t, err, "auth.SignJWT() %v; want nil")
+
+	card := "/api/card/" + c.UUID().String()
+	capture := "/api/authorization-request/" + req.UUID().String() + "/capture"
+	authorize := fmt.Sprintf(`{"merchantUUID":"%s","cardUUID":"%s","amount":"1","currency":"GBP"}`, merchant, c.UUID())
+	register := fmt.Sprintf(`{"merchantUUID":"%s","url":"https://example.com/webhook","secret":"0123456789abcdef"}`, merchant)
+	hook := webhook.Webhook{UUID: uuid.Must(uuid.NewV4()), MerchantUUID: merchant, URL: "https://example.com/webhook"}
+	repo.Webhooks = append(repo.Webhooks, hook)
+	deliveries := "/api/webhooks/" + hook.UUID.String() + "/deliveries"
+	merchants := "/api/merchants/" + merchant.String()
+	create := `{"name":"Test Merchant","category":"5411","country":"GB","settlementAccount":"GB29NWBK60161331926819"}`
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		key    string
+		code   int
+	}{
+		{"requests without credentials are unauthorized", "GET", card, "", "", 401},
+		{"requests with invalid API key are unauthorized", "GET", card, "", "pck_foo", 401},
+		{"requests with invalid JWT are unauthorized", "GET", card, "", "foo.bar.baz", 401},
+		{"the version is public", "GET", "/api/version", "", "", 200},
+		{"bank can read cards", "GET", card, "", bank, 200},
+		{"cardholder can read own card", "GET", card, "", holder, 200},
+		{"cardholder can read own card with JWT", "GET", card, "", jwt, 200},
+		{"cardholder cannot read other cards", "GET", card, "", other, 403},
+		{"merchant cannot read cards", "GET", card, "", owner, 403},
+		{"cardholder cannot create cards", "POST", "/api/card", "", holder, 403},
+		{"cardholder cannot block cards", "POST", card + "/block", "", holder, 403},
+		{"merchant cannot authorize for other merchants", "POST", "/api/authorization-request", authorize, stranger, 403},
+		{"bank cannot authorize", "POST", "/api/authorization-request", authorize, bank, 403},
+		{"merchant cannot capture authorization requests of other merchants", "POST", capture, `{"amount":"1"}`, stranger, 403},
+		{"merchant can capture own authorization requests", "POST", capture, `{"amount":"1"}`, owner, 201},
+		{"merchant cannot authorize with malformed body", "POST", "/api/authorization-request", "{", owner, 403},
+		{"merchant cannot authorize without merchant UUID", "POST", "/api/authorization-request", `{"amount":"1"}`, owner, 403},
+		{"merchant cannot authorize with invalid merchant UUID", "POST", "/api/authorization-request", `{"merchantUUID":"foo"}`, owner, 403},
+		{"merchant cannot register webhooks with malformed body", "POST", "/api/webhooks", "[]", owner, 403},
+		{"merchant can authorize", "POST", "/api/authorization-request", authorize, owner, 201},
+		{"merchant cannot register webhooks for other merchants", "POST", "/api/webhooks", register, stranger, 403},
+		{"bank cannot register webhooks", "POST", "/api/webhooks", register, bank, 403},
+		{"merchant can register webhooks", "POST", "/api/webhooks", register, owner, 201},
+		{"merchant cannot list deliveries of other merchants", "GET", deliveries, "", stranger, 403},
+		{"merchant can list deliveries of own webhooks", "GET", deliveries, "", owner, 200},
+		{"merchant cannot register merchants", "POST", "/api/merchants", create, owner, 403},
+		{"merchant cannot read merchants", "GET", merchants, "", owner, 403},
+		{"bank can read merchants", "GET", merchants, "", bank, 200},
+		{"merchant cannot update merchants", "POST", merchants, create, owner, 403},
+		{"cardholder can read merchant categories of own card", "GET", card + "/merchant-categories", "", holder, 200},
+		{"cardholder cannot set merchant categories", "POST", card + "/merchant-categories", `{"blocked":["7995"]}`, holder, 403},
+		{"bank can set merchant categories", "POST", card + "/merchant-categories", `{"blocked":["7995"]}`, bank, 200},
+		{"bank can register merchants", "POST", "/api/merchants", create, bank, 201},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := request(tc.method, tc.path, tc.key)
+			r.Body = ioutil.NopCloser(strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assert.MustE(t, w.Code, tc.code, "got status code %d, want %d")
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	repo := &assert.Repository{}
+	a, err := api.New(api.RepositoryOption(repo))
+	assert.MustNotErr(t, err, "cannot create new API: %v")
+
+	t.Run("creates, lists and revokes API keys", func(t *testing.T) {
+		merchant := uuid.Must(uuid.NewV4()).String()
+		id, key, err := a.CreateAPIKey("merchant", merchant)
+		assert.MustNotErr(t, err, "a.CreateAPIKey() %v; want nil")
+		assert.MustE(t, len(repo.APIKeys), 1, "got %d API keys, want %d")
+		assert.MustE(t, repo.APIKeys[0].Hash, auth.HashAPIKey(key), "got hash %q, want %q")
+
+		w := &strings.Builder{}
+		assert.MustNotErr(t, a.ListAPIKeys(w), "a.ListAPIKeys() %v; want nil")
+		assert.Must(t, strings.HasPrefix(w.String(), id+"\tmerchant\t"+merchant+"\t"), "got list %q, want the API key", w.String())
+		assert.Must(t, !strings.Contains(w.String(), key), "got list %q, want no secret key", w.String())
+
+		assert.MustNotErr(t, a.RevokeAPIKey(id), "a.RevokeAPIKey() %v; want nil")
+		assert.MustE(t, len(repo.APIKeys), 0, "got %d API keys, want %d")
+		assert.MustErr(t, a.RevokeAPIKey(id), "a.RevokeAPIKey() nil; want error")
+	})
+	t.Run("returns error if the role or the subject is invalid", func(t *testing.T) {
+		for _, args := range [][2]string{{"admin", ""}, {"user", ""}, {"merchant", "foo"}} {
+			_, _, err := a.CreateAPIKey(args[0], args[1])
+			assert.MustErr(t, err, "a.CreateAPIKey() nil; want error")
+		}
+	})
+}
+
+func TestVerifyLedger(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	assert.MustNotErr(t, err, "%v")
+	assert.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney(100) %v; want nil")
+	tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "CardLoaded", 100, "", time.Now())
+	assert.MustNotErr(t, err, "NewTransaction() %v; want nil")
+	repo := &assert.Repository{}
+	assert.MustNotErr(t, repo.SaveCardTransaction(context.Background(), c, tx), "repo.SaveCardTransaction() %v; want nil")
+	a, err := api.New(api.RepositoryOption(repo))
+	assert.MustNotErr(t, err, "cannot create new API: %v")
+
+	t.Run("verifies the ledger", func(t *testing.T) {
+		w := &strings.Builder{}
+		assert.MustNotErr(t, a.VerifyLedger(w), "a.VerifyLedger() %v; want nil")
+		assert.MustE(t, w.String(), "Verified 2 ledger accounts and 1 cards.\n", "got report %q; want %q")
+	})
+	t.Run("returns error if the ledger has discrepancies", func(t *testing.T) {
+		assert.MustNotErr(t, c.LoadMoney(5), "c.LoadMoney(5) %v; want nil")
+		w := &strings.Builder{}
+		assert.MustErr(t, a.VerifyLedger(w), "a.VerifyLedger() nil; want error")
+		assert.Must(t, strings.Contains(w.String(), "ledger balance 100, card balance 105"), "got report %q; want discrepancy", w.String())
+	})
+}
+
+// request returns new request with method, path and API key or JWT key.
+// mustProblem decodes body as exactly one problem document with status code.
+func mustProblem(t *testing.T, body []byte, code int) {
+	t.Helper()
+	res := struct {
+		Status int `json:"status"`
+	}{}
+	assert.MustNotErr(t, json.Unmarshal(body, &res), "got JSON-decoding error %v, want one problem document")
+	assert.MustE(t, res.Status, code, "got problem status %d, want %d")
+}
+
+func request(method, path, key string) *http.Request {
+	r := httptest.NewRequest(method, "http://example.com"+path, nil)
+	if len(key) > 0 {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	return r
+}
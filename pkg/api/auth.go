@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// AuthenticationMiddleware returns middleware, which authenticates the requests with
+// "Authorization: Bearer" header with an API key in keys or a JWT signed with HMAC-SHA256
+// with jwtSecret. If jwtSecret is empty, only API keys are accepted. It is meant to be
+// set with MiddlewareOption. The handlers authorize the authenticated actors per route.
+func AuthenticationMiddleware(keys auth.KeyStore, jwtSecret []byte) Middleware {
+	m := middleware.Authenticate(auth.NewAuthenticator(keys, jwtSecret))
+	return func(h Handler) Handler {
+		return m(h)
+	}
+}
+
+// CreateAPIKey creates API key for role "bank", "user" or "merchant" and subject.
+// The subject is the card UUID for users and the merchant UUID for merchants.
+// It returns the UUID of the key and the key, which is stored hashed and cannot be retrieved later.
+func (api *API) CreateAPIKey(role, subject string) (string, string, error) {
+	r, err := auth.ParseRole(role)
+	if err != nil {
+		return "", "", err
+	}
+	var sub uuid.UUID
+	if r != auth.RoleBank || len(subject) > 0 {
+		if sub, err = uuid.FromString(subject); err != nil {
+			return "", "", fmt.Errorf("subject must be UUID: %v", err)
+		}
+	}
+	key, rec, err := auth.NewAPIKey(auth.Principal{Role: r, Subject: sub})
+	if err != nil {
+		return "", "", err
+	}
+	if err := api.repository.SaveAPIKey(context.Background(), rec); err != nil {
+		return "", "", fmt.Errorf("cannot save API key: %v", err)
+	}
+	return rec.UUID.String(), key, nil
+}
+
+// ListAPIKeys writes the UUIDs, roles, subjects and creation times of the API keys to w.
+func (api *API) ListAPIKeys(w io.Writer) error {
+	keys, err := api.repository.ListAPIKeys(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot list API keys: %v", err)
+	}
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", k.UUID, k.Principal.Role, k.Principal.Subject, k.CreatedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RevokeAPIKey deletes the API key with UUID id.
+func (api *API) RevokeAPIKey(id string) error {
+	keyUUID, err := uuid.FromString(id)
+	if err != nil {
+		return fmt.Errorf("API key must be UUID: %v", err)
+	}
+	err = api.repository.DeleteAPIKey(context.Background(), keyUUID)
+	if err == service.ErrNotFound {
+		return fmt.Errorf("API key %s not found", keyUUID)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot delete API key: %v", err)
+	}
+	return nil
+}
+
+// bank authorizes the bank personnel.
+func bank(p auth.Principal, _ *http.Request) (bool, error) {
+	return p.Role == auth.RoleBank, nil
+}
+
+// bankOrCardholder authorizes the bank personnel and the holder of the card in path parameter "uuid".
+func bankOrCardholder(p auth.Principal, r *http.Request) (bool, error) {
+	switch p.Role {
+	case auth.RoleBank:
+		return true, nil
+	case auth.RoleUser:
+		id, err := uuid.FromString(handler.Param(r, "uuid"))
+		return err == nil && id == p.Subject, nil
+	}
+	return false, nil
+}
+
+// requestingMerchant authorizes the merchant with UUID in field "merchantUUID" of the request body.
+// The requests, which body is not JSON or which merchant UUID is not valid, are not authorized.
+func requestingMerchant(p auth.Principal, r *http.Request) (bool, error) {
+	if p.Role != auth.RoleMerchant {
+		return false, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return false, fmt.Errorf("cannot read request body; %v", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req := struct {
+		MerchantUUID string `json:"merchantUUID"`
+	}{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false, nil
+	}
+	id, err := uuid.FromString(req.MerchantUUID)
+	return err == nil && id == p.Subject, nil
+}
+
+// authorizationRequestMerchant authorizes the merchant of the authorization request in path parameter "uuid".
+func (api *API) authorizationRequestMerchant(p auth.Principal, r *http.Request) (bool, error) {
+	if p.Role != auth.RoleMerchant {
+		return false, nil
+	}
+	id, err := uuid.FromString(handler.Param(r, "uuid"))
+	if err != nil {
+		// The handler responds with 404.
+		return true, nil
+	}
+	req, err := api.repository.GetAuthorizationRequest(r.Context(), id)
+	if err == service.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot get authorization request; %v", err)
+	}
+	return req.MerchantUUID() == p.Subject, nil
+}
+
+// webhookMerchant authorizes the merchant of the webhook in path parameter "uuid".
+func (api *API) webhookMerchant(p auth.Principal, r *http.Request) (bool, error) {
+	if p.Role != auth.RoleMerchant {
+		return false, nil
+	}
+	id, err := uuid.FromString(handler.Param(r, "uuid"))
+	if err != nil {
+		// The handler responds with 404.
+		return true, nil
+	}
+	w, err := api.repository.GetWebhook(r.Context(), id)
+	if err == service.ErrNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot get webhook; %v", err)
+	}
+	return w.MerchantUUID == p.Subject, nil
+}
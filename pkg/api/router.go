@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// router dispatches requests to handlers by request method and path.
+type router struct {
+	api    *API
+	routes []route
+}
+
+// route is a handler registered for request method and path pattern.
+type route struct {
+	method   string
+	segments []string
+	handler  Handler
+}
+
+// handle registers h for requests with method and path matching pattern.
+// The pattern segments in braces, e.g. "{uuid}", are path parameters, which
+// the handlers can read with handler.Param.
+func (rt *router) handle(method, pattern string, h Handler) {
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: strings.Split(strings.Trim(pattern, "/"), "/"),
+		handler:  h,
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var pathFound bool
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		pathFound = true
+		if route.method != r.Method {
+			continue
+		}
+		route.handler.Handle(w, handler.WithParams(r, params))
+		return
+	}
+	switch {
+	case pathFound && r.Method == http.MethodOptions:
+		rt.api.withMiddleware(handler.Func(func(w http.ResponseWriter, _ *http.Request) error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		}), nil).Handle(w, r)
+	case pathFound:
+		rt.api.withMiddleware(handler.Func(func(http.ResponseWriter, *http.Request) error {
+			return service.ErrorResponse{Status: http.StatusMethodNotAllowed}
+		}), nil).Handle(w, r)
+	default:
+		rt.api.withMiddleware(handler.Func(func(http.ResponseWriter, *http.Request) error {
+			return service.NewNotFoundErrorResponse()
+		}), nil).Handle(w, r)
+	}
+}
+
+// match returns the path parameters if the path segments match the route.
+func (route route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(route.segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, s := range route.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			params[strings.Trim(s, "{}")] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
@@ -0,0 +1,153 @@
+// Package auth authenticates the actors of the API with API keys and JWTs.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// ErrInvalidCredentials is returned when the credentials of a request are invalid.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// apiKeyPrefix is the prefix of the API keys, which distinguishes them from JWTs.
+const apiKeyPrefix = "pck_"
+
+// Role is the role of an actor.
+type Role string
+
+// The roles of the actors.
+const (
+	// RoleBank is the role of the authorised bank personnel.
+	RoleBank Role = "bank"
+	// RoleUser is the role of a cardholder. The subject is the card UUID.
+	RoleUser Role = "user"
+	// RoleMerchant is the role of a merchant. The subject is the merchant UUID.
+	RoleMerchant Role = "merchant"
+)
+
+// ParseRole returns the role with name s.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleBank, RoleUser, RoleMerchant:
+		return r, nil
+	}
+	return "", fmt.Errorf("role %q is not supported", s)
+}
+
+// Principal is the authenticated actor.
+type Principal struct {
+	Role    Role
+	Subject uuid.UUID
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx with principal p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of ctx.
+// It returns false if the request of ctx is not authenticated.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// APIKey is the record of an API key. Only the hash of the key is stored.
+type APIKey struct {
+	UUID      uuid.UUID
+	Hash      string
+	Principal Principal
+	CreatedAt time.Time
+}
+
+// KeyStore is an interface for persistence of API keys.
+type KeyStore interface {
+	// SaveAPIKey persists key.
+	SaveAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKey returns the API key with hash. It must return service.ErrNotFound if the key does not exist.
+	GetAPIKey(ctx context.Context, hash string) (APIKey, error)
+	// ListAPIKeys returns all API keys.
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// DeleteAPIKey deletes the API key with UUID id. It must return service.ErrNotFound if the key does not exist.
+	DeleteAPIKey(ctx context.Context, id uuid.UUID) error
+}
+
+// NewAPIKey returns new secret API key for principal p and its record, which can be stored.
+func NewAPIKey(p Principal) (string, APIKey, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", APIKey{}, fmt.Errorf("cannot generate API key; %v", err)
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", APIKey{}, fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, APIKey{UUID: id, Hash: HashAPIKey(key), Principal: p, CreatedAt: time.Now()}, nil
+}
+
+// HashAPIKey returns the hash of key, which is stored instead of the key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticator authenticates requests with "Authorization: Bearer" header,
+// which has an API key or a JWT signed with HMAC-SHA256.
+type Authenticator struct {
+	keys   KeyStore
+	secret []byte
+	now    func() time.Time
+}
+
+// NewAuthenticator returns new authenticator of API keys in keys and JWTs signed with secret.
+// If secret is empty, the JWTs are not accepted.
+func NewAuthenticator(keys KeyStore, secret []byte) *Authenticator {
+	return &Authenticator{keys, secret, time.Now}
+}
+
+// Authenticate returns the principal of r. It returns false if r has no credentials and
+// ErrInvalidCredentials if the credentials are invalid.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	h := r.Header.Get("Authorization")
+	if len(h) == 0 {
+		return Principal{}, false, nil
+	}
+	const scheme = "Bearer "
+	if len(h) <= len(scheme) || !strings.EqualFold(h[:len(scheme)], scheme) {
+		return Principal{}, false, ErrInvalidCredentials
+	}
+	token := strings.TrimSpace(h[len(scheme):])
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		key, err := a.keys.GetAPIKey(r.Context(), HashAPIKey(token))
+		if err == service.ErrNotFound {
+			return Principal{}, false, ErrInvalidCredentials
+		}
+		if err != nil {
+			return Principal{}, false, fmt.Errorf("Authenticate() cannot get API key; %v", err)
+		}
+		return key.Principal, true, nil
+	}
+	if len(a.secret) == 0 {
+		return Principal{}, false, ErrInvalidCredentials
+	}
+	p, err := ParseJWT(token, a.secret, a.now())
+	if err != nil {
+		return Principal{}, false, ErrInvalidCredentials
+	}
+	return p, true, nil
+}
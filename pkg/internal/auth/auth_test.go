@@ -0,0 +1,80 @@
+// +build !integration
+
+package auth_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	p := auth.Principal{Role: auth.RoleMerchant, Subject: uuid.Must(uuid.NewV4())}
+	key, rec, err := auth.NewAPIKey(p)
+	assert.MustNotErr(t, err, "auth.NewAPIKey() %v; want nil")
+	assert.Must(t, strings.HasPrefix(key, "pck_"), "got key %q, want prefix pck_", key)
+	assert.MustE(t, rec.Hash, auth.HashAPIKey(key), "got hash %q, want %q")
+	assert.Must(t, !strings.Contains(rec.Hash, key), "got hash %q with the key", rec.Hash)
+	assert.MustE(t, rec.Principal, p, "got principal %v, want %v")
+	assert.Must(t, rec.UUID != uuid.Nil, "got nil UUID, want UUID")
+
+	other, _, err := auth.NewAPIKey(p)
+	assert.MustNotErr(t, err, "auth.NewAPIKey() %v; want nil")
+	assert.Must(t, other != key, "got the same key %q twice", key)
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	secret := []byte("secret")
+	p := auth.Principal{Role: auth.RoleUser, Subject: uuid.Must(uuid.NewV4())}
+	key, rec, err := auth.NewAPIKey(p)
+	assert.MustNotErr(t, err, "auth.NewAPIKey() %v; want nil")
+	jwt, err := auth.SignJWT(p, secret, time.Now().Add(time.Hour))
+	assert.MustNotErr(t, err, "auth.SignJWT() %v; want nil")
+	keys := &assert.Repository{APIKeys: []auth.APIKey{rec}}
+
+	t.Run("authenticates API keys and JWTs", func(t *testing.T) {
+		for _, token := range []string{key, jwt} {
+			r := httptest.NewRequest("GET", "http://example.com", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			got, ok, err := auth.NewAuthenticator(keys, secret).Authenticate(r)
+			assert.MustNotErr(t, err, "Authenticate() %v; want nil")
+			assert.Must(t, ok, "got not authenticated, want authenticated")
+			assert.MustE(t, got, p, "got principal %v, want %v")
+		}
+	})
+	t.Run("returns false without credentials", func(t *testing.T) {
+		_, ok, err := auth.NewAuthenticator(keys, secret).Authenticate(httptest.NewRequest("GET", "http://example.com", nil))
+		assert.MustNotErr(t, err, "Authenticate() %v; want nil")
+		assert.Must(t, !ok, "got authenticated, want not authenticated")
+	})
+	t.Run("returns ErrInvalidCredentials if the credentials are invalid", func(t *testing.T) {
+		for _, tc := range []struct {
+			header string
+			secret []byte
+		}{
+			{"Basic " + key, secret},
+			{"Bearer pck_foo", secret},
+			{"Bearer " + jwt, []byte("other")},
+			{"Bearer " + jwt, nil},
+		} {
+			r := httptest.NewRequest("GET", "http://example.com", nil)
+			r.Header.Set("Authorization", tc.header)
+			_, _, err := auth.NewAuthenticator(keys, tc.secret).Authenticate(r)
+			assert.MustE(t, err, auth.ErrInvalidCredentials, "got error %v, want %v")
+		}
+	})
+	t.Run("returns error if the keys cannot be read", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "http://example.com", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		_, _, err := auth.NewAuthenticator(&assert.Repository{Err: errors.New("test store failed")}, secret).Authenticate(r)
+		assert.MustErr(t, err, "Authenticate() nil; want error")
+		assert.Must(t, err != auth.ErrInvalidCredentials, "got %v, want error of the store", err)
+	})
+}
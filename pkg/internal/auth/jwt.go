@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type claims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SignJWT returns JWT of principal p, which expires at expiresAt, signed with HMAC-SHA256 with secret.
+func SignJWT(p Principal, secret []byte, expiresAt time.Time) (string, error) {
+	c, err := json.Marshal(claims{p.Subject.String(), string(p.Role), expiresAt.Unix()})
+	if err != nil {
+		return "", fmt.Errorf("cannot encode claims; %v", err)
+	}
+	s := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(c)
+	return s + "." + sign(s, secret), nil
+}
+
+// ParseJWT returns the principal of JWT token signed with HMAC-SHA256 with secret.
+// It returns error if the signature is invalid or the token is expired at now.
+func ParseJWT(token string, secret []byte, now time.Time) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, errors.New("token must have 3 parts")
+	}
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Principal{}, fmt.Errorf("cannot decode header; %v", err)
+	}
+	header := struct {
+		Algorithm string `json:"alg"`
+	}{}
+	if err := json.Unmarshal(h, &header); err != nil {
+		return Principal{}, fmt.Errorf("cannot decode header; %v", err)
+	}
+	if header.Algorithm != "HS256" {
+		return Principal{}, fmt.Errorf("algorithm %q is not supported", header.Algorithm)
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(parts[0]+"."+parts[1], secret))) {
+		return Principal{}, errors.New("invalid signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, fmt.Errorf("cannot decode claims; %v", err)
+	}
+	c := claims{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Principal{}, fmt.Errorf("cannot decode claims; %v", err)
+	}
+	if c.ExpiresAt == 0 || !now.Before(time.Unix(c.ExpiresAt, 0)) {
+		return Principal{}, errors.New("token is expired")
+	}
+	role, err := ParseRole(c.Role)
+	if err != nil {
+		return Principal{}, err
+	}
+	sub, err := uuid.FromString(c.Subject)
+	if err != nil {
+		return Principal{}, fmt.Errorf("subject must be UUID; %v", err)
+	}
+	return Principal{role, sub}, nil
+}
+
+func sign(s string, secret []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
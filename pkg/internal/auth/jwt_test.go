@@ -0,0 +1,55 @@
+// +build !integration
+
+package auth_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	p := auth.Principal{Role: auth.RoleMerchant, Subject: uuid.Must(uuid.NewV4())}
+	token, err := auth.SignJWT(p, secret, now.Add(time.Minute))
+	assert.MustNotErr(t, err, "auth.SignJWT() %v; want nil")
+
+	t.Run("returns the principal of signed token", func(t *testing.T) {
+		got, err := auth.ParseJWT(token, secret, now)
+		assert.MustNotErr(t, err, "auth.ParseJWT() %v; want nil")
+		assert.MustE(t, got, p, "got principal %v, want %v")
+	})
+	t.Run("returns error if the token is expired", func(t *testing.T) {
+		_, err := auth.ParseJWT(token, secret, now.Add(time.Minute))
+		assert.MustErr(t, err, "auth.ParseJWT() nil; want error")
+	})
+	t.Run("returns error if the token is signed with other secret", func(t *testing.T) {
+		_, err := auth.ParseJWT(token, []byte("other"), now)
+		assert.MustErr(t, err, "auth.ParseJWT() nil; want error")
+	})
+	t.Run("returns error if the claims are tampered", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"` + p.Subject.String() + `","role":"bank","exp":9999999999}`))
+		_, err := auth.ParseJWT(strings.Join(parts, "."), secret, now)
+		assert.MustErr(t, err, "auth.ParseJWT() nil; want error")
+	})
+	t.Run("returns error if the algorithm is not HS256", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+		_, err := auth.ParseJWT(parts[0]+"."+parts[1]+".", secret, now)
+		assert.MustErr(t, err, "auth.ParseJWT() nil; want error")
+	})
+	t.Run("returns error if the token is malformed", func(t *testing.T) {
+		for _, s := range []string{"", "foo", "foo.bar", "foo.bar.baz"} {
+			_, err := auth.ParseJWT(s, secret, now)
+			assert.MustErr(t, err, "auth.ParseJWT() nil; want error")
+		}
+	})
+}
@@ -7,33 +7,95 @@ import (
 	"github.com/gofrs/uuid"
 )
 
+// The type names of the events.
+const (
+	TypeCardCreated                     = "CardCreated"
+	TypeCardLoaded                      = "CardLoaded"
+	TypeCardFrozen                      = "CardFrozen"
+	TypeCardUnfrozen                    = "CardUnfrozen"
+	TypeCardBlocked                     = "CardBlocked"
+	TypeCardClosed                      = "CardClosed"
+	TypeAuthorizationRequestCreated     = "AuthorizationRequestCreated"
+	TypeAuthorizationRequestIncremented = "AuthorizationRequestIncremented"
+	TypeAuthorizationRequestReversed    = "AuthorizationRequestReversed"
+	TypeAuthorizationRequestCaptured    = "AuthorizationRequestCaptured"
+	TypeAuthorizationRequestRefunded    = "AuthorizationRequestRefunded"
+	TypeAuthorizationRequestExpired     = "AuthorizationRequestExpired"
+)
+
 // CardCreated represents the registration of a new card to the system.
 type CardCreated struct {
-	UUID     uuid.UUID
-	Time     time.Time
-	CardUUID uuid.UUID
+	UUID     uuid.UUID `json:"uuid"`
+	Time     time.Time `json:"time"`
+	CardUUID uuid.UUID `json:"cardUUID"`
+	Currency string    `json:"currency"`
 }
 
 // CardLoaded represents the loading of a card by the user.
 type CardLoaded struct {
-	UUID     uuid.UUID
-	Time     time.Time
-	CardUUID uuid.UUID
-	Amount   uint64
+	UUID     uuid.UUID `json:"uuid"`
+	Time     time.Time `json:"time"`
+	CardUUID uuid.UUID `json:"cardUUID"`
+	Amount   uint64    `json:"amount,string"`
+}
+
+// CardFrozen represents the temporary suspension of a card by the user.
+type CardFrozen card
+
+// CardUnfrozen represents the activation of a frozen card by the user.
+type CardUnfrozen card
+
+// CardBlocked represents the permanent suspension of a card by the bank.
+type CardBlocked card
+
+// CardClosed represents the closing of a card and the payout of its available balance.
+type CardClosed struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Time     time.Time `json:"time"`
+	CardUUID uuid.UUID `json:"cardUUID"`
+	Amount   uint64    `json:"amount,string"`
+}
+
+type card struct {
+	UUID     uuid.UUID `json:"uuid"`
+	Time     time.Time `json:"time"`
+	CardUUID uuid.UUID `json:"cardUUID"`
 }
 
 // AuthorizationRequestCreated represents the submission of an authorization request from a merchant.
 type AuthorizationRequestCreated authorizationRequest
 
+// AuthorizationRequestIncremented represents the increase of the blocked amount of an authorization request
+// by its merchant.
+type AuthorizationRequestIncremented authorizationRequest
+
 // AuthorizationRequestReversed represents the reversal of an authorization request from a merchant.
 type AuthorizationRequestReversed authorizationRequest
 
 // AuthorizationRequestCaptured represents the capturing of a transaction by merchant.
 type AuthorizationRequestCaptured authorizationRequest
 
+// AuthorizationRequestRefunded represents the refunding of a captured transaction by merchant.
+type AuthorizationRequestRefunded authorizationRequest
+
+// AuthorizationRequestExpired represents the release of the blocked amount of an authorization request,
+// which is not captured or reversed before its expiry.
+type AuthorizationRequestExpired authorizationRequest
+
 type authorizationRequest struct {
-	UUID         uuid.UUID
-	Time         time.Time
-	CardUUID     uuid.UUID
-	MerchantUUID uuid.UUID
+	UUID                     uuid.UUID `json:"uuid"`
+	Time                     time.Time `json:"time"`
+	AuthorizationRequestUUID uuid.UUID `json:"authorizationRequestUUID"`
+	CardUUID                 uuid.UUID `json:"cardUUID"`
+	MerchantUUID             uuid.UUID `json:"merchantUUID"`
+	// Amount is the amount in the currency of the card.
+	Amount uint64 `json:"amount,string"`
+	// OriginalAmount is the amount in the currency of the authorization.
+	OriginalAmount uint64 `json:"originalAmount,string"`
+	Currency       string `json:"currency"`
+	CardCurrency   string `json:"cardCurrency"`
+	// Rate is the exchange rate from Currency to CardCurrency, which is locked when the request is authorised.
+	Rate string `json:"rate"`
+	// ExpiresAt is the expiry of the authorization request after the event.
+	ExpiresAt time.Time `json:"expiresAt"`
 }
@@ -0,0 +1,129 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// SchemaVersion is the version of the schema of the serialized events.
+// It must be incremented when the serialization of an event changes incompatibly.
+const SchemaVersion = 1
+
+// Message is a serialized event.
+type Message struct {
+	UUID          uuid.UUID
+	Type          string
+	SchemaVersion int
+	Time          time.Time
+	// Payload is the event encoded as JSON.
+	Payload []byte
+}
+
+// Marshal serializes event e, which must be one of the events of the package.
+func Marshal(e interface{}) (Message, error) {
+	var m Message
+	switch e := e.(type) {
+	case CardCreated:
+		m = Message{UUID: e.UUID, Type: TypeCardCreated, Time: e.Time}
+	case CardLoaded:
+		m = Message{UUID: e.UUID, Type: TypeCardLoaded, Time: e.Time}
+	case CardFrozen:
+		m = Message{UUID: e.UUID, Type: TypeCardFrozen, Time: e.Time}
+	case CardUnfrozen:
+		m = Message{UUID: e.UUID, Type: TypeCardUnfrozen, Time: e.Time}
+	case CardBlocked:
+		m = Message{UUID: e.UUID, Type: TypeCardBlocked, Time: e.Time}
+	case CardClosed:
+		m = Message{UUID: e.UUID, Type: TypeCardClosed, Time: e.Time}
+	case AuthorizationRequestCreated:
+		m = Message{UUID: e.UUID, Type: TypeAuthorizationRequestCreated, Time: e.Time}
+	case AuthorizationRequestIncremented:
+		m = Message{UUID: e.UUID, Type: TypeAuthorizationRequestIncremented, Time: e.Time}
+	case AuthorizationRequestReversed:
+		m = Message{UUID: e.UUID, Type: TypeAuthorizationRequestReversed, Time: e.Time}
+	case AuthorizationRequestCaptured:
+		m = Message{UUID: e.UUID, Type: TypeAuthorizationRequestCaptured, Time: e.Time}
+	case AuthorizationRequestRefunded:
+		m = Message{UUID: e.UUID, Type: TypeAuthorizationRequestRefunded, Time: e.Time}
+	case AuthorizationRequestExpired:
+		m = Message{UUID: e.UUID, Type: TypeAuthorizationRequestExpired, Time: e.Time}
+	default:
+		return Message{}, fmt.Errorf("cannot marshal %T; unknown event", e)
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return Message{}, fmt.Errorf("cannot marshal %s; %v", m.Type, err)
+	}
+	m.SchemaVersion = SchemaVersion
+	m.Payload = payload
+	return m, nil
+}
+
+// Unmarshal deserializes the event of m. It returns the event as value, e.g. CardCreated.
+func Unmarshal(m Message) (interface{}, error) {
+	if m.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("cannot unmarshal %s; unsupported schema version %d", m.Type, m.SchemaVersion)
+	}
+	var err error
+	switch m.Type {
+	case TypeCardCreated:
+		e := CardCreated{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeCardLoaded:
+		e := CardLoaded{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeCardFrozen:
+		e := CardFrozen{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeCardUnfrozen:
+		e := CardUnfrozen{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeCardBlocked:
+		e := CardBlocked{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeCardClosed:
+		e := CardClosed{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeAuthorizationRequestCreated:
+		e := AuthorizationRequestCreated{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeAuthorizationRequestIncremented:
+		e := AuthorizationRequestIncremented{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeAuthorizationRequestReversed:
+		e := AuthorizationRequestReversed{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeAuthorizationRequestCaptured:
+		e := AuthorizationRequestCaptured{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeAuthorizationRequestRefunded:
+		e := AuthorizationRequestRefunded{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	case TypeAuthorizationRequestExpired:
+		e := AuthorizationRequestExpired{}
+		err = json.Unmarshal(m.Payload, &e)
+		return e, unmarshalError(m, err)
+	}
+	return nil, fmt.Errorf("cannot unmarshal %s; unknown event type", m.Type)
+}
+
+func unmarshalError(m Message, err error) error {
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal %s; %v", m.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// +build !integration
+
+package event_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestMarshal(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+	now := time.Now().UTC()
+	card := uuid.Must(uuid.NewV4())
+	req := uuid.Must(uuid.NewV4())
+	merchant := uuid.Must(uuid.NewV4())
+	for _, tc := range []struct {
+		e   interface{}
+		typ string
+	}{
+		{event.CardCreated{UUID: id, Time: now, CardUUID: card, Currency: "GBP"}, event.TypeCardCreated},
+		{event.CardLoaded{UUID: id, Time: now, CardUUID: card, Amount: 100}, event.TypeCardLoaded},
+		{event.CardFrozen{UUID: id, Time: now, CardUUID: card}, event.TypeCardFrozen},
+		{event.CardUnfrozen{UUID: id, Time: now, CardUUID: card}, event.TypeCardUnfrozen},
+		{event.CardBlocked{UUID: id, Time: now, CardUUID: card}, event.TypeCardBlocked},
+		{event.CardClosed{UUID: id, Time: now, CardUUID: card, Amount: 100}, event.TypeCardClosed},
+		{event.AuthorizationRequestCreated{UUID: id, Time: now, AuthorizationRequestUUID: req, CardUUID: card, MerchantUUID: merchant, Amount: 10, OriginalAmount: 12, Currency: "EUR", CardCurrency: "GBP", Rate: "0.85"}, event.TypeAuthorizationRequestCreated},
+		{event.AuthorizationRequestIncremented{UUID: id, Time: now, AuthorizationRequestUUID: req, CardUUID: card, MerchantUUID: merchant, Amount: 10, OriginalAmount: 12, ExpiresAt: now}, event.TypeAuthorizationRequestIncremented},
+		{event.AuthorizationRequestReversed{UUID: id, Time: now, AuthorizationRequestUUID: req, CardUUID: card, MerchantUUID: merchant, Amount: 10}, event.TypeAuthorizationRequestReversed},
+		{event.AuthorizationRequestCaptured{UUID: id, Time: now, AuthorizationRequestUUID: req, CardUUID: card, MerchantUUID: merchant, Amount: 10}, event.TypeAuthorizationRequestCaptured},
+		{event.AuthorizationRequestRefunded{UUID: id, Time: now, AuthorizationRequestUUID: req, CardUUID: card, MerchantUUID: merchant, Amount: 10}, event.TypeAuthorizationRequestRefunded},
+		{event.AuthorizationRequestExpired{UUID: id, Time: now, AuthorizationRequestUUID: req, CardUUID: card, MerchantUUID: merchant, Amount: 10, ExpiresAt: now}, event.TypeAuthorizationRequestExpired},
+	} {
+		m, err := event.Marshal(tc.e)
+		h.MustNotErr(t, err, "got Marshal() error %v, want nil")
+		h.MustE(t, m.Type, tc.typ, "got type %q, want %q")
+		h.MustE(t, m.UUID, id, "got UUID %v, want %v")
+		h.MustE(t, m.SchemaVersion, event.SchemaVersion, "got schema version %v, want %v")
+		h.Must(t, m.Time.Equal(now), "got time %v, want %v", m.Time, now)
+		e, err := event.Unmarshal(m)
+		h.MustNotErr(t, err, "got Unmarshal() error %v, want nil")
+		h.Must(t, reflect.DeepEqual(e, tc.e), "got event %#v, want %#v", e, tc.e)
+	}
+}
+
+func TestMarshal_unknownEvent(t *testing.T) {
+	_, err := event.Marshal(struct{}{})
+	h.MustErr(t, err, "got Marshal() nil error for unknown event, want error")
+}
+
+func TestUnmarshal(t *testing.T) {
+	m, err := event.Marshal(event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), Amount: 100})
+	h.MustNotErr(t, err, "got Marshal() error %v, want nil")
+	h.Must(t, string(m.Payload) != "", "got empty payload, want JSON")
+
+	unknown := m
+	unknown.Type = "Unknown"
+	_, err = event.Unmarshal(unknown)
+	h.MustErr(t, err, "got Unmarshal() nil error for unknown type, want error")
+
+	version := m
+	version.SchemaVersion = event.SchemaVersion + 1
+	_, err = event.Unmarshal(version)
+	h.MustErr(t, err, "got Unmarshal() nil error for unsupported schema version, want error")
+
+	invalid := m
+	invalid.Payload = []byte(`{"amount":100}`)
+	_, err = event.Unmarshal(invalid)
+	h.MustErr(t, err, "got Unmarshal() nil error for invalid payload, want error")
+}
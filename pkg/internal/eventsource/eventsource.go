@@ -0,0 +1,348 @@
+// Package eventsource rebuilds the cards and the authorization requests by folding their events.
+//
+// The events are appended to the streams of the aggregates, which they change, e.g. AuthorizationRequestCaptured
+// is appended to the stream of the authorization request and to the stream of its card. The events of a stream have
+// consecutive sequence numbers starting with 1, so the sequence number of the last event of a card is its version.
+// Each event is chained to the previous event of its stream with a hash keyed with a secret key, so a changed, removed
+// or reordered event is detected when the stream is folded and the chain cannot be recomputed without the key.
+//
+// A snapshot of the aggregate is saved every SnapshotInterval events, so an aggregate is rebuilt from its latest
+// snapshot and the events after it. The snapshots are signed with the key and they are folded only if they match
+// their signatures and the hashes of their events.
+//
+// The stream of an aggregate, which was created before its events were recorded, starts with event TypeMigrated
+// with the state of the aggregate. The migrated stream of a card starts with the version of the card.
+package eventsource
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// SnapshotInterval is the number of events between the snapshots of an aggregate.
+const SnapshotInterval = 100
+
+// TypeMigrated is the type of the first event of the stream of an aggregate, which was created before its events
+// were recorded. The payload of the event is the state of the aggregate encoded as JSON.
+const TypeMigrated = "Migrated"
+
+// The types of the aggregates.
+const (
+	AggregateCard                 = "card"
+	AggregateAuthorizationRequest = "authorizationRequest"
+)
+
+// Record is an event in the stream of an aggregate.
+type Record struct {
+	AggregateType string
+	AggregateUUID uuid.UUID
+	Sequence      uint64
+	Message       event.Message
+	// Hash is the hash of the record chained to the hash of the previous record of the stream.
+	Hash string
+}
+
+// Snapshot is the state of an aggregate after the event with Sequence.
+type Snapshot struct {
+	AggregateUUID uuid.UUID
+	Sequence      uint64
+	// Hash is the hash of the event with Sequence.
+	Hash string
+	// State is the state of the aggregate encoded as JSON.
+	State []byte
+	// Signature is the signature of the snapshot returned by Sign.
+	Signature string
+}
+
+// Store is interface for persistence of the streams of the aggregates.
+type Store interface {
+	// AppendRecord appends r to the stream of its aggregate. It must return service.ErrConcurrentModification
+	// if the stream already has an event with the sequence number of r.
+	AppendRecord(ctx context.Context, r Record) error
+	// LastRecord returns the last event of the aggregate with UUID id or service.ErrNotFound if the stream is empty.
+	LastRecord(ctx context.Context, id uuid.UUID) (Record, error)
+	// ListRecords returns the events of the aggregate with UUID id after sequence number after in order.
+	ListRecords(ctx context.Context, id uuid.UUID, after uint64) ([]Record, error)
+	// SaveSnapshot persists s.
+	SaveSnapshot(ctx context.Context, s Snapshot) error
+	// LatestSnapshot returns the snapshot of the aggregate with UUID id with the greatest sequence number
+	// or service.ErrNotFound if the aggregate has no snapshots.
+	LatestSnapshot(ctx context.Context, id uuid.UUID) (Snapshot, error)
+}
+
+// Hash returns the hash of r chained to prev, the hash of the previous record of the stream.
+// The hash is hex-encoded HMAC-SHA256 with key of prev, the aggregate, the sequence number and the event of r.
+func Hash(key []byte, prev string, r Record) string {
+	h := hmac.New(sha256.New, key)
+	for _, s := range []string{
+		prev,
+		r.AggregateType,
+		r.AggregateUUID.String(),
+		strconv.FormatUint(r.Sequence, 10),
+		r.Message.UUID.String(),
+		r.Message.Type,
+		strconv.Itoa(r.Message.SchemaVersion),
+	} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	h.Write(r.Message.Payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign returns the signature of snapshot s, which is hex-encoded HMAC-SHA256 with key of the aggregate,
+// the sequence number, the hash and the state of s.
+func Sign(key []byte, s Snapshot) string {
+	h := hmac.New(sha256.New, key)
+	for _, v := range []string{s.AggregateUUID.String(), strconv.FormatUint(s.Sequence, 10), s.Hash} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	h.Write(s.State)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Append appends the event of m to the streams of the aggregates, which it changes, with hashes keyed with key
+// and saves the snapshots of the aggregates, which streams reach a multiple of SnapshotInterval events.
+// It returns the appended records or service.ErrConcurrentModification if an event is appended
+// to the streams concurrently.
+func Append(ctx context.Context, s Store, key []byte, m event.Message) ([]Record, error) {
+	e, err := event.Unmarshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("Append() %v", err)
+	}
+	var records []Record
+	for _, a := range aggregatesOf(e) {
+		last, err := s.LastRecord(ctx, a.id)
+		if err != nil && err != service.ErrNotFound {
+			return nil, service.Wrap(err, "Append() cannot get last event")
+		}
+		r := Record{AggregateType: a.typ, AggregateUUID: a.id, Sequence: last.Sequence + 1, Message: m}
+		r.Hash = Hash(key, last.Hash, r)
+		if err := s.AppendRecord(ctx, r); err != nil {
+			return nil, service.Wrap(err, "Append() cannot append event")
+		}
+		if r.Sequence%SnapshotInterval == 0 {
+			if err := saveSnapshot(ctx, s, key, a); err != nil {
+				return nil, fmt.Errorf("Append() %v", err)
+			}
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// MigrateCard appends event TypeMigrated with the state of card c at t to the empty stream of c with the version
+// of c as sequence number, so the following events of the card continue from its version.
+// It returns service.ErrConcurrentModification if the card is migrated concurrently.
+func MigrateCard(ctx context.Context, s Store, key []byte, c *model.Card, t time.Time) error {
+	if c.Version() == 0 {
+		return fmt.Errorf("MigrateCard() card %s is not saved", c.UUID())
+	}
+	if err := migrate(ctx, s, key, aggregate{AggregateCard, c.UUID()}, c.Version(), newCardState(c), t); err != nil {
+		return service.Wrap(err, "MigrateCard() cannot append event")
+	}
+	return nil
+}
+
+// MigrateAuthorizationRequest appends event TypeMigrated with the state of authorization request req at t
+// to the empty stream of req.
+// It returns service.ErrConcurrentModification if the authorization request is migrated concurrently.
+func MigrateAuthorizationRequest(ctx context.Context, s Store, key []byte, req *model.AuthorizationRequest, t time.Time) error {
+	a := aggregate{AggregateAuthorizationRequest, req.UUID()}
+	if err := migrate(ctx, s, key, a, 1, newAuthorizationRequestState(req), t); err != nil {
+		return service.Wrap(err, "MigrateAuthorizationRequest() cannot append event")
+	}
+	return nil
+}
+
+// migrate appends event TypeMigrated with state st of aggregate a at t with sequence number seq.
+func migrate(ctx context.Context, s Store, key []byte, a aggregate, seq uint64, st state, t time.Time) error {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("cannot encode state of %s; %v", a.id, err)
+	}
+	r := Record{
+		AggregateType: a.typ,
+		AggregateUUID: a.id,
+		Sequence:      seq,
+		Message:       event.Message{UUID: id, Type: TypeMigrated, SchemaVersion: event.SchemaVersion, Time: t, Payload: payload},
+	}
+	r.Hash = Hash(key, "", r)
+	return s.AppendRecord(ctx, r)
+}
+
+// LoadCard returns the card with UUID id folded from its events, which hashes are keyed with key.
+// It returns service.ErrNotFound if the card has no events.
+func LoadCard(ctx context.Context, s Store, key []byte, id uuid.UUID) (*model.Card, error) {
+	c := &cardState{}
+	if _, err := load(ctx, s, key, id, c); err != nil {
+		return nil, err
+	}
+	return model.CardFromData(cardData{c}), nil
+}
+
+// LoadAuthorizationRequest returns the authorization request with UUID id folded from its events, which hashes
+// are keyed with key. It returns service.ErrNotFound if the authorization request has no events.
+func LoadAuthorizationRequest(ctx context.Context, s Store, key []byte, id uuid.UUID) (*model.AuthorizationRequest, error) {
+	req := &authorizationRequestState{}
+	if _, err := load(ctx, s, key, id, req); err != nil {
+		return nil, err
+	}
+	data, err := req.data()
+	if err != nil {
+		return nil, fmt.Errorf("LoadAuthorizationRequest() %v", err)
+	}
+	return model.AuthorizationRequestFromData(data), nil
+}
+
+// state is the state of an aggregate, which is folded from its events.
+type state interface {
+	// apply changes the state with event e, which is the event of r.
+	apply(r Record, e interface{}) error
+}
+
+// load folds the latest snapshot and the following events of the aggregate with UUID id into st.
+// The snapshot is folded only if it matches its signature and the hash of its event.
+// It returns the last event of the aggregate or service.ErrNotFound if the aggregate has no events.
+func load(ctx context.Context, s Store, key []byte, id uuid.UUID, st state) (Record, error) {
+	last := Record{AggregateUUID: id}
+	snapshot, err := s.LatestSnapshot(ctx, id)
+	switch err {
+	case nil:
+		if !hmac.Equal([]byte(snapshot.Signature), []byte(Sign(key, snapshot))) {
+			return Record{}, fmt.Errorf("snapshot %d of %s does not match its signature", snapshot.Sequence, id)
+		}
+		if err := json.Unmarshal(snapshot.State, st); err != nil {
+			return Record{}, fmt.Errorf("cannot decode snapshot %d of %s; %v", snapshot.Sequence, id, err)
+		}
+	case service.ErrNotFound:
+		snapshot = Snapshot{}
+	default:
+		return Record{}, service.Wrap(err, "cannot get snapshot")
+	}
+	after := snapshot.Sequence
+	if after > 0 {
+		after--
+	}
+	records, err := s.ListRecords(ctx, id, after)
+	if err != nil {
+		return Record{}, service.Wrap(err, "cannot get events")
+	}
+	if snapshot.Sequence > 0 {
+		// The event of the snapshot is already folded, but it is the start of the chain of the following events.
+		if len(records) == 0 || records[0].Sequence != snapshot.Sequence || records[0].Hash != snapshot.Hash {
+			return Record{}, fmt.Errorf("snapshot %d of %s does not match its event", snapshot.Sequence, id)
+		}
+		last, records = records[0], records[1:]
+	}
+	if last.Sequence == 0 && len(records) == 0 {
+		return Record{}, service.ErrNotFound
+	}
+	for _, r := range records {
+		migrated := r.Message.Type == TypeMigrated
+		if migrated && last.Sequence == 0 && r.Sequence > 0 {
+			last.Sequence = r.Sequence - 1
+		}
+		if r.Sequence != last.Sequence+1 {
+			return Record{}, fmt.Errorf("event %d of %s is missing", last.Sequence+1, id)
+		}
+		if r.Hash != Hash(key, last.Hash, r) {
+			return Record{}, fmt.Errorf("event %d of %s does not match its hash", r.Sequence, id)
+		}
+		if migrated {
+			if last.Hash != "" {
+				return Record{}, fmt.Errorf("event %d of %s migrates the aggregate after its events", r.Sequence, id)
+			}
+			if err := json.Unmarshal(r.Message.Payload, st); err != nil {
+				return Record{}, fmt.Errorf("cannot decode event %d of %s; %v", r.Sequence, id, err)
+			}
+			last = r
+			continue
+		}
+		e, err := event.Unmarshal(r.Message)
+		if err != nil {
+			return Record{}, fmt.Errorf("event %d of %s: %v", r.Sequence, id, err)
+		}
+		if err := st.apply(r, e); err != nil {
+			return Record{}, fmt.Errorf("cannot apply event %d of %s; %v", r.Sequence, id, err)
+		}
+		last = r
+	}
+	return last, nil
+}
+
+// saveSnapshot saves the snapshot of aggregate a after its last event signed with key.
+func saveSnapshot(ctx context.Context, s Store, key []byte, a aggregate) error {
+	var st state = &cardState{}
+	if a.typ == AggregateAuthorizationRequest {
+		st = &authorizationRequestState{}
+	}
+	last, err := load(ctx, s, key, a.id, st)
+	if err != nil {
+		return fmt.Errorf("cannot fold %s %s; %v", a.typ, a.id, err)
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("cannot encode snapshot of %s; %v", a.id, err)
+	}
+	snapshot := Snapshot{AggregateUUID: a.id, Sequence: last.Sequence, Hash: last.Hash, State: b}
+	snapshot.Signature = Sign(key, snapshot)
+	if err := s.SaveSnapshot(ctx, snapshot); err != nil {
+		return service.Wrap(err, "cannot save snapshot")
+	}
+	return nil
+}
+
+// aggregate identifies the stream of an aggregate.
+type aggregate struct {
+	typ string
+	id  uuid.UUID
+}
+
+// aggregatesOf returns the aggregates changed by event e.
+func aggregatesOf(e interface{}) []aggregate {
+	switch e := e.(type) {
+	case event.CardCreated:
+		return []aggregate{{AggregateCard, e.CardUUID}}
+	case event.CardLoaded:
+		return []aggregate{{AggregateCard, e.CardUUID}}
+	case event.CardFrozen:
+		return []aggregate{{AggregateCard, e.CardUUID}}
+	case event.CardUnfrozen:
+		return []aggregate{{AggregateCard, e.CardUUID}}
+	case event.CardBlocked:
+		return []aggregate{{AggregateCard, e.CardUUID}}
+	case event.CardClosed:
+		return []aggregate{{AggregateCard, e.CardUUID}}
+	case event.AuthorizationRequestCreated:
+		return []aggregate{{AggregateAuthorizationRequest, e.AuthorizationRequestUUID}, {AggregateCard, e.CardUUID}}
+	case event.AuthorizationRequestIncremented:
+		return []aggregate{{AggregateAuthorizationRequest, e.AuthorizationRequestUUID}, {AggregateCard, e.CardUUID}}
+	case event.AuthorizationRequestReversed:
+		return []aggregate{{AggregateAuthorizationRequest, e.AuthorizationRequestUUID}, {AggregateCard, e.CardUUID}}
+	case event.AuthorizationRequestCaptured:
+		return []aggregate{{AggregateAuthorizationRequest, e.AuthorizationRequestUUID}, {AggregateCard, e.CardUUID}}
+	case event.AuthorizationRequestRefunded:
+		return []aggregate{{AggregateAuthorizationRequest, e.AuthorizationRequestUUID}, {AggregateCard, e.CardUUID}}
+	case event.AuthorizationRequestExpired:
+		return []aggregate{{AggregateAuthorizationRequest, e.AuthorizationRequestUUID}, {AggregateCard, e.CardUUID}}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+// +build !integration
+
+package eventsource_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/eventsource"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+var key = []byte("secret")
+
+func TestLoadCard(t *testing.T) {
+	card := uuid.Must(uuid.NewV4())
+	req := uuid.Must(uuid.NewV4())
+	newRepository := func() *h.Repository {
+		r := &h.Repository{}
+		mustAppend(t, r,
+			event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Currency: "GBP"},
+			event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Amount: 100},
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestCreated, req, card, 30, 35),
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestCaptured, req, card, 20, 23),
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestReversed, req, card, 10, 12),
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestRefunded, req, card, 5, 6),
+			event.CardFrozen{UUID: uuid.Must(uuid.NewV4()), CardUUID: card},
+		)
+		return r
+	}
+
+	t.Run("folds the events of the card", func(t *testing.T) {
+		r := newRepository()
+		c, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustNotErr(t, err, "got LoadCard() error %v, want nil")
+		h.MustE(t, c.UUID(), card, "got UUID %v, want %v")
+		h.MustE(t, c.Currency(), model.GBP, "got currency %v, want %v")
+		h.MustE(t, c.Status(), model.CardFrozen, "got status %v, want %v")
+		h.MustE(t, c.AvailableBalance(), uint64(85), "got available balance %v, want %v")
+		h.MustE(t, c.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+		h.MustE(t, c.Version(), uint64(7), "got version %v, want %v")
+	})
+	t.Run("folds the events of the authorization request", func(t *testing.T) {
+		r := newRepository()
+		a, err := eventsource.LoadAuthorizationRequest(context.Background(), r, key, req)
+		h.MustNotErr(t, err, "got LoadAuthorizationRequest() error %v, want nil")
+		h.MustE(t, a.CardUUID(), card, "got card UUID %v, want %v")
+		h.MustE(t, a.Rate().Rate(), "0.85", "got rate %v, want %v")
+		h.MustE(t, a.OriginalAmount(), uint64(35), "got original amount %v, want %v")
+		h.MustE(t, a.ConvertedAmount(), uint64(30), "got converted amount %v, want %v")
+		h.MustE(t, a.BlockedAmount(), uint64(0), "got blocked amount %v, want %v")
+		h.MustE(t, a.CapturedAmount(), uint64(20), "got captured amount %v, want %v")
+		h.MustE(t, a.RefundedAmount(), uint64(5), "got refunded amount %v, want %v")
+		h.MustE(t, a.OriginalCapturedAmount(), uint64(23), "got original captured amount %v, want %v")
+		h.MustE(t, a.OriginalRefundedAmount(), uint64(6), "got original refunded amount %v, want %v")
+		h.MustE(t, len(a.History()), 4, "got %d history entries, want %d")
+		h.MustE(t, a.History()[1].CapturedAmount(), uint64(20), "got captured amount %v of the capture, want %v")
+	})
+	t.Run("appends the events of authorization requests to the streams of both aggregates", func(t *testing.T) {
+		r := newRepository()
+		h.MustE(t, len(r.Records), 11, "got %d records, want %d")
+		last, err := r.LastRecord(context.Background(), req)
+		h.MustNotErr(t, err, "got LastRecord() error %v, want nil")
+		h.MustE(t, last.Sequence, uint64(4), "got last sequence %v, want %v")
+		h.MustE(t, last.AggregateType, eventsource.AggregateAuthorizationRequest, "got aggregate type %q, want %q")
+	})
+	t.Run("folds the expiry of the authorization request", func(t *testing.T) {
+		r := &h.Repository{}
+		expired := authorizationRequestEvent(t, event.TypeAuthorizationRequestExpired, req, card, 30, 35).(event.AuthorizationRequestExpired)
+		expired.ExpiresAt = time.Now().UTC().Truncate(time.Second)
+		mustAppend(t, r,
+			event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Currency: "GBP"},
+			event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Amount: 100},
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestCreated, req, card, 30, 35),
+			expired,
+		)
+		a, err := eventsource.LoadAuthorizationRequest(context.Background(), r, key, req)
+		h.MustNotErr(t, err, "got LoadAuthorizationRequest() error %v, want nil")
+		h.MustE(t, a.OriginalBlockedAmount(), uint64(0), "got original blocked amount %v, want %v")
+		h.Must(t, a.ExpiresAt().Equal(expired.ExpiresAt), "got expiry %v, want %v", a.ExpiresAt(), expired.ExpiresAt)
+		c, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustNotErr(t, err, "got LoadCard() error %v, want nil")
+		h.MustE(t, c.AvailableBalance(), uint64(100), "got available balance %v, want %v")
+		h.MustE(t, c.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+	})
+	t.Run("folds the increment of the authorization request", func(t *testing.T) {
+		r := &h.Repository{}
+		mustAppend(t, r,
+			event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Currency: "GBP"},
+			event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Amount: 100},
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestCreated, req, card, 30, 35),
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestIncremented, req, card, 17, 20),
+			authorizationRequestEvent(t, event.TypeAuthorizationRequestCaptured, req, card, 20, 23),
+		)
+		a, err := eventsource.LoadAuthorizationRequest(context.Background(), r, key, req)
+		h.MustNotErr(t, err, "got LoadAuthorizationRequest() error %v, want nil")
+		h.MustE(t, a.OriginalAmount(), uint64(55), "got original amount %v, want %v")
+		h.MustE(t, a.ConvertedAmount(), uint64(47), "got converted amount %v, want %v")
+		h.MustE(t, a.OriginalBlockedAmount(), uint64(32), "got original blocked amount %v, want %v")
+		h.MustE(t, a.BlockedAmount(), uint64(27), "got blocked amount %v, want %v")
+		h.MustE(t, len(a.History()), 3, "got %d history entries, want %d")
+		c, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustNotErr(t, err, "got LoadCard() error %v, want nil")
+		h.MustE(t, c.AvailableBalance(), uint64(53), "got available balance %v, want %v")
+		h.MustE(t, c.BlockedBalance(), uint64(27), "got blocked balance %v, want %v")
+	})
+	t.Run("returns ErrNotFound if the aggregate has no events", func(t *testing.T) {
+		_, err := eventsource.LoadCard(context.Background(), newRepository(), key, uuid.Must(uuid.NewV4()))
+		h.MustE(t, err, service.ErrNotFound, "got error %v, want %v")
+	})
+	t.Run("returns error if an event is changed", func(t *testing.T) {
+		r := newRepository()
+		r.Records[1].Message.Payload = []byte(`{"cardUUID":"` + card.String() + `","amount":"1000"}`)
+		_, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustErr(t, err, "got LoadCard() nil error for changed event, want error")
+	})
+	t.Run("returns error if an event is removed", func(t *testing.T) {
+		r := newRepository()
+		r.Records = append(r.Records[:1], r.Records[2:]...)
+		_, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustErr(t, err, "got LoadCard() nil error for removed event, want error")
+	})
+	t.Run("returns error if the first event does not create the card", func(t *testing.T) {
+		r := &h.Repository{}
+		mustAppend(t, r, event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Amount: 100})
+		_, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustErr(t, err, "got LoadCard() nil error, want error")
+	})
+}
+
+func TestMigrateCard(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(100), "%v")
+	c.Saved()
+	c.Saved()
+	r := &h.Repository{}
+	h.MustNotErr(t, eventsource.MigrateCard(context.Background(), r, key, c, time.Now()), "got MigrateCard() error %v, want nil")
+	h.MustE(t, r.Records[0].Sequence, c.Version(), "got migration sequence %v, want the card version %v")
+	mustAppend(t, r, event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: c.UUID(), Amount: 20})
+
+	res, err := eventsource.LoadCard(context.Background(), r, key, c.UUID())
+	h.MustNotErr(t, err, "got LoadCard() error %v, want nil")
+	h.MustE(t, res.Currency(), model.GBP, "got currency %v, want %v")
+	h.MustE(t, res.AvailableBalance(), uint64(120), "got available balance %v, want %v")
+	h.MustE(t, res.Version(), uint64(3), "got version %v, want %v")
+
+	err = eventsource.MigrateCard(context.Background(), r, key, c, time.Now())
+	h.MustE(t, service.KindOf(err), service.ErrConcurrentModification, "got MigrateCard() error %v of migrated card, want %v")
+	r.Records[0].Message.Payload = []byte(`{"currency":"GBP","availableBalance":"1000","version":2}`)
+	_, err = eventsource.LoadCard(context.Background(), r, key, c.UUID())
+	h.MustErr(t, err, "got LoadCard() nil error for changed migration, want error")
+}
+
+func TestMigrateAuthorizationRequest(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(100), "%v")
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(30, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+	r := &h.Repository{}
+	h.MustNotErr(t, eventsource.MigrateAuthorizationRequest(context.Background(), r, key, req, time.Now()), "got MigrateAuthorizationRequest() error %v, want nil")
+	mustAppend(t, r, event.AuthorizationRequestCaptured{
+		UUID:                     uuid.Must(uuid.NewV4()),
+		AuthorizationRequestUUID: req.UUID(),
+		CardUUID:                 c.UUID(),
+		Amount:                   10,
+		OriginalAmount:           10,
+	})
+
+	res, err := eventsource.LoadAuthorizationRequest(context.Background(), r, key, req.UUID())
+	h.MustNotErr(t, err, "got LoadAuthorizationRequest() error %v, want nil")
+	h.MustE(t, res.MerchantUUID(), req.MerchantUUID(), "got merchant UUID %v, want %v")
+	h.MustE(t, res.Rate().Rate(), "1", "got rate %v, want %v")
+	h.MustE(t, res.BlockedAmount(), uint64(20), "got blocked amount %v, want %v")
+	h.MustE(t, res.CapturedAmount(), uint64(10), "got captured amount %v, want %v")
+	h.MustE(t, len(res.History()), len(req.History())+1, "got %d history entries, want %d")
+}
+
+func TestAppend(t *testing.T) {
+	card := uuid.Must(uuid.NewV4())
+	req := uuid.Must(uuid.NewV4())
+	r := &h.Repository{}
+	mustAppend(t, r, event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Currency: "GBP"})
+	records, err := eventsource.Append(context.Background(), r, key, mustMarshal(t, authorizationRequestEvent(t, event.TypeAuthorizationRequestCreated, req, card, 30, 35)))
+	h.MustNotErr(t, err, "got Append() error %v, want nil")
+	h.MustE(t, len(records), 2, "got %d appended records, want %d")
+	for _, rec := range records {
+		want := uint64(1)
+		if rec.AggregateUUID == card {
+			want = 2
+		}
+		h.MustE(t, rec.Sequence, want, "got sequence %v, want %v")
+	}
+}
+
+func TestAppend_snapshot(t *testing.T) {
+	card := uuid.Must(uuid.NewV4())
+	r := &h.Repository{}
+	mustAppend(t, r, event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Currency: "EUR"})
+	for i := 1; i < eventsource.SnapshotInterval+1; i++ {
+		mustAppend(t, r, event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Amount: 1})
+	}
+	h.MustE(t, len(r.Snapshots), 1, "got %d snapshots, want %d")
+	h.MustE(t, r.Snapshots[0].Sequence, uint64(eventsource.SnapshotInterval), "got snapshot sequence %v, want %v")
+
+	// The events before the snapshot are not folded, so their changes are not detected.
+	r.Records[1].Message.Payload = []byte(`{}`)
+	c, err := eventsource.LoadCard(context.Background(), r, key, card)
+	h.MustNotErr(t, err, "got LoadCard() error %v, want nil")
+	h.MustE(t, c.AvailableBalance(), uint64(eventsource.SnapshotInterval), "got available balance %v, want %v")
+	h.MustE(t, c.Version(), uint64(eventsource.SnapshotInterval+1), "got version %v, want %v")
+	h.MustE(t, c.Currency(), model.EUR, "got currency %v, want %v")
+}
+
+func TestLoadCard_snapshot(t *testing.T) {
+	card := uuid.Must(uuid.NewV4())
+	newRepository := func() *h.Repository {
+		r := &h.Repository{}
+		mustAppend(t, r, event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Currency: "EUR"})
+		for i := 1; i < eventsource.SnapshotInterval+1; i++ {
+			mustAppend(t, r, event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: card, Amount: 1})
+		}
+		return r
+	}
+
+	t.Run("returns error if the snapshot is changed", func(t *testing.T) {
+		r := newRepository()
+		r.Snapshots[0].State = []byte(`{"currency":"EUR","availableBalance":"1000000"}`)
+		_, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustErr(t, err, "got LoadCard() error nil, want error")
+	})
+	t.Run("returns error if the snapshot is signed with another key", func(t *testing.T) {
+		r := newRepository()
+		_, err := eventsource.LoadCard(context.Background(), r, []byte("other"), card)
+		h.MustErr(t, err, "got LoadCard() error nil, want error")
+	})
+	t.Run("returns error if the snapshot does not match its event", func(t *testing.T) {
+		r := newRepository()
+		r.Records[eventsource.SnapshotInterval-1].Hash = eventsource.Hash(key, "", r.Records[eventsource.SnapshotInterval-1])
+		_, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustErr(t, err, "got LoadCard() error nil, want error")
+	})
+	t.Run("returns error if the event of the snapshot is removed", func(t *testing.T) {
+		r := newRepository()
+		r.Records = r.Records[:eventsource.SnapshotInterval-1]
+		_, err := eventsource.LoadCard(context.Background(), r, key, card)
+		h.MustErr(t, err, "got LoadCard() error nil, want error")
+	})
+}
+
+func TestHash(t *testing.T) {
+	r := eventsource.Record{
+		AggregateType: eventsource.AggregateCard,
+		AggregateUUID: uuid.Must(uuid.NewV4()),
+		Sequence:      2,
+		Message:       mustMarshal(t, event.CardFrozen{UUID: uuid.Must(uuid.NewV4())}),
+	}
+	got := eventsource.Hash(key, "prev", r)
+	h.MustE(t, len(got), 64, "got hash of length %d, want %d")
+	h.MustE(t, eventsource.Hash(key, "prev", r), got, "got hash %q of the same record, want %q")
+	h.Must(t, eventsource.Hash(key, "other", r) != got, "got the same hash with different previous hash")
+	h.Must(t, eventsource.Hash([]byte("other"), "prev", r) != got, "got the same hash with different key")
+	r.Sequence++
+	h.Must(t, eventsource.Hash(key, "prev", r) != got, "got the same hash with different sequence number")
+}
+
+func mustAppend(t *testing.T, s eventsource.Store, events ...interface{}) {
+	t.Helper()
+	for _, e := range events {
+		_, err := eventsource.Append(context.Background(), s, key, mustMarshal(t, e))
+		h.MustNotErr(t, err, "got Append() error %v, want nil")
+	}
+}
+
+func mustMarshal(t *testing.T, e interface{}) event.Message {
+	t.Helper()
+	m, err := event.Marshal(e)
+	h.MustNotErr(t, err, "got Marshal() error %v, want nil")
+	return m
+}
+
+// authorizationRequestEvent returns the event of typ of the authorization request with UUID req of card
+// for amount in GBP and originalAmount in EUR.
+func authorizationRequestEvent(t *testing.T, typ string, req, card uuid.UUID, amount, originalAmount uint64) interface{} {
+	t.Helper()
+	m := mustMarshal(t, event.AuthorizationRequestCreated{
+		UUID:                     uuid.Must(uuid.NewV4()),
+		Time:                     time.Now().UTC(),
+		AuthorizationRequestUUID: req,
+		CardUUID:                 card,
+		MerchantUUID:             uuid.Must(uuid.NewV4()),
+		Amount:                   amount,
+		OriginalAmount:           originalAmount,
+		Currency:                 "EUR",
+		CardCurrency:             "GBP",
+		Rate:                     "0.85",
+	})
+	m.Type = typ
+	e, err := event.Unmarshal(m)
+	h.MustNotErr(t, err, "got Unmarshal() error %v, want nil")
+	return e
+}
@@ -0,0 +1,438 @@
+package eventsource
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+)
+
+// cardState is the state of a card, which is folded from its events and saved in its snapshots.
+type cardState struct {
+	UUID             uuid.UUID `json:"uuid"`
+	Currency         string    `json:"currency"`
+	Status           string    `json:"status"`
+	AvailableBalance uint64    `json:"availableBalance,string"`
+	BlockedBalance   uint64    `json:"blockedBalance,string"`
+	Version          uint64    `json:"version"`
+}
+
+// newCardState returns the state of card c.
+func newCardState(c *model.Card) *cardState {
+	return &cardState{
+		UUID:             c.UUID(),
+		Currency:         string(c.Currency()),
+		Status:           string(c.Status()),
+		AvailableBalance: c.AvailableBalance(),
+		BlockedBalance:   c.BlockedBalance(),
+		Version:          c.Version(),
+	}
+}
+
+// apply implements state.
+func (c *cardState) apply(r Record, e interface{}) error {
+	_, ok := e.(event.CardCreated)
+	if ok != (c.Version == 0) {
+		if ok {
+			return errors.New("the card is already created")
+		}
+		return fmt.Errorf("%s before %s", r.Message.Type, event.TypeCardCreated)
+	}
+	var err error
+	switch e := e.(type) {
+	case event.CardCreated:
+		if e.Currency == "" {
+			return errors.New("the card has no currency")
+		}
+		c.UUID, c.Currency, c.Status = e.CardUUID, e.Currency, string(model.CardActive)
+	case event.CardLoaded:
+		c.AvailableBalance, err = add(c.AvailableBalance, e.Amount)
+	case event.CardFrozen:
+		c.Status = string(model.CardFrozen)
+	case event.CardUnfrozen:
+		c.Status = string(model.CardActive)
+	case event.CardBlocked:
+		c.Status = string(model.CardBlocked)
+	case event.CardClosed:
+		c.AvailableBalance, err = sub(c.AvailableBalance, e.Amount)
+		c.Status = string(model.CardClosed)
+	case event.AuthorizationRequestCreated:
+		if c.AvailableBalance, err = sub(c.AvailableBalance, e.Amount); err == nil {
+			c.BlockedBalance, err = add(c.BlockedBalance, e.Amount)
+		}
+	case event.AuthorizationRequestIncremented:
+		if c.AvailableBalance, err = sub(c.AvailableBalance, e.Amount); err == nil {
+			c.BlockedBalance, err = add(c.BlockedBalance, e.Amount)
+		}
+	case event.AuthorizationRequestReversed:
+		if c.BlockedBalance, err = sub(c.BlockedBalance, e.Amount); err == nil {
+			c.AvailableBalance, err = add(c.AvailableBalance, e.Amount)
+		}
+	case event.AuthorizationRequestCaptured:
+		c.BlockedBalance, err = sub(c.BlockedBalance, e.Amount)
+	case event.AuthorizationRequestRefunded:
+		c.AvailableBalance, err = add(c.AvailableBalance, e.Amount)
+	case event.AuthorizationRequestExpired:
+		if c.BlockedBalance, err = sub(c.BlockedBalance, e.Amount); err == nil {
+			c.AvailableBalance, err = add(c.AvailableBalance, e.Amount)
+		}
+	default:
+		return fmt.Errorf("%s is not an event of cards", r.Message.Type)
+	}
+	if err != nil {
+		return err
+	}
+	c.Version = r.Sequence
+	return nil
+}
+
+// cardData implements model.CardData with the state of a card.
+type cardData struct {
+	c *cardState
+}
+
+// UUID returns the UUID.
+func (d cardData) UUID() uuid.UUID {
+	return d.c.UUID
+}
+
+// Currency returns the currency.
+func (d cardData) Currency() model.Currency {
+	return model.Currency(d.c.Currency)
+}
+
+// Status returns the status.
+func (d cardData) Status() model.CardStatus {
+	return model.CardStatus(d.c.Status)
+}
+
+// AvailableBalance returns the available balance.
+func (d cardData) AvailableBalance() uint64 {
+	return d.c.AvailableBalance
+}
+
+// BlockedBalance returns the blocked balance.
+func (d cardData) BlockedBalance() uint64 {
+	return d.c.BlockedBalance
+}
+
+// Version returns the version, which is the sequence number of the last event.
+func (d cardData) Version() uint64 {
+	return d.c.Version
+}
+
+// authorizationRequestState is the state of an authorization request, which is folded from its events
+// and saved in its snapshots.
+type authorizationRequestState struct {
+	UUID                   uuid.UUID                           `json:"uuid"`
+	CardUUID               uuid.UUID                           `json:"cardUUID"`
+	MerchantUUID           uuid.UUID                           `json:"merchantUUID"`
+	Currency               string                              `json:"currency"`
+	CardCurrency           string                              `json:"cardCurrency"`
+	Rate                   string                              `json:"rate"`
+	OriginalAmount         uint64                              `json:"originalAmount,string"`
+	ConvertedAmount        uint64                              `json:"convertedAmount,string"`
+	BlockedAmount          uint64                              `json:"blockedAmount,string"`
+	CapturedAmount         uint64                              `json:"capturedAmount,string"`
+	RefundedAmount         uint64                              `json:"refundedAmount,string"`
+	OriginalBlockedAmount  uint64                              `json:"originalBlockedAmount,string"`
+	OriginalCapturedAmount uint64                              `json:"originalCapturedAmount,string"`
+	OriginalRefundedAmount uint64                              `json:"originalRefundedAmount,string"`
+	ExpiresAt              time.Time                           `json:"expiresAt"`
+	History                []authorizationRequestSnapshotState `json:"history"`
+}
+
+// authorizationRequestSnapshotState is an entry of the history of an authorization request.
+// The UUID of the entry is the UUID of the event, which changed the authorization request.
+type authorizationRequestSnapshotState struct {
+	UUID                   uuid.UUID `json:"uuid"`
+	BlockedAmount          uint64    `json:"blockedAmount,string"`
+	CapturedAmount         uint64    `json:"capturedAmount,string"`
+	RefundedAmount         uint64    `json:"refundedAmount,string"`
+	OriginalBlockedAmount  uint64    `json:"originalBlockedAmount,string"`
+	OriginalCapturedAmount uint64    `json:"originalCapturedAmount,string"`
+	OriginalRefundedAmount uint64    `json:"originalRefundedAmount,string"`
+	CreatedAt              time.Time `json:"createdAt"`
+}
+
+// newAuthorizationRequestState returns the state of authorization request req.
+func newAuthorizationRequestState(req *model.AuthorizationRequest) *authorizationRequestState {
+	st := &authorizationRequestState{
+		UUID:                   req.UUID(),
+		CardUUID:               req.CardUUID(),
+		MerchantUUID:           req.MerchantUUID(),
+		Currency:               string(req.Rate().From()),
+		CardCurrency:           string(req.Rate().To()),
+		Rate:                   req.Rate().Rate(),
+		OriginalAmount:         req.OriginalAmount(),
+		ConvertedAmount:        req.ConvertedAmount(),
+		BlockedAmount:          req.BlockedAmount(),
+		CapturedAmount:         req.CapturedAmount(),
+		RefundedAmount:         req.RefundedAmount(),
+		OriginalBlockedAmount:  req.OriginalBlockedAmount(),
+		OriginalCapturedAmount: req.OriginalCapturedAmount(),
+		OriginalRefundedAmount: req.OriginalRefundedAmount(),
+		ExpiresAt:              req.ExpiresAt(),
+	}
+	for _, h := range req.History() {
+		st.History = append(st.History, authorizationRequestSnapshotState{
+			UUID:                   h.UUID(),
+			BlockedAmount:          h.BlockedAmount(),
+			CapturedAmount:         h.CapturedAmount(),
+			RefundedAmount:         h.RefundedAmount(),
+			OriginalBlockedAmount:  h.OriginalBlockedAmount(),
+			OriginalCapturedAmount: h.OriginalCapturedAmount(),
+			OriginalRefundedAmount: h.OriginalRefundedAmount(),
+			CreatedAt:              h.CreatedAt(),
+		})
+	}
+	return st
+}
+
+// apply implements state.
+func (req *authorizationRequestState) apply(r Record, e interface{}) error {
+	_, ok := e.(event.AuthorizationRequestCreated)
+	if ok != (len(req.History) == 0) {
+		if ok {
+			return errors.New("the authorization request is already created")
+		}
+		return fmt.Errorf("%s before %s", r.Message.Type, event.TypeAuthorizationRequestCreated)
+	}
+	var err error
+	switch e := e.(type) {
+	case event.AuthorizationRequestCreated:
+		if e.Currency == "" || e.CardCurrency == "" || e.Rate == "" {
+			return errors.New("the authorization request has no exchange rate")
+		}
+		req.UUID, req.CardUUID, req.MerchantUUID = e.AuthorizationRequestUUID, e.CardUUID, e.MerchantUUID
+		req.Currency, req.CardCurrency, req.Rate = e.Currency, e.CardCurrency, e.Rate
+		req.OriginalAmount, req.OriginalBlockedAmount = e.OriginalAmount, e.OriginalAmount
+		req.ConvertedAmount, req.BlockedAmount = e.Amount, e.Amount
+	case event.AuthorizationRequestIncremented:
+		if req.OriginalAmount, err = add(req.OriginalAmount, e.OriginalAmount); err == nil {
+			if req.ConvertedAmount, err = add(req.ConvertedAmount, e.Amount); err == nil {
+				req.OriginalBlockedAmount += e.OriginalAmount
+				req.BlockedAmount += e.Amount
+			}
+		}
+	case event.AuthorizationRequestReversed:
+		if req.OriginalBlockedAmount, err = sub(req.OriginalBlockedAmount, e.OriginalAmount); err == nil {
+			req.BlockedAmount, err = sub(req.BlockedAmount, e.Amount)
+		}
+	case event.AuthorizationRequestCaptured:
+		if req.OriginalBlockedAmount, err = sub(req.OriginalBlockedAmount, e.OriginalAmount); err == nil {
+			if req.BlockedAmount, err = sub(req.BlockedAmount, e.Amount); err == nil {
+				req.OriginalCapturedAmount += e.OriginalAmount
+				req.CapturedAmount += e.Amount
+			}
+		}
+	case event.AuthorizationRequestRefunded:
+		if req.OriginalRefundedAmount, err = add(req.OriginalRefundedAmount, e.OriginalAmount); err == nil {
+			req.RefundedAmount, err = add(req.RefundedAmount, e.Amount)
+		}
+	case event.AuthorizationRequestExpired:
+		if req.OriginalBlockedAmount, err = sub(req.OriginalBlockedAmount, e.OriginalAmount); err == nil {
+			req.BlockedAmount, err = sub(req.BlockedAmount, e.Amount)
+		}
+	default:
+		return fmt.Errorf("%s is not an event of authorization requests", r.Message.Type)
+	}
+	if err != nil {
+		return err
+	}
+	if expiresAt := expiryOf(e); !expiresAt.IsZero() {
+		req.ExpiresAt = expiresAt
+	}
+	req.History = append(req.History, authorizationRequestSnapshotState{
+		UUID:                   r.Message.UUID,
+		BlockedAmount:          req.BlockedAmount,
+		CapturedAmount:         req.CapturedAmount,
+		RefundedAmount:         req.RefundedAmount,
+		OriginalBlockedAmount:  req.OriginalBlockedAmount,
+		OriginalCapturedAmount: req.OriginalCapturedAmount,
+		OriginalRefundedAmount: req.OriginalRefundedAmount,
+		CreatedAt:              r.Message.Time,
+	})
+	return nil
+}
+
+// expiryOf returns the expiry of the authorization request after event e or zero time if e has no expiry.
+func expiryOf(e interface{}) time.Time {
+	switch e := e.(type) {
+	case event.AuthorizationRequestCreated:
+		return e.ExpiresAt
+	case event.AuthorizationRequestIncremented:
+		return e.ExpiresAt
+	case event.AuthorizationRequestReversed:
+		return e.ExpiresAt
+	case event.AuthorizationRequestCaptured:
+		return e.ExpiresAt
+	case event.AuthorizationRequestRefunded:
+		return e.ExpiresAt
+	case event.AuthorizationRequestExpired:
+		return e.ExpiresAt
+	}
+	return time.Time{}
+}
+
+// data returns the model data of the authorization request.
+func (req *authorizationRequestState) data() (model.AuthorizationRequestData, error) {
+	rate, err := model.NewFXRate(model.Currency(req.Currency), model.Currency(req.CardCurrency), req.Rate)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse rate of authorization request %s; %v", req.UUID, err)
+	}
+	history := make([]model.AuthorizationRequestSnapshot, len(req.History))
+	for i := range req.History {
+		history[i] = model.AuthorizationRequestSnapshotFromData(authorizationRequestSnapshotData{&req.History[i], rate})
+	}
+	return authorizationRequestData{req, rate, history}, nil
+}
+
+// authorizationRequestData implements model.AuthorizationRequestData with the state of an authorization request.
+type authorizationRequestData struct {
+	req     *authorizationRequestState
+	rate    model.FXRate
+	history []model.AuthorizationRequestSnapshot
+}
+
+// UUID returns the UUID.
+func (d authorizationRequestData) UUID() uuid.UUID {
+	return d.req.UUID
+}
+
+// CardUUID returns the card UUID.
+func (d authorizationRequestData) CardUUID() uuid.UUID {
+	return d.req.CardUUID
+}
+
+// MerchantUUID returns the merchant UUID.
+func (d authorizationRequestData) MerchantUUID() uuid.UUID {
+	return d.req.MerchantUUID
+}
+
+// Rate returns the exchange rate.
+func (d authorizationRequestData) Rate() model.FXRate {
+	return d.rate
+}
+
+// OriginalAmount returns the requested amount in the currency of the authorization.
+func (d authorizationRequestData) OriginalAmount() uint64 {
+	return d.req.OriginalAmount
+}
+
+// ConvertedAmount returns the requested amount in the currency of the card.
+func (d authorizationRequestData) ConvertedAmount() uint64 {
+	return d.req.ConvertedAmount
+}
+
+// BlockedAmount returns the blocked amount.
+func (d authorizationRequestData) BlockedAmount() uint64 {
+	return d.req.BlockedAmount
+}
+
+// CapturedAmount returns the captured amount.
+func (d authorizationRequestData) CapturedAmount() uint64 {
+	return d.req.CapturedAmount
+}
+
+// RefundedAmount returns the refunded amount.
+func (d authorizationRequestData) RefundedAmount() uint64 {
+	return d.req.RefundedAmount
+}
+
+// OriginalBlockedAmount returns the blocked amount in the currency of the authorization.
+func (d authorizationRequestData) OriginalBlockedAmount() uint64 {
+	return d.req.OriginalBlockedAmount
+}
+
+// OriginalCapturedAmount returns the captured amount in the currency of the authorization.
+func (d authorizationRequestData) OriginalCapturedAmount() uint64 {
+	return d.req.OriginalCapturedAmount
+}
+
+// OriginalRefundedAmount returns the refunded amount in the currency of the authorization.
+func (d authorizationRequestData) OriginalRefundedAmount() uint64 {
+	return d.req.OriginalRefundedAmount
+}
+
+// ExpiresAt returns the expiry.
+func (d authorizationRequestData) ExpiresAt() time.Time {
+	return d.req.ExpiresAt
+}
+
+// History returns the log of changes.
+func (d authorizationRequestData) History() []model.AuthorizationRequestSnapshot {
+	return d.history
+}
+
+// authorizationRequestSnapshotData implements model.AuthorizationRequestSnapshotData with an entry of the history.
+type authorizationRequestSnapshotData struct {
+	s    *authorizationRequestSnapshotState
+	rate model.FXRate
+}
+
+// UUID returns the UUID.
+func (d authorizationRequestSnapshotData) UUID() uuid.UUID {
+	return d.s.UUID
+}
+
+// Rate returns the exchange rate.
+func (d authorizationRequestSnapshotData) Rate() model.FXRate {
+	return d.rate
+}
+
+// BlockedAmount returns the blocked amount.
+func (d authorizationRequestSnapshotData) BlockedAmount() uint64 {
+	return d.s.BlockedAmount
+}
+
+// CapturedAmount returns the captured amount.
+func (d authorizationRequestSnapshotData) CapturedAmount() uint64 {
+	return d.s.CapturedAmount
+}
+
+// RefundedAmount returns the refunded amount.
+func (d authorizationRequestSnapshotData) RefundedAmount() uint64 {
+	return d.s.RefundedAmount
+}
+
+// OriginalBlockedAmount returns the blocked amount in the currency of the authorization.
+func (d authorizationRequestSnapshotData) OriginalBlockedAmount() uint64 {
+	return d.s.OriginalBlockedAmount
+}
+
+// OriginalCapturedAmount returns the captured amount in the currency of the authorization.
+func (d authorizationRequestSnapshotData) OriginalCapturedAmount() uint64 {
+	return d.s.OriginalCapturedAmount
+}
+
+// OriginalRefundedAmount returns the refunded amount in the currency of the authorization.
+func (d authorizationRequestSnapshotData) OriginalRefundedAmount() uint64 {
+	return d.s.OriginalRefundedAmount
+}
+
+// CreatedAt returns the time of the event, which changed the authorization request.
+func (d authorizationRequestSnapshotData) CreatedAt() time.Time {
+	return d.s.CreatedAt
+}
+
+// add returns a + b or error if the sum overflows.
+func add(a, b uint64) (uint64, error) {
+	if a > math.MaxUint64-b {
+		return a, errors.New("the amount cannot exceed math.MaxUint64")
+	}
+	return a + b, nil
+}
+
+// sub returns a - b or error if b is greater than a.
+func sub(a, b uint64) (uint64, error) {
+	if b > a {
+		return a, fmt.Errorf("cannot subtract %d from %d", b, a)
+	}
+	return a - b, nil
+}
@@ -1,11 +1,27 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/capture"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardcategories"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardlimits"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardstatus"
 	"github.com/sepetrov/prepaidcard/pkg/internal/service/createcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/getcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/increment"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listdeliveries"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/loadcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/merchant"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/refund"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/registerwebhook"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/reverse"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
 )
 
 // Func is an adapter to allow regular functions with the signature of
@@ -23,6 +39,20 @@ type Handler interface {
 	Handle(http.ResponseWriter, *http.Request) error
 }
 
+type paramsKey struct{}
+
+// WithParams returns a shallow copy of r with the path parameters params.
+func WithParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), paramsKey{}, params))
+}
+
+// Param returns the value of the path parameter name of r.
+// It returns an empty string if the parameter is not set.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
 // CreateCard is handler for new cards.
 type CreateCard struct {
 	svc *createcard.Service
@@ -35,20 +65,550 @@ func NewCreateCard(svc *createcard.Service) *CreateCard {
 	return &CreateCard{svc}
 }
 
-// Handle handles requests for new card.
-func (h *CreateCard) Handle(w http.ResponseWriter, _ *http.Request) error {
-	res, err := h.svc.CreateCard()
+// Handle handles requests for new card. The request body is optional.
+func (h *CreateCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := createcard.Request{}
+	if err := readOptionalJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.CreateCard(r.Context(), req)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// GetCard is handler for card details.
+type GetCard struct {
+	svc *getcard.Service
+}
+
+var _ Handler = &GetCard{}
+
+// NewGetCard returns GetCard handler.
+func NewGetCard(svc *getcard.Service) *GetCard {
+	return &GetCard{svc}
+}
+
+// Handle handles requests for card details. The ETag header is the entity tag of the card.
+func (h *GetCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.GetCard(r.Context(), Param(r, "uuid"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// GetCardLimits is handler for the limits of cards.
+type GetCardLimits struct {
+	svc *cardlimits.Service
+}
+
+var _ Handler = &GetCardLimits{}
+
+// NewGetCardLimits returns GetCardLimits handler.
+func NewGetCardLimits(svc *cardlimits.Service) *GetCardLimits {
+	return &GetCardLimits{svc}
+}
+
+// Handle handles requests for the limits of cards.
+func (h *GetCardLimits) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.GetLimits(r.Context(), Param(r, "uuid"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// SetCardLimits is handler for setting the limits of cards.
+type SetCardLimits struct {
+	svc *cardlimits.Service
+}
+
+var _ Handler = &SetCardLimits{}
+
+// NewSetCardLimits returns SetCardLimits handler.
+func NewSetCardLimits(svc *cardlimits.Service) *SetCardLimits {
+	return &SetCardLimits{svc}
+}
+
+// Handle handles requests for setting the limits of cards.
+func (h *SetCardLimits) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := cardlimits.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.SetLimits(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// GetCardCategories is handler for the merchant category restrictions of cards.
+type GetCardCategories struct {
+	svc *cardcategories.Service
+}
+
+var _ Handler = &GetCardCategories{}
+
+// NewGetCardCategories returns GetCardCategories handler.
+func NewGetCardCategories(svc *cardcategories.Service) *GetCardCategories {
+	return &GetCardCategories{svc}
+}
+
+// Handle handles requests for the merchant category restrictions of cards.
+func (h *GetCardCategories) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.GetCategories(r.Context(), Param(r, "uuid"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// SetCardCategories is handler for setting the merchant category restrictions of cards.
+type SetCardCategories struct {
+	svc *cardcategories.Service
+}
+
+var _ Handler = &SetCardCategories{}
+
+// NewSetCardCategories returns SetCardCategories handler.
+func NewSetCardCategories(svc *cardcategories.Service) *SetCardCategories {
+	return &SetCardCategories{svc}
+}
+
+// Handle handles requests for setting the merchant category restrictions of cards.
+func (h *SetCardCategories) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := cardcategories.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.SetCategories(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// ListTransactions is handler for card transactions.
+type ListTransactions struct {
+	svc *listtransactions.Service
+}
+
+var _ Handler = &ListTransactions{}
+
+// NewListTransactions returns ListTransactions handler.
+func NewListTransactions(svc *listtransactions.Service) *ListTransactions {
+	return &ListTransactions{svc}
+}
+
+// Handle handles requests for card transactions.
+// It reads the query parameters cursor, limit, from, to and eventType, which can be repeated.
+func (h *ListTransactions) Handle(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	res, err := h.svc.ListTransactions(r.Context(), Param(r, "uuid"), listtransactions.Request{
+		Cursor:     q.Get("cursor"),
+		Limit:      q.Get("limit"),
+		From:       q.Get("from"),
+		To:         q.Get("to"),
+		EventTypes: q["eventType"],
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// LoadCard is handler for loading money onto cards.
+type LoadCard struct {
+	svc *loadcard.Service
+}
+
+var _ Handler = &LoadCard{}
+
+// NewLoadCard returns LoadCard handler.
+func NewLoadCard(svc *loadcard.Service) *LoadCard {
+	return &LoadCard{svc}
+}
+
+// Handle handles requests for loading money onto card. The card is loaded only if it matches
+// the If-Match header. The ETag header is the entity tag of the loaded card.
+func (h *LoadCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := loadcard.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.LoadCard(r.Context(), Param(r, "uuid"), r.Header.Get("If-Match"), req)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// FreezeCard is handler for freezing cards.
+type FreezeCard struct {
+	svc *cardstatus.Service
+}
+
+var _ Handler = &FreezeCard{}
+
+// NewFreezeCard returns FreezeCard handler.
+func NewFreezeCard(svc *cardstatus.Service) *FreezeCard {
+	return &FreezeCard{svc}
+}
+
+// Handle handles requests for freezing cards.
+// The card is changed only if it matches the If-Match header. The ETag header is the entity tag of the changed card.
+func (h *FreezeCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.Freeze(r.Context(), Param(r, "uuid"), r.Header.Get("If-Match"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// UnfreezeCard is handler for unfreezing cards.
+type UnfreezeCard struct {
+	svc *cardstatus.Service
+}
+
+var _ Handler = &UnfreezeCard{}
+
+// NewUnfreezeCard returns UnfreezeCard handler.
+func NewUnfreezeCard(svc *cardstatus.Service) *UnfreezeCard {
+	return &UnfreezeCard{svc}
+}
+
+// Handle handles requests for unfreezing cards.
+// The card is changed only if it matches the If-Match header. The ETag header is the entity tag of the changed card.
+func (h *UnfreezeCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.Unfreeze(r.Context(), Param(r, "uuid"), r.Header.Get("If-Match"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// BlockCard is handler for blocking cards.
+type BlockCard struct {
+	svc *cardstatus.Service
+}
+
+var _ Handler = &BlockCard{}
+
+// NewBlockCard returns BlockCard handler.
+func NewBlockCard(svc *cardstatus.Service) *BlockCard {
+	return &BlockCard{svc}
+}
+
+// Handle handles requests for blocking cards.
+// The card is changed only if it matches the If-Match header. The ETag header is the entity tag of the changed card.
+func (h *BlockCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.Block(r.Context(), Param(r, "uuid"), r.Header.Get("If-Match"))
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// CloseCard is handler for closing cards.
+type CloseCard struct {
+	svc *cardstatus.Service
+}
+
+var _ Handler = &CloseCard{}
+
+// NewCloseCard returns CloseCard handler.
+func NewCloseCard(svc *cardstatus.Service) *CloseCard {
+	return &CloseCard{svc}
+}
+
+// Handle handles requests for closing cards.
+// The card is changed only if it matches the If-Match header. The ETag header is the entity tag of the changed card.
+func (h *CloseCard) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.Close(r.Context(), Param(r, "uuid"), r.Header.Get("If-Match"))
 	if err != nil {
 		return err
 	}
+	w.Header().Set("ETag", res.ETag)
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// Authorize is handler for merchant authorization requests.
+type Authorize struct {
+	svc *authorize.Service
+}
+
+var _ Handler = &Authorize{}
+
+// NewAuthorize returns Authorize handler.
+func NewAuthorize(svc *authorize.Service) *Authorize {
+	return &Authorize{svc}
+}
+
+// Handle handles authorization requests.
+func (h *Authorize) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := authorize.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.Authorize(r.Context(), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// Capture is handler for capturing transactions of authorization requests.
+type Capture struct {
+	svc *capture.Service
+}
+
+var _ Handler = &Capture{}
+
+// NewCapture returns Capture handler.
+func NewCapture(svc *capture.Service) *Capture {
+	return &Capture{svc}
+}
+
+// Handle handles requests for capturing transactions.
+func (h *Capture) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := capture.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.Capture(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// Refund is handler for refunding captured transactions.
+type Refund struct {
+	svc *refund.Service
+}
+
+var _ Handler = &Refund{}
+
+// NewRefund returns Refund handler.
+func NewRefund(svc *refund.Service) *Refund {
+	return &Refund{svc}
+}
+
+// Handle handles requests for refunding captured transactions.
+func (h *Refund) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := refund.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.Refund(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// Increment is handler for incrementing authorization requests.
+type Increment struct {
+	svc *increment.Service
+}
+
+var _ Handler = &Increment{}
+
+// NewIncrement returns Increment handler.
+func NewIncrement(svc *increment.Service) *Increment {
+	return &Increment{svc}
+}
+
+// Handle handles requests for incrementing authorization requests.
+func (h *Increment) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := increment.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.Increment(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// Reverse is handler for reversing authorization requests.
+type Reverse struct {
+	svc *reverse.Service
+}
+
+var _ Handler = &Reverse{}
+
+// NewReverse returns Reverse handler.
+func NewReverse(svc *reverse.Service) *Reverse {
+	return &Reverse{svc}
+}
+
+// Handle handles requests for reversing authorization requests.
+func (h *Reverse) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := reverse.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.Reverse(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// CreateMerchant is handler for registration of merchants.
+type CreateMerchant struct {
+	svc *merchant.Service
+}
+
+var _ Handler = &CreateMerchant{}
+
+// NewCreateMerchant returns CreateMerchant handler.
+func NewCreateMerchant(svc *merchant.Service) *CreateMerchant {
+	return &CreateMerchant{svc}
+}
+
+// Handle handles requests for registration of merchants.
+func (h *CreateMerchant) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := merchant.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.CreateMerchant(r.Context(), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// GetMerchant is handler for merchant details.
+type GetMerchant struct {
+	svc *merchant.Service
+}
+
+var _ Handler = &GetMerchant{}
+
+// NewGetMerchant returns GetMerchant handler.
+func NewGetMerchant(svc *merchant.Service) *GetMerchant {
+	return &GetMerchant{svc}
+}
+
+// Handle handles requests for merchant details.
+func (h *GetMerchant) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.GetMerchant(r.Context(), Param(r, "uuid"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// UpdateMerchant is handler for updates of merchants.
+type UpdateMerchant struct {
+	svc *merchant.Service
+}
+
+var _ Handler = &UpdateMerchant{}
+
+// NewUpdateMerchant returns UpdateMerchant handler.
+func NewUpdateMerchant(svc *merchant.Service) *UpdateMerchant {
+	return &UpdateMerchant{svc}
+}
+
+// Handle handles requests for updates of merchants.
+func (h *UpdateMerchant) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := merchant.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.UpdateMerchant(r.Context(), Param(r, "uuid"), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// RegisterWebhook is handler for registration of webhooks.
+type RegisterWebhook struct {
+	svc *registerwebhook.Service
+}
+
+var _ Handler = &RegisterWebhook{}
+
+// NewRegisterWebhook returns RegisterWebhook handler.
+func NewRegisterWebhook(svc *registerwebhook.Service) *RegisterWebhook {
+	return &RegisterWebhook{svc}
+}
+
+// Handle handles requests for registration of webhooks.
+func (h *RegisterWebhook) Handle(w http.ResponseWriter, r *http.Request) error {
+	req := registerwebhook.Request{}
+	if err := readJSON(r, &req); err != nil {
+		return err
+	}
+	res, err := h.svc.RegisterWebhook(r.Context(), req)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, res)
+}
+
+// ListDeliveries is handler for the deliveries of webhooks.
+type ListDeliveries struct {
+	svc *listdeliveries.Service
+}
+
+var _ Handler = &ListDeliveries{}
+
+// NewListDeliveries returns ListDeliveries handler.
+func NewListDeliveries(svc *listdeliveries.Service) *ListDeliveries {
+	return &ListDeliveries{svc}
+}
+
+// Handle handles requests for the deliveries of webhooks. It reads the query parameter limit.
+func (h *ListDeliveries) Handle(w http.ResponseWriter, r *http.Request) error {
+	res, err := h.svc.ListDeliveries(r.Context(), Param(r, "uuid"), listdeliveries.Request{
+		Limit: r.URL.Query().Get("limit"),
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, res)
+}
+
+// readJSON decodes the JSON-encoded body of r into req strictly.
+// It returns 422 service.ErrorResponse if the body cannot be decoded.
+func readJSON(r *http.Request, req interface{}) error {
+	return validation.Decode(r.Body, req)
+}
+
+// readOptionalJSON decodes the JSON-encoded body of r into req unless the body is empty.
+// It returns 422 service.ErrorResponse if the body cannot be decoded.
+func readOptionalJSON(r *http.Request, req interface{}) error {
+	if r.Body == nil {
+		return nil
+	}
+	return validation.DecodeOptional(r.Body, req)
+}
 
+// writeJSON writes JSON-encoded res with status code to w.
+func writeJSON(w http.ResponseWriter, code int, res interface{}) error {
 	j, err := json.Marshal(res)
 	if err != nil {
 		return fmt.Errorf("got json.Marshal(%T) error; %v", res, err)
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(code)
 	w.Write(j)
 	return nil
 }
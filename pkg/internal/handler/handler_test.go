@@ -3,24 +3,40 @@
 package handler_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
 
-	"github.com/sepetrov/prepaidcard/pkg/internal/event"
 	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/capture"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardcategories"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardlimits"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardstatus"
 	"github.com/sepetrov/prepaidcard/pkg/internal/service/createcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/getcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/increment"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/loadcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/merchant"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/refund"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/reverse"
 	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
 )
 
 func TestNew(t *testing.T) {
 	t.Run("renders the card details on success", func(t *testing.T) {
 		s := &assert.Repository{}
-		d := &dispatcher{}
-		h := handler.NewCreateCard(createcard.New(s, d))
+		h := handler.NewCreateCard(createcard.New(s, nil))
 
 		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
 		w := httptest.NewRecorder()
@@ -37,14 +53,482 @@ func TestNew(t *testing.T) {
 		assert.MustE(t, resp.Header.Get("Content-Type"), "application/json; charset=utf-8", "")
 		assert.Must(t, strings.Contains(string(body), fmt.Sprintf(`"uuid":"%s"`, s.Card.UUID().String())), "")
 	})
+	t.Run("creates the card in the requested currency", func(t *testing.T) {
+		s := &assert.Repository{}
+		h := handler.NewCreateCard(createcard.New(s, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card", strings.NewReader(`{"currency":"EUR"}`))
+		w := httptest.NewRecorder()
+		assert.MustNotErr(t, h.Handle(w, req), "got error %v, want nil")
+		assert.MustE(t, w.Code, 201, "")
+		assert.MustE(t, s.Card.Currency(), model.EUR, "got card currency %q, want %q")
+	})
+	t.Run("passes the request context to the unit of work", func(t *testing.T) {
+		s := &ctxUnitOfWork{}
+		h := handler.NewCreateCard(createcard.New(s, nil))
+
+		type key struct{}
+		req := httptest.NewRequest("POST", "http://example.com/api/card", nil)
+		req = req.WithContext(context.WithValue(req.Context(), key{}, "foo"))
+		assert.MustNotErr(t, h.Handle(httptest.NewRecorder(), req), "got error %v, want nil")
+		assert.MustE(t, s.ctx.Value(key{}), "foo", "got unit of work context value %v, want %v")
+	})
+	t.Run("returns 422 error response if the body is not JSON", func(t *testing.T) {
+		h := handler.NewCreateCard(createcard.New(&assert.Repository{}, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card", strings.NewReader(`foo`))
+		err := h.Handle(httptest.NewRecorder(), req)
+		res, ok := err.(service.ErrorResponse)
+		assert.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		assert.MustE(t, res.StatusCode(), 422, "")
+	})
+}
+
+func TestGetCard(t *testing.T) {
+	t.Run("renders the card details on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		h := handler.NewGetCard(getcard.New(&assert.Repository{Card: c}))
+
+		req := httptest.NewRequest("GET", "http://example.com/api/card/"+c.UUID().String(), nil)
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.MustE(t, resp.Header.Get("Content-Type"), "application/json; charset=utf-8", "")
+		assert.MustE(t, resp.Header.Get("ETag"), `"0"`, "got ETag %s, want %s")
+		assert.Must(t, strings.Contains(string(body), fmt.Sprintf(`"uuid":"%s"`, c.UUID().String())), "")
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		h := handler.NewGetCard(getcard.New(&assert.Repository{}))
+
+		req := httptest.NewRequest("GET", "http://example.com/api/card/foo", nil)
+		req = handler.WithParams(req, map[string]string{"uuid": "foo"})
+		err := h.Handle(httptest.NewRecorder(), req)
+		res, ok := err.(service.ErrorResponse)
+		assert.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		assert.MustE(t, res.StatusCode(), 404, "")
+	})
+}
+
+func TestListTransactions(t *testing.T) {
+	t.Run("renders the card transactions on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		assert.MustNotErr(t, c.LoadMoney(10), "%v")
+		tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "CardLoaded", 10, "Card load", time.Now())
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, r.SaveCardTransaction(context.Background(), c, tx), "%v")
+		h := handler.NewListTransactions(listtransactions.New(r, r))
+
+		req := httptest.NewRequest("GET", "http://example.com/api/card/"+c.UUID().String()+"/transactions?eventType=CardLoaded&limit=10", nil)
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.Must(t, strings.Contains(string(b), fmt.Sprintf(`"uuid":"%s"`, tx.UUID())), "")
+	})
+}
+
+func TestLoadCard(t *testing.T) {
+	t.Run("renders the transaction UUID and the ETag header on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewLoadCard(loadcard.New(r, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/load", strings.NewReader(`{"amount":"1950","currency":"GBP"}`))
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.MustE(t, resp.Header.Get("Content-Type"), "application/json; charset=utf-8", "")
+		assert.MustE(t, resp.Header.Get("ETag"), `"1"`, "got ETag %s, want %s")
+		assert.MustE(t, len(r.Transactions), 1, "")
+		assert.Must(t, strings.Contains(string(body), fmt.Sprintf(`"uuid":"%s"`, r.Transactions[0].UUID().String())), "")
+	})
+	t.Run("returns 422 error response if the body is not JSON", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewLoadCard(loadcard.New(r, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/load", strings.NewReader(`foo`))
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		err = h.Handle(httptest.NewRecorder(), req)
+		res, ok := err.(service.ErrorResponse)
+		assert.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		assert.MustE(t, res.StatusCode(), 422, "")
+	})
+	t.Run("returns 422 error response if the body has unknown fields", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewLoadCard(loadcard.New(r, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/load", strings.NewReader(`{"amount":"1950","currency":"GBP","ammount":"1"}`))
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		err = h.Handle(httptest.NewRecorder(), req)
+		res, ok := err.(service.ErrorResponse)
+		assert.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		assert.MustE(t, res.StatusCode(), 422, "")
+		assert.MustE(t, len(r.Transactions), 0, "")
+	})
+}
+
+func TestCardStatus(t *testing.T) {
+	t.Run("renders the card on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		svc := cardstatus.New(r)
+		for i, tc := range []struct {
+			path   string
+			h      handler.Handler
+			status string
+		}{
+			{"freeze", handler.NewFreezeCard(svc), "frozen"},
+			{"unfreeze", handler.NewUnfreezeCard(svc), "active"},
+			{"block", handler.NewBlockCard(svc), "blocked"},
+			{"close", handler.NewCloseCard(svc), "closed"},
+		} {
+			req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/"+tc.path, nil)
+			req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+			w := httptest.NewRecorder()
+			err = tc.h.Handle(w, req)
+			assert.MustNotErr(t, err, "got error %v, want nil")
+
+			resp := w.Result()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			assert.MustE(t, resp.StatusCode, 200, "")
+			assert.MustE(t, resp.Header.Get("ETag"), service.ETag(uint64(i+1)), "got ETag %s, want %s")
+			assert.Must(t, strings.Contains(string(body), fmt.Sprintf(`"status":%q`, tc.status)), "got body %s, want status %q", body, tc.status)
+		}
+	})
+	t.Run("returns 412 error response if the card does not match If-Match", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewFreezeCard(cardstatus.New(r))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/freeze", nil)
+		req.Header.Set("If-Match", `"1"`)
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		err = h.Handle(httptest.NewRecorder(), req)
+		res, ok := err.(service.ErrorResponse)
+		assert.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		assert.MustE(t, res.StatusCode(), 412, "")
+		assert.MustE(t, c.Status(), model.CardActive, "got card status %q, want %q")
+	})
+	t.Run("returns 422 error response if the status cannot be changed", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewUnfreezeCard(cardstatus.New(r))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/unfreeze", nil)
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		err = h.Handle(httptest.NewRecorder(), req)
+		res, ok := err.(service.ErrorResponse)
+		assert.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		assert.MustE(t, res.StatusCode(), 422, "")
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Run("renders the authorization request on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, c.LoadMoney(100), "%v")
+		m, err := model.NewMerchant("Test Merchant", "5411", "GB", "GB29NWBK60161331926819")
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c, Merchant: m}
+		h := handler.NewAuthorize(authorize.New(r, nil, nil, nil))
+
+		body := fmt.Sprintf(`{"merchantUUID":%q,"cardUUID":%q,"amount":"70","currency":"GBP"}`, m.UUID(), c.UUID())
+		req := httptest.NewRequest("POST", "http://example.com/api/authorization-request", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.MustE(t, resp.Header.Get("Content-Type"), "application/json; charset=utf-8", "")
+		assert.Must(t, strings.Contains(string(b), fmt.Sprintf(`"uuid":"%s"`, r.AuthorizationRequest.UUID())), "")
+		assert.Must(t, strings.Contains(string(b), `"blockedAmount":"70"`), "")
+		assert.Must(t, strings.Contains(string(b), `"history":[{`), "")
+	})
+}
+
+func TestCapture(t *testing.T) {
+	t.Run("renders the authorization request on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, c.LoadMoney(100), "%v")
+		a, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(70, model.GBP), nil, time.Now())
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c, AuthorizationRequest: a}
+		h := handler.NewCapture(capture.New(r))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/authorization-request/"+a.UUID().String()+"/capture", strings.NewReader(`{"amount":"50"}`))
+		req = handler.WithParams(req, map[string]string{"uuid": a.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.Must(t, strings.Contains(string(b), `"capturedAmount":"50"`), "")
+	})
+}
+
+func TestRefund(t *testing.T) {
+	t.Run("renders the authorization request on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, c.LoadMoney(100), "%v")
+		a, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(70, model.GBP), nil, time.Now())
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, a.Capture(c, 70, time.Now()), "%v")
+		r := &assert.Repository{Card: c, AuthorizationRequest: a}
+		h := handler.NewRefund(refund.New(r))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/authorization-request/"+a.UUID().String()+"/refund", strings.NewReader(`{"amount":"20"}`))
+		req = handler.WithParams(req, map[string]string{"uuid": a.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.Must(t, strings.Contains(string(b), `"refundedAmount":"20"`), "")
+	})
+}
+
+func TestIncrement(t *testing.T) {
+	t.Run("renders the authorization request on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, c.LoadMoney(100), "%v")
+		a, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(70, model.GBP), nil, time.Now())
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c, AuthorizationRequest: a}
+		h := handler.NewIncrement(increment.New(r, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/authorization-request/"+a.UUID().String()+"/increment", strings.NewReader(`{"amount":"20"}`))
+		req = handler.WithParams(req, map[string]string{"uuid": a.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.Must(t, strings.Contains(string(b), `"blockedAmount":"90"`), "")
+	})
+}
+
+func TestReverse(t *testing.T) {
+	t.Run("renders the authorization request on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		assert.MustNotErr(t, c.LoadMoney(100), "%v")
+		a, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(70, model.GBP), nil, time.Now())
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c, AuthorizationRequest: a}
+		h := handler.NewReverse(reverse.New(r))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/authorization-request/"+a.UUID().String()+"/reverse", strings.NewReader(`{"amount":"20"}`))
+		req = handler.WithParams(req, map[string]string{"uuid": a.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.Must(t, strings.Contains(string(b), `"blockedAmount":"50"`), "")
+	})
 }
 
-type dispatcher struct {
-	e event.CardCreated
+func TestCardLimits(t *testing.T) {
+	t.Run("renders the limits of the card on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		h := handler.NewGetCardLimits(cardlimits.New(&assert.Repository{Card: c}, model.ProductLimits{model.DefaultProduct: {Daily: 500}}))
+
+		req := httptest.NewRequest("GET", "http://example.com/api/card/"+c.UUID().String()+"/limits", nil)
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.MustE(t, string(b), `{"product":"standard","limits":{"daily":"500"},"own":{}}`, "got body %s, want %s")
+	})
+	t.Run("sets the limits of the card on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewSetCardLimits(cardlimits.New(r, nil))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/limits", strings.NewReader(`{"limits":{"daily":"200"}}`))
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.Must(t, strings.Contains(string(b), `"own":{"daily":"200"}`), "got body %s, want own daily limit", b)
+		assert.MustE(t, r.CardLimits.Limits.Daily, uint64(200), "got saved daily limit %v, want %v")
+	})
 }
 
-var _ createcard.Dispatcher = &dispatcher{}
+func TestCardCategories(t *testing.T) {
+	t.Run("renders the merchant category restrictions of the card on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c, MerchantCategories: &model.MerchantCategories{Blocked: []string{"7995"}}}
+		h := handler.NewGetCardCategories(cardcategories.New(r))
+
+		req := httptest.NewRequest("GET", "http://example.com/api/card/"+c.UUID().String()+"/merchant-categories", nil)
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.MustE(t, string(b), `{"allowed":[],"blocked":["7995"]}`, "got body %s, want %s")
+	})
+	t.Run("sets the merchant category restrictions of the card on success", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Card: c}
+		h := handler.NewSetCardCategories(cardcategories.New(r))
+
+		req := httptest.NewRequest("POST", "http://example.com/api/card/"+c.UUID().String()+"/merchant-categories", strings.NewReader(`{"allowed":["5411"]}`))
+		req = handler.WithParams(req, map[string]string{"uuid": c.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.MustE(t, string(b), `{"allowed":["5411"],"blocked":[]}`, "got body %s, want %s")
+		assert.Must(t, r.MerchantCategories != nil, "got no saved merchant categories, want one")
+	})
+}
+
+func TestMerchant(t *testing.T) {
+	t.Run("renders the registered merchant on success", func(t *testing.T) {
+		r := &assert.Repository{}
+		h := handler.NewCreateMerchant(merchant.New(r))
+
+		body := `{"name":"Grand Hotel","category":"7011","country":"gb","settlementAccount":"GB29NWBK60161331926819"}`
+		req := httptest.NewRequest("POST", "http://example.com/api/merchants", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		err := h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 201, "")
+		assert.Must(t, r.Merchant != nil, "got no saved merchant, want one")
+		assert.Must(t, strings.Contains(string(b), fmt.Sprintf(`"uuid":"%s"`, r.Merchant.UUID())), "got body %s, want merchant UUID", b)
+		assert.Must(t, strings.Contains(string(b), `"country":"GB"`), "got body %s, want country GB", b)
+		assert.Must(t, strings.Contains(string(b), `"status":"active"`), "got body %s, want status active", b)
+	})
+	t.Run("renders the merchant on success", func(t *testing.T) {
+		m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+		assert.MustNotErr(t, err, "%v")
+		h := handler.NewGetMerchant(merchant.New(&assert.Repository{Merchant: m}))
+
+		req := httptest.NewRequest("GET", "http://example.com/api/merchants/"+m.UUID().String(), nil)
+		req = handler.WithParams(req, map[string]string{"uuid": m.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		want := fmt.Sprintf(`{"uuid":"%s","name":"Grand Hotel","category":"7011","country":"GB","settlementAccount":"GB29NWBK60161331926819","status":"active"}`, m.UUID())
+		assert.MustE(t, string(b), want, "got body %s, want %s")
+	})
+	t.Run("renders the updated merchant on success", func(t *testing.T) {
+		m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+		assert.MustNotErr(t, err, "%v")
+		r := &assert.Repository{Merchant: m}
+		h := handler.NewUpdateMerchant(merchant.New(r))
+
+		body := `{"name":"Grand Hotel","category":"7011","country":"GB","settlementAccount":"GB29NWBK60161331926819","status":"suspended"}`
+		req := httptest.NewRequest("POST", "http://example.com/api/merchants/"+m.UUID().String(), strings.NewReader(body))
+		req = handler.WithParams(req, map[string]string{"uuid": m.UUID().String()})
+		w := httptest.NewRecorder()
+		err = h.Handle(w, req)
+		assert.MustNotErr(t, err, "got error %v, want nil")
+
+		resp := w.Result()
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 200, "")
+		assert.Must(t, strings.Contains(string(b), `"status":"suspended"`), "got body %s, want status suspended", b)
+		assert.MustE(t, r.Merchant.Status(), model.MerchantSuspended, "got saved status %v, want %v")
+	})
+}
+
+func TestParam(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	assert.MustE(t, handler.Param(req, "uuid"), "", "got %q, want %q")
+	req = handler.WithParams(req, map[string]string{"uuid": "bar"})
+	assert.MustE(t, handler.Param(req, "uuid"), "bar", "got %q, want %q")
+}
+
+// ctxUnitOfWork is service.UnitOfWork, which records the context.
+type ctxUnitOfWork struct {
+	assert.Repository
+	ctx context.Context
+}
 
-func (d *dispatcher) DispatchCardCreated(e event.CardCreated) {
-	d.e = e
+func (u *ctxUnitOfWork) WithinTx(ctx context.Context, fn func(service.Tx) error) error {
+	u.ctx = ctx
+	return u.Repository.WithinTx(ctx, fn)
 }
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Authenticator is an interface for authentication of requests.
+type Authenticator interface {
+	Authenticate(*http.Request) (auth.Principal, bool, error)
+}
+
+// Policy returns true if principal p is authorized to send request r.
+type Policy func(p auth.Principal, r *http.Request) (bool, error)
+
+// Authenticate adds the principal of the request to the request context.
+// The requests without credentials are handled unauthenticated and Authorize
+// rejects them. It responds with 401 service.ErrorResponse if the credentials are
+// invalid. It renders its own errors, so it can wrap the Error middleware.
+func Authenticate(a Authenticator) Middleware {
+	return func(prev handler.Handler) handler.Handler {
+		return handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			p, ok, err := a.Authenticate(r)
+			if err == auth.ErrInvalidCredentials {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				return writeErrorResponse(w, r, newUnauthorizedErrorResponse())
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return fmt.Errorf("Authenticate() cannot authenticate request; %v", err)
+			}
+			if ok {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+			}
+			return prev.Handle(w, r)
+		})
+	}
+}
+
+// Authorize returns 401 service.ErrorResponse if the request is not authenticated and
+// 403 service.ErrorResponse if policy does not authorize the principal of the request.
+func Authorize(policy Policy) Middleware {
+	return func(prev handler.Handler) handler.Handler {
+		return handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			p, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				return newUnauthorizedErrorResponse()
+			}
+			ok, err := policy(p, r)
+			if err != nil {
+				return fmt.Errorf("Authorize() cannot authorize request; %v", err)
+			}
+			if !ok {
+				return service.ErrorResponse{
+					Title:  http.StatusText(http.StatusForbidden),
+					Status: http.StatusForbidden,
+				}
+			}
+			return prev.Handle(w, r)
+		})
+	}
+}
+
+func newUnauthorizedErrorResponse() service.ErrorResponse {
+	return service.ErrorResponse{
+		Title:  http.StatusText(http.StatusUnauthorized),
+		Status: http.StatusUnauthorized,
+	}
+}
@@ -0,0 +1,98 @@
+// +build !integration
+
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
+	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	p := auth.Principal{Role: auth.RoleBank, Subject: uuid.Must(uuid.NewV4())}
+	t.Run("adds the principal to the request context", func(t *testing.T) {
+		var got auth.Principal
+		h := middleware.Authenticate(authenticator{p: p, ok: true})(handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			got, _ = auth.PrincipalFromContext(r.Context())
+			return nil
+		}))
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil))
+		assert.MustE(t, got, p, "got principal %v, want %v")
+	})
+	t.Run("handles requests without credentials without principal", func(t *testing.T) {
+		ok := true
+		h := middleware.Authenticate(authenticator{})(handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			_, ok = auth.PrincipalFromContext(r.Context())
+			return nil
+		}))
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil))
+		assert.Must(t, !ok, "got principal, want none")
+	})
+	t.Run("responds with 401 if the credentials are invalid", func(t *testing.T) {
+		h, calls := counter(http.StatusOK)
+		w := httptest.NewRecorder()
+		err := middleware.Authenticate(authenticator{err: auth.ErrInvalidCredentials})(h).Handle(w, httptest.NewRequest("GET", "http://example.com", nil))
+		assert.MustNotErr(t, err, "got error %v, want nil")
+		assert.MustE(t, w.Code, http.StatusUnauthorized, "got status code %d, want %d")
+		assert.MustE(t, w.Header().Get("WWW-Authenticate"), "Bearer", "got header %q, want %q")
+		assert.MustE(t, *calls, 0, "got %d calls, want %d")
+	})
+	t.Run("responds with 500 if the authenticator fails", func(t *testing.T) {
+		h, calls := counter(http.StatusOK)
+		w := httptest.NewRecorder()
+		err := middleware.Authenticate(authenticator{err: errors.New("test authenticator failed")})(h).Handle(w, httptest.NewRequest("GET", "http://example.com", nil))
+		assert.MustErr(t, err, "got nil error, want error")
+		assert.MustE(t, w.Code, http.StatusInternalServerError, "got status code %d, want %d")
+		assert.MustE(t, *calls, 0, "got %d calls, want %d")
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	p := auth.Principal{Role: auth.RoleUser, Subject: uuid.Must(uuid.NewV4())}
+	allow := func(ok bool, err error) middleware.Policy {
+		return func(auth.Principal, *http.Request) (bool, error) {
+			return ok, err
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		policy middleware.Policy
+		ctx    bool
+		code   int
+	}{
+		{"handles the requests authorized by the policy", allow(true, nil), true, http.StatusOK},
+		{"returns 401 if the request is not authenticated", allow(true, nil), false, http.StatusUnauthorized},
+		{"returns 403 if the policy denies the request", allow(false, nil), true, http.StatusForbidden},
+		{"returns 500 if the policy fails", allow(true, errors.New("test policy failed")), true, http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, _ := counter(http.StatusOK)
+			h = middleware.Error()(middleware.Authorize(tc.policy)(h))
+			r := httptest.NewRequest("GET", "http://example.com", nil)
+			if tc.ctx {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+			}
+			w := httptest.NewRecorder()
+			h.Handle(w, r)
+			assert.MustE(t, w.Code, tc.code, "got status code %d, want %d")
+		})
+	}
+}
+
+type authenticator struct {
+	p   auth.Principal
+	ok  bool
+	err error
+}
+
+func (a authenticator) Authenticate(*http.Request) (auth.Principal, bool, error) {
+	return a.p, a.ok, a.err
+}
@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// IdempotencyKeyHeader is the request header with the idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is the response header, which is set when the response is replayed.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// IdempotentRequest is the record of a request with idempotency key.
+// The response is empty until the request is completed.
+type IdempotentRequest struct {
+	Fingerprint string
+	Completed   bool
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	// CreatedAt is the time, at which the key was reserved.
+	CreatedAt time.Time
+}
+
+// IdempotencyStore is an interface for persistence of requests with idempotency keys.
+// The keys are unique per caller.
+type IdempotencyStore interface {
+	// BeginIdempotentRequest reserves key of caller for a request with fingerprint. It takes over the key
+	// of a request with fingerprint, which is in progress since before staleBefore, e.g. because the server
+	// crashed while handling it. If the key is already reserved, it returns the existing record and false.
+	BeginIdempotentRequest(ctx context.Context, caller, key, fingerprint string, staleBefore time.Time) (IdempotentRequest, bool, error)
+	// CompleteIdempotentRequest stores the response of the request with key of caller.
+	CompleteIdempotentRequest(ctx context.Context, caller, key string, req IdempotentRequest) error
+	// ReleaseIdempotentRequest removes the reservation of key of caller, so the request can be retried.
+	ReleaseIdempotentRequest(ctx context.Context, caller, key string) error
+}
+
+// Idempotency makes POST requests with Idempotency-Key header safe to retry.
+// The response of the first request with a key is stored and replayed for the retries.
+// It returns 409 service.ErrorResponse if the same key of the caller is used for a request
+// with a different method, path or body, or while the first request is still in progress.
+// The key of a request, which is in progress longer than ttl, is taken over by its retry.
+// If the first request fails with an error or 5xx status code, the key is released, so the request
+// can be retried, and the error is returned to be rendered by the Error middleware.
+func Idempotency(store IdempotencyStore, caller func(*http.Request) string, ttl time.Duration) Middleware {
+	return func(prev handler.Handler) handler.Handler {
+		return handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if r.Method != http.MethodPost || len(key) == 0 {
+				return prev.Handle(w, r)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return service.NewValidationErrorResponse(
+					"The request has invalid header.",
+					service.InvalidParameter{
+						Name:   IdempotencyKeyHeader,
+						Reason: fmt.Sprintf("must not be longer than %d characters", maxIdempotencyKeyLength),
+					},
+				)
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return fmt.Errorf("Idempotency() cannot read request body; %v", err)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			id := caller(r)
+			fp := fingerprint(r, body)
+			stored, ok, err := store.BeginIdempotentRequest(r.Context(), id, key, fp, time.Now().Add(-ttl))
+			if err != nil {
+				return service.Wrap(err, "Idempotency() cannot begin request")
+			}
+			if !ok {
+				return replay(w, stored, fp)
+			}
+
+			rec := &responseRecorder{header: http.Header{}, statusCode: http.StatusOK}
+			// The reservation is completed or released even if the request is cancelled,
+			// otherwise the key would stay in progress.
+			ctx := context.Background()
+			if err := prev.Handle(rec, r); err != nil || rec.statusCode >= http.StatusInternalServerError {
+				if rErr := store.ReleaseIdempotentRequest(ctx, id, key); rErr != nil {
+					return fmt.Errorf("Idempotency() cannot release request; %v", rErr)
+				}
+				if err != nil {
+					return err
+				}
+			} else {
+				err := store.CompleteIdempotentRequest(ctx, id, key, IdempotentRequest{
+					Fingerprint: fp,
+					Completed:   true,
+					StatusCode:  rec.statusCode,
+					Header:      rec.header,
+					Body:        rec.body.Bytes(),
+				})
+				if err != nil {
+					return fmt.Errorf("Idempotency() cannot complete request; %v", err)
+				}
+			}
+			copyHeader(w.Header(), rec.header)
+			w.WriteHeader(rec.statusCode)
+			w.Write(rec.body.Bytes())
+			return nil
+		})
+	}
+}
+
+// replay writes the stored response of req to w.
+func replay(w http.ResponseWriter, req IdempotentRequest, fingerprint string) error {
+	if req.Fingerprint != fingerprint {
+		return service.ErrorResponse{
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: "The Idempotency-Key is already used for a different request.",
+		}
+	}
+	if !req.Completed {
+		return service.ErrorResponse{
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: "A request with the Idempotency-Key is in progress.",
+		}
+	}
+	copyHeader(w.Header(), req.Header)
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(req.StatusCode)
+	w.Write(req.Body)
+	return nil
+}
+
+// fingerprint returns the hash of the method, the path and the body of r.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.Path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+// responseRecorder is http.ResponseWriter, which records the response.
+type responseRecorder struct {
+	header      http.Header
+	statusCode  int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+// Header implements http.ResponseWriter.
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.statusCode = statusCode
+	rec.wroteHeader = true
+}
+
+// Write implements http.ResponseWriter.
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(b)
+}
+
+// MemoryIdempotencyStore is in-memory IdempotencyStore.
+type MemoryIdempotencyStore struct {
+	mu       sync.Mutex
+	requests map[[2]string]IdempotentRequest
+}
+
+var _ IdempotencyStore = &MemoryIdempotencyStore{}
+
+// NewMemoryIdempotencyStore returns new in-memory IdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{requests: map[[2]string]IdempotentRequest{}}
+}
+
+// BeginIdempotentRequest implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) BeginIdempotentRequest(_ context.Context, caller, key, fingerprint string, staleBefore time.Time) (IdempotentRequest, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[[2]string{caller, key}]
+	if ok && (req.Completed || req.Fingerprint != fingerprint || !req.CreatedAt.Before(staleBefore)) {
+		return req, false, nil
+	}
+	req = IdempotentRequest{Fingerprint: fingerprint, CreatedAt: time.Now()}
+	s.requests[[2]string{caller, key}] = req
+	return req, true, nil
+}
+
+// CompleteIdempotentRequest implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) CompleteIdempotentRequest(_ context.Context, caller, key string, req IdempotentRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[[2]string{caller, key}] = req
+	return nil
+}
+
+// ReleaseIdempotentRequest implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) ReleaseIdempotentRequest(_ context.Context, caller, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.requests, [2]string{caller, key})
+	return nil
+}
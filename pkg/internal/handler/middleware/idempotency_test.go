@@ -0,0 +1,193 @@
+// +build !integration
+
+package middleware_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestIdempotency(t *testing.T) {
+	t.Run("replays the response of the first request", func(t *testing.T) {
+		h, calls := counter(http.StatusCreated)
+		h = idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(h)
+
+		first := serve(t, h, "POST", "/foo", "key", "bar", nil)
+		second := serve(t, h, "POST", "/foo", "key", "bar", nil)
+
+		assert.MustE(t, *calls, 1, "got %d calls, want %d")
+		assert.MustE(t, first.Code, http.StatusCreated, "got status code %d, want %d")
+		assert.MustE(t, second.Code, http.StatusCreated, "got replayed status code %d, want %d")
+		assert.MustE(t, second.Body.String(), first.Body.String(), "got replayed body %q, want %q")
+		assert.MustE(t, second.Header().Get("X-Test"), "test", "got replayed header %q, want %q")
+		assert.MustE(t, second.Header().Get(middleware.IdempotentReplayedHeader), "true", "got replayed header %q, want %q")
+		assert.MustE(t, first.Header().Get(middleware.IdempotentReplayedHeader), "", "got replayed header %q, want %q")
+	})
+	t.Run("does not store error responses", func(t *testing.T) {
+		calls := 0
+		h := idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(handler.Func(func(http.ResponseWriter, *http.Request) error {
+			calls++
+			return service.NewValidationErrorResponse("foo")
+		}))
+
+		serve(t, h, "POST", "/foo", "key", "bar", nil)
+		res := serve(t, h, "POST", "/foo", "key", "bar", nil)
+
+		assert.MustE(t, calls, 2, "got %d calls, want %d")
+		assert.MustE(t, res.Code, http.StatusUnprocessableEntity, "got status code %d, want %d")
+		assert.MustE(t, res.Header().Get(middleware.IdempotentReplayedHeader), "", "got replayed header %q, want %q")
+	})
+	t.Run("scopes the keys to the caller", func(t *testing.T) {
+		h, calls := counter(http.StatusCreated)
+		h = idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(h)
+
+		serve(t, h, "POST", "/foo", "key", "bar", http.Header{"Authorization": {"a"}})
+		serve(t, h, "POST", "/foo", "key", "bar", http.Header{"Authorization": {"b"}})
+
+		assert.MustE(t, *calls, 2, "got %d calls, want %d")
+	})
+	t.Run("ignores requests without key and not POST requests", func(t *testing.T) {
+		h, calls := counter(http.StatusOK)
+		h = idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(h)
+
+		serve(t, h, "POST", "/foo", "", "bar", nil)
+		serve(t, h, "POST", "/foo", "", "bar", nil)
+		serve(t, h, "GET", "/foo", "key", "", nil)
+		serve(t, h, "GET", "/foo", "key", "", nil)
+
+		assert.MustE(t, *calls, 4, "got %d calls, want %d")
+	})
+	t.Run("returns 409 if the key is used for a different request", func(t *testing.T) {
+		h, calls := counter(http.StatusCreated)
+		h = idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(h)
+
+		serve(t, h, "POST", "/foo", "key", "bar", nil)
+		for _, req := range [][2]string{{"/foo", "baz"}, {"/bar", "bar"}} {
+			res := serve(t, h, "POST", req[0], "key", req[1], nil)
+			assert.MustE(t, res.Code, http.StatusConflict, "got status code %d, want %d")
+		}
+		assert.MustE(t, *calls, 1, "got %d calls, want %d")
+	})
+	t.Run("returns 409 while the first request is in progress", func(t *testing.T) {
+		store := middleware.NewMemoryIdempotencyStore()
+		var inner *httptest.ResponseRecorder
+		var h handler.Handler
+		h = idempotency(store, time.Minute)(handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			inner = serve(t, h, "POST", "/foo", "key", "bar", nil)
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}))
+
+		res := serve(t, h, "POST", "/foo", "key", "bar", nil)
+
+		assert.MustE(t, inner.Code, http.StatusConflict, "got status code %d of concurrent request, want %d")
+		assert.MustE(t, res.Code, http.StatusCreated, "got status code %d, want %d")
+	})
+	t.Run("takes over the key of the request in progress longer than the TTL", func(t *testing.T) {
+		store := middleware.NewMemoryIdempotencyStore()
+		var inner, other *httptest.ResponseRecorder
+		calls := 0
+		var h handler.Handler
+		h = idempotency(store, time.Millisecond)(handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			calls++
+			if calls == 1 {
+				time.Sleep(2 * time.Millisecond)
+				other = serve(t, h, "POST", "/foo", "key", "baz", nil)
+				inner = serve(t, h, "POST", "/foo", "key", "bar", nil)
+			}
+			w.WriteHeader(http.StatusCreated)
+			return nil
+		}))
+
+		serve(t, h, "POST", "/foo", "key", "bar", nil)
+
+		assert.MustE(t, calls, 2, "got %d calls, want %d")
+		assert.MustE(t, inner.Code, http.StatusCreated, "got status code %d of the retry, want %d")
+		assert.MustE(t, other.Code, http.StatusConflict, "got status code %d of the different request, want %d")
+	})
+	t.Run("releases the key if the request fails", func(t *testing.T) {
+		calls := 0
+		h := idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(handler.Func(func(http.ResponseWriter, *http.Request) error {
+			calls++
+			if calls == 1 {
+				return errors.New("test handler failed")
+			}
+			return nil
+		}))
+
+		first := serve(t, h, "POST", "/foo", "key", "bar", nil)
+		second := serve(t, h, "POST", "/foo", "key", "bar", nil)
+
+		assert.MustE(t, calls, 2, "got %d calls, want %d")
+		assert.MustE(t, first.Code, http.StatusInternalServerError, "got status code %d, want %d")
+		assert.MustE(t, second.Code, http.StatusOK, "got status code %d, want %d")
+	})
+	t.Run("returns 422 if the key is too long", func(t *testing.T) {
+		h, calls := counter(http.StatusCreated)
+		h = idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(h)
+
+		res := serve(t, h, "POST", "/foo", strings.Repeat("k", 256), "bar", nil)
+
+		assert.MustE(t, res.Code, http.StatusUnprocessableEntity, "got status code %d, want %d")
+		assert.MustE(t, *calls, 0, "got %d calls, want %d")
+	})
+	t.Run("passes the body to the wrapped handler", func(t *testing.T) {
+		var body string
+		h := idempotency(middleware.NewMemoryIdempotencyStore(), time.Minute)(handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			return nil
+		}))
+
+		serve(t, h, "POST", "/foo", "key", "bar", nil)
+
+		assert.MustE(t, body, "bar", "got body %q, want %q")
+	})
+}
+
+// idempotency returns the Idempotency middleware with store and ttl, which errors are rendered by the Error middleware.
+func idempotency(store middleware.IdempotencyStore, ttl time.Duration) middleware.Middleware {
+	return func(h handler.Handler) handler.Handler {
+		return middleware.Error()(middleware.Idempotency(store, func(r *http.Request) string {
+			return r.Header.Get("Authorization")
+		}, ttl)(h))
+	}
+}
+
+// counter returns handler, which writes response with status code and counts the calls.
+func counter(code int) (handler.Handler, *int) {
+	calls := 0
+	return handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+		calls++
+		w.Header().Set("X-Test", "test")
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "call %d", calls)
+		return nil
+	}), &calls
+}
+
+// serve handles request with method, path, idempotency key, body and header with h.
+func serve(t *testing.T, h handler.Handler, method, path, key, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body))
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	if len(key) > 0 {
+		r.Header.Set(middleware.IdempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.Handle(w, r)
+	return w
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
 	"github.com/sepetrov/prepaidcard/pkg/internal/service"
@@ -13,8 +15,13 @@ import (
 type Middleware func(handler.Handler) handler.Handler
 
 // Error handles error returned by the wrapped handler prev.
-// If the error is type service.ErrorResponse, it will be sent as a response.
-// For all other errors a generic 500 service.ErrorResponse will be sent.
+// If the error is type service.ErrorResponse, it will be sent as a response
+// and its instance defaults to the request path.
+// The errors classified by service.KindOf are sent as 404, 409 or 503 service.ErrorResponse.
+// For all other errors a generic 500 service.ErrorResponse will be sent, or
+// 503 service.ErrorResponse if the deadline of the request context is exceeded.
+// The errors, which are not service.ErrorResponse, are returned to be logged after
+// their response is written, and the outer Error middleware does not write them again.
 func Error() Middleware {
 	return func(prev handler.Handler) handler.Handler {
 		return handler.Func(func(w http.ResponseWriter, r *http.Request) error {
@@ -22,29 +29,117 @@ func Error() Middleware {
 			if err == nil {
 				return nil
 			}
+			if _, ok := err.(writtenError); ok {
+				return err
+			}
 
 			errRes, ok := err.(service.ErrorResponse)
 			if !ok {
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return err
+				if res, ok := newKindErrorResponse(service.KindOf(err)); ok {
+					if wErr := writeErrorResponse(w, r, res); wErr != nil {
+						return writtenError{fmt.Errorf("%v; %v", err, wErr)}
+					}
+					return writtenError{err}
+				}
 			}
-
-			j, err := errRes.MarshalJSON()
-			if err != nil {
+			if !ok && r.Context().Err() == context.DeadlineExceeded {
+				if wErr := writeErrorResponse(w, r, newTimeoutErrorResponse()); wErr != nil {
+					return writtenError{fmt.Errorf("%v; %v", err, wErr)}
+				}
+				return writtenError{err}
+			}
+			if !ok {
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return fmt.Errorf("got %#v.MarshalJSON() error %v; %T", errRes, err, prev)
+				return writtenError{err}
 			}
-
-			w.WriteHeader(errRes.StatusCode())
-			for k := range errRes.Headers() {
-				w.Header().Set(k, errRes.Headers().Get(k))
+			if err := writeErrorResponse(w, r, errRes); err != nil {
+				return writtenError{fmt.Errorf("%v; %T", err, prev)}
 			}
-			w.Write(j)
 			return nil
 		})
 	}
 }
 
+// writtenError is the error of a request, whose response is already written by Error.
+type writtenError struct {
+	error
+}
+
+// writeErrorResponse writes errRes to w. Its instance defaults to the path of r.
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, errRes service.ErrorResponse) error {
+	if len(errRes.Instance) == 0 {
+		errRes.Instance = r.URL.Path
+	}
+	j, err := errRes.MarshalJSON()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return fmt.Errorf("got %#v.MarshalJSON() error %v", errRes, err)
+	}
+
+	for k := range errRes.Headers() {
+		w.Header().Set(k, errRes.Headers().Get(k))
+	}
+	w.WriteHeader(errRes.StatusCode())
+	w.Write(j)
+	return nil
+}
+
+// Timeout cancels the context of the request after d.
+// If d is not positive, the request has no timeout.
+func Timeout(d time.Duration) Middleware {
+	return func(prev handler.Handler) handler.Handler {
+		if d <= 0 {
+			return prev
+		}
+		return handler.Func(func(w http.ResponseWriter, r *http.Request) error {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			return prev.Handle(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func newTimeoutErrorResponse() service.ErrorResponse {
+	return service.ErrorResponse{
+		Title:  http.StatusText(http.StatusServiceUnavailable),
+		Status: http.StatusServiceUnavailable,
+		Detail: "The request timed out.",
+	}
+}
+
+// retryAfter is the number of seconds, after which the client can retry the request
+// when the repository is unavailable.
+const retryAfter = "5"
+
+// newKindErrorResponse returns the error response for the errors of kind.
+// It returns false if the kind has no error response.
+func newKindErrorResponse(kind error) (service.ErrorResponse, bool) {
+	switch kind {
+	case service.ErrNotFound:
+		return service.NewNotFoundErrorResponse(), true
+	case service.ErrConflict, service.ErrConstraint:
+		return service.ErrorResponse{
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: "The request conflicts with the current state of the resource.",
+		}, true
+	case service.ErrConcurrentModification:
+		return service.ErrorResponse{
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: "The resource was modified concurrently.",
+		}, true
+	case service.ErrUnavailable:
+		return service.ErrorResponse{
+			Title:  http.StatusText(http.StatusServiceUnavailable),
+			Status: http.StatusServiceUnavailable,
+			Detail: "The service is temporarily unavailable.",
+			Header: http.Header{"Retry-After": []string{retryAfter}},
+		}, true
+	}
+	return service.ErrorResponse{}, false
+}
+
 // ErrorLog logs the error returned by the wrapped handler prev.
 func ErrorLog(logger *log.Logger) Middleware {
 	return func(prev handler.Handler) handler.Handler {
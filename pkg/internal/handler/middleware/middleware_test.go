@@ -12,9 +12,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sepetrov/prepaidcard/pkg/internal/handler"
 	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
 	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
 )
 
@@ -43,7 +45,61 @@ func TestError(t *testing.T) {
 		assert.MustE(t, strings.TrimSpace(string(body)), "Internal Server Error", "")
 	})
 	t.Run("renders the information from service.ErrorResponse error", func(t *testing.T) {
-		t.Skipf("TODO")
+		m := middleware.Error()
+
+		var h handler.Handler
+		h = handler.Func(func(w http.ResponseWriter, _ *http.Request) error {
+			return service.ErrorResponse{Title: "Foo", Status: 404, Detail: "bar"}
+		})
+		h = m(h)
+
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		w := httptest.NewRecorder()
+
+		th := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.Handle(w, r)
+		})
+		th(w, req)
+
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.MustE(t, resp.StatusCode, 404, "")
+		assert.MustE(t, resp.Header.Get("Content-Type"), "application/problem+json", "")
+		assert.MustE(t, strings.TrimSpace(string(body)), `{"title":"Foo","status":404,"detail":"bar","instance":"/foo"}`, "")
+	})
+	t.Run("renders the error response of the repository errors", func(t *testing.T) {
+		tests := []struct {
+			err        error
+			statusCode int
+			retryAfter string
+		}{
+			{service.ErrNotFound, 404, ""},
+			{service.Wrap(service.NewError(service.ErrConflict, errors.New("foo")), "bar"), 409, ""},
+			{service.NewError(service.ErrConstraint, errors.New("foo")), 409, ""},
+			{service.ErrConcurrentModification, 409, ""},
+			{service.NewError(service.ErrUnavailable, errors.New("foo")), 503, "5"},
+		}
+		for _, test := range tests {
+			h := middleware.Error()(handler.Func(func(w http.ResponseWriter, _ *http.Request) error {
+				return test.err
+			}))
+			w := httptest.NewRecorder()
+			err := h.Handle(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+			assert.MustE(t, err.Error(), test.err.Error(), "got error %q, want %q")
+			assert.MustE(t, w.Code, test.statusCode, "got status code %d, want %d")
+			assert.MustE(t, w.Header().Get("Content-Type"), "application/problem+json", "got Content-Type %q, want %q")
+			assert.MustE(t, w.Header().Get("Retry-After"), test.retryAfter, "got Retry-After %q, want %q")
+		}
+	})
+	t.Run("does not write the response of the nested Error middleware again", func(t *testing.T) {
+		h := middleware.Error()(middleware.Error()(handler.Func(func(w http.ResponseWriter, _ *http.Request) error {
+			return errors.New("foo")
+		})))
+		w := httptest.NewRecorder()
+		err := h.Handle(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+		assert.MustE(t, err.Error(), "foo", "got error %q, want %q")
+		assert.MustE(t, w.Body.String(), "Internal Server Error\n", "got body %q, want %q")
 	})
 	t.Run("does nothing if the handle does not return error", func(t *testing.T) {
 		m := middleware.Error()
@@ -71,6 +127,40 @@ func TestError(t *testing.T) {
 	})
 }
 
+func TestTimeout(t *testing.T) {
+	t.Run("sets the deadline of the request context", func(t *testing.T) {
+		var deadline time.Time
+		var ok bool
+		h := middleware.Timeout(time.Minute)(handler.Func(func(_ http.ResponseWriter, r *http.Request) error {
+			deadline, ok = r.Context().Deadline()
+			return nil
+		}))
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/foo", nil))
+		assert.Must(t, ok, "got no deadline, want deadline")
+		assert.Must(t, time.Until(deadline) <= time.Minute, "got deadline %v, want within a minute", deadline)
+	})
+	t.Run("does not set deadline if the timeout is not positive", func(t *testing.T) {
+		ok := true
+		h := middleware.Timeout(0)(handler.Func(func(_ http.ResponseWriter, r *http.Request) error {
+			_, ok = r.Context().Deadline()
+			return nil
+		}))
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/foo", nil))
+		assert.Must(t, !ok, "got deadline, want none")
+	})
+	t.Run("Error renders 503 if the deadline is exceeded", func(t *testing.T) {
+		h := middleware.Timeout(time.Nanosecond)(middleware.Error()(handler.Func(func(_ http.ResponseWriter, r *http.Request) error {
+			<-r.Context().Done()
+			return r.Context().Err()
+		})))
+		w := httptest.NewRecorder()
+		err := h.Handle(w, httptest.NewRequest("GET", "http://example.com/foo", nil))
+		assert.MustErr(t, err, "got nil error, want error")
+		assert.MustE(t, w.Code, http.StatusServiceUnavailable, "got status code %d, want %d")
+		assert.MustE(t, w.Header().Get("Content-Type"), "application/problem+json", "got Content-Type %q, want %q")
+	})
+}
+
 func TestErrorLog(t *testing.T) {
 	b := &bytes.Buffer{}
 	l := log.New(b, "", 0)
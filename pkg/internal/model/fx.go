@@ -0,0 +1,138 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// fxRatePrecision is the number of decimal places of the exchange rates.
+const fxRatePrecision = 10
+
+// FXRateProvider is an interface for retrieval of exchange rates.
+type FXRateProvider interface {
+	// FXRate returns the rate for conversion from currency from to currency to.
+	FXRate(from, to Currency) (FXRate, error)
+}
+
+// FXRate is the exchange rate of a currency pair. One major unit of From is
+// Rate major units of To. The rate is rounded to 10 decimal places, so its
+// decimal representation is exact.
+type FXRate struct {
+	from Currency
+	to   Currency
+	rate *big.Rat
+}
+
+// NewFXRate returns the exchange rate from currency from to currency to, where rate is a positive decimal number.
+func NewFXRate(from, to Currency, rate string) (FXRate, error) {
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok {
+		return FXRate{}, fmt.Errorf("rate %q must be decimal number", rate)
+	}
+	return newFXRate(from, to, r)
+}
+
+func newFXRate(from, to Currency, rate *big.Rat) (FXRate, error) {
+	if _, ok := exponents[from]; !ok {
+		return FXRate{}, fmt.Errorf("currency %q is not supported", from)
+	}
+	if _, ok := exponents[to]; !ok {
+		return FXRate{}, fmt.Errorf("currency %q is not supported", to)
+	}
+	r := roundRat(rate, fxRatePrecision)
+	if r.Sign() <= 0 {
+		return FXRate{}, errors.New("rate must be greater than zero")
+	}
+	return FXRate{from, to, r}, nil
+}
+
+// identityFXRate returns the rate for conversion of currency to itself.
+func identityFXRate(currency Currency) FXRate {
+	return FXRate{currency, currency, big.NewRat(1, 1)}
+}
+
+// From returns the currency, which is converted.
+func (r FXRate) From() Currency {
+	return r.from
+}
+
+// To returns the currency of the converted amounts.
+func (r FXRate) To() Currency {
+	return r.to
+}
+
+// Rate returns the decimal representation of the rate.
+func (r FXRate) Rate() string {
+	if r.rate == nil {
+		return ""
+	}
+	s := r.rate.FloatString(fxRatePrecision)
+	for s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// Inverse returns the rate for conversion from To to From.
+func (r FXRate) Inverse() (FXRate, error) {
+	return newFXRate(r.to, r.from, new(big.Rat).Inv(r.rate))
+}
+
+// Convert returns amount minor units of From in minor units of To rounded half up.
+func (r FXRate) Convert(amount uint64) (uint64, error) {
+	e := r.to.Exponent() - r.from.Exponent()
+	v := new(big.Rat).Mul(new(big.Rat).SetInt(new(big.Int).SetUint64(amount)), r.rate)
+	if e > 0 {
+		v.Mul(v, new(big.Rat).SetInt(pow10(e)))
+	} else if e < 0 {
+		v.Quo(v, new(big.Rat).SetInt(pow10(-e)))
+	}
+	n := roundRat(v, 0).Num()
+	if !n.IsUint64() {
+		return 0, fmt.Errorf("converted amount cannot exceed %d", uint64(math.MaxUint64))
+	}
+	return n.Uint64(), nil
+}
+
+// WithMarkup returns provider p, which increases the rates of p with markup,
+// e.g. "0.0275" for 2.75%.
+func WithMarkup(p FXRateProvider, markup string) (FXRateProvider, error) {
+	m, ok := new(big.Rat).SetString(markup)
+	if !ok || m.Sign() < 0 {
+		return nil, fmt.Errorf("markup %q must be non-negative decimal number", markup)
+	}
+	return &markupProvider{p, new(big.Rat).Add(big.NewRat(1, 1), m)}, nil
+}
+
+type markupProvider struct {
+	provider FXRateProvider
+	factor   *big.Rat
+}
+
+// FXRate implements FXRateProvider.
+func (p *markupProvider) FXRate(from, to Currency) (FXRate, error) {
+	r, err := p.provider.FXRate(from, to)
+	if err != nil {
+		return FXRate{}, err
+	}
+	return newFXRate(r.from, r.to, new(big.Rat).Mul(r.rate, p.factor))
+}
+
+// roundRat returns v rounded half up to decimals places.
+func roundRat(v *big.Rat, decimals int) *big.Rat {
+	scale := pow10(decimals)
+	n := new(big.Int).Mul(v.Num(), scale)
+	n.Mul(n, big.NewInt(2))
+	n.Add(n, v.Denom())
+	n.Div(n, new(big.Int).Mul(v.Denom(), big.NewInt(2)))
+	return new(big.Rat).SetFrac(n, scale)
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
@@ -0,0 +1,173 @@
+// +build !integration
+
+package model_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestNewFXRate(t *testing.T) {
+	t.Run("returns the rate rounded to 10 decimal places", func(t *testing.T) {
+		for in, want := range map[string]string{"1.1734": "1.1734", "2": "2", "0.12345678905": "0.1234567891"} {
+			r, err := model.NewFXRate(model.EUR, model.GBP, in)
+			h.MustNotErr(t, err, "NewFXRate(%q) = %v; want nil", in)
+			h.MustE(t, r.Rate(), want, "r.Rate() = %q; want %q")
+			h.MustE(t, r.From(), model.EUR, "r.From() = %q; want %q")
+			h.MustE(t, r.To(), model.GBP, "r.To() = %q; want %q")
+		}
+	})
+	t.Run("returns error if the rate is invalid", func(t *testing.T) {
+		for _, in := range []string{"", "foo", "0", "-1.5", "0.00000000001"} {
+			_, err := model.NewFXRate(model.EUR, model.GBP, in)
+			h.MustErr(t, err, "NewFXRate(%q) = nil; want error", in)
+		}
+		_, err := model.NewFXRate("XXX", model.GBP, "1")
+		h.MustErr(t, err, "NewFXRate(XXX, GBP) = nil; want error")
+	})
+}
+
+func TestFXRate_Convert(t *testing.T) {
+	for _, tc := range []struct {
+		from, to model.Currency
+		rate     string
+		amount   uint64
+		want     uint64
+	}{
+		{model.EUR, model.GBP, "0.85", 1000, 850},
+		{model.EUR, model.GBP, "0.85", 333, 283},
+		{model.EUR, model.GBP, "0.85", 1, 1},
+		{model.JPY, model.GBP, "0.0053", 1000, 530},
+		{model.GBP, model.JPY, "188.5", 199, 375},
+		{model.BHD, model.GBP, "2.1", 1000, 210},
+		{model.GBP, model.KWD, "0.38", 100, 380},
+	} {
+		r, err := model.NewFXRate(tc.from, tc.to, tc.rate)
+		h.MustNotErr(t, err, "%v")
+		got, err := r.Convert(tc.amount)
+		h.MustNotErr(t, err, "r.Convert() = %v; want nil")
+		if got != tc.want {
+			t.Errorf("Convert(%d %s) with %s/%s %s = %d; want %d", tc.amount, tc.from, tc.from, tc.to, tc.rate, got, tc.want)
+		}
+	}
+	r, err := model.NewFXRate(model.GBP, model.EUR, "2")
+	h.MustNotErr(t, err, "%v")
+	_, err = r.Convert(math.MaxUint64)
+	h.MustErr(t, err, "r.Convert(math.MaxUint64) = nil; want error")
+}
+
+func TestWithMarkup(t *testing.T) {
+	p, err := model.WithMarkup(rates{"EUR/GBP": "0.85"}, "0.02")
+	h.MustNotErr(t, err, "WithMarkup() = %v; want nil")
+	r, err := p.FXRate(model.EUR, model.GBP)
+	h.MustNotErr(t, err, "p.FXRate() = %v; want nil")
+	h.MustE(t, r.Rate(), "0.867", "r.Rate() = %q; want %q")
+	_, err = p.FXRate(model.USD, model.GBP)
+	h.MustErr(t, err, "p.FXRate(USD, GBP) = nil; want error")
+	for _, m := range []string{"", "foo", "-0.01"} {
+		_, err := model.WithMarkup(rates{}, m)
+		h.MustErr(t, err, "WithMarkup(%q) = nil; want error", m)
+	}
+}
+
+func TestNewAuthorizationRequest_FX(t *testing.T) {
+	t.Run("converts the amount to the card currency", func(t *testing.T) {
+		c, req := mustCardWithFXAuthorizationRequest(t, 1000, 1000, "0.85")
+		h.MustE(t, req.Currency(), model.EUR, "req.Currency() = %q; want %q")
+		h.MustE(t, req.Rate().Rate(), "0.85", "req.Rate() = %q; want %q")
+		h.MustE(t, req.OriginalAmount(), uint64(1000), "req.OriginalAmount() = %d; want %d")
+		h.MustE(t, req.ConvertedAmount(), uint64(850), "req.ConvertedAmount() = %d; want %d")
+		h.MustE(t, req.OriginalBlockedAmount(), uint64(1000), "req.OriginalBlockedAmount() = %d; want %d")
+		h.MustE(t, req.History()[0].Rate(), req.Rate(), "s.Rate() = %v; want %v")
+		h.MustE(t, req.History()[0].OriginalBlockedAmount(), uint64(1000), "s.OriginalBlockedAmount() = %d; want %d")
+		assertAuthorizationRequestBalance(t, req, 850, 0, 0)
+		assertCardBalance(t, c, 150, 850)
+	})
+	t.Run("returns error if the amount cannot be converted", func(t *testing.T) {
+		for _, p := range []model.FXRateProvider{nil, rates{}, rates{"GBP/EUR": "1.2"}, errRates{}} {
+			c := mustCard(t, 1000, 0)
+			_, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(100, model.EUR), p, time.Now())
+			h.MustErr(t, err, "NewAuthorizationRequest() with %v = nil; want error", p)
+			assertCardBalance(t, c, 1000, 0)
+		}
+	})
+	t.Run("returns error if the converted amount exceeds the available balance", func(t *testing.T) {
+		c := mustCard(t, 850, 0)
+		_, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(1001, model.EUR), rates{"EUR/GBP": "0.85"}, time.Now())
+		h.MustErr(t, err, "NewAuthorizationRequest() = nil; want error")
+	})
+}
+
+func TestAuthorizationRequest_FX(t *testing.T) {
+	t.Run("captures and refunds with the locked rate", func(t *testing.T) {
+		c, req := mustCardWithFXAuthorizationRequest(t, 1000, 1000, "0.85")
+		h.MustNotErr(t, req.Capture(c, 333, time.Now()), "req.Capture(333) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 567, 283, 0)
+		h.MustNotErr(t, req.Capture(c, 667, time.Now()), "req.Capture(667) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 850, 0)
+		assertCardBalance(t, c, 150, 0)
+		h.MustE(t, req.OriginalCapturedAmount(), uint64(1000), "req.OriginalCapturedAmount() = %d; want %d")
+		h.MustNotErr(t, req.Refund(c, 1, time.Now()), "req.Refund(1) = %v; want nil")
+		h.MustNotErr(t, req.Refund(c, 999, time.Now()), "req.Refund(999) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 850, 850)
+		assertCardBalance(t, c, 1000, 0)
+		h.MustE(t, req.OriginalRefundedAmount(), uint64(1000), "req.OriginalRefundedAmount() = %d; want %d")
+		h.MustErr(t, req.Refund(c, 1, time.Now()), "req.Refund(1) = nil; want error")
+		s := req.History()[len(req.History())-1]
+		h.MustE(t, s.OriginalRefundedAmount(), uint64(1000), "s.OriginalRefundedAmount() = %d; want %d")
+		h.MustE(t, s.RefundedAmount(), uint64(850), "s.RefundedAmount() = %d; want %d")
+	})
+	t.Run("reverses with the locked rate", func(t *testing.T) {
+		c, req := mustCardWithFXAuthorizationRequest(t, 1000, 1000, "0.85")
+		h.MustNotErr(t, req.Reverse(c, 500, time.Now()), "req.Reverse(500) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 425, 0, 0)
+		h.MustErr(t, req.Reverse(c, 501, time.Now()), "req.Reverse(501) = nil; want error")
+		h.MustNotErr(t, req.Reverse(c, 500, time.Now()), "req.Reverse(500) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 0, 0)
+		assertCardBalance(t, c, 1000, 0)
+	})
+	t.Run("never charges more than the converted amount", func(t *testing.T) {
+		c, req := mustCardWithFXAuthorizationRequest(t, 1000, 3, "0.5")
+		assertAuthorizationRequestBalance(t, req, 2, 0, 0)
+		for i := 0; i < 3; i++ {
+			h.MustNotErr(t, req.Capture(c, 1, time.Now()), "req.Capture(1) = %v; want nil")
+		}
+		assertAuthorizationRequestBalance(t, req, 0, 2, 0)
+		h.MustE(t, req.OriginalCapturedAmount(), uint64(3), "req.OriginalCapturedAmount() = %d; want %d")
+		assertCardBalance(t, c, 998, 0)
+	})
+}
+
+// mustCardWithFXAuthorizationRequest returns GBP card with balance and authorization request of amount EUR with rate.
+func mustCardWithFXAuthorizationRequest(t *testing.T, balance, amount uint64, rate string) (*model.Card, *model.AuthorizationRequest) {
+	t.Helper()
+	c := mustCard(t, balance, 0)
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(amount, model.EUR), rates{"EUR/GBP": rate}, time.Now())
+	h.MustNotErr(t, err, "NewAuthorizationRequest() = %v; want nil")
+	return c, req
+}
+
+// rates is FXRateProvider with rates like {"EUR/GBP": "0.85"}.
+type rates map[string]string
+
+func (r rates) FXRate(from, to model.Currency) (model.FXRate, error) {
+	rate, ok := r[string(from)+"/"+string(to)]
+	if !ok {
+		return model.FXRate{}, errors.New("rate not found")
+	}
+	return model.NewFXRate(from, to, rate)
+}
+
+// errRates is FXRateProvider returning rates of wrong currency pair.
+type errRates struct{}
+
+func (errRates) FXRate(from, to model.Currency) (model.FXRate, error) {
+	return model.NewFXRate(to, from, "1")
+}
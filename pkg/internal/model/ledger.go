@@ -0,0 +1,90 @@
+package model
+
+import (
+	"github.com/gofrs/uuid"
+)
+
+// AccountType is the type of a ledger account.
+type AccountType string
+
+// The types of the ledger accounts.
+const (
+	// AccountCardAvailable holds the available balance of a card.
+	AccountCardAvailable AccountType = "card_available"
+	// AccountCardBlocked holds the blocked balance of a card.
+	AccountCardBlocked AccountType = "card_blocked"
+	// AccountMerchantSettlement holds the money captured by a merchant, which is not settled yet.
+	AccountMerchantSettlement AccountType = "merchant_settlement"
+	// AccountBankFloat holds the money loaded onto or paid out from the cards.
+	AccountBankFloat AccountType = "bank_float"
+	// AccountFees holds the fees charged by the bank.
+	AccountFees AccountType = "fees"
+)
+
+// Account is a ledger account in a single currency. The accounts of cards and
+// merchants are owned by the card or the merchant. The bank accounts are owned by uuid.Nil.
+type Account struct {
+	accountType AccountType
+	ownerUUID   uuid.UUID
+	currency    Currency
+}
+
+// NewAccount returns the account in currency with accountType owned by owner.
+func NewAccount(accountType AccountType, owner uuid.UUID, currency Currency) Account {
+	return Account{accountType, owner, currency}
+}
+
+// Type returns the account type.
+func (a Account) Type() AccountType {
+	return a.accountType
+}
+
+// OwnerUUID returns the UUID of the account owner.
+func (a Account) OwnerUUID() uuid.UUID {
+	return a.ownerUUID
+}
+
+// Currency returns the currency.
+func (a Account) Currency() Currency {
+	return a.currency
+}
+
+// Direction is the side of the ledger account, which a posting is recorded to.
+type Direction string
+
+// The directions of the postings.
+const (
+	Debit  Direction = "debit"
+	Credit Direction = "credit"
+)
+
+// Posting is an entry of amount to the debit or the credit side of a ledger account.
+// The balance of an account is the sum of its credits minus the sum of its debits.
+type Posting struct {
+	account   Account
+	direction Direction
+	amount    uint64
+}
+
+// Account returns the account.
+func (p Posting) Account() Account {
+	return p.account
+}
+
+// Direction returns the direction.
+func (p Posting) Direction() Direction {
+	return p.direction
+}
+
+// Amount returns the amount.
+func (p Posting) Amount() uint64 {
+	return p.amount
+}
+
+// transfer returns the balanced postings of amount moved from account from to account to.
+func transfer(from, to Account, amount uint64) []Posting {
+	return []Posting{
+		{account: from, direction: Debit, amount: amount},
+		{account: to, direction: Credit, amount: amount},
+	}
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+)
+
+// DefaultProduct is the product of the cards, which are issued without product.
+const DefaultProduct = "standard"
+
+// The names of the limits, which are reported by LimitExceededError.
+const (
+	LimitPerTransaction = "perTransaction"
+	LimitDaily          = "daily"
+	LimitMonthly        = "monthly"
+	LimitAuthorizations = "authorizations"
+	LimitDailyLoad      = "dailyLoad"
+)
+
+// Limits are the spending limits and the velocity controls of a card. The amounts are in minor units
+// of the currency of the card and the limits, which are zero, are not enforced. The days and the months
+// are in UTC.
+type Limits struct {
+	// PerTransaction is the maximum amount of an authorization request.
+	PerTransaction uint64
+	// Daily is the maximum amount authorized per day.
+	Daily uint64
+	// Monthly is the maximum amount authorized per month.
+	Monthly uint64
+	// Authorizations is the maximum number of authorization requests per AuthorizationWindow.
+	Authorizations uint64
+	// AuthorizationWindow is the rolling window of Authorizations.
+	AuthorizationWindow time.Duration
+	// DailyLoad is the maximum amount loaded per day.
+	DailyLoad uint64
+}
+
+// Override returns l with the limits of o, which are not zero.
+func (l Limits) Override(o Limits) Limits {
+	if o.PerTransaction > 0 {
+		l.PerTransaction = o.PerTransaction
+	}
+	if o.Daily > 0 {
+		l.Daily = o.Daily
+	}
+	if o.Monthly > 0 {
+		l.Monthly = o.Monthly
+	}
+	if o.Authorizations > 0 {
+		l.Authorizations = o.Authorizations
+	}
+	if o.AuthorizationWindow > 0 {
+		l.AuthorizationWindow = o.AuthorizationWindow
+	}
+	if o.DailyLoad > 0 {
+		l.DailyLoad = o.DailyLoad
+	}
+	return l
+}
+
+// Validate returns error if the number of authorizations is limited without window.
+func (l Limits) Validate() error {
+	if l.Authorizations > 0 && l.AuthorizationWindow <= 0 {
+		return fmt.Errorf("the %s limit requires positive window", LimitAuthorizations)
+	}
+	return nil
+}
+
+// Since returns the date of the oldest transaction, which l is checked against at now.
+func (l Limits) Since(now time.Time) time.Time {
+	now = now.UTC()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if w := now.Add(-l.AuthorizationWindow); w.Before(since) {
+		since = w
+	}
+	return since
+}
+
+// CheckAuthorization returns *LimitExceededError if an authorization request of amount at now exceeds l.
+// The card transactions txs must include the transactions since l.Since(now).
+func (l Limits) CheckAuthorization(txs []*Transaction, amount uint64, now time.Time) error {
+	if l.PerTransaction > 0 && amount > l.PerTransaction {
+		return &LimitExceededError{LimitPerTransaction, l.PerTransaction}
+	}
+	if l.Authorizations > 0 {
+		from := now.Add(-l.AuthorizationWindow)
+		var n uint64
+		for _, tx := range txs {
+			if tx.EventType() == event.TypeAuthorizationRequestCreated && tx.Date().After(from) {
+				n++
+			}
+		}
+		if n >= l.Authorizations {
+			return &LimitExceededError{LimitAuthorizations, l.Authorizations}
+		}
+	}
+	return l.CheckIncrement(txs, amount, now)
+}
+
+// CheckIncrement returns *LimitExceededError if the increment of an authorization request with amount at now
+// exceeds the daily or the monthly limit of l. The amounts authorized since the start of the day or the month
+// are the amounts of the authorization requests and their increments without the reversed and the expired amounts.
+// The card transactions txs must include the transactions since l.Since(now).
+func (l Limits) CheckIncrement(txs []*Transaction, amount uint64, now time.Time) error {
+	day, month := startOfDay(now), startOfMonth(now)
+	if l.Daily > 0 && !withinLimit(spent(txs, day), amount, l.Daily) {
+		return &LimitExceededError{LimitDaily, l.Daily}
+	}
+	if l.Monthly > 0 && !withinLimit(spent(txs, month), amount, l.Monthly) {
+		return &LimitExceededError{LimitMonthly, l.Monthly}
+	}
+	return nil
+}
+
+// CheckLoad returns *LimitExceededError if a load of amount at now exceeds the daily load limit of l.
+// The card transactions txs must include the transactions since l.Since(now).
+func (l Limits) CheckLoad(txs []*Transaction, amount uint64, now time.Time) error {
+	if l.DailyLoad == 0 {
+		return nil
+	}
+	day := startOfDay(now)
+	var loaded uint64
+	for _, tx := range txs {
+		if tx.EventType() == event.TypeCardLoaded && !tx.Date().Before(day) {
+			loaded += tx.Amount()
+		}
+	}
+	if !withinLimit(loaded, amount, l.DailyLoad) {
+		return &LimitExceededError{LimitDailyLoad, l.DailyLoad}
+	}
+	return nil
+}
+
+// spent returns the amount authorized with txs since from. The reversed and the expired amounts are deducted
+// only from the amounts of the same authorization request authorized since from, so releasing an authorization
+// request authorized before from does not reduce the amount spent since from. The transactions recorded without
+// the authorization request UUID are netted together.
+func spent(txs []*Transaction, from time.Time) uint64 {
+	authorized := map[uuid.UUID]uint64{}
+	released := map[uuid.UUID]uint64{}
+	for _, tx := range txs {
+		if tx.Date().Before(from) {
+			continue
+		}
+		switch tx.EventType() {
+		case event.TypeAuthorizationRequestCreated, event.TypeAuthorizationRequestIncremented:
+			authorized[tx.AuthorizationRequestUUID()] += tx.Amount()
+		case event.TypeAuthorizationRequestReversed, event.TypeAuthorizationRequestExpired:
+			released[tx.AuthorizationRequestUUID()] += tx.Amount()
+		}
+	}
+	var total uint64
+	for id, amount := range authorized {
+		if r := released[id]; r < amount {
+			total += amount - r
+		}
+	}
+	return total
+}
+
+// withinLimit returns true if used and amount together do not exceed limit.
+func withinLimit(used, amount, limit uint64) bool {
+	return used <= limit && amount <= limit-used
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// LimitExceededError is returned when a transaction exceeds a limit of the card.
+type LimitExceededError struct {
+	// Limit is the name of the exceeded limit, e.g. LimitDaily.
+	Limit string
+	// Max is the value of the exceeded limit.
+	Max uint64
+}
+
+// Error implements error.
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("the %s limit of %d is exceeded", e.Limit, e.Max)
+}
+
+// ProductLimits are the default limits of the cards by product.
+type ProductLimits map[string]Limits
+
+// Limits returns the default limits of the cards of product. The limits of the products,
+// which are not in p, are not enforced.
+func (p ProductLimits) Limits(product string) Limits {
+	return p[product]
+}
+
+// Has returns true if product is DefaultProduct or it is in p.
+func (p ProductLimits) Has(product string) bool {
+	_, ok := p[product]
+	return ok || product == DefaultProduct
+}
+
+// Of returns the limits of a card with limits c, which are the defaults of its product overridden by its own limits.
+func (p ProductLimits) Of(c CardLimits) Limits {
+	return p.Limits(c.Product).Override(c.Limits)
+}
+
+// CardLimits are the product of a card and its own limits, which override the default limits of the product.
+type CardLimits struct {
+	Product string
+	Limits  Limits
+}
@@ -0,0 +1,143 @@
+// +build !integration
+
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+var now = time.Date(2019, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+func TestLimits_CheckAuthorization(t *testing.T) {
+	t.Run("does not enforce zero limits", func(t *testing.T) {
+		txs := []*model.Transaction{mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-time.Minute), 1000)}
+		h.MustNotErr(t, model.Limits{}.CheckAuthorization(txs, 1000, now), "CheckAuthorization() = %v; want nil")
+	})
+	t.Run("limits the amount per transaction", func(t *testing.T) {
+		l := model.Limits{PerTransaction: 100}
+		h.MustNotErr(t, l.CheckAuthorization(nil, 100, now), "CheckAuthorization(100) = %v; want nil")
+		assertLimitExceeded(t, l.CheckAuthorization(nil, 101, now), model.LimitPerTransaction)
+	})
+	t.Run("limits the number of authorizations per rolling window", func(t *testing.T) {
+		l := model.Limits{Authorizations: 2, AuthorizationWindow: time.Hour}
+		txs := []*model.Transaction{
+			mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-time.Hour), 1),
+			mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-30*time.Minute), 1),
+			mustTransaction(event.TypeAuthorizationRequestIncremented, now.Add(-20*time.Minute), 1),
+		}
+		h.MustNotErr(t, l.CheckAuthorization(txs, 1, now), "CheckAuthorization() = %v; want nil")
+		txs = append(txs, mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-time.Minute), 1))
+		assertLimitExceeded(t, l.CheckAuthorization(txs, 1, now), model.LimitAuthorizations)
+	})
+	t.Run("limits the amount authorized per day", func(t *testing.T) {
+		l := model.Limits{Daily: 100}
+		txs := []*model.Transaction{
+			mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-13*time.Hour), 90),
+			mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-time.Hour), 50),
+			mustTransaction(event.TypeAuthorizationRequestIncremented, now.Add(-time.Minute), 30),
+			mustTransaction(event.TypeAuthorizationRequestReversed, now.Add(-time.Minute), 10),
+		}
+		h.MustNotErr(t, l.CheckAuthorization(txs, 30, now), "CheckAuthorization(30) = %v; want nil")
+		assertLimitExceeded(t, l.CheckAuthorization(txs, 31, now), model.LimitDaily)
+	})
+	t.Run("does not deduct the release of an authorization request authorized before the day", func(t *testing.T) {
+		l := model.Limits{Daily: 100}
+		before, today := uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4())
+		txs := []*model.Transaction{
+			mustAuthorizationTransaction(before, event.TypeAuthorizationRequestCreated, now.Add(-13*time.Hour), 90),
+			mustAuthorizationTransaction(today, event.TypeAuthorizationRequestCreated, now.Add(-time.Hour), 60),
+			mustAuthorizationTransaction(before, event.TypeAuthorizationRequestReversed, now.Add(-30*time.Minute), 90),
+			mustAuthorizationTransaction(today, event.TypeAuthorizationRequestReversed, now.Add(-time.Minute), 20),
+		}
+		h.MustNotErr(t, l.CheckAuthorization(txs, 60, now), "CheckAuthorization(60) = %v; want nil")
+		assertLimitExceeded(t, l.CheckAuthorization(txs, 61, now), model.LimitDaily)
+	})
+	t.Run("limits the amount authorized per month", func(t *testing.T) {
+		l := model.Limits{Monthly: 100}
+		txs := []*model.Transaction{
+			mustTransaction(event.TypeAuthorizationRequestCreated, now.AddDate(0, 0, -15), 90),
+			mustTransaction(event.TypeAuthorizationRequestCreated, now.AddDate(0, 0, -14), 60),
+			mustTransaction(event.TypeAuthorizationRequestExpired, now.AddDate(0, 0, -7), 20),
+		}
+		h.MustNotErr(t, l.CheckAuthorization(txs, 60, now), "CheckAuthorization(60) = %v; want nil")
+		h.MustE(t, l.Since(now), time.Date(2019, time.March, 1, 0, 0, 0, 0, time.UTC), "Since() = %v; want %v")
+		assertLimitExceeded(t, l.CheckAuthorization(txs, 61, now), model.LimitMonthly)
+	})
+}
+
+func TestLimits_CheckIncrement(t *testing.T) {
+	l := model.Limits{PerTransaction: 10, Daily: 100, Authorizations: 1, AuthorizationWindow: time.Hour}
+	txs := []*model.Transaction{mustTransaction(event.TypeAuthorizationRequestCreated, now.Add(-time.Minute), 10)}
+	h.MustNotErr(t, l.CheckIncrement(txs, 90, now), "CheckIncrement(90) = %v; want nil")
+	assertLimitExceeded(t, l.CheckIncrement(txs, 91, now), model.LimitDaily)
+}
+
+func TestLimits_CheckLoad(t *testing.T) {
+	l := model.Limits{DailyLoad: 100}
+	txs := []*model.Transaction{
+		mustTransaction(event.TypeCardLoaded, now.Add(-13*time.Hour), 100),
+		mustTransaction(event.TypeCardLoaded, now.Add(-time.Hour), 60),
+		mustTransaction(event.TypeAuthorizationRequestReversed, now.Add(-time.Minute), 60),
+	}
+	h.MustNotErr(t, l.CheckLoad(txs, 40, now), "CheckLoad(40) = %v; want nil")
+	assertLimitExceeded(t, l.CheckLoad(txs, 41, now), model.LimitDailyLoad)
+	h.MustNotErr(t, model.Limits{}.CheckLoad(txs, 1000, now), "CheckLoad() without limit = %v; want nil")
+}
+
+func TestLimits_Override(t *testing.T) {
+	defaults := model.Limits{PerTransaction: 100, Daily: 500, Authorizations: 5, AuthorizationWindow: time.Hour}
+	l := defaults.Override(model.Limits{Daily: 200, DailyLoad: 1000})
+	h.MustE(t, l, model.Limits{PerTransaction: 100, Daily: 200, Authorizations: 5, AuthorizationWindow: time.Hour, DailyLoad: 1000}, "Override() = %+v; want %+v")
+	h.MustErr(t, model.Limits{Authorizations: 5}.Validate(), "Validate() = nil without window; want error")
+	h.MustNotErr(t, l.Validate(), "Validate() = %v; want nil")
+}
+
+func TestProductLimits(t *testing.T) {
+	p := model.ProductLimits{"travel": {Daily: 500}}
+	h.Must(t, p.Has("travel"), "p.Has(travel) = false; want true")
+	h.Must(t, p.Has(model.DefaultProduct), "p.Has(DefaultProduct) = false; want true")
+	h.Must(t, !p.Has("gold"), "p.Has(gold) = true; want false")
+	h.MustE(t, p.Of(model.CardLimits{Product: "travel", Limits: model.Limits{Monthly: 900}}), model.Limits{Daily: 500, Monthly: 900}, "p.Of() = %+v; want %+v")
+	h.MustE(t, p.Of(model.CardLimits{Product: model.DefaultProduct}), model.Limits{}, "p.Of() = %+v; want %+v")
+}
+
+func assertLimitExceeded(t *testing.T, err error, limit string) {
+	t.Helper()
+	e, ok := err.(*model.LimitExceededError)
+	h.Must(t, ok, "got error %#v, want *model.LimitExceededError", err)
+	h.MustE(t, e.Limit, limit, "got exceeded limit %q, want %q")
+}
+
+// transaction implements model.TransactionData.
+type transaction struct {
+	authReqUUID uuid.UUID
+	eventType   string
+	date        time.Time
+	amount      uint64
+}
+
+func (tx transaction) UUID() uuid.UUID                     { return uuid.Nil }
+func (tx transaction) CardUUID() uuid.UUID                 { return uuid.Nil }
+func (tx transaction) EventUUID() uuid.UUID                { return uuid.Nil }
+func (tx transaction) EventType() string                   { return tx.eventType }
+func (tx transaction) AuthorizationRequestUUID() uuid.UUID { return tx.authReqUUID }
+func (tx transaction) Date() time.Time                     { return tx.date }
+func (tx transaction) Amount() uint64                      { return tx.amount }
+func (tx transaction) AvailableBalance() uint64            { return 0 }
+func (tx transaction) BlockedBalance() uint64              { return 0 }
+func (tx transaction) Description() string                 { return "" }
+
+func mustTransaction(eventType string, date time.Time, amount uint64) *model.Transaction {
+	return mustAuthorizationTransaction(uuid.Nil, eventType, date, amount)
+}
+
+func mustAuthorizationTransaction(authReqUUID uuid.UUID, eventType string, date time.Time, amount uint64) *model.Transaction {
+	return model.TransactionFromData(transaction{authReqUUID, eventType, date, amount})
+}
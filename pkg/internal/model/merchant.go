@@ -0,0 +1,179 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+)
+
+// MerchantStatus is the lifecycle status of a merchant.
+type MerchantStatus string
+
+// The statuses of the merchants.
+const (
+	// MerchantActive is the status of a merchant, which can authorize payments.
+	MerchantActive MerchantStatus = "active"
+	// MerchantSuspended is the status of a merchant temporarily suspended by the bank.
+	// The merchant cannot authorize new payments.
+	MerchantSuspended MerchantStatus = "suspended"
+	// MerchantClosed is the status of a merchant, which is removed from the registry.
+	MerchantClosed MerchantStatus = "closed"
+)
+
+// ParseMerchantStatus returns the merchant status s.
+func ParseMerchantStatus(s string) (MerchantStatus, error) {
+	switch status := MerchantStatus(s); status {
+	case MerchantActive, MerchantSuspended, MerchantClosed:
+		return status, nil
+	}
+	return "", fmt.Errorf("merchant status %q is not supported", s)
+}
+
+// MerchantData is an interface providing merchant data.
+type MerchantData interface {
+	UUID() uuid.UUID
+	Name() string
+	Category() string
+	Country() string
+	Status() MerchantStatus
+	SettlementAccount() string
+}
+
+// Merchant represents a merchant registered by the bank, which can authorize payments with the cards.
+type Merchant struct {
+	uuid              uuid.UUID
+	name              string
+	category          string
+	country           string
+	status            MerchantStatus
+	settlementAccount string
+}
+
+// NewMerchant returns new active Merchant with name, merchant category code category, ISO 3166-1 alpha-2
+// country code country and the account, to which its payments are settled.
+func NewMerchant(name, category, country, account string) (*Merchant, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	m := &Merchant{uuid: id, status: MerchantActive}
+	if err := m.Update(name, category, country, account); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MerchantFromData reconstructs merchant from data.
+func MerchantFromData(data MerchantData) *Merchant {
+	return &Merchant{
+		uuid:              data.UUID(),
+		name:              data.Name(),
+		category:          data.Category(),
+		country:           data.Country(),
+		status:            data.Status(),
+		settlementAccount: data.SettlementAccount(),
+	}
+}
+
+// UUID returns the UUID.
+func (m *Merchant) UUID() uuid.UUID {
+	return m.uuid
+}
+
+// Name returns the name.
+func (m *Merchant) Name() string {
+	return m.name
+}
+
+// Category returns the merchant category code.
+func (m *Merchant) Category() string {
+	return m.category
+}
+
+// Country returns the ISO 3166-1 alpha-2 country code.
+func (m *Merchant) Country() string {
+	return m.country
+}
+
+// Status returns the status.
+func (m *Merchant) Status() MerchantStatus {
+	return m.status
+}
+
+// SettlementAccount returns the account, to which the payments of the merchant are settled.
+func (m *Merchant) SettlementAccount() string {
+	return m.settlementAccount
+}
+
+// Update changes the details of m. Closed merchants cannot be changed.
+func (m *Merchant) Update(name, category, country, account string) error {
+	if m.status == MerchantClosed {
+		return errors.New("closed merchant cannot be changed")
+	}
+	if name == "" {
+		return errors.New("merchant name must not be empty")
+	}
+	if !IsMerchantCategory(category) {
+		return fmt.Errorf("merchant category %q must be 4 digits", category)
+	}
+	if !IsCountry(country) {
+		return fmt.Errorf("country %q must be ISO 3166-1 alpha-2 code", country)
+	}
+	if account == "" {
+		return errors.New("settlement account must not be empty")
+	}
+	m.name, m.category, m.country, m.settlementAccount = name, category, country, account
+	return nil
+}
+
+// SetStatus changes the status of m. Closed merchants cannot be reopened.
+func (m *Merchant) SetStatus(status MerchantStatus) error {
+	if _, err := ParseMerchantStatus(string(status)); err != nil {
+		return err
+	}
+	if m.status == MerchantClosed && status != MerchantClosed {
+		return fmt.Errorf("closed merchant cannot be %s", status)
+	}
+	m.status = status
+	return nil
+}
+
+// IsCountry reports whether s is ISO 3166-1 alpha-2 country code, which is 2 uppercase letters.
+func IsCountry(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// MerchantCategories are the merchant category restrictions of a card.
+type MerchantCategories struct {
+	// Allowed are the only merchant category codes, which are allowed, if it is not empty.
+	Allowed []string
+	// Blocked are the merchant category codes, which are not allowed.
+	Blocked []string
+}
+
+// Allows reports whether the payments to merchants with merchant category code mcc are allowed.
+func (c MerchantCategories) Allows(mcc string) bool {
+	for _, blocked := range c.Blocked {
+		if blocked == mcc {
+			return false
+		}
+	}
+	if len(c.Allowed) == 0 {
+		return true
+	}
+	for _, allowed := range c.Allowed {
+		if allowed == mcc {
+			return true
+		}
+	}
+	return false
+}
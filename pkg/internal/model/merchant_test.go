@@ -0,0 +1,79 @@
+// +build !integration
+
+package model_test
+
+import (
+	"testing"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestNewMerchant(t *testing.T) {
+	t.Run("returns active merchant", func(t *testing.T) {
+		m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+		h.MustNotErr(t, err, "NewMerchant() error %v; want nil")
+		h.MustE(t, m.Status(), model.MerchantActive, "got status %v, want %v")
+		h.MustE(t, m.Category(), "7011", "got category %q, want %q")
+		h.MustE(t, m.Country(), "GB", "got country %q, want %q")
+	})
+	t.Run("returns error if the details are invalid", func(t *testing.T) {
+		for _, d := range [][4]string{
+			{"", "7011", "GB", "GB29"},
+			{"Grand Hotel", "hotel", "GB", "GB29"},
+			{"Grand Hotel", "7011", "gb", "GB29"},
+			{"Grand Hotel", "7011", "GBR", "GB29"},
+			{"Grand Hotel", "7011", "GB", ""},
+		} {
+			_, err := model.NewMerchant(d[0], d[1], d[2], d[3])
+			h.MustErr(t, err, "NewMerchant(%q) error nil; want error", d)
+		}
+	})
+}
+
+func TestMerchant_Update(t *testing.T) {
+	t.Run("changes the details", func(t *testing.T) {
+		m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, m.Update("Car Rental", "7512", "IE", "IE29AIBK93115212345678"), "Update() error %v; want nil")
+		h.MustE(t, m.Name(), "Car Rental", "got name %q, want %q")
+		h.MustE(t, m.Category(), "7512", "got category %q, want %q")
+		h.MustE(t, m.Country(), "IE", "got country %q, want %q")
+		h.MustE(t, m.SettlementAccount(), "IE29AIBK93115212345678", "got settlement account %q, want %q")
+	})
+	t.Run("does not change closed merchant", func(t *testing.T) {
+		m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, m.SetStatus(model.MerchantClosed), "SetStatus() error %v; want nil")
+		h.MustErr(t, m.Update("Car Rental", "7512", "IE", "IE29AIBK93115212345678"), "Update() error nil; want error")
+		h.MustE(t, m.Name(), "Grand Hotel", "got name %q, want %q")
+	})
+}
+
+func TestMerchant_SetStatus(t *testing.T) {
+	m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+	h.MustNotErr(t, err, "%v")
+	h.MustErr(t, m.SetStatus("deleted"), "SetStatus(deleted) error nil; want error")
+	h.MustNotErr(t, m.SetStatus(model.MerchantSuspended), "SetStatus(suspended) error %v; want nil")
+	h.MustNotErr(t, m.SetStatus(model.MerchantActive), "SetStatus(active) error %v; want nil")
+	h.MustNotErr(t, m.SetStatus(model.MerchantClosed), "SetStatus(closed) error %v; want nil")
+	h.MustErr(t, m.SetStatus(model.MerchantActive), "SetStatus(active) of closed merchant error nil; want error")
+	h.MustE(t, m.Status(), model.MerchantClosed, "got status %v, want %v")
+}
+
+func TestMerchantCategories_Allows(t *testing.T) {
+	for _, tc := range []struct {
+		categories model.MerchantCategories
+		mcc        string
+		want       bool
+	}{
+		{model.MerchantCategories{}, "7995", true},
+		{model.MerchantCategories{Blocked: []string{"7995"}}, "7995", false},
+		{model.MerchantCategories{Blocked: []string{"7995"}}, "5411", true},
+		{model.MerchantCategories{Allowed: []string{"5411"}}, "5411", true},
+		{model.MerchantCategories{Allowed: []string{"5411"}}, "7011", false},
+		{model.MerchantCategories{Allowed: []string{"5411"}, Blocked: []string{"5411"}}, "5411", false},
+	} {
+		h.Must(t, tc.categories.Allows(tc.mcc) == tc.want, "%+v.Allows(%q) = %v; want %v", tc.categories, tc.mcc, !tc.want, tc.want)
+	}
+}
@@ -5,26 +5,80 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
 )
 
+// AuthorizationRequestData is an interface providing authorization request data.
+type AuthorizationRequestData interface {
+	UUID() uuid.UUID
+	CardUUID() uuid.UUID
+	MerchantUUID() uuid.UUID
+	Rate() FXRate
+	OriginalAmount() uint64
+	ConvertedAmount() uint64
+	BlockedAmount() uint64
+	CapturedAmount() uint64
+	RefundedAmount() uint64
+	OriginalBlockedAmount() uint64
+	OriginalCapturedAmount() uint64
+	OriginalRefundedAmount() uint64
+	ExpiresAt() time.Time
+	History() []AuthorizationRequestSnapshot
+}
+
 // AuthorizationRequest represents the requests sent by a merchant to charge a customer.
+// The merchant requests amounts in the currency of the authorization, which are converted
+// to the currency of the card with the rate locked when the request is authorised.
+// The original amounts are in the currency of the authorization and the other amounts are
+// in the currency of the card.
 type AuthorizationRequest struct {
-	uuid           uuid.UUID
-	cardUUID       uuid.UUID
-	merchantUUID   uuid.UUID
-	blockedAmount  uint64
-	capturedAmount uint64
-	refundedAmount uint64
-	history        []AuthorizationRequestSnapshot
+	uuid                   uuid.UUID
+	cardUUID               uuid.UUID
+	merchantUUID           uuid.UUID
+	rate                   FXRate
+	originalAmount         uint64
+	convertedAmount        uint64
+	blockedAmount          uint64
+	capturedAmount         uint64
+	refundedAmount         uint64
+	originalBlockedAmount  uint64
+	originalCapturedAmount uint64
+	originalRefundedAmount uint64
+	expiresAt              time.Time
+	history                []AuthorizationRequestSnapshot
 }
 
 // NewAuthorizationRequest creates new AuthorizationRequest and blocks amount on card if the request is authorised.
+// If amount is in a currency different from the currency of card, it is converted with the rate of rates,
+// which is locked for the lifetime of the request.
+// The request is authorised at time now and it expires DefaultHoldPeriod later, which is changed with HoldFor.
 // It returns an error if the request is not authorised.
-func NewAuthorizationRequest(card *Card, merchant uuid.UUID, amount uint64) (*AuthorizationRequest, error) {
-	if err := card.blockMoney(amount); err != nil {
+func NewAuthorizationRequest(card *Card, merchant uuid.UUID, amount Money, rates FXRateProvider, now time.Time) (*AuthorizationRequest, error) {
+	rate := identityFXRate(card.currency)
+	if amount.Currency() != card.currency {
+		if rates == nil {
+			return &AuthorizationRequest{}, fmt.Errorf("cannot convert %s to %s", amount.Currency(), card.currency)
+		}
+		r, err := rates.FXRate(amount.Currency(), card.currency)
+		if err != nil {
+			return &AuthorizationRequest{}, fmt.Errorf("cannot convert %s to %s; %v", amount.Currency(), card.currency, err)
+		}
+		if r.From() != amount.Currency() || r.To() != card.currency {
+			return &AuthorizationRequest{}, fmt.Errorf("cannot convert %s to %s with rate %s/%s", amount.Currency(), card.currency, r.From(), r.To())
+		}
+		rate = r
+	}
+	if amount.Amount() == 0 {
+		return &AuthorizationRequest{}, errors.New("cannot block the requested amount; amount must be greater than zero")
+	}
+	converted, err := rate.Convert(amount.Amount())
+	if err != nil {
+		return &AuthorizationRequest{}, fmt.Errorf("cannot convert the requested amount; %v", err)
+	}
+	if err := card.blockMoney(converted); err != nil {
 		return &AuthorizationRequest{}, fmt.Errorf("cannot block the requested amount; %v", err)
 	}
 	id1, err := uuid.NewV4()
@@ -36,49 +90,265 @@ func NewAuthorizationRequest(card *Card, merchant uuid.UUID, amount uint64) (*Au
 		return &AuthorizationRequest{}, fmt.Errorf("cannot generate identifier; %v", err)
 	}
 	req := &AuthorizationRequest{
-		uuid:          id1,
-		cardUUID:      card.UUID(),
-		merchantUUID:  merchant,
-		blockedAmount: amount,
-		history: []AuthorizationRequestSnapshot{
-			{
-				uuid:          id2,
-				blockedAmount: amount,
-				createdAt:     time.Now(),
-			},
-		},
+		uuid:                  id1,
+		cardUUID:              card.UUID(),
+		merchantUUID:          merchant,
+		rate:                  rate,
+		originalAmount:        amount.Amount(),
+		convertedAmount:       converted,
+		blockedAmount:         converted,
+		originalBlockedAmount: amount.Amount(),
 	}
+	req.snapshot(id2, now)
+	req.expiresAt = req.history[0].createdAt.Add(DefaultHoldPeriod)
 	return req, nil
 }
 
-// Reverse decreases the blocked amount on card and updates req. It returns error if the request is not authorized.
-func (req *AuthorizationRequest) Reverse(card *Card, amount uint64) error {
+// AuthorizationRequestFromData reconstructs authorization request from data.
+func AuthorizationRequestFromData(data AuthorizationRequestData) *AuthorizationRequest {
+	history := make([]AuthorizationRequestSnapshot, len(data.History()))
+	copy(history, data.History())
+	return &AuthorizationRequest{
+		uuid:                   data.UUID(),
+		cardUUID:               data.CardUUID(),
+		merchantUUID:           data.MerchantUUID(),
+		rate:                   data.Rate(),
+		originalAmount:         data.OriginalAmount(),
+		convertedAmount:        data.ConvertedAmount(),
+		blockedAmount:          data.BlockedAmount(),
+		capturedAmount:         data.CapturedAmount(),
+		refundedAmount:         data.RefundedAmount(),
+		originalBlockedAmount:  data.OriginalBlockedAmount(),
+		originalCapturedAmount: data.OriginalCapturedAmount(),
+		originalRefundedAmount: data.OriginalRefundedAmount(),
+		expiresAt:              data.ExpiresAt(),
+		history:                history,
+	}
+}
+
+// HoldFor sets the expiry of req to period after its authorization. It returns error if period is not positive.
+func (req *AuthorizationRequest) HoldFor(period time.Duration) error {
+	if period <= 0 {
+		return errors.New("hold period must be greater than zero")
+	}
+	if len(req.history) == 0 {
+		return errors.New("cannot hold authorization request without history")
+	}
+	req.expiresAt = req.history[0].createdAt.Add(period)
+	return nil
+}
+
+// IsExpired reports whether the hold of req is expired at time now.
+func (req *AuthorizationRequest) IsExpired(now time.Time) bool {
+	return !now.Before(req.expiresAt)
+}
+
+// Expire releases the whole blocked amount of req on card like Reverse. It returns error if req is not
+// expired at time now or it has no blocked amount.
+func (req *AuthorizationRequest) Expire(card *Card, now time.Time) error {
+	if !req.IsExpired(now) {
+		return fmt.Errorf("cannot expire authorization request before %s", req.expiresAt.Format(time.RFC3339))
+	}
+	if req.originalBlockedAmount == 0 {
+		return errors.New("cannot expire authorization request without blocked amount")
+	}
+	if err := req.Reverse(card, req.originalBlockedAmount, now); err != nil {
+		return fmt.Errorf("cannot expire authorization request; %v", err)
+	}
+	return nil
+}
+
+// Increment blocks amount more on card, e.g. when a hotel guest extends the stay, and restarts the hold
+// period of req. The amount is in the currency of the authorization and it is converted with
+// the locked rate. It returns error if req is expired at time now or it has no blocked amount.
+func (req *AuthorizationRequest) Increment(card *Card, amount uint64, now time.Time) error {
+	if card.UUID() != req.cardUUID {
+		return errors.New("cannot increment from different card")
+	}
+	if amount == 0 {
+		return errors.New("amount must be greater than zero")
+	}
+	if len(req.history) == 0 {
+		return errors.New("cannot increment authorization request without history")
+	}
+	if req.IsExpired(now) {
+		return errors.New("cannot increment expired authorization request")
+	}
+	if req.originalBlockedAmount == 0 {
+		return errors.New("cannot increment authorization request without blocked amount")
+	}
+	if amount > math.MaxUint64-req.originalAmount {
+		return errors.New("cannot increment authorization request; amount is too big")
+	}
+	converted, err := req.rate.Convert(amount)
+	if err != nil {
+		return fmt.Errorf("cannot increment authorization request; %v", err)
+	}
+	if converted > math.MaxUint64-req.convertedAmount {
+		return errors.New("cannot increment authorization request; amount is too big")
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	if err := card.blockMoney(converted); err != nil {
+		return fmt.Errorf("cannot increment authorization request; %v", err)
+	}
+	period := req.holdPeriod()
+	req.originalAmount += amount
+	req.convertedAmount += converted
+	req.originalBlockedAmount += amount
+	req.blockedAmount += converted
+	req.snapshot(id, now)
+	req.expiresAt = req.history[len(req.history)-1].createdAt.Add(period)
+	return nil
+}
+
+// holdPeriod returns the hold period of req, which is the time between its expiry and the snapshot of
+// its authorization or its last increment, i.e. the last snapshot which increases the blocked amount.
+func (req *AuthorizationRequest) holdPeriod() time.Duration {
+	i := len(req.history) - 1
+	for i > 0 && req.history[i].originalBlockedAmount <= req.history[i-1].originalBlockedAmount {
+		i--
+	}
+	return req.expiresAt.Sub(req.history[i].createdAt)
+}
+
+// Reverse decreases the blocked amount on card and updates req at time now. It returns error if the request
+// is not authorized. The amount is in the currency of the authorization.
+func (req *AuthorizationRequest) Reverse(card *Card, amount uint64, now time.Time) error {
 	if card.UUID() != req.cardUUID {
 		return errors.New("cannot reverse from different card")
 	}
 	if amount == 0 {
 		return errors.New("amount must be greater than zero")
 	}
-	if amount > req.blockedAmount {
+	if amount > req.originalBlockedAmount {
 		return errors.New("cannot reverse more than the blocked amount")
 	}
-	if err := card.releaseMoney(amount); err != nil {
+	converted, err := req.convert(amount, req.originalBlockedAmount, req.blockedAmount)
+	if err != nil {
 		return fmt.Errorf("cannot reverse authorization request; %v", err)
 	}
 	id, err := uuid.NewV4()
 	if err != nil {
 		return fmt.Errorf("cannot generate identifier; %v", err)
 	}
-	req.blockedAmount -= amount
+	if converted > 0 {
+		if err := card.releaseMoney(converted); err != nil {
+			return fmt.Errorf("cannot reverse authorization request; %v", err)
+		}
+	}
+	req.originalBlockedAmount -= amount
+	req.blockedAmount -= converted
+	req.snapshot(id, now)
+	return nil
+}
+
+// Capture charges amount from the blocked amount on card and updates req at time now. It returns error if the request
+// is not authorized or it is expired at time now. The amount is in the currency of the authorization.
+// The amount can be captured partially in multiple captures until the blocked amount reaches zero.
+func (req *AuthorizationRequest) Capture(card *Card, amount uint64, now time.Time) error {
+	if card.UUID() != req.cardUUID {
+		return errors.New("cannot capture from different card")
+	}
+	if amount == 0 {
+		return errors.New("amount must be greater than zero")
+	}
+	if req.IsExpired(now) {
+		return errors.New("cannot capture expired authorization request")
+	}
+	if amount > req.originalBlockedAmount {
+		return errors.New("cannot capture more than the blocked amount")
+	}
+	converted, err := req.convert(amount, req.originalBlockedAmount, req.blockedAmount)
+	if err != nil {
+		return fmt.Errorf("cannot capture authorization request; %v", err)
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	if converted > 0 {
+		if err := card.chargeMoney(converted, req.merchantUUID); err != nil {
+			return fmt.Errorf("cannot capture authorization request; %v", err)
+		}
+	}
+	req.originalBlockedAmount -= amount
+	req.originalCapturedAmount += amount
+	req.blockedAmount -= converted
+	req.capturedAmount += converted
+	req.snapshot(id, now)
+	return nil
+}
+
+// Refund returns amount of the captured amount to the available balance of card and updates req at time now.
+// The amount is in the currency of the authorization.
+// It returns error if the amount is more than the captured amount, which is not refunded yet.
+func (req *AuthorizationRequest) Refund(card *Card, amount uint64, now time.Time) error {
+	if card.UUID() != req.cardUUID {
+		return errors.New("cannot refund to different card")
+	}
+	if amount == 0 {
+		return errors.New("amount must be greater than zero")
+	}
+	if amount > req.originalCapturedAmount-req.originalRefundedAmount {
+		return errors.New("cannot refund more than the captured amount")
+	}
+	converted, err := req.convert(amount, req.originalCapturedAmount-req.originalRefundedAmount, req.capturedAmount-req.refundedAmount)
+	if err != nil {
+		return fmt.Errorf("cannot refund authorization request; %v", err)
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	if converted > 0 {
+		if err := card.refundMoney(converted, req.merchantUUID); err != nil {
+			return fmt.Errorf("cannot refund authorization request; %v", err)
+		}
+	}
+	req.originalRefundedAmount += amount
+	req.refundedAmount += converted
+	req.snapshot(id, now)
+	return nil
+}
+
+// convert returns amount of the original remaining amount converted with the locked rate.
+// The converted amount never exceeds remaining, which is the remaining amount in the currency of the card,
+// and it is equal to remaining when the whole original remaining amount is converted, so the rounding
+// of partial amounts does not leave residual amounts blocked or charge more than authorised.
+// A small partial amount can be converted to zero, in which case the card balances do not change.
+func (req *AuthorizationRequest) convert(amount, originalRemaining, remaining uint64) (uint64, error) {
+	if amount == originalRemaining {
+		return remaining, nil
+	}
+	converted, err := req.rate.Convert(amount)
+	if err != nil {
+		return 0, err
+	}
+	if converted > remaining {
+		converted = remaining
+	}
+	return converted, nil
+}
+
+// snapshot appends the current state of req with id at time now to the history.
+func (req *AuthorizationRequest) snapshot(id uuid.UUID, now time.Time) {
 	req.history = append(
 		req.history,
 		AuthorizationRequestSnapshot{
-			uuid:          id,
-			blockedAmount: req.blockedAmount,
-			createdAt:     time.Now(),
+			uuid:                   id,
+			rate:                   req.rate,
+			blockedAmount:          req.blockedAmount,
+			capturedAmount:         req.capturedAmount,
+			refundedAmount:         req.refundedAmount,
+			originalBlockedAmount:  req.originalBlockedAmount,
+			originalCapturedAmount: req.originalCapturedAmount,
+			originalRefundedAmount: req.originalRefundedAmount,
+			createdAt:              now,
 		},
 	)
-	return nil
 }
 
 // UUID returns the UUID.
@@ -96,33 +366,154 @@ func (req *AuthorizationRequest) MerchantUUID() uuid.UUID {
 	return req.merchantUUID
 }
 
+// Currency returns the currency of the authorization.
+func (req *AuthorizationRequest) Currency() Currency {
+	return req.rate.From()
+}
+
+// Rate returns the exchange rate locked when the request was authorised.
+func (req *AuthorizationRequest) Rate() FXRate {
+	return req.rate
+}
+
+// OriginalAmount returns the requested amount in the currency of the authorization.
+func (req *AuthorizationRequest) OriginalAmount() uint64 {
+	return req.originalAmount
+}
+
+// ConvertedAmount returns the requested amount converted to the currency of the card.
+func (req *AuthorizationRequest) ConvertedAmount() uint64 {
+	return req.convertedAmount
+}
+
 // BlockedAmount returns the blocked amount.
 func (req *AuthorizationRequest) BlockedAmount() uint64 {
 	return req.blockedAmount
 }
 
-// CapturedAmount returns the blocked amount.
+// CapturedAmount returns the captured amount.
 func (req *AuthorizationRequest) CapturedAmount() uint64 {
 	return req.capturedAmount
 }
 
-// RefundedAmount returns the blocked amount.
+// RefundedAmount returns the refunded amount.
 func (req *AuthorizationRequest) RefundedAmount() uint64 {
 	return req.refundedAmount
 }
 
+// OriginalBlockedAmount returns the blocked amount in the currency of the authorization.
+func (req *AuthorizationRequest) OriginalBlockedAmount() uint64 {
+	return req.originalBlockedAmount
+}
+
+// OriginalCapturedAmount returns the captured amount in the currency of the authorization.
+func (req *AuthorizationRequest) OriginalCapturedAmount() uint64 {
+	return req.originalCapturedAmount
+}
+
+// OriginalRefundedAmount returns the refunded amount in the currency of the authorization.
+func (req *AuthorizationRequest) OriginalRefundedAmount() uint64 {
+	return req.originalRefundedAmount
+}
+
+// ExpiresAt returns the time, after which the blocked amount is released if it is not captured or reversed.
+func (req *AuthorizationRequest) ExpiresAt() time.Time {
+	return req.expiresAt
+}
+
 // History returns the log of changes.
 func (req *AuthorizationRequest) History() []AuthorizationRequestSnapshot {
 	return req.history
 }
 
+// DefaultHoldPeriod is the period after the authorization, in which the blocked amount of an authorization request
+// can be captured or reversed, unless HoldPeriods configures a different period for the merchant category.
+const DefaultHoldPeriod = 7 * 24 * time.Hour
+
+// HoldPeriods are the hold periods of the authorization requests by merchant category code, e.g. "7011" for hotels.
+type HoldPeriods map[string]time.Duration
+
+// ParseHoldPeriods parses hold periods s formatted as comma-separated merchant category codes and durations,
+// e.g. "7011=720h,7512=720h".
+func ParseHoldPeriods(s string) (HoldPeriods, error) {
+	p := HoldPeriods{}
+	if s == "" {
+		return p, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || !IsMerchantCategory(kv[0]) {
+			return nil, fmt.Errorf("invalid hold period %q; want <merchant category code>=<duration>", item)
+		}
+		d, err := time.ParseDuration(kv[1])
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid hold period %q; duration must be positive", item)
+		}
+		p[kv[0]] = d
+	}
+	return p, nil
+}
+
+// Period returns the hold period of merchant category code mcc or DefaultHoldPeriod if p does not configure it.
+func (p HoldPeriods) Period(mcc string) time.Duration {
+	if d, ok := p[mcc]; ok {
+		return d
+	}
+	return DefaultHoldPeriod
+}
+
+// IsMerchantCategory reports whether s is a merchant category code, which is 4 digits.
+func IsMerchantCategory(s string) bool {
+	if len(s) != 4 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthorizationRequestSnapshotData is an interface providing authorization request snapshot data.
+type AuthorizationRequestSnapshotData interface {
+	UUID() uuid.UUID
+	Rate() FXRate
+	BlockedAmount() uint64
+	CapturedAmount() uint64
+	RefundedAmount() uint64
+	OriginalBlockedAmount() uint64
+	OriginalCapturedAmount() uint64
+	OriginalRefundedAmount() uint64
+	CreatedAt() time.Time
+}
+
 // AuthorizationRequestSnapshot represents a snapshot of AuthorizationRequest.
 type AuthorizationRequestSnapshot struct {
-	uuid           uuid.UUID
-	blockedAmount  uint64
-	capturedAmount uint64
-	refundedAmount uint64
-	createdAt      time.Time
+	uuid                   uuid.UUID
+	rate                   FXRate
+	blockedAmount          uint64
+	capturedAmount         uint64
+	refundedAmount         uint64
+	originalBlockedAmount  uint64
+	originalCapturedAmount uint64
+	originalRefundedAmount uint64
+	createdAt              time.Time
+}
+
+// AuthorizationRequestSnapshotFromData reconstructs authorization request snapshot from data.
+func AuthorizationRequestSnapshotFromData(data AuthorizationRequestSnapshotData) AuthorizationRequestSnapshot {
+	return AuthorizationRequestSnapshot{
+		uuid:                   data.UUID(),
+		rate:                   data.Rate(),
+		blockedAmount:          data.BlockedAmount(),
+		capturedAmount:         data.CapturedAmount(),
+		refundedAmount:         data.RefundedAmount(),
+		originalBlockedAmount:  data.OriginalBlockedAmount(),
+		originalCapturedAmount: data.OriginalCapturedAmount(),
+		originalRefundedAmount: data.OriginalRefundedAmount(),
+		createdAt:              data.CreatedAt(),
+	}
 }
 
 // UUID returns the UUID.
@@ -130,55 +521,112 @@ func (s AuthorizationRequestSnapshot) UUID() uuid.UUID {
 	return s.uuid
 }
 
+// Rate returns the exchange rate of the authorization request.
+func (s AuthorizationRequestSnapshot) Rate() FXRate {
+	return s.rate
+}
+
 // BlockedAmount returns the blocked amount.
 func (s AuthorizationRequestSnapshot) BlockedAmount() uint64 {
 	return s.blockedAmount
 }
 
-// CapturedAmount returns the blocked amount.
+// CapturedAmount returns the captured amount.
 func (s AuthorizationRequestSnapshot) CapturedAmount() uint64 {
 	return s.capturedAmount
 }
 
-// RefundedAmount returns the blocked amount.
+// RefundedAmount returns the refunded amount.
 func (s AuthorizationRequestSnapshot) RefundedAmount() uint64 {
 	return s.refundedAmount
 }
 
+// OriginalBlockedAmount returns the blocked amount in the currency of the authorization.
+func (s AuthorizationRequestSnapshot) OriginalBlockedAmount() uint64 {
+	return s.originalBlockedAmount
+}
+
+// OriginalCapturedAmount returns the captured amount in the currency of the authorization.
+func (s AuthorizationRequestSnapshot) OriginalCapturedAmount() uint64 {
+	return s.originalCapturedAmount
+}
+
+// OriginalRefundedAmount returns the refunded amount in the currency of the authorization.
+func (s AuthorizationRequestSnapshot) OriginalRefundedAmount() uint64 {
+	return s.originalRefundedAmount
+}
+
 // CreatedAt returns the time when the snapshot was taken.
 func (s AuthorizationRequestSnapshot) CreatedAt() time.Time {
 	return s.createdAt
 }
 
+// CardStatus is the lifecycle status of a card.
+type CardStatus string
+
+// The statuses of the cards.
+const (
+	// CardActive is the status of a card, which can be loaded and used for payments.
+	CardActive CardStatus = "active"
+	// CardFrozen is the status of a card temporarily suspended by the cardholder.
+	// The card can be loaded, but it cannot be used for new payments.
+	CardFrozen CardStatus = "frozen"
+	// CardBlocked is the status of a card permanently suspended by the bank.
+	// The card cannot be loaded and used for new payments.
+	CardBlocked CardStatus = "blocked"
+	// CardClosed is the status of a card, which balance is paid out.
+	CardClosed CardStatus = "closed"
+)
+
 // CardData is an interface providing card data.
 type CardData interface {
 	UUID() uuid.UUID
+	Currency() Currency
+	Status() CardStatus
 	AvailableBalance() uint64
 	BlockedBalance() uint64
+	Version() uint64
 }
 
 // Card represents a prepaid card.
+// The balances of the card are projections of its ledger accounts. Each change of
+// the balances is recorded as balanced ledger postings, which are taken by the next
+// Transaction of the card.
+//
+// The version of the card is used for optimistic concurrency control. The card is
+// saved with NextVersion only if the stored card still has Version, and then the
+// repository marks the card as Saved.
 type Card struct {
 	uuid             uuid.UUID
+	currency         Currency
+	status           CardStatus
 	availableBalance uint64
 	blockedBalance   uint64
+	version          uint64
+	postings         []Posting
 }
 
-// NewCard returns new Card.
-func NewCard() (*Card, error) {
+// NewCard returns new Card in currency.
+func NewCard(currency Currency) (*Card, error) {
+	if _, ok := exponents[currency]; !ok {
+		return nil, fmt.Errorf("currency %q is not supported", currency)
+	}
 	id, err := uuid.NewV4()
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate identifier; %v", err)
 	}
-	return &Card{uuid: id}, nil
+	return &Card{uuid: id, currency: currency, status: CardActive}, nil
 }
 
 // CardFromData reconstructs card from data.
 func CardFromData(data CardData) *Card {
 	return &Card{
 		uuid:             data.UUID(),
+		currency:         data.Currency(),
+		status:           data.Status(),
 		availableBalance: data.AvailableBalance(),
 		blockedBalance:   data.BlockedBalance(),
+		version:          data.Version(),
 	}
 }
 
@@ -187,6 +635,77 @@ func (c *Card) UUID() uuid.UUID {
 	return c.uuid
 }
 
+// Version returns the version of the stored card, from which the card is reconstructed.
+// The version of new cards is 0.
+func (c *Card) Version() uint64 {
+	return c.version
+}
+
+// NextVersion returns the version, with which the changes of the card are saved.
+func (c *Card) NextVersion() uint64 {
+	return c.version + 1
+}
+
+// Saved advances the version of the card to NextVersion after the card is saved.
+func (c *Card) Saved() {
+	c.version++
+}
+
+// Currency returns the currency of the balances.
+func (c *Card) Currency() Currency {
+	return c.currency
+}
+
+// Status returns the status.
+func (c *Card) Status() CardStatus {
+	return c.status
+}
+
+// Freeze suspends active card c temporarily.
+func (c *Card) Freeze() error {
+	if c.status != CardActive {
+		return fmt.Errorf("%s card cannot be frozen", c.status)
+	}
+	c.status = CardFrozen
+	return nil
+}
+
+// Unfreeze activates frozen card c.
+func (c *Card) Unfreeze() error {
+	if c.status != CardFrozen {
+		return fmt.Errorf("%s card cannot be unfrozen", c.status)
+	}
+	c.status = CardActive
+	return nil
+}
+
+// Block suspends active or frozen card c permanently.
+func (c *Card) Block() error {
+	if c.status != CardActive && c.status != CardFrozen {
+		return fmt.Errorf("%s card cannot be blocked", c.status)
+	}
+	c.status = CardBlocked
+	return nil
+}
+
+// Close pays out the available balance of c and closes it. It returns the paid out amount.
+// The card cannot be closed while it has blocked balance.
+func (c *Card) Close() (uint64, error) {
+	if c.status == CardClosed {
+		return 0, errors.New("card is already closed")
+	}
+	if c.blockedBalance > 0 {
+		return 0, errors.New("card with blocked balance cannot be closed")
+	}
+	amount := c.availableBalance
+	if amount > 0 {
+		c.availableBalance = 0
+		c.post(transfer(c.availableAccount(), NewAccount(AccountBankFloat, uuid.Nil, c.currency), amount))
+	}
+	c.status = CardClosed
+	return amount, nil
+}
+
 // AvailableBalance returns the available balance.
 func (c *Card) AvailableBalance() uint64 {
 	return c.availableBalance
@@ -198,7 +717,11 @@ func (c *Card) BlockedBalance() uint64 {
 }
 
 // LoadMoney loads amount onto c.
+// Only active and frozen cards can be loaded.
 func (c *Card) LoadMoney(amount uint64) error {
+	if c.status != CardActive && c.status != CardFrozen {
+		return fmt.Errorf("%s card cannot be loaded", c.status)
+	}
 	if amount == 0 {
 		return errors.New("amount must be greater than zero")
 	}
@@ -206,11 +729,16 @@ func (c *Card) LoadMoney(amount uint64) error {
 		return errors.New("available balance cannot exceed math.MaxUint64")
 	}
 	c.availableBalance += amount
+	c.post(transfer(NewAccount(AccountBankFloat, uuid.Nil, c.currency), c.availableAccount(), amount))
 	return nil
 }
 
 // blockMoney blocks amount from the available balance of c.
+// Only active cards can be used for new payments.
 func (c *Card) blockMoney(amount uint64) error {
+	if c.status != CardActive {
+		return fmt.Errorf("%s card cannot be used for payments", c.status)
+	}
 	if amount == 0 {
 		return errors.New("amount must be greater than zero")
 	}
@@ -219,6 +747,7 @@ func (c *Card) blockMoney(amount uint64) error {
 	}
 	c.availableBalance -= amount
 	c.blockedBalance += amount
+	c.post(transfer(c.availableAccount(), c.blockedAccount(), amount))
 	return nil
 }
 
@@ -235,11 +764,12 @@ func (c *Card) releaseMoney(amount uint64) error {
 	}
 	c.availableBalance += amount
 	c.blockedBalance -= amount
+	c.post(transfer(c.blockedAccount(), c.availableAccount(), amount))
 	return nil
 }
 
-// chargeMoney reduces the blocked balance with amount.
-func (c *Card) chargeMoney(amount uint64) error {
+// chargeMoney reduces the blocked balance with amount, which is settled to merchant.
+func (c *Card) chargeMoney(amount uint64, merchant uuid.UUID) error {
 	if amount == 0 {
 		return errors.New("amount must be greater than zero")
 	}
@@ -247,18 +777,174 @@ func (c *Card) chargeMoney(amount uint64) error {
 		return errors.New("blocked balance is too low")
 	}
 	c.blockedBalance -= amount
+	c.post(transfer(c.blockedAccount(), NewAccount(AccountMerchantSettlement, merchant, c.currency), amount))
+	return nil
+}
+
+// refundMoney returns amount charged by merchant to the available balance.
+func (c *Card) refundMoney(amount uint64, merchant uuid.UUID) error {
+	if c.status == CardClosed {
+		return errors.New("closed card cannot be refunded")
+	}
+	if amount == 0 {
+		return errors.New("amount must be greater than zero")
+	}
+	if c.availableBalance > math.MaxUint64-amount {
+		return errors.New("available balance cannot exceed math.MaxUint64")
+	}
+	c.availableBalance += amount
+	c.post(transfer(NewAccount(AccountMerchantSettlement, merchant, c.currency), c.availableAccount(), amount))
 	return nil
 }
 
+// takePostings returns and clears the postings of c, which are not taken by a transaction yet.
+func (c *Card) takePostings() []Posting {
+	postings := c.postings
+	c.postings = nil
+	return postings
+}
+
+// availableAccount returns the ledger account of the available balance.
+func (c *Card) availableAccount() Account {
+	return NewAccount(AccountCardAvailable, c.uuid, c.currency)
+}
+
+// blockedAccount returns the ledger account of the blocked balance.
+func (c *Card) blockedAccount() Account {
+	return NewAccount(AccountCardBlocked, c.uuid, c.currency)
+}
+
+// post records postings, which are not taken by a transaction yet.
+func (c *Card) post(postings []Posting) {
+	c.postings = append(c.postings, postings...)
+}
+
+// TransactionData is an interface providing transaction data.
+type TransactionData interface {
+	UUID() uuid.UUID
+	CardUUID() uuid.UUID
+	EventUUID() uuid.UUID
+	EventType() string
+	AuthorizationRequestUUID() uuid.UUID
+	Date() time.Time
+	Amount() uint64
+	AvailableBalance() uint64
+	BlockedBalance() uint64
+	Description() string
+}
+
 // Transaction represents a transaction associated with a card.
 type Transaction struct {
 	uuid             uuid.UUID
 	cardUUID         uuid.UUID
 	eventUUID        uuid.UUID
 	eventType        string
+	authReqUUID      uuid.UUID
 	date             time.Time
 	amount           uint64
 	availableBalance uint64
 	blockedBalance   uint64
 	description      string
+	postings         []Posting
+}
+
+// NewTransaction returns new Transaction at now for amount, which is the result of
+// event with eventUUID and eventType of authorization request authReq or
+// of the card if authReq is nil. The transaction records the current
+// balances of card and takes the ledger postings of the card changes.
+func NewTransaction(card *Card, authReq *AuthorizationRequest, eventUUID uuid.UUID, eventType string, amount uint64, description string, now time.Time) (*Transaction, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	var authReqUUID uuid.UUID
+	if authReq != nil {
+		authReqUUID = authReq.UUID()
+	}
+	return &Transaction{
+		uuid:             id,
+		cardUUID:         card.UUID(),
+		eventUUID:        eventUUID,
+		eventType:        eventType,
+		authReqUUID:      authReqUUID,
+		date:             now,
+		amount:           amount,
+		availableBalance: card.AvailableBalance(),
+		blockedBalance:   card.BlockedBalance(),
+		description:      description,
+		postings:         card.takePostings(),
+	}, nil
+}
+
+// TransactionFromData reconstructs transaction from data.
+func TransactionFromData(data TransactionData) *Transaction {
+	return &Transaction{
+		uuid:             data.UUID(),
+		cardUUID:         data.CardUUID(),
+		eventUUID:        data.EventUUID(),
+		eventType:        data.EventType(),
+		authReqUUID:      data.AuthorizationRequestUUID(),
+		date:             data.Date(),
+		amount:           data.Amount(),
+		availableBalance: data.AvailableBalance(),
+		blockedBalance:   data.BlockedBalance(),
+		description:      data.Description(),
+	}
+}
+
+// UUID returns the UUID.
+func (t *Transaction) UUID() uuid.UUID {
+	return t.uuid
+}
+
+// CardUUID returns the card UUID.
+func (t *Transaction) CardUUID() uuid.UUID {
+	return t.cardUUID
+}
+
+// EventUUID returns the UUID of the event, which caused the transaction.
+func (t *Transaction) EventUUID() uuid.UUID {
+	return t.eventUUID
+}
+
+// EventType returns the type of the event, which caused the transaction.
+func (t *Transaction) EventType() string {
+	return t.eventType
+}
+
+// AuthorizationRequestUUID returns the UUID of the authorization request of the transaction
+// or uuid.Nil if the transaction is not caused by an authorization request.
+func (t *Transaction) AuthorizationRequestUUID() uuid.UUID {
+	return t.authReqUUID
+}
+
+// Date returns the time of the transaction.
+func (t *Transaction) Date() time.Time {
+	return t.date
+}
+
+// Amount returns the amount.
+func (t *Transaction) Amount() uint64 {
+	return t.amount
+}
+
+// AvailableBalance returns the available balance of the card after the transaction.
+func (t *Transaction) AvailableBalance() uint64 {
+	return t.availableBalance
+}
+
+// BlockedBalance returns the blocked balance of the card after the transaction.
+func (t *Transaction) BlockedBalance() uint64 {
+	return t.blockedBalance
+}
+
+// Description returns the description.
+func (t *Transaction) Description() string {
+	return t.description
+}
+
+// Postings returns the ledger postings of the transaction.
+// The postings are not available for reconstructed transactions.
+func (t *Transaction) Postings() []Posting {
+	return t.postings
 }
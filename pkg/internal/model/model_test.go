@@ -4,6 +4,7 @@ package model_test
 
 import (
 	"math"
+	"reflect"
 	"testing"
 	"time"
 
@@ -13,9 +14,50 @@ import (
 	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
 )
 
+func TestNewCard(t *testing.T) {
+	t.Run("returns card in currency", func(t *testing.T) {
+		c, err := model.NewCard(model.JPY)
+		h.MustNotErr(t, err, "NewCard(JPY) = %v; want nil")
+		h.MustE(t, c.Currency(), model.JPY, "c.Currency() = %q; want %q")
+	})
+	t.Run("returns error if the currency is not supported", func(t *testing.T) {
+		_, err := model.NewCard(model.Currency("XXX"))
+		h.MustErr(t, err, "NewCard(XXX) = nil; want error")
+	})
+}
+
+func TestCard_Version(t *testing.T) {
+	t.Run("new card has version 0", func(t *testing.T) {
+		c := mustCard(t, 0, 0)
+		h.MustE(t, c.Version(), uint64(0), "c.Version() = %v; want %v")
+		h.MustE(t, c.NextVersion(), uint64(1), "c.NextVersion() = %v; want %v")
+	})
+	t.Run("card from data has the version of data", func(t *testing.T) {
+		c := model.CardFromData(cardData{mustCard(t, 0, 0), 7})
+		h.MustE(t, c.Version(), uint64(7), "c.Version() = %v; want %v")
+		h.MustE(t, c.NextVersion(), uint64(8), "c.NextVersion() = %v; want %v")
+	})
+	t.Run("saved card has the next version", func(t *testing.T) {
+		c := mustCard(t, 0, 0)
+		c.Saved()
+		h.MustE(t, c.Version(), uint64(1), "c.Version() = %v; want %v")
+		h.MustE(t, c.NextVersion(), uint64(2), "c.NextVersion() = %v; want %v")
+	})
+}
+
+// cardData is model.CardData with version.
+type cardData struct {
+	*model.Card
+	version uint64
+}
+
+func (d cardData) Version() uint64 {
+	return d.version
+}
+
 func TestCard_LoadMoney(t *testing.T) {
 	t.Run("amount must be greater than zero", func(t *testing.T) {
-		c, err := model.NewCard()
+		c, err := model.NewCard(model.GBP)
 		h.MustNotErr(t, err, "%v")
 		h.MustErr(t, c.LoadMoney(0), "c.LoadBalance(0) nil; want error")
 	})
@@ -29,20 +71,87 @@ func TestCard_LoadMoney(t *testing.T) {
 	})
 }
 
+func TestCard_Status(t *testing.T) {
+	t.Run("new card is active", func(t *testing.T) {
+		c := mustCard(t, 0, 0)
+		h.MustE(t, c.Status(), model.CardActive, "c.Status() = %q; want %q")
+	})
+	t.Run("transitions", func(t *testing.T) {
+		transitions := []struct {
+			name string
+			f    func(*model.Card) error
+			from []model.CardStatus
+			to   model.CardStatus
+		}{
+			{"Freeze", (*model.Card).Freeze, []model.CardStatus{model.CardActive}, model.CardFrozen},
+			{"Unfreeze", (*model.Card).Unfreeze, []model.CardStatus{model.CardFrozen}, model.CardActive},
+			{"Block", (*model.Card).Block, []model.CardStatus{model.CardActive, model.CardFrozen}, model.CardBlocked},
+			{"Close", func(c *model.Card) error {
+				_, err := c.Close()
+				return err
+			}, []model.CardStatus{model.CardActive, model.CardFrozen, model.CardBlocked}, model.CardClosed},
+		}
+		for _, tr := range transitions {
+			for _, status := range []model.CardStatus{model.CardActive, model.CardFrozen, model.CardBlocked, model.CardClosed} {
+				c := mustCardWithStatus(t, status)
+				err := tr.f(c)
+				if !containsStatus(tr.from, status) {
+					h.MustErr(t, err, "%s() of %s card = nil; want error", tr.name, status)
+					h.MustE(t, c.Status(), status, "c.Status() = %q; want %q")
+					continue
+				}
+				h.MustNotErr(t, err, "%s() of %s card = %v; want nil", tr.name, status)
+				h.MustE(t, c.Status(), tr.to, "c.Status() = %q; want %q")
+			}
+		}
+	})
+	t.Run("closing pays out the available balance", func(t *testing.T) {
+		c := mustCard(t, 100, 0)
+		amount, err := c.Close()
+		h.MustNotErr(t, err, "c.Close() = %v; want nil")
+		h.MustE(t, amount, uint64(100), "c.Close() = %v; want %v")
+		assertCardBalance(t, c, 0, 0)
+	})
+	t.Run("card with blocked balance cannot be closed", func(t *testing.T) {
+		c := mustCard(t, 100, 30)
+		_, err := c.Close()
+		h.MustErr(t, err, "c.Close() = nil; want error")
+		assertCardBalance(t, c, 70, 30)
+	})
+	t.Run("only active and frozen cards can be loaded", func(t *testing.T) {
+		h.MustNotErr(t, mustCardWithStatus(t, model.CardActive).LoadMoney(1), "LoadMoney() of active card = %v; want nil")
+		h.MustNotErr(t, mustCardWithStatus(t, model.CardFrozen).LoadMoney(1), "LoadMoney() of frozen card = %v; want nil")
+		h.MustErr(t, mustCardWithStatus(t, model.CardBlocked).LoadMoney(1), "LoadMoney() of blocked card = nil; want error")
+		h.MustErr(t, mustCardWithStatus(t, model.CardClosed).LoadMoney(1), "LoadMoney() of closed card = nil; want error")
+	})
+	t.Run("only active cards can be used for payments", func(t *testing.T) {
+		for _, status := range []model.CardStatus{model.CardFrozen, model.CardBlocked} {
+			c := mustCard(t, 100, 0)
+			h.MustNotErr(t, c.Freeze(), "c.Freeze() = %v; want nil")
+			if status == model.CardBlocked {
+				h.MustNotErr(t, c.Block(), "c.Block() = %v; want nil")
+			}
+			_, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(10, model.GBP), nil, time.Now())
+			h.MustErr(t, err, "NewAuthorizationRequest() of %s card = nil; want error", status)
+			assertCardBalance(t, c, 100, 0)
+		}
+	})
+}
+
 func TestNewAuthorizationRequest(t *testing.T) {
 	t.Run("cannot block 0", func(t *testing.T) {
-		c, err := model.NewCard()
+		c, err := model.NewCard(model.GBP)
 		h.MustNotErr(t, err, "%v")
-		_, err = model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), 0)
+		_, err = model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(0, model.GBP), nil, time.Now())
 		h.MustErr(t, err, "NewAuthorizationRequest() = AuthorizationRequest{}, nil; want AuthorizationRequest{}, error")
 	})
 	t.Run("success", func(t *testing.T) {
-		c, err := model.NewCard()
+		c, err := model.NewCard(model.GBP)
 		h.MustNotErr(t, err, "%v")
 		c.LoadMoney(100)
 		m := uuid.Must(uuid.NewV4())
 		b := time.Now()
-		req, err := model.NewAuthorizationRequest(c, m, 70)
+		req, err := model.NewAuthorizationRequest(c, m, model.NewMoney(70, model.GBP), nil, time.Now())
 		h.MustNotErr(t, err, "NewAuthorizationRequest() = %+v, %v; want nil", req)
 		if req.CardUUID() != c.UUID() {
 			t.Errorf("req.CardUUID() = %v; want %v", req.CardUUID(), c.UUID())
@@ -63,6 +172,102 @@ func TestNewAuthorizationRequest(t *testing.T) {
 
 }
 
+func TestAuthorizationRequestFromData(t *testing.T) {
+	c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+	h.MustNotErr(t, req.Reverse(c, 20, time.Now()), "req.Reverse(20) = %v; want nil")
+	res := model.AuthorizationRequestFromData(req)
+	if res.UUID() != req.UUID() {
+		t.Errorf("res.UUID() = %v; want %v", res.UUID(), req.UUID())
+	}
+	if res.CardUUID() != req.CardUUID() {
+		t.Errorf("res.CardUUID() = %v; want %v", res.CardUUID(), req.CardUUID())
+	}
+	if res.MerchantUUID() != req.MerchantUUID() {
+		t.Errorf("res.MerchantUUID() = %v; want %v", res.MerchantUUID(), req.MerchantUUID())
+	}
+	assertAuthorizationRequestBalance(t, res, 30, 0, 0)
+	if len(res.History()) != 2 {
+		t.Fatalf("len(res.History()) = %v; want 2", len(res.History()))
+	}
+	for i, s := range res.History() {
+		if s != model.AuthorizationRequestSnapshotFromData(req.History()[i]) {
+			t.Errorf("res.History()[%d] = %+v; want %+v", i, s, req.History()[i])
+		}
+	}
+}
+
+func TestAuthorizationRequest_Increment(t *testing.T) {
+	t.Run("cannot increment from different card", func(t *testing.T) {
+		c1 := mustCard(t, 10, 0)
+		c2 := mustCard(t, 10, 0)
+		req := mustAuthorizationRequest(t, c1, 1)
+		h.MustErr(t, req.Increment(c2, 1, time.Now()), "req.Increment(c2, 1) = nil; want error")
+	})
+	t.Run("cannot increment 0", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.Increment(c, 0, time.Now()), "req.Increment(c, 0) = nil; want error")
+	})
+	t.Run("cannot increment more than the available balance", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.Increment(c, 51, time.Now()), "req.Increment(c, 51) = nil; want error")
+		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
+		assertCardBalance(t, c, 50, 50)
+		h.MustE(t, len(req.History()), 1, "len(req.History()) = %v; want %v")
+	})
+	t.Run("cannot increment expired authorization request", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustNotErr(t, req.HoldFor(time.Hour), "req.HoldFor() = %v; want nil")
+		h.MustErr(t, req.Increment(c, 10, req.ExpiresAt()), "req.Increment(c, 10) = nil at the expiry; want error")
+		assertCardBalance(t, c, 50, 50)
+		h.MustNotErr(t, req.Increment(c, 10, req.ExpiresAt().Add(-time.Nanosecond)), "req.Increment(c, 10) = %v before the expiry; want nil")
+		assertCardBalance(t, c, 40, 60)
+	})
+	t.Run("cannot increment without blocked amount", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustNotErr(t, req.Capture(c, 50, time.Now()), "req.Capture(c, 50) = %v; want nil")
+		h.MustErr(t, req.Increment(c, 10, time.Now()), "req.Increment(c, 10) = nil after the full capture; want error")
+		assertAuthorizationRequestBalance(t, req, 0, 50, 0)
+		assertCardBalance(t, c, 50, 0)
+	})
+	t.Run("blocks the amount and restarts the hold period", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 30)
+		h.MustNotErr(t, req.HoldFor(time.Hour), "req.HoldFor(time.Hour) = %v; want nil")
+		h.MustNotErr(t, req.Increment(c, 20, time.Now()), "req.Increment(c, 20) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
+		assertCardBalance(t, c, 50, 50)
+		h.MustE(t, req.OriginalAmount(), uint64(50), "req.OriginalAmount() = %v; want %v")
+		h.MustE(t, len(req.History()), 2, "len(req.History()) = %v; want %v")
+		h.MustE(t, req.ExpiresAt(), req.History()[1].CreatedAt().Add(time.Hour), "req.ExpiresAt() = %v; want %v")
+	})
+	t.Run("can increment interleaved with reverse and capture", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 30)
+		h.MustNotErr(t, req.HoldFor(time.Hour), "req.HoldFor(time.Hour) = %v; want nil")
+
+		h.MustNotErr(t, req.Reverse(c, 10, time.Now()), "req.Reverse(10) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 20, 0, 0)
+		assertCardBalance(t, c, 80, 20)
+
+		h.MustNotErr(t, req.Increment(c, 40, time.Now()), "req.Increment(40) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 60, 0, 0)
+		assertCardBalance(t, c, 40, 60)
+
+		h.MustNotErr(t, req.Capture(c, 25, time.Now()), "req.Capture(25) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 35, 25, 0)
+		assertCardBalance(t, c, 40, 35)
+
+		h.MustNotErr(t, req.Increment(c, 15, time.Now()), "req.Increment(15) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 50, 25, 0)
+		assertCardBalance(t, c, 25, 50)
+		h.MustE(t, req.ExpiresAt(), req.History()[4].CreatedAt().Add(time.Hour), "req.ExpiresAt() = %v; want %v")
+
+		h.MustNotErr(t, req.Reverse(c, 50, time.Now()), "req.Reverse(50) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 25, 0)
+		assertCardBalance(t, c, 75, 0)
+		h.MustE(t, len(req.History()), 6, "len(req.History()) = %v; want %v")
+		h.MustErr(t, req.Increment(c, 1, time.Now()), "req.Increment(1) = nil without blocked amount; want error")
+	})
+}
+
 func TestAuthorizationRequest_Reverse(t *testing.T) {
 	t.Run("cannot reverse from different card", func(t *testing.T) {
 		c1 := mustCard(t, 1, 0)
@@ -71,41 +276,289 @@ func TestAuthorizationRequest_Reverse(t *testing.T) {
 			t.Fatalf("c1.UUID() == c2.UUID(); want %v != %v", c1.UUID(), c2.UUID())
 		}
 		req := mustAuthorizationRequest(t, c1, 1)
-		h.MustErr(t, req.Reverse(c2, 1), "req.Reverse(c2, 0) = nil; want error")
+		h.MustErr(t, req.Reverse(c2, 1, time.Now()), "req.Reverse(c2, 0) = nil; want error")
 	})
 	t.Run("cannot reverse 0", func(t *testing.T) {
 		c, req := mustCardWithAuthorizationRequest(t, 100, 100)
-		h.MustErr(t, req.Reverse(c, 0), "req.Reverse(c, 0) = nil; want error")
+		h.MustErr(t, req.Reverse(c, 0, time.Now()), "req.Reverse(c, 0) = nil; want error")
 	})
 	t.Run("cannot reverse more than the blocked amount", func(t *testing.T) {
 		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
-		h.MustErr(t, req.Reverse(c, 51), "req.Reverse(51) = nil; want error")
+		h.MustErr(t, req.Reverse(c, 51, time.Now()), "req.Reverse(51) = nil; want error")
 	})
 	t.Run("cannot reverse money if available balance becomes more than math.MaxUint64", func(t *testing.T) {
 		c, req := mustCardWithAuthorizationRequest(t, math.MaxUint64, 1)
 		h.MustNotErr(t, c.LoadMoney(1), "c.LoadMoney(1) %v; want nil")
 		assertCardBalance(t, c, math.MaxUint64, 1)
-		h.MustErr(t, req.Reverse(c, 1), "req.Reverse(c, 1) nil; want error")
+		h.MustErr(t, req.Reverse(c, 1, time.Now()), "req.Reverse(c, 1) nil; want error")
 	})
 	t.Run("can reverse multiple times until the blocked amount reaches 0", func(t *testing.T) {
 		c, req := mustCardWithAuthorizationRequest(t, 50, 50)
 		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
 		assertCardBalance(t, c, 0, 50)
 
-		h.MustNotErr(t, req.Reverse(c, 10), "req.Reverse(10) = %v; want nil")
+		h.MustNotErr(t, req.Reverse(c, 10, time.Now()), "req.Reverse(10) = %v; want nil")
 		assertAuthorizationRequestBalance(t, req, 40, 0, 0)
 		assertCardBalance(t, c, 10, 40)
 
-		h.MustNotErr(t, req.Reverse(c, 15), "req.Reverse(15) = %v; want nil")
+		h.MustNotErr(t, req.Reverse(c, 15, time.Now()), "req.Reverse(15) = %v; want nil")
 		assertAuthorizationRequestBalance(t, req, 25, 0, 0)
 		assertCardBalance(t, c, 25, 25)
 
-		h.MustNotErr(t, req.Reverse(c, 25), "req.Reverse(25) = %v; want nil")
+		h.MustNotErr(t, req.Reverse(c, 25, time.Now()), "req.Reverse(25) = %v; want nil")
 		assertAuthorizationRequestBalance(t, req, 0, 0, 0)
 		assertCardBalance(t, c, 50, 0)
 	})
 }
 
+func TestAuthorizationRequest_Capture(t *testing.T) {
+	t.Run("cannot capture from different card", func(t *testing.T) {
+		c1 := mustCard(t, 1, 0)
+		c2 := mustCard(t, 10, 10)
+		req := mustAuthorizationRequest(t, c1, 1)
+		h.MustErr(t, req.Capture(c2, 1, time.Now()), "req.Capture(c2, 1) = nil; want error")
+	})
+	t.Run("cannot capture 0", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 100)
+		h.MustErr(t, req.Capture(c, 0, time.Now()), "req.Capture(c, 0) = nil; want error")
+	})
+	t.Run("cannot capture expired authorization request", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.Capture(c, 10, req.ExpiresAt()), "req.Capture(c, 10) = nil at the expiry; want error")
+		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
+		assertCardBalance(t, c, 50, 50)
+		h.MustNotErr(t, req.Capture(c, 10, req.ExpiresAt().Add(-time.Nanosecond)), "req.Capture(c, 10) = %v before the expiry; want nil")
+	})
+	t.Run("cannot capture more than the blocked amount", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.Capture(c, 51, time.Now()), "req.Capture(c, 51) = nil; want error")
+		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
+		assertCardBalance(t, c, 50, 50)
+	})
+	t.Run("can capture the full blocked amount", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustNotErr(t, req.Capture(c, 50, time.Now()), "req.Capture(c, 50) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 50, 0)
+		assertCardBalance(t, c, 50, 0)
+		h.MustE(t, len(req.History()), 2, "len(req.History()) = %v; want %v")
+	})
+	t.Run("can capture multiple times until the blocked amount reaches 0", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+
+		h.MustNotErr(t, req.Capture(c, 10, time.Now()), "req.Capture(10) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 40, 10, 0)
+		assertCardBalance(t, c, 50, 40)
+
+		h.MustNotErr(t, req.Reverse(c, 15, time.Now()), "req.Reverse(15) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 25, 10, 0)
+		assertCardBalance(t, c, 65, 25)
+
+		h.MustNotErr(t, req.Capture(c, 25, time.Now()), "req.Capture(25) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 35, 0)
+		assertCardBalance(t, c, 65, 0)
+
+		h.MustErr(t, req.Capture(c, 1, time.Now()), "req.Capture(1) = nil; want error")
+		h.MustE(t, len(req.History()), 4, "len(req.History()) = %v; want %v")
+	})
+}
+
+func TestAuthorizationRequest_Refund(t *testing.T) {
+	t.Run("cannot refund to different card", func(t *testing.T) {
+		c1, req := mustCardWithAuthorizationRequest(t, 10, 10)
+		h.MustNotErr(t, req.Capture(c1, 10, time.Now()), "req.Capture(c1, 10) = %v; want nil")
+		c2 := mustCard(t, 10, 0)
+		h.MustErr(t, req.Refund(c2, 1, time.Now()), "req.Refund(c2, 1) = nil; want error")
+	})
+	t.Run("cannot refund 0", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 100)
+		h.MustNotErr(t, req.Capture(c, 100, time.Now()), "req.Capture(c, 100) = %v; want nil")
+		h.MustErr(t, req.Refund(c, 0, time.Now()), "req.Refund(c, 0) = nil; want error")
+	})
+	t.Run("cannot refund if nothing is captured", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.Refund(c, 1, time.Now()), "req.Refund(c, 1) = nil; want error")
+		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
+		assertCardBalance(t, c, 50, 50)
+	})
+	t.Run("cannot refund more than the captured amount", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustNotErr(t, req.Capture(c, 30, time.Now()), "req.Capture(c, 30) = %v; want nil")
+		h.MustErr(t, req.Refund(c, 31, time.Now()), "req.Refund(c, 31) = nil; want error")
+		assertAuthorizationRequestBalance(t, req, 20, 30, 0)
+		assertCardBalance(t, c, 50, 20)
+	})
+	t.Run("cannot refund money if available balance becomes more than math.MaxUint64", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, math.MaxUint64, 1)
+		h.MustNotErr(t, req.Capture(c, 1, time.Now()), "req.Capture(c, 1) = %v; want nil")
+		h.MustNotErr(t, c.LoadMoney(1), "c.LoadMoney(1) %v; want nil")
+		h.MustErr(t, req.Refund(c, 1, time.Now()), "req.Refund(c, 1) = nil; want error")
+	})
+	t.Run("can refund multiple times until the captured amount is refunded", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustNotErr(t, req.Capture(c, 30, time.Now()), "req.Capture(c, 30) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 20, 30, 0)
+		assertCardBalance(t, c, 50, 20)
+
+		h.MustNotErr(t, req.Refund(c, 10, time.Now()), "req.Refund(c, 10) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 20, 30, 10)
+		assertCardBalance(t, c, 60, 20)
+
+		h.MustNotErr(t, req.Capture(c, 20, time.Now()), "req.Capture(c, 20) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 50, 10)
+		assertCardBalance(t, c, 60, 0)
+
+		h.MustNotErr(t, req.Refund(c, 40, time.Now()), "req.Refund(c, 40) = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 50, 50)
+		assertCardBalance(t, c, 100, 0)
+
+		h.MustErr(t, req.Refund(c, 1, time.Now()), "req.Refund(c, 1) = nil; want error")
+		h.MustE(t, len(req.History()), 5, "len(req.History()) = %v; want %v")
+	})
+}
+
+func TestAuthorizationRequest_Expire(t *testing.T) {
+	t.Run("expires after the default hold period", func(t *testing.T) {
+		_, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		created := req.History()[0].CreatedAt()
+		h.MustE(t, req.ExpiresAt(), created.Add(model.DefaultHoldPeriod), "req.ExpiresAt() = %v; want %v")
+		h.Must(t, !req.IsExpired(req.ExpiresAt().Add(-time.Nanosecond)), "req.IsExpired() = true before the expiry; want false")
+		h.Must(t, req.IsExpired(req.ExpiresAt()), "req.IsExpired() = false at the expiry; want true")
+	})
+	t.Run("cannot hold for non-positive period", func(t *testing.T) {
+		_, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.HoldFor(0), "req.HoldFor(0) = nil; want error")
+		h.MustNotErr(t, req.HoldFor(time.Hour), "req.HoldFor(time.Hour) = %v; want nil")
+		h.MustE(t, req.ExpiresAt(), req.History()[0].CreatedAt().Add(time.Hour), "req.ExpiresAt() = %v; want %v")
+	})
+	t.Run("cannot expire before the expiry", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustErr(t, req.Expire(c, req.ExpiresAt().Add(-time.Second)), "req.Expire() = nil before the expiry; want error")
+		assertAuthorizationRequestBalance(t, req, 50, 0, 0)
+		assertCardBalance(t, c, 50, 50)
+	})
+	t.Run("releases the remaining blocked amount", func(t *testing.T) {
+		c, req := mustCardWithAuthorizationRequest(t, 100, 50)
+		h.MustNotErr(t, req.Capture(c, 20, time.Now()), "req.Capture(c, 20) = %v; want nil")
+		h.MustNotErr(t, req.Expire(c, req.ExpiresAt()), "req.Expire() = %v; want nil")
+		assertAuthorizationRequestBalance(t, req, 0, 20, 0)
+		assertCardBalance(t, c, 80, 0)
+		h.MustE(t, len(req.History()), 3, "len(req.History()) = %v; want %v")
+		h.MustErr(t, req.Expire(c, req.ExpiresAt()), "req.Expire() = nil without blocked amount; want error")
+	})
+}
+
+func TestParseHoldPeriods(t *testing.T) {
+	p, err := model.ParseHoldPeriods("7011=720h,7512=240h")
+	h.MustNotErr(t, err, "ParseHoldPeriods() = %v; want nil")
+	h.MustE(t, p.Period("7011"), 720*time.Hour, "p.Period(7011) = %v; want %v")
+	h.MustE(t, p.Period("7512"), 240*time.Hour, "p.Period(7512) = %v; want %v")
+	h.MustE(t, p.Period("5411"), model.DefaultHoldPeriod, "p.Period(5411) = %v; want %v")
+	p, err = model.ParseHoldPeriods("")
+	h.MustNotErr(t, err, "ParseHoldPeriods(\"\") = %v; want nil")
+	h.MustE(t, p.Period("7011"), model.DefaultHoldPeriod, "p.Period(7011) = %v; want %v")
+	for _, s := range []string{"7011", "701=1h", "hotel=1h", "7011=1d", "7011=-1h"} {
+		_, err := model.ParseHoldPeriods(s)
+		h.MustErr(t, err, "ParseHoldPeriods(%q) = nil; want error", s)
+	}
+}
+
+func TestNewTransaction(t *testing.T) {
+	c, req := mustCardWithAuthorizationRequest(t, 100, 30)
+	e := uuid.Must(uuid.NewV4())
+	tx, err := model.NewTransaction(c, req, e, "Foo", 30, "Bar", now)
+	h.MustNotErr(t, err, "NewTransaction() = %+v, %v; want nil", tx)
+	if tx.UUID() == uuid.Nil {
+		t.Errorf("tx.UUID() = %v; want !uuid.Nil", tx.UUID())
+	}
+	if tx.CardUUID() != req.CardUUID() {
+		t.Errorf("tx.CardUUID() = %v; want %v", tx.CardUUID(), req.CardUUID())
+	}
+	if tx.EventUUID() != e {
+		t.Errorf("tx.EventUUID() = %v; want %v", tx.EventUUID(), e)
+	}
+	if tx.EventType() != "Foo" {
+		t.Errorf("tx.EventType() = %q; want %q", tx.EventType(), "Foo")
+	}
+	if tx.AuthorizationRequestUUID() != req.UUID() {
+		t.Errorf("tx.AuthorizationRequestUUID() = %v; want %v", tx.AuthorizationRequestUUID(), req.UUID())
+	}
+	if !tx.Date().Equal(now) {
+		t.Errorf("tx.Date() = %v; want %v", tx.Date(), now)
+	}
+	if tx.Amount() != 30 {
+		t.Errorf("tx.Amount() = %v; want 30", tx.Amount())
+	}
+	if tx.AvailableBalance() != 70 {
+		t.Errorf("tx.AvailableBalance() = %v; want 70", tx.AvailableBalance())
+	}
+	if tx.BlockedBalance() != 30 {
+		t.Errorf("tx.BlockedBalance() = %v; want 30", tx.BlockedBalance())
+	}
+	if tx.Description() != "Bar" {
+		t.Errorf("tx.Description() = %q; want %q", tx.Description(), "Bar")
+	}
+}
+
+func TestTransactionFromData(t *testing.T) {
+	c := model.CardFromData(mustCard(t, 100, 30))
+	tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "Foo", 30, "Bar", time.Now())
+	h.MustNotErr(t, err, "NewTransaction() = %+v, %v; want nil", tx)
+	if res := model.TransactionFromData(tx); !reflect.DeepEqual(res, tx) {
+		t.Errorf("TransactionFromData() = %+v; want %+v", res, tx)
+	}
+}
+
+func TestTransaction_Postings(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	m := uuid.Must(uuid.NewV4())
+	float := model.NewAccount(model.AccountBankFloat, uuid.Nil, model.GBP)
+	available := model.NewAccount(model.AccountCardAvailable, c.UUID(), model.GBP)
+	blocked := model.NewAccount(model.AccountCardBlocked, c.UUID(), model.GBP)
+	settlement := model.NewAccount(model.AccountMerchantSettlement, m, model.GBP)
+
+	h.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney(100) %v; want nil")
+	req, err := model.NewAuthorizationRequest(c, m, model.NewMoney(50, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "NewAuthorizationRequest() %v; want nil")
+	h.MustNotErr(t, req.Capture(c, 30, time.Now()), "req.Capture(c, 30) %v; want nil")
+	h.MustNotErr(t, req.Refund(c, 10, time.Now()), "req.Refund(c, 10) %v; want nil")
+	h.MustNotErr(t, req.Reverse(c, 20, time.Now()), "req.Reverse(c, 20) %v; want nil")
+
+	tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "Foo", 100, "Bar", time.Now())
+	h.MustNotErr(t, err, "NewTransaction() = %+v, %v; want nil", tx)
+	want := []struct {
+		account   model.Account
+		direction model.Direction
+		amount    uint64
+	}{
+		{float, model.Debit, 100},
+		{available, model.Credit, 100},
+		{available, model.Debit, 50},
+		{blocked, model.Credit, 50},
+		{blocked, model.Debit, 30},
+		{settlement, model.Credit, 30},
+		{settlement, model.Debit, 10},
+		{available, model.Credit, 10},
+		{blocked, model.Debit, 20},
+		{available, model.Credit, 20},
+	}
+	got := tx.Postings()
+	if len(got) != len(want) {
+		t.Fatalf("len(tx.Postings()) = %d; want %d", len(got), len(want))
+	}
+	for i, p := range got {
+		if p.Account() != want[i].account || p.Direction() != want[i].direction || p.Amount() != want[i].amount {
+			t.Errorf("tx.Postings()[%d] = %+v; want %+v", i, p, want[i])
+		}
+	}
+
+	tx, err = model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "Foo", 100, "Bar", time.Now())
+	h.MustNotErr(t, err, "NewTransaction() = %+v, %v; want nil", tx)
+	if len(tx.Postings()) != 0 {
+		t.Errorf("tx.Postings() = %+v; want postings taken by the previous transaction", tx.Postings())
+	}
+}
+
 func assertAuthorizationRequestBalance(t *testing.T, req *model.AuthorizationRequest, b, c, r uint64) {
 	t.Helper()
 	if req.BlockedAmount() != b {
@@ -155,7 +608,7 @@ func assertCardBalance(t *testing.T, c *model.Card, a, b uint64) {
 
 func mustCard(t *testing.T, l, b uint64) *model.Card {
 	t.Helper()
-	c, err := model.NewCard()
+	c, err := model.NewCard(model.GBP)
 	h.MustNotErr(t, err, "%v")
 	if l > 0 {
 		h.MustNotErr(t, c.LoadMoney(l), "Card.LoadMoney(%v) %v; want nil; mustCard", l)
@@ -166,9 +619,34 @@ func mustCard(t *testing.T, l, b uint64) *model.Card {
 	return c
 }
 
+// mustCardWithStatus returns new card with status.
+func mustCardWithStatus(t *testing.T, status model.CardStatus) *model.Card {
+	t.Helper()
+	c := mustCard(t, 0, 0)
+	switch status {
+	case model.CardFrozen:
+		h.MustNotErr(t, c.Freeze(), "c.Freeze() %v; want nil; mustCardWithStatus")
+	case model.CardBlocked:
+		h.MustNotErr(t, c.Block(), "c.Block() %v; want nil; mustCardWithStatus")
+	case model.CardClosed:
+		_, err := c.Close()
+		h.MustNotErr(t, err, "c.Close() %v; want nil; mustCardWithStatus")
+	}
+	return c
+}
+
+func containsStatus(statuses []model.CardStatus, status model.CardStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 func mustAuthorizationRequest(t *testing.T, c *model.Card, b uint64) *model.AuthorizationRequest {
 	t.Helper()
-	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), b)
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(b, model.GBP), nil, time.Now())
 	h.MustNotErr(t, err, "NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), %v) %v; want nil; mustAuthorizationRequest", b)
 	return req
 }
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+// The supported currencies.
+const (
+	BHD Currency = "BHD"
+	CHF Currency = "CHF"
+	EUR Currency = "EUR"
+	GBP Currency = "GBP"
+	JPY Currency = "JPY"
+	KWD Currency = "KWD"
+	USD Currency = "USD"
+)
+
+// exponents are the ISO 4217 minor unit exponents of the supported currencies.
+var exponents = map[Currency]int{
+	BHD: 3,
+	CHF: 2,
+	EUR: 2,
+	GBP: 2,
+	JPY: 0,
+	KWD: 3,
+	USD: 2,
+}
+
+// ParseCurrency returns the supported currency with ISO 4217 code.
+func ParseCurrency(code string) (Currency, error) {
+	if code == "" {
+		return "", errors.New("currency must be ISO 4217 code")
+	}
+	c := Currency(strings.ToUpper(code))
+	if _, ok := exponents[c]; !ok {
+		return "", fmt.Errorf("currency %q is not supported", code)
+	}
+	return c, nil
+}
+
+// Exponent returns the number of digits after the decimal separator of the currency.
+func (c Currency) Exponent() int {
+	return exponents[c]
+}
+
+// Money is an amount of the minor units of a currency, e.g. pence for GBP.
+type Money struct {
+	amount   uint64
+	currency Currency
+}
+
+// NewMoney returns Money for amount minor units of currency.
+func NewMoney(amount uint64, currency Currency) Money {
+	return Money{amount, currency}
+}
+
+// ParseMoney returns Money for amount minor units of currency with ISO 4217 code.
+func ParseMoney(amount, currency string) (Money, error) {
+	c, err := ParseCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	a, err := strconv.ParseUint(amount, 10, 64)
+	if err != nil {
+		return Money{}, errors.New("amount must be unsigned integer")
+	}
+	return Money{a, c}, nil
+}
+
+// Amount returns the amount in minor units.
+func (m Money) Amount() uint64 {
+	return m.amount
+}
+
+// Currency returns the currency.
+func (m Money) Currency() Currency {
+	return m.currency
+}
+
+// String returns the amount in major units followed by the currency code, e.g. "19.50 GBP".
+func (m Money) String() string {
+	s := strconv.FormatUint(m.amount, 10)
+	e := m.currency.Exponent()
+	if e == 0 {
+		return fmt.Sprintf("%s %s", s, m.currency)
+	}
+	if len(s) <= e {
+		s = strings.Repeat("0", e-len(s)+1) + s
+	}
+	return fmt.Sprintf("%s.%s %s", s[:len(s)-e], s[len(s)-e:], m.currency)
+}
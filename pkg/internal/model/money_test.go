@@ -0,0 +1,60 @@
+// +build !integration
+
+package model_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestParseCurrency(t *testing.T) {
+	t.Run("returns the currency", func(t *testing.T) {
+		c, err := model.ParseCurrency("eur")
+		h.MustNotErr(t, err, "ParseCurrency(\"eur\") = %v; want nil")
+		h.MustE(t, c, model.EUR, "ParseCurrency(\"eur\") = %q; want %q")
+	})
+	t.Run("returns error if the currency is not supported", func(t *testing.T) {
+		for _, code := range []string{"", "XXX", "pounds"} {
+			_, err := model.ParseCurrency(code)
+			h.MustErr(t, err, "ParseCurrency(%q) = nil; want error", code)
+		}
+	})
+}
+
+func TestCurrency_Exponent(t *testing.T) {
+	for c, e := range map[model.Currency]int{model.GBP: 2, model.EUR: 2, model.USD: 2, model.JPY: 0, model.BHD: 3} {
+		h.MustE(t, c.Exponent(), e, "c.Exponent() = %d; want %d")
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	m, err := model.ParseMoney("1950", "USD")
+	h.MustNotErr(t, err, "ParseMoney() = %v; want nil")
+	h.MustE(t, m, model.NewMoney(1950, model.USD), "ParseMoney() = %v; want %v")
+	for _, a := range []string{"", "-1", "1.5", "foo", "18446744073709551616"} {
+		_, err := model.ParseMoney(a, "USD")
+		h.MustErr(t, err, "ParseMoney(%q, \"USD\") = nil; want error", a)
+	}
+	_, err = model.ParseMoney("1", "XXX")
+	h.MustErr(t, err, "ParseMoney(\"1\", \"XXX\") = nil; want error")
+}
+
+func TestMoney_String(t *testing.T) {
+	for _, tc := range []struct {
+		m    model.Money
+		want string
+	}{
+		{model.NewMoney(1950, model.GBP), "19.50 GBP"},
+		{model.NewMoney(5, model.EUR), "0.05 EUR"},
+		{model.NewMoney(0, model.USD), "0.00 USD"},
+		{model.NewMoney(1950, model.JPY), "1950 JPY"},
+		{model.NewMoney(1950, model.BHD), "1.950 BHD"},
+		{model.NewMoney(7, model.KWD), "0.007 KWD"},
+		{model.NewMoney(math.MaxUint64, model.GBP), "184467440737095516.15 GBP"},
+	} {
+		h.MustE(t, tc.m.String(), tc.want, "m.String() = %q; want %q")
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+)
+
+// AuthorizationRequest is the authorization request returned to the client.
+type AuthorizationRequest struct {
+	UUID                   string                         `json:"uuid"`
+	CardUUID               string                         `json:"cardUUID"`
+	MerchantUUID           string                         `json:"merchantUUID"`
+	Currency               string                         `json:"currency"`
+	CardCurrency           string                         `json:"cardCurrency"`
+	Rate                   string                         `json:"rate"`
+	OriginalAmount         string                         `json:"originalAmount"`
+	ConvertedAmount        string                         `json:"convertedAmount"`
+	BlockedAmount          string                         `json:"blockedAmount"`
+	CapturedAmount         string                         `json:"capturedAmount"`
+	RefundedAmount         string                         `json:"refundedAmount"`
+	OriginalBlockedAmount  string                         `json:"originalBlockedAmount"`
+	OriginalCapturedAmount string                         `json:"originalCapturedAmount"`
+	OriginalRefundedAmount string                         `json:"originalRefundedAmount"`
+	ExpiresAt              string                         `json:"expiresAt"`
+	History                []AuthorizationRequestSnapshot `json:"history"`
+}
+
+// AuthorizationRequestSnapshot is the authorization request snapshot returned to the client.
+type AuthorizationRequestSnapshot struct {
+	UUID                   string `json:"uuid"`
+	Currency               string `json:"currency"`
+	CardCurrency           string `json:"cardCurrency"`
+	Rate                   string `json:"rate"`
+	BlockedAmount          string `json:"blockedAmount"`
+	CapturedAmount         string `json:"capturedAmount"`
+	RefundedAmount         string `json:"refundedAmount"`
+	OriginalBlockedAmount  string `json:"originalBlockedAmount"`
+	OriginalCapturedAmount string `json:"originalCapturedAmount"`
+	OriginalRefundedAmount string `json:"originalRefundedAmount"`
+	CreatedAt              string `json:"createdAt"`
+}
+
+// NewAuthorizationRequest returns AuthorizationRequest representing req.
+func NewAuthorizationRequest(req *model.AuthorizationRequest) AuthorizationRequest {
+	history := make([]AuthorizationRequestSnapshot, 0, len(req.History()))
+	for _, s := range req.History() {
+		history = append(history, AuthorizationRequestSnapshot{
+			UUID:                   s.UUID().String(),
+			Currency:               string(s.Rate().From()),
+			CardCurrency:           string(s.Rate().To()),
+			Rate:                   s.Rate().Rate(),
+			BlockedAmount:          strconv.FormatUint(s.BlockedAmount(), 10),
+			CapturedAmount:         strconv.FormatUint(s.CapturedAmount(), 10),
+			RefundedAmount:         strconv.FormatUint(s.RefundedAmount(), 10),
+			OriginalBlockedAmount:  strconv.FormatUint(s.OriginalBlockedAmount(), 10),
+			OriginalCapturedAmount: strconv.FormatUint(s.OriginalCapturedAmount(), 10),
+			OriginalRefundedAmount: strconv.FormatUint(s.OriginalRefundedAmount(), 10),
+			CreatedAt:              s.CreatedAt().Format(time.RFC3339),
+		})
+	}
+	return AuthorizationRequest{
+		UUID:                   req.UUID().String(),
+		CardUUID:               req.CardUUID().String(),
+		MerchantUUID:           req.MerchantUUID().String(),
+		Currency:               string(req.Rate().From()),
+		CardCurrency:           string(req.Rate().To()),
+		Rate:                   req.Rate().Rate(),
+		OriginalAmount:         strconv.FormatUint(req.OriginalAmount(), 10),
+		ConvertedAmount:        strconv.FormatUint(req.ConvertedAmount(), 10),
+		BlockedAmount:          strconv.FormatUint(req.BlockedAmount(), 10),
+		CapturedAmount:         strconv.FormatUint(req.CapturedAmount(), 10),
+		RefundedAmount:         strconv.FormatUint(req.RefundedAmount(), 10),
+		OriginalBlockedAmount:  strconv.FormatUint(req.OriginalBlockedAmount(), 10),
+		OriginalCapturedAmount: strconv.FormatUint(req.OriginalCapturedAmount(), 10),
+		OriginalRefundedAmount: strconv.FormatUint(req.OriginalRefundedAmount(), 10),
+		ExpiresAt:              req.ExpiresAt().Format(time.RFC3339),
+		History:                history,
+	}
+}
@@ -0,0 +1,175 @@
+package authorize
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Request is the authorization request sent by a merchant.
+type Request struct {
+	MerchantUUID string `json:"merchantUUID"`
+	CardUUID     string `json:"cardUUID"`
+	Amount       string `json:"amount"`
+	Currency     string `json:"currency"`
+	// MerchantCategory is the optional merchant category code, which must be the category of the merchant.
+	MerchantCategory string `json:"merchantCategory"`
+}
+
+// Response is the response, which Service returns when a request is authorized.
+type Response service.AuthorizationRequest
+
+// Service is the service authorizing merchant requests.
+type Service struct {
+	uow    service.UnitOfWork
+	rates  model.FXRateProvider
+	holds  model.HoldPeriods
+	limits model.ProductLimits
+
+	// Now returns the current time, at which the requests are authorized and their holds start, the limits of
+	// the cards are checked and the transactions are recorded.
+	Now func() time.Time
+}
+
+// New returns new service authorizing merchant requests, which saves the card, the authorization
+// request, its transaction and its event with unit of work u.
+// The requests in currencies different from the currencies of the cards are converted with the rates of r.
+// If r is nil, only requests in the currencies of the cards are authorized.
+// The blocked amounts expire after the hold periods p of the categories of the merchants and the requests,
+// which exceed the limits of the cards with the default limits l of their products, are declined.
+func New(u service.UnitOfWork, r model.FXRateProvider, p model.HoldPeriods, l model.ProductLimits) *Service {
+	return &Service{uow: u, rates: r, holds: p, limits: l, Now: time.Now}
+}
+
+// Authorize blocks the amount of req on the card and returns the authorization request.
+// It returns 422 service.ErrorResponse if the request cannot be authorized, the merchant is not registered
+// and active, the card does not allow the category of the merchant,
+// its currency cannot be converted to the currency of the card or it exceeds a limit of the card and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Authorize(ctx context.Context, req Request) (Response, error) {
+	v := &validation.Validator{}
+	merchantUUID := v.UUID("merchantUUID", req.MerchantUUID)
+	cardUUID := v.UUID("cardUUID", req.CardUUID)
+	money := v.Money("amount", "currency", req.Amount, req.Currency)
+	if req.MerchantCategory != "" && !model.IsMerchantCategory(req.MerchantCategory) {
+		v.Invalid("merchantCategory", "must be 4-digit merchant category code")
+	}
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err := service.Retry(func() error {
+		var err error
+		res, err = svc.authorize(ctx, merchantUUID, cardUUID, money, req.MerchantCategory)
+		return err
+	})
+	return res, err
+}
+
+// authorize is Authorize without retries.
+func (svc *Service) authorize(ctx context.Context, merchantUUID, cardUUID uuid.UUID, money model.Money, category string) (Response, error) {
+	var authReq *model.AuthorizationRequest
+	err := svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		card, err := r.GetCard(ctx, cardUUID)
+		if err == service.ErrNotFound {
+			return service.NewValidationErrorResponse(
+				"The card does not exist.",
+				service.InvalidParameter{Name: "cardUUID", Reason: "must be UUID of existing card"},
+			)
+		}
+		if err != nil {
+			return service.Wrap(err, "Authorize() cannot get card")
+		}
+		merchant, err := r.GetMerchant(ctx, merchantUUID)
+		if err == service.ErrNotFound {
+			return service.NewValidationErrorResponse(
+				"The merchant is not registered.",
+				service.InvalidParameter{Name: "merchantUUID", Reason: "must be UUID of registered merchant"},
+			)
+		}
+		if err != nil {
+			return service.Wrap(err, "Authorize() cannot get merchant")
+		}
+		if merchant.Status() != model.MerchantActive {
+			return service.NewValidationErrorResponse(fmt.Sprintf("The merchant is %s.", merchant.Status()))
+		}
+		if category != "" && category != merchant.Category() {
+			return service.NewValidationErrorResponse(
+				"The merchant category is not the category of the merchant.",
+				service.InvalidParameter{Name: "merchantCategory", Reason: "must be " + merchant.Category()},
+			)
+		}
+		categories, err := r.GetCardMerchantCategories(ctx, card.UUID())
+		if err != nil {
+			return service.Wrap(err, "Authorize() cannot get card merchant categories")
+		}
+		if !categories.Allows(merchant.Category()) {
+			return service.NewValidationErrorResponse(
+				fmt.Sprintf("The card does not allow payments to merchant category %s.", merchant.Category()),
+			)
+		}
+		if money.Currency() != card.Currency() && svc.rates == nil {
+			return service.NewCurrencyMismatchErrorResponse(card.Currency())
+		}
+		now := svc.Now()
+		authReq, err = model.NewAuthorizationRequest(card, merchantUUID, money, svc.rates, now)
+		if err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		amount := authReq.ConvertedAmount()
+		err = service.CheckLimits(ctx, r, card.UUID(), svc.limits, now, func(l model.Limits, txs []*model.Transaction) error {
+			return l.CheckAuthorization(txs, amount, now)
+		})
+		if err != nil {
+			return err
+		}
+		if err := authReq.HoldFor(svc.holds.Period(merchant.Category())); err != nil {
+			return fmt.Errorf("Authorize() cannot set hold period; %v", err)
+		}
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Authorize() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, authReq, eventUUID, event.TypeAuthorizationRequestCreated, authReq.ConvertedAmount(), "Authorization request", now)
+		if err != nil {
+			return fmt.Errorf("Authorize() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "Authorize() cannot persist card")
+		}
+		if err := r.SaveAuthorizationRequest(ctx, authReq); err != nil {
+			return service.Wrap(err, "Authorize() cannot persist authorization request")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "Authorize() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.AuthorizationRequestCreated{
+			UUID:                     eventUUID,
+			Time:                     tx.Date(),
+			AuthorizationRequestUUID: authReq.UUID(),
+			CardUUID:                 card.UUID(),
+			MerchantUUID:             merchantUUID,
+			Amount:                   authReq.ConvertedAmount(),
+			OriginalAmount:           money.Amount(),
+			Currency:                 string(authReq.Currency()),
+			CardCurrency:             string(card.Currency()),
+			Rate:                     authReq.Rate().Rate(),
+			ExpiresAt:                authReq.ExpiresAt(),
+		})
+		if err != nil {
+			return service.Wrap(err, "Authorize() cannot persist event")
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return Response(service.NewAuthorizationRequest(authReq)), nil
+}
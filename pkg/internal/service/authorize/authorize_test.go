@@ -0,0 +1,389 @@
+// +build !integration
+
+package authorize_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Authorize(t *testing.T) {
+	t.Run("blocks the amount, saves the authorization request and its event", func(t *testing.T) {
+		c := mustCard(t, 100)
+		m := mustMerchant(t, "5411")
+		r := &h.Repository{Card: c, Merchant: m}
+		svc := authorize.New(r, nil, nil, nil)
+		res, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: m.UUID().String(), CardUUID: c.UUID().String(), Amount: "70", Currency: "GBP"})
+		h.MustNotErr(t, err, "got svc.Authorize() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(30), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(70), "got blocked balance %v, want %v")
+		h.Must(t, r.AuthorizationRequest != nil, "got no saved authorization request, want one")
+		h.MustE(t, res.UUID, r.AuthorizationRequest.UUID().String(), "got response UUID %q != saved UUID %q, want them equal")
+		h.MustE(t, res.CardUUID, c.UUID().String(), "got response card UUID %q, want %q")
+		h.MustE(t, res.MerchantUUID, m.UUID().String(), "got response merchant UUID %q, want %q")
+		h.MustE(t, res.BlockedAmount, "70", "got response blocked amount %q, want %q")
+		h.MustE(t, len(res.History), 1, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, r.Transactions[0].EventType(), event.TypeAuthorizationRequestCreated, "got transaction event type %q, want %q")
+		h.MustE(t, savedEvent(t, r).UUID, r.Transactions[0].EventUUID(), "got saved event event UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).AuthorizationRequestUUID, r.AuthorizationRequest.UUID(), "got saved event authorization request UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).MerchantUUID, m.UUID(), "got saved event merchant UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(70), "got saved event amount %v, want %v")
+	})
+	t.Run("converts the amount to the currency of the card", func(t *testing.T) {
+		c := mustCard(t, 1000)
+		r := &h.Repository{Card: c, Merchant: mustMerchant(t, "5411")}
+		svc := authorize.New(r, rates{"EUR/GBP": "0.85"}, nil, nil)
+		res, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: r.Merchant.UUID().String(), CardUUID: c.UUID().String(), Amount: "1000", Currency: "EUR"})
+		h.MustNotErr(t, err, "got svc.Authorize() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.BlockedBalance(), uint64(850), "got blocked balance %v, want %v")
+		h.MustE(t, res.Currency, "EUR", "got response currency %q, want %q")
+		h.MustE(t, res.CardCurrency, "GBP", "got response card currency %q, want %q")
+		h.MustE(t, res.Rate, "0.85", "got response rate %q, want %q")
+		h.MustE(t, res.OriginalAmount, "1000", "got response original amount %q, want %q")
+		h.MustE(t, res.ConvertedAmount, "850", "got response converted amount %q, want %q")
+		h.MustE(t, res.BlockedAmount, "850", "got response blocked amount %q, want %q")
+		h.MustE(t, r.Transactions[0].Amount(), uint64(850), "got transaction amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(850), "got saved event amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).OriginalAmount, uint64(1000), "got saved event original amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).Currency, "EUR", "got saved event currency %v, want %v")
+		h.MustE(t, savedEvent(t, r).CardCurrency, "GBP", "got saved event card currency %v, want %v")
+		h.MustE(t, savedEvent(t, r).Rate, "0.85", "got saved event rate %v, want %v")
+	})
+	t.Run("holds the amount for the period of the category of the merchant", func(t *testing.T) {
+		for _, tc := range []struct {
+			merchant, request string
+			period            time.Duration
+		}{
+			{"7011", "7011", 30 * 24 * time.Hour},
+			{"7011", "", 30 * 24 * time.Hour},
+			{"5411", "", model.DefaultHoldPeriod},
+		} {
+			c := mustCard(t, 100)
+			r := &h.Repository{Card: c, Merchant: mustMerchant(t, tc.merchant)}
+			svc := authorize.New(r, nil, model.HoldPeriods{"7011": 30 * 24 * time.Hour}, nil)
+			_, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: r.Merchant.UUID().String(), CardUUID: c.UUID().String(), Amount: "1", Currency: "GBP", MerchantCategory: tc.request})
+			h.MustNotErr(t, err, "got svc.Authorize() error %v, want nil")
+			want := r.AuthorizationRequest.History()[0].CreatedAt().Add(tc.period)
+			h.MustE(t, r.AuthorizationRequest.ExpiresAt(), want, "got expiry %v, want %v")
+			h.MustE(t, savedEvent(t, r).ExpiresAt, want, "got saved event expiry %v, want %v")
+		}
+	})
+	t.Run("returns 422 error response if the currency cannot be converted", func(t *testing.T) {
+		c := mustCard(t, 1000)
+		for _, p := range []model.FXRateProvider{nil, rates{}} {
+			r := &h.Repository{Card: c, Merchant: mustMerchant(t, "5411")}
+			_, err := authorize.New(r, p, nil, nil).Authorize(context.Background(), authorize.Request{MerchantUUID: r.Merchant.UUID().String(), CardUUID: c.UUID().String(), Amount: "1", Currency: "USD"})
+			res, ok := err.(service.ErrorResponse)
+			h.Must(t, ok, "got error %#v for %v, want service.ErrorResponse", err, p)
+			h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+			h.Must(t, r.AuthorizationRequest == nil, "got saved authorization request for %v, want none", p)
+		}
+	})
+	t.Run("returns 422 error response if the request is invalid", func(t *testing.T) {
+		c := mustCard(t, 100)
+		merchant := mustMerchant(t, "5411")
+		m := merchant.UUID().String()
+		for _, req := range []authorize.Request{
+			{MerchantUUID: "foo", CardUUID: c.UUID().String(), Amount: "1", Currency: "GBP"},
+			{MerchantUUID: m, CardUUID: "foo", Amount: "1", Currency: "GBP"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "foo", Currency: "GBP"},
+			{MerchantUUID: m, CardUUID: uuid.Must(uuid.NewV4()).String(), Amount: "1", Currency: "GBP"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "0", Currency: "GBP"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "101", Currency: "GBP"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "1"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "1", Currency: "XXX"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "1", Currency: "EUR"},
+			{MerchantUUID: m, CardUUID: c.UUID().String(), Amount: "1", Currency: "GBP", MerchantCategory: "hotel"},
+		} {
+			r := &h.Repository{Card: c, Merchant: merchant}
+			_, err := authorize.New(r, nil, nil, nil).Authorize(context.Background(), req)
+			res, ok := err.(service.ErrorResponse)
+			h.Must(t, ok, "got error %#v for %+v, want service.ErrorResponse", err, req)
+			h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+			h.Must(t, r.AuthorizationRequest == nil, "got saved authorization request for %+v, want none", req)
+			h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+		}
+	})
+	t.Run("returns 422 error response with all invalid parameters", func(t *testing.T) {
+		r := &h.Repository{Card: mustCard(t, 100)}
+		_, err := authorize.New(r, nil, nil, nil).Authorize(context.Background(), authorize.Request{MerchantUUID: "foo", CardUUID: "bar", Amount: "-1", Currency: "XXX"})
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, len(res.InvalidParameters), 4, "got %d invalid parameters, want %d")
+		for i, name := range []string{"merchantUUID", "cardUUID", "amount", "currency"} {
+			h.MustE(t, res.InvalidParameters[i].Name, name, "got invalid parameter %q, want %q")
+		}
+	})
+	t.Run("returns 422 error response if the merchant cannot authorize the payment", func(t *testing.T) {
+		c := mustCard(t, 100)
+		suspended := mustMerchant(t, "7995")
+		h.MustNotErr(t, suspended.SetStatus(model.MerchantSuspended), "got SetStatus() error %v, want nil")
+		for _, tc := range []struct {
+			name       string
+			merchant   *model.Merchant
+			categories *model.MerchantCategories
+			category   string
+		}{
+			{name: "not registered"},
+			{name: "suspended", merchant: suspended},
+			{name: "other category", merchant: mustMerchant(t, "7995"), category: "5411"},
+			{name: "blocked category", merchant: mustMerchant(t, "7995"), categories: &model.MerchantCategories{Blocked: []string{"7995"}}},
+			{name: "not allowed category", merchant: mustMerchant(t, "7995"), categories: &model.MerchantCategories{Allowed: []string{"5411"}}},
+		} {
+			r := &h.Repository{Card: c, Merchant: tc.merchant, MerchantCategories: tc.categories}
+			m := uuid.Must(uuid.NewV4())
+			if tc.merchant != nil {
+				m = tc.merchant.UUID()
+			}
+			_, err := authorize.New(r, nil, nil, nil).Authorize(context.Background(), authorize.Request{MerchantUUID: m.String(), CardUUID: c.UUID().String(), Amount: "1", Currency: "GBP", MerchantCategory: tc.category})
+			res, ok := err.(service.ErrorResponse)
+			h.Must(t, ok, "got error %#v for %s merchant, want service.ErrorResponse", err, tc.name)
+			h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+			h.Must(t, r.AuthorizationRequest == nil, "got saved authorization request for %s merchant, want none", tc.name)
+			h.MustE(t, r.Card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+		}
+	})
+	t.Run("returns 422 error response if the authorization exceeds the limits of the card", func(t *testing.T) {
+		c := mustCard(t, 100)
+		r := &h.Repository{Card: c, Merchant: mustMerchant(t, "5411")}
+		r.CardLimits = &model.CardLimits{Product: "travel", Limits: model.Limits{Authorizations: 1}}
+		svc := authorize.New(r, nil, nil, model.ProductLimits{"travel": {PerTransaction: 50, AuthorizationWindow: time.Hour}})
+		auth := func(amount string) error {
+			_, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: r.Merchant.UUID().String(), CardUUID: c.UUID().String(), Amount: amount, Currency: "GBP"})
+			return err
+		}
+		mustLimitExceeded(t, auth("51"), model.LimitPerTransaction)
+		h.MustNotErr(t, auth("50"), "got svc.Authorize() error %v, want nil")
+		mustLimitExceeded(t, auth("1"), model.LimitAuthorizations)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(50), "got available balance %v, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		c := mustCard(t, 100)
+		r := &h.Repository{Card: c, Merchant: mustMerchant(t, "5411"), CommitErr: errors.New("test commit failed")}
+		svc := authorize.New(r, nil, nil, nil)
+		_, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: r.Merchant.UUID().String(), CardUUID: c.UUID().String(), Amount: "1", Currency: "GBP"})
+		h.MustErr(t, err, "got svc.Authorize() = authorize.Response, nil, want authorize.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), uint64(100), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+		h.Must(t, r.AuthorizationRequest == nil, "got saved authorization request, want none")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func TestService_Authorize_concurrency(t *testing.T) {
+	t.Run("retries the authorization if the card is changed concurrently", func(t *testing.T) {
+		s := newStore(t, mustCard(t, 100))
+		s.conflicts = 3
+		svc := authorize.New(s, nil, nil, nil)
+		_, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: s.merchant.UUID().String(), CardUUID: s.card.UUID().String(), Amount: "1", Currency: "GBP"})
+		h.MustNotErr(t, err, "got error %v, want nil")
+		h.MustE(t, s.card.BlockedBalance(), uint64(1), "got blocked balance %v, want %v")
+		h.MustE(t, s.card.Version(), uint64(4), "got version %v, want %v")
+	})
+	t.Run("returns ErrConcurrentModification after the retries", func(t *testing.T) {
+		s := newStore(t, mustCard(t, 100))
+		s.conflicts = service.MaxRetries + 1
+		svc := authorize.New(s, nil, nil, nil)
+		_, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: s.merchant.UUID().String(), CardUUID: s.card.UUID().String(), Amount: "1", Currency: "GBP"})
+		h.MustE(t, service.KindOf(err), service.ErrConcurrentModification, "got error kind %v, want %v")
+		h.MustE(t, s.card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+	})
+	t.Run("does not overdraw the card with parallel authorizations", func(t *testing.T) {
+		const balance, requests = 50, 300
+		s := newStore(t, mustCard(t, balance))
+		svc := authorize.New(s, nil, nil, nil)
+		id := s.card.UUID().String()
+		m := s.merchant.UUID().String()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, requests)
+		for i := 0; i < requests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: m, CardUUID: id, Amount: "1", Currency: "GBP"})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		var authorized uint64
+		for err := range errs {
+			if err == nil {
+				authorized++
+				continue
+			}
+			if res, ok := err.(service.ErrorResponse); ok && res.StatusCode() == 422 {
+				continue
+			}
+			h.MustE(t, service.KindOf(err), service.ErrConcurrentModification, "got error kind %v, want %v")
+		}
+		h.Must(t, authorized <= balance, "got %d authorizations, want at most %d", authorized, balance)
+		h.MustE(t, s.card.BlockedBalance(), authorized, "got blocked balance %v, want %v")
+		h.MustE(t, s.card.AvailableBalance(), balance-authorized, "got available balance %v, want %v")
+		h.MustE(t, s.saved, authorized, "got %v saved authorization requests, want %v")
+		h.MustE(t, s.events, authorized, "got %v saved events, want %v")
+	})
+}
+
+func mustLimitExceeded(t *testing.T, err error, limit string) {
+	t.Helper()
+	res, ok := err.(service.ErrorResponse)
+	h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+	h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+	h.MustE(t, res.Type, "/doc/error/limit-exceeded", "got type %q, want %q")
+	h.MustE(t, res.Extensions["limit"], limit, "got limit %v, want %v")
+}
+
+func mustMerchant(t *testing.T, category string) *model.Merchant {
+	t.Helper()
+	m, err := model.NewMerchant("Test Merchant", category, "GB", "GB29NWBK60161331926819")
+	h.MustNotErr(t, err, "%v")
+	return m
+}
+
+func mustCard(t *testing.T, amount uint64) *model.Card {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(amount), "c.LoadMoney() %v; want nil")
+	return c
+}
+
+// rates is model.FXRateProvider with rates like {"EUR/GBP": "0.85"}.
+type rates map[string]string
+
+func (r rates) FXRate(from, to model.Currency) (model.FXRate, error) {
+	rate, ok := r[string(from)+"/"+string(to)]
+	if !ok {
+		return model.FXRate{}, errors.New("rate not found")
+	}
+	return model.NewFXRate(from, to, rate)
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) event.AuthorizationRequestCreated {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	e, ok := r.Events[0].(event.AuthorizationRequestCreated)
+	h.Must(t, ok, "got saved event %T, want event.AuthorizationRequestCreated", r.Events[0])
+	return e
+}
+
+// store is a concurrency-safe unit of work of a card without isolation, which updates the card
+// only if the stored card has the version of the updated card.
+type store struct {
+	mu        sync.Mutex
+	card      *model.Card
+	merchant  *model.Merchant
+	saved     uint64
+	events    uint64
+	conflicts int // the number of the updates, which fail with service.ErrConcurrentModification
+}
+
+var _ service.UnitOfWork = &store{}
+var _ service.Tx = &store{}
+
+func newStore(t *testing.T, c *model.Card) *store {
+	return &store{card: c, merchant: mustMerchant(t, "5411")}
+}
+
+func (s *store) WithinTx(_ context.Context, fn func(service.Tx) error) error {
+	return fn(s)
+}
+
+func (s *store) GetCard(_ context.Context, id uuid.UUID) (*model.Card, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id != s.card.UUID() {
+		return &model.Card{}, service.ErrNotFound
+	}
+	return model.CardFromData(s.card), nil
+}
+
+func (s *store) GetAuthorizationRequest(context.Context, uuid.UUID) (*model.AuthorizationRequest, error) {
+	return &model.AuthorizationRequest{}, service.ErrNotFound
+}
+
+func (s *store) SaveCard(context.Context, *model.Card) error {
+	return nil
+}
+
+func (s *store) UpdateCard(_ context.Context, c *model.Card) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conflicts > 0 {
+		s.conflicts--
+		s.card = model.CardFromData(s.card)
+		s.card.Saved()
+		return service.ErrConcurrentModification
+	}
+	if c.Version() != s.card.Version() {
+		return service.ErrConcurrentModification
+	}
+	c.Saved()
+	s.card = c
+	s.saved++
+	return nil
+}
+
+func (s *store) SaveAuthorizationRequest(context.Context, *model.AuthorizationRequest) error {
+	return nil
+}
+
+func (s *store) SaveTransaction(context.Context, *model.Transaction) error {
+	return nil
+}
+
+func (s *store) GetCardLimits(context.Context, uuid.UUID) (model.CardLimits, error) {
+	return model.CardLimits{}, service.ErrNotFound
+}
+
+func (s *store) SaveCardLimits(context.Context, uuid.UUID, model.CardLimits) error {
+	return nil
+}
+
+func (s *store) ListCardTransactions(context.Context, uuid.UUID, time.Time) ([]*model.Transaction, error) {
+	return nil, nil
+}
+
+func (s *store) GetCardMerchantCategories(context.Context, uuid.UUID) (model.MerchantCategories, error) {
+	return model.MerchantCategories{}, nil
+}
+
+func (s *store) SaveCardMerchantCategories(context.Context, uuid.UUID, model.MerchantCategories) error {
+	return nil
+}
+
+func (s *store) GetMerchant(_ context.Context, id uuid.UUID) (*model.Merchant, error) {
+	if id != s.merchant.UUID() {
+		return &model.Merchant{}, service.ErrNotFound
+	}
+	return model.MerchantFromData(s.merchant), nil
+}
+
+func (s *store) SaveMerchant(context.Context, *model.Merchant) error {
+	return nil
+}
+
+func (s *store) SaveEvent(context.Context, interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events++
+	return nil
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ExponentialBackoff returns backoff, which doubles the delay from base after each failed attempt up to max.
+func ExponentialBackoff(base, max time.Duration) func(attempts int) time.Duration {
+	return func(attempts int) time.Duration {
+		d := base
+		for i := 1; i < attempts && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// Jitter returns backoff, which randomizes the delays of b between the half and the whole delay,
+// so the operations, which fail at the same time, are not retried at the same time.
+func Jitter(b func(attempts int) time.Duration) func(attempts int) time.Duration {
+	return func(attempts int) time.Duration {
+		d := b(attempts)
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+}
@@ -0,0 +1,34 @@
+// +build !integration
+
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := service.ExponentialBackoff(time.Second, time.Minute)
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		6:  32 * time.Second,
+		7:  time.Minute,
+		50: time.Minute,
+	} {
+		h.MustE(t, b(attempts), want, "got backoff %v, want %v")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	b := service.Jitter(service.ExponentialBackoff(time.Second, time.Minute))
+	for i := 0; i < 100; i++ {
+		d := b(2)
+		h.Must(t, d >= time.Second && d <= 2*time.Second, "got backoff %v, want between 1s and 2s", d)
+	}
+	h.MustE(t, service.Jitter(func(int) time.Duration { return 0 })(1), time.Duration(0), "got backoff %v, want %v")
+}
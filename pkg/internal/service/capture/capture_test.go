@@ -0,0 +1,116 @@
+// +build !integration
+
+package capture_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/capture"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Capture(t *testing.T) {
+	t.Run("captures the amount, saves the authorization request and its event", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		res, err := capture.New(r).Capture(context.Background(), r.AuthorizationRequest.UUID().String(), capture.Request{Amount: "50"})
+		h.MustNotErr(t, err, "got svc.Capture() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(30), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(20), "got blocked balance %v, want %v")
+		h.MustE(t, res.BlockedAmount, "20", "got response blocked amount %q, want %q")
+		h.MustE(t, res.CapturedAmount, "50", "got response captured amount %q, want %q")
+		h.MustE(t, len(res.History), 2, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, r.Transactions[0].EventType(), event.TypeAuthorizationRequestCaptured, "got transaction event type %q, want %q")
+		h.MustE(t, savedEvent(t, r).UUID, r.Transactions[0].EventUUID(), "got saved event event UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).AuthorizationRequestUUID, r.AuthorizationRequest.UUID(), "got saved event authorization request UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(50), "got saved event amount %v, want %v")
+	})
+	t.Run("converts the amount with the rate of the authorization request", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, c.LoadMoney(1000), "c.LoadMoney() %v; want nil")
+		rate, err := model.NewFXRate(model.EUR, model.GBP, "0.85")
+		h.MustNotErr(t, err, "%v")
+		req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(1000, model.EUR), fixedRate(rate), time.Now())
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c, AuthorizationRequest: req}
+		res, err := capture.New(r).Capture(context.Background(), req.UUID().String(), capture.Request{Amount: "500"})
+		h.MustNotErr(t, err, "got svc.Capture() = %T, %#v, want nil", res)
+		h.MustE(t, res.CapturedAmount, "425", "got response captured amount %q, want %q")
+		h.MustE(t, res.OriginalCapturedAmount, "500", "got response original captured amount %q, want %q")
+		h.MustE(t, r.Transactions[0].Amount(), uint64(425), "got transaction amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(425), "got saved event amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).OriginalAmount, uint64(500), "got saved event original amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).Rate, "0.85", "got saved event rate %v, want %v")
+	})
+	t.Run("returns 404 error response if the authorization request does not exist", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		_, err := capture.New(r).Capture(context.Background(), uuid.Must(uuid.NewV4()).String(), capture.Request{Amount: "1"})
+		h.MustErrorResponse(t, err, 404)
+	})
+	t.Run("returns 422 error response if the amount cannot be captured", func(t *testing.T) {
+		for _, a := range []string{"foo", "0", "71"} {
+			r := mustRepository(t, 100, 70)
+			_, err := capture.New(r).Capture(context.Background(), r.AuthorizationRequest.UUID().String(), capture.Request{Amount: a})
+			h.MustErrorResponse(t, err, 422)
+			h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+			h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+		}
+	})
+	t.Run("returns 422 error response if the authorization request is expired at the current time", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		svc := capture.New(r)
+		svc.Now = func() time.Time { return r.AuthorizationRequest.ExpiresAt() }
+		_, err := svc.Capture(context.Background(), r.AuthorizationRequest.UUID().String(), capture.Request{Amount: "70"})
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, r.Card.BlockedBalance(), uint64(70), "got blocked balance %v, want %v")
+		h.MustE(t, r.AuthorizationRequest.CapturedAmount(), uint64(0), "got captured amount %v, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		r.CommitErr = errors.New("test commit failed")
+		available, blocked := r.Card.AvailableBalance(), r.Card.BlockedBalance()
+		history := len(r.AuthorizationRequest.History())
+		_, err := capture.New(r).Capture(context.Background(), r.AuthorizationRequest.UUID().String(), capture.Request{Amount: "1"})
+		h.MustErr(t, err, "got svc.Capture() = capture.Response, nil, want capture.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), available, "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), blocked, "got blocked balance %v, want %v")
+		h.MustE(t, len(r.AuthorizationRequest.History()), history, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func mustRepository(t *testing.T, l, b uint64) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(l), "c.LoadMoney() %v; want nil")
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(b, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Card: c, AuthorizationRequest: req}
+}
+
+// fixedRate is model.FXRateProvider returning the same rate for any currency pair.
+type fixedRate model.FXRate
+
+func (r fixedRate) FXRate(from, to model.Currency) (model.FXRate, error) {
+	return model.FXRate(r), nil
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) event.AuthorizationRequestCaptured {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	e, ok := r.Events[0].(event.AuthorizationRequestCaptured)
+	h.Must(t, ok, "got saved event %T, want event.AuthorizationRequestCaptured", r.Events[0])
+	return e
+}
@@ -0,0 +1,123 @@
+package cardcategories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Request is the request for setting the merchant category restrictions of a card,
+// which replace its restrictions.
+type Request struct {
+	// Allowed are the only merchant category codes, which are allowed, if it is not empty.
+	Allowed []string `json:"allowed"`
+	// Blocked are the merchant category codes, which are not allowed, e.g. "7995" for gambling.
+	Blocked []string `json:"blocked"`
+}
+
+// Response is the response, which Service returns with the merchant category restrictions of a card.
+type Response struct {
+	Allowed []string `json:"allowed"`
+	Blocked []string `json:"blocked"`
+}
+
+// Service is the service returning and setting the merchant category restrictions of cards.
+type Service struct {
+	uow service.UnitOfWork
+}
+
+// New returns new service returning and setting the merchant category restrictions of cards,
+// which saves the restrictions with unit of work u.
+func New(u service.UnitOfWork) *Service {
+	return &Service{u}
+}
+
+// GetCategories returns the merchant category restrictions of the card with UUID id.
+// It returns 404 service.ErrorResponse if the card does not exist.
+func (svc *Service) GetCategories(ctx context.Context, id string) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	var res Response
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		if err := findCard(ctx, r, cardUUID); err != nil {
+			return err
+		}
+		c, err := r.GetCardMerchantCategories(ctx, cardUUID)
+		if err != nil {
+			return service.Wrap(err, "GetCategories() cannot get card merchant categories")
+		}
+		res = newResponse(c)
+		return nil
+	})
+	return res, err
+}
+
+// SetCategories replaces the merchant category restrictions of the card with UUID id with req and returns them.
+// It returns 404 service.ErrorResponse if the card does not exist and
+// 422 service.ErrorResponse if the merchant category codes are invalid or repeated.
+func (svc *Service) SetCategories(ctx context.Context, id string, req Request) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	v := &validation.Validator{}
+	seen := map[string]bool{}
+	for _, list := range []struct {
+		name  string
+		codes []string
+	}{{"allowed", req.Allowed}, {"blocked", req.Blocked}} {
+		for i, mcc := range list.codes {
+			name := fmt.Sprintf("%s[%d]", list.name, i)
+			switch {
+			case !model.IsMerchantCategory(mcc):
+				v.Invalid(name, "must be 4-digit merchant category code")
+			case seen[mcc]:
+				v.Invalid(name, "must not be repeated")
+			}
+			seen[mcc] = true
+		}
+	}
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	c := model.MerchantCategories{Allowed: req.Allowed, Blocked: req.Blocked}
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		if err := findCard(ctx, r, cardUUID); err != nil {
+			return err
+		}
+		if err := r.SaveCardMerchantCategories(ctx, cardUUID, c); err != nil {
+			return service.Wrap(err, "SetCategories() cannot persist card merchant categories")
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return newResponse(c), nil
+}
+
+// findCard returns 404 service.ErrorResponse if the card with UUID id does not exist.
+func findCard(ctx context.Context, r service.Tx, id uuid.UUID) error {
+	_, err := r.GetCard(ctx, id)
+	if err == service.ErrNotFound {
+		return service.NewNotFoundErrorResponse()
+	}
+	if err != nil {
+		return service.Wrap(err, "cannot get card")
+	}
+	return nil
+}
+
+func newResponse(c model.MerchantCategories) Response {
+	res := Response{Allowed: []string{}, Blocked: []string{}}
+	res.Allowed = append(res.Allowed, c.Allowed...)
+	res.Blocked = append(res.Blocked, c.Blocked...)
+	return res
+}
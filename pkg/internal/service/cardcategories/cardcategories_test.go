@@ -0,0 +1,74 @@
+// +build !integration
+
+package cardcategories_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardcategories"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_GetCategories(t *testing.T) {
+	t.Run("returns empty restrictions if the card has none", func(t *testing.T) {
+		r := mustRepository(t)
+		res, err := cardcategories.New(r).GetCategories(context.Background(), r.Card.UUID().String())
+		h.MustNotErr(t, err, "got svc.GetCategories() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Allowed), 0, "got %d allowed categories, want %d")
+		h.MustE(t, len(res.Blocked), 0, "got %d blocked categories, want %d")
+	})
+	t.Run("returns the restrictions of the card", func(t *testing.T) {
+		r := mustRepository(t)
+		r.MerchantCategories = &model.MerchantCategories{Allowed: []string{"5411"}, Blocked: []string{"7995"}}
+		res, err := cardcategories.New(r).GetCategories(context.Background(), r.Card.UUID().String())
+		h.MustNotErr(t, err, "got svc.GetCategories() = %T, %#v, want nil", res)
+		h.MustE(t, res.Allowed[0], "5411", "got allowed category %q, want %q")
+		h.MustE(t, res.Blocked[0], "7995", "got blocked category %q, want %q")
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		for _, id := range []string{"foo", uuid.Must(uuid.NewV4()).String()} {
+			_, err := cardcategories.New(&h.Repository{}).GetCategories(context.Background(), id)
+			h.MustErrorResponse(t, err, 404)
+		}
+	})
+}
+
+func TestService_SetCategories(t *testing.T) {
+	t.Run("saves the restrictions of the card", func(t *testing.T) {
+		r := mustRepository(t)
+		req := cardcategories.Request{Blocked: []string{"7995", "5933"}}
+		res, err := cardcategories.New(r).SetCategories(context.Background(), r.Card.UUID().String(), req)
+		h.MustNotErr(t, err, "got svc.SetCategories() = %T, %#v, want nil", res)
+		h.Must(t, r.MerchantCategories != nil, "got no saved merchant categories, want them")
+		h.MustE(t, len(r.MerchantCategories.Blocked), 2, "got %d saved blocked categories, want %d")
+		h.Must(t, !r.MerchantCategories.Allows("7995"), "got saved categories allowing 7995, want them blocking it")
+		h.MustE(t, len(res.Allowed), 0, "got %d allowed categories, want %d")
+	})
+	t.Run("returns 422 error response with the invalid parameters if the request is invalid", func(t *testing.T) {
+		r := mustRepository(t)
+		req := cardcategories.Request{Allowed: []string{"5411", "shop"}, Blocked: []string{"5411"}}
+		_, err := cardcategories.New(r).SetCategories(context.Background(), r.Card.UUID().String(), req)
+		res := h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(res.InvalidParameters), 2, "got %v invalid parameters, want %v")
+		h.MustE(t, res.InvalidParameters[0].Name, "allowed[1]", "got invalid parameter %q, want %q")
+		h.MustE(t, res.InvalidParameters[1].Name, "blocked[0]", "got invalid parameter %q, want %q")
+		h.Must(t, r.MerchantCategories == nil, "got saved merchant categories %+v, want nil", r.MerchantCategories)
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		r := &h.Repository{}
+		_, err := cardcategories.New(r).SetCategories(context.Background(), uuid.Must(uuid.NewV4()).String(), cardcategories.Request{})
+		h.MustErrorResponse(t, err, 404)
+		h.Must(t, r.MerchantCategories == nil, "got saved merchant categories %+v, want nil", r.MerchantCategories)
+	})
+}
+
+func mustRepository(t *testing.T) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Card: c}
+}
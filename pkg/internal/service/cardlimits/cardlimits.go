@@ -0,0 +1,226 @@
+package cardlimits
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Limits are the limits of a card. The amounts are unsigned integers encoded as strings in minor units
+// of the currency of the card, the window is a duration like "24h" and the limits, which are empty, are not set.
+type Limits struct {
+	PerTransaction      string `json:"perTransaction,omitempty"`
+	Daily               string `json:"daily,omitempty"`
+	Monthly             string `json:"monthly,omitempty"`
+	Authorizations      string `json:"authorizations,omitempty"`
+	AuthorizationWindow string `json:"authorizationWindow,omitempty"`
+	DailyLoad           string `json:"dailyLoad,omitempty"`
+}
+
+// Request is the request for setting the product and the own limits of a card.
+// The product is not changed if it is empty and the limits replace the own limits of the card.
+type Request struct {
+	Product string `json:"product"`
+	Limits  Limits `json:"limits"`
+}
+
+// Response is the response, which Service returns with the product and the limits of a card.
+type Response struct {
+	Product string `json:"product"`
+	// Limits are the default limits of the product overridden by the own limits of the card.
+	Limits Limits `json:"limits"`
+	// Own are the own limits of the card.
+	Own Limits `json:"own"`
+}
+
+// Service is the service returning and setting the limits of cards.
+type Service struct {
+	uow      service.UnitOfWork
+	products model.ProductLimits
+}
+
+// New returns new service returning and setting the limits of cards, which saves the limits with unit of work u.
+// The limits of the cards are their own limits, which override the default limits p of their products.
+func New(u service.UnitOfWork, p model.ProductLimits) *Service {
+	return &Service{u, p}
+}
+
+// GetLimits returns the product and the limits of the card with UUID id.
+// It returns 404 service.ErrorResponse if the card does not exist.
+func (svc *Service) GetLimits(ctx context.Context, id string) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	var res Response
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		cl, err := getCardLimits(ctx, r, cardUUID)
+		if err != nil {
+			return err
+		}
+		res = svc.response(cl)
+		return nil
+	})
+	return res, err
+}
+
+// SetLimits sets the product and the own limits of req to the card with UUID id and returns its limits.
+// It returns 404 service.ErrorResponse if the card does not exist and
+// 422 service.ErrorResponse if the product is not supported or the limits are invalid.
+func (svc *Service) SetLimits(ctx context.Context, id string, req Request) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	v := &validation.Validator{}
+	if req.Product != "" && !svc.products.Has(req.Product) {
+		v.Invalid("product", "is not supported")
+	}
+	own := parseLimits(req.Limits, func(name, reason string) {
+		v.Invalid("limits."+name, reason)
+	})
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		cl, err := getCardLimits(ctx, r, cardUUID)
+		if err != nil {
+			return err
+		}
+		if req.Product != "" {
+			cl.Product = req.Product
+		}
+		cl.Limits = own
+		if err := svc.products.Of(cl).Validate(); err != nil {
+			return service.NewValidationErrorResponse(
+				"The limits are invalid.",
+				service.InvalidParameter{Name: "limits.authorizationWindow", Reason: err.Error()},
+			)
+		}
+		if err := r.SaveCardLimits(ctx, cardUUID, cl); err != nil {
+			return service.Wrap(err, "SetLimits() cannot persist card limits")
+		}
+		res = svc.response(cl)
+		return nil
+	})
+	return res, err
+}
+
+// getCardLimits returns the limits of the card with UUID id or the limits of model.DefaultProduct
+// if the limits of the card are not set.
+func getCardLimits(ctx context.Context, r service.Tx, id uuid.UUID) (model.CardLimits, error) {
+	_, err := r.GetCard(ctx, id)
+	if err == service.ErrNotFound {
+		return model.CardLimits{}, service.NewNotFoundErrorResponse()
+	}
+	if err != nil {
+		return model.CardLimits{}, service.Wrap(err, "cannot get card")
+	}
+	cl, err := r.GetCardLimits(ctx, id)
+	if err == service.ErrNotFound {
+		return model.CardLimits{Product: model.DefaultProduct}, nil
+	}
+	if err != nil {
+		return model.CardLimits{}, service.Wrap(err, "cannot get card limits")
+	}
+	return cl, nil
+}
+
+func (svc *Service) response(cl model.CardLimits) Response {
+	return Response{
+		Product: cl.Product,
+		Limits:  newLimits(svc.products.Of(cl)),
+		Own:     newLimits(cl.Limits),
+	}
+}
+
+// ParseProducts returns the default limits of the products in JSON object b, which has the products
+// as names and Limits as values, e.g. {"standard": {"daily": "50000", "monthly": "500000"}}.
+func ParseProducts(b []byte) (model.ProductLimits, error) {
+	products := map[string]Limits{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&products); err != nil {
+		return nil, fmt.Errorf("cannot decode product limits: %v", err)
+	}
+	p := make(model.ProductLimits, len(products))
+	for product, l := range products {
+		if product == "" {
+			return nil, fmt.Errorf("product must not be empty")
+		}
+		var err error
+		p[product] = parseLimits(l, func(name, reason string) {
+			if err == nil {
+				err = fmt.Errorf("limit %q of product %q %s", name, product, reason)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := p[product].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid limits of product %q: %v", product, err)
+		}
+	}
+	return p, nil
+}
+
+// parseLimits returns the model limits of l. It calls invalid with the name of each invalid limit and the reason.
+func parseLimits(l Limits, invalid func(name, reason string)) model.Limits {
+	amount := func(name, s string) uint64 {
+		if s == "" {
+			return 0
+		}
+		a, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			invalid(name, "must be unsigned integer")
+		}
+		return a
+	}
+	var window time.Duration
+	if l.AuthorizationWindow != "" {
+		d, err := time.ParseDuration(l.AuthorizationWindow)
+		if err != nil || d <= 0 {
+			invalid("authorizationWindow", "must be positive duration, e.g. 24h")
+		}
+		window = d
+	}
+	return model.Limits{
+		PerTransaction:      amount(model.LimitPerTransaction, l.PerTransaction),
+		Daily:               amount(model.LimitDaily, l.Daily),
+		Monthly:             amount(model.LimitMonthly, l.Monthly),
+		Authorizations:      amount(model.LimitAuthorizations, l.Authorizations),
+		AuthorizationWindow: window,
+		DailyLoad:           amount(model.LimitDailyLoad, l.DailyLoad),
+	}
+}
+
+// newLimits returns the limits l, which are not zero, encoded as strings.
+func newLimits(l model.Limits) Limits {
+	format := func(a uint64) string {
+		if a == 0 {
+			return ""
+		}
+		return strconv.FormatUint(a, 10)
+	}
+	res := Limits{
+		PerTransaction: format(l.PerTransaction),
+		Daily:          format(l.Daily),
+		Monthly:        format(l.Monthly),
+		Authorizations: format(l.Authorizations),
+		DailyLoad:      format(l.DailyLoad),
+	}
+	if l.AuthorizationWindow > 0 {
+		res.AuthorizationWindow = l.AuthorizationWindow.String()
+	}
+	return res
+}
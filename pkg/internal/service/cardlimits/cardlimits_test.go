@@ -0,0 +1,123 @@
+// +build !integration
+
+package cardlimits_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardlimits"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+var products = model.ProductLimits{
+	model.DefaultProduct: {Daily: 500},
+	"travel":             {Daily: 1000, Monthly: 5000},
+}
+
+func TestService_GetLimits(t *testing.T) {
+	t.Run("returns the limits of the default product if the card has no limits", func(t *testing.T) {
+		r := mustRepository(t)
+		res, err := cardlimits.New(r, products).GetLimits(context.Background(), r.Card.UUID().String())
+		h.MustNotErr(t, err, "got svc.GetLimits() = %T, %#v, want nil", res)
+		h.MustE(t, res.Product, model.DefaultProduct, "got product %q, want %q")
+		h.MustE(t, res.Limits, cardlimits.Limits{Daily: "500"}, "got limits %+v, want %+v")
+		h.MustE(t, res.Own, cardlimits.Limits{}, "got own limits %+v, want %+v")
+	})
+	t.Run("returns the own limits of the card over the limits of its product", func(t *testing.T) {
+		r := mustRepository(t)
+		r.CardLimits = &model.CardLimits{Product: "travel", Limits: model.Limits{Daily: 200, DailyLoad: 300}}
+		res, err := cardlimits.New(r, products).GetLimits(context.Background(), r.Card.UUID().String())
+		h.MustNotErr(t, err, "got svc.GetLimits() = %T, %#v, want nil", res)
+		h.MustE(t, res.Product, "travel", "got product %q, want %q")
+		h.MustE(t, res.Limits, cardlimits.Limits{Daily: "200", Monthly: "5000", DailyLoad: "300"}, "got limits %+v, want %+v")
+		h.MustE(t, res.Own, cardlimits.Limits{Daily: "200", DailyLoad: "300"}, "got own limits %+v, want %+v")
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		for _, id := range []string{"foo", uuid.Must(uuid.NewV4()).String()} {
+			_, err := cardlimits.New(&h.Repository{}, products).GetLimits(context.Background(), id)
+			h.MustErrorResponse(t, err, 404)
+		}
+	})
+}
+
+func TestService_SetLimits(t *testing.T) {
+	t.Run("saves the product and the own limits of the card", func(t *testing.T) {
+		r := mustRepository(t)
+		req := cardlimits.Request{
+			Product: "travel",
+			Limits:  cardlimits.Limits{Authorizations: "5", AuthorizationWindow: "1h"},
+		}
+		res, err := cardlimits.New(r, products).SetLimits(context.Background(), r.Card.UUID().String(), req)
+		h.MustNotErr(t, err, "got svc.SetLimits() = %T, %#v, want nil", res)
+		h.Must(t, r.CardLimits != nil, "got no saved card limits, want them")
+		h.MustE(t, *r.CardLimits, model.CardLimits{
+			Product: "travel",
+			Limits:  model.Limits{Authorizations: 5, AuthorizationWindow: time.Hour},
+		}, "got saved card limits %+v, want %+v")
+		h.MustE(t, res.Product, "travel", "got product %q, want %q")
+		h.MustE(t, res.Limits, cardlimits.Limits{Daily: "1000", Monthly: "5000", Authorizations: "5", AuthorizationWindow: "1h0m0s"}, "got limits %+v, want %+v")
+	})
+	t.Run("keeps the product of the card if the product is empty", func(t *testing.T) {
+		r := mustRepository(t)
+		r.CardLimits = &model.CardLimits{Product: "travel", Limits: model.Limits{Daily: 200}}
+		res, err := cardlimits.New(r, products).SetLimits(context.Background(), r.Card.UUID().String(), cardlimits.Request{})
+		h.MustNotErr(t, err, "got svc.SetLimits() = %T, %#v, want nil", res)
+		h.MustE(t, *r.CardLimits, model.CardLimits{Product: "travel"}, "got saved card limits %+v, want %+v")
+	})
+	t.Run("returns 422 error response with the invalid parameters if the request is invalid", func(t *testing.T) {
+		r := mustRepository(t)
+		req := cardlimits.Request{
+			Product: "gold",
+			Limits:  cardlimits.Limits{Daily: "-1", AuthorizationWindow: "0s"},
+		}
+		_, err := cardlimits.New(r, products).SetLimits(context.Background(), r.Card.UUID().String(), req)
+		res := h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(res.InvalidParameters), 3, "got %v invalid parameters, want %v")
+		h.MustE(t, res.InvalidParameters[0].Name, "product", "got invalid parameter %q, want %q")
+		h.Must(t, r.CardLimits == nil, "got saved card limits %+v, want nil", r.CardLimits)
+	})
+	t.Run("returns 422 error response if the number of authorizations is limited without window", func(t *testing.T) {
+		r := mustRepository(t)
+		req := cardlimits.Request{Limits: cardlimits.Limits{Authorizations: "5"}}
+		_, err := cardlimits.New(r, products).SetLimits(context.Background(), r.Card.UUID().String(), req)
+		res := h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(res.InvalidParameters), 1, "got %v invalid parameters, want %v")
+		h.MustE(t, res.InvalidParameters[0].Name, "limits.authorizationWindow", "got invalid parameter %q, want %q")
+		h.Must(t, r.CardLimits == nil, "got saved card limits %+v, want nil", r.CardLimits)
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		r := &h.Repository{}
+		_, err := cardlimits.New(r, products).SetLimits(context.Background(), uuid.Must(uuid.NewV4()).String(), cardlimits.Request{})
+		h.MustErrorResponse(t, err, 404)
+		h.Must(t, r.CardLimits == nil, "got saved card limits %+v, want nil", r.CardLimits)
+	})
+}
+
+func TestParseProducts(t *testing.T) {
+	p, err := cardlimits.ParseProducts([]byte(`{"standard": {"daily": "500"}, "travel": {"authorizations": "5", "authorizationWindow": "24h"}}`))
+	h.MustNotErr(t, err, "got ParseProducts() error %v, want nil")
+	h.MustE(t, p.Limits(model.DefaultProduct), model.Limits{Daily: 500}, "got standard limits %+v, want %+v")
+	h.MustE(t, p.Limits("travel"), model.Limits{Authorizations: 5, AuthorizationWindow: 24 * time.Hour}, "got travel limits %+v, want %+v")
+	for _, b := range []string{
+		`[]`,
+		`{"": {}}`,
+		`{"standard": {"weekly": "500"}}`,
+		`{"standard": {"daily": "foo"}}`,
+		`{"standard": {"authorizations": "5"}}`,
+	} {
+		_, err := cardlimits.ParseProducts([]byte(b))
+		h.MustErr(t, err, "got ParseProducts(%s) error nil, want error", b)
+	}
+}
+
+func mustRepository(t *testing.T) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Card: c}
+}
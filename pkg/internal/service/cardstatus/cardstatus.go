@@ -0,0 +1,252 @@
+package cardstatus
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Response is the response, which Service returns when the card status is changed.
+type Response struct {
+	UUID             string `json:"uuid"`
+	Currency         string `json:"currency"`
+	Status           string `json:"status"`
+	AvailableBalance string `json:"availableBalance"`
+	BlockedBalance   string `json:"blockedBalance"`
+	// ETag is the entity tag of the changed card.
+	ETag string `json:"-"`
+}
+
+// Service is the service changing the status of cards.
+type Service struct {
+	uow service.UnitOfWork
+
+	// Now returns the current time, at which the events and the transactions are recorded.
+	Now func() time.Time
+}
+
+// New returns new service changing the status of cards, which saves the changes with unit of work u.
+func New(u service.UnitOfWork) *Service {
+	return &Service{uow: u, Now: time.Now}
+}
+
+// Freeze suspends the card with UUID id temporarily.
+// The card is changed only if it matches the If-Match header value ifMatch, which is ignored if empty.
+// It returns 404 service.ErrorResponse if the card does not exist,
+// 422 service.ErrorResponse if the card is not active,
+// 412 service.ErrorResponse if the card does not match ifMatch and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Freeze(ctx context.Context, id, ifMatch string) (Response, error) {
+	var res Response
+	err := service.Retry(func() error {
+		var err error
+		res, err = svc.freeze(ctx, id, ifMatch)
+		return err
+	})
+	return res, err
+}
+
+// freeze is Freeze without retries.
+func (svc *Service) freeze(ctx context.Context, id, ifMatch string) (Response, error) {
+	return svc.update(ctx, id, ifMatch, func(r service.Tx, card *model.Card) error {
+		if err := card.Freeze(); err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		err := updateCard(ctx, r, card, func(eventUUID uuid.UUID) interface{} {
+			return event.CardFrozen{
+				UUID:     eventUUID,
+				Time:     svc.Now(),
+				CardUUID: card.UUID(),
+			}
+		})
+		if err != nil {
+			return service.Wrap(err, "Freeze()")
+		}
+		return nil
+	})
+}
+
+// Unfreeze activates the frozen card with UUID id.
+// The card is changed only if it matches the If-Match header value ifMatch, which is ignored if empty.
+// It returns 404 service.ErrorResponse if the card does not exist,
+// 422 service.ErrorResponse if the card is not frozen,
+// 412 service.ErrorResponse if the card does not match ifMatch and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Unfreeze(ctx context.Context, id, ifMatch string) (Response, error) {
+	var res Response
+	err := service.Retry(func() error {
+		var err error
+		res, err = svc.unfreeze(ctx, id, ifMatch)
+		return err
+	})
+	return res, err
+}
+
+// unfreeze is Unfreeze without retries.
+func (svc *Service) unfreeze(ctx context.Context, id, ifMatch string) (Response, error) {
+	return svc.update(ctx, id, ifMatch, func(r service.Tx, card *model.Card) error {
+		if err := card.Unfreeze(); err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		err := updateCard(ctx, r, card, func(eventUUID uuid.UUID) interface{} {
+			return event.CardUnfrozen{
+				UUID:     eventUUID,
+				Time:     svc.Now(),
+				CardUUID: card.UUID(),
+			}
+		})
+		if err != nil {
+			return service.Wrap(err, "Unfreeze()")
+		}
+		return nil
+	})
+}
+
+// Block suspends the card with UUID id permanently.
+// The card is changed only if it matches the If-Match header value ifMatch, which is ignored if empty.
+// It returns 404 service.ErrorResponse if the card does not exist,
+// 422 service.ErrorResponse if the card is already blocked or closed,
+// 412 service.ErrorResponse if the card does not match ifMatch and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Block(ctx context.Context, id, ifMatch string) (Response, error) {
+	var res Response
+	err := service.Retry(func() error {
+		var err error
+		res, err = svc.block(ctx, id, ifMatch)
+		return err
+	})
+	return res, err
+}
+
+// block is Block without retries.
+func (svc *Service) block(ctx context.Context, id, ifMatch string) (Response, error) {
+	return svc.update(ctx, id, ifMatch, func(r service.Tx, card *model.Card) error {
+		if err := card.Block(); err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		err := updateCard(ctx, r, card, func(eventUUID uuid.UUID) interface{} {
+			return event.CardBlocked{
+				UUID:     eventUUID,
+				Time:     svc.Now(),
+				CardUUID: card.UUID(),
+			}
+		})
+		if err != nil {
+			return service.Wrap(err, "Block()")
+		}
+		return nil
+	})
+}
+
+// Close pays out the available balance of the card with UUID id and closes it.
+// The card is changed only if it matches the If-Match header value ifMatch, which is ignored if empty.
+// It returns 404 service.ErrorResponse if the card does not exist,
+// 422 service.ErrorResponse if the card is closed or it has blocked balance,
+// 412 service.ErrorResponse if the card does not match ifMatch and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Close(ctx context.Context, id, ifMatch string) (Response, error) {
+	var res Response
+	err := service.Retry(func() error {
+		var err error
+		res, err = svc.close(ctx, id, ifMatch)
+		return err
+	})
+	return res, err
+}
+
+// close is Close without retries.
+func (svc *Service) close(ctx context.Context, id, ifMatch string) (Response, error) {
+	return svc.update(ctx, id, ifMatch, func(r service.Tx, card *model.Card) error {
+		amount, err := card.Close()
+		if err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Close() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, nil, eventUUID, event.TypeCardClosed, amount, "Card closure payout", svc.Now())
+		if err != nil {
+			return fmt.Errorf("Close() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "Close() cannot persist card")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "Close() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.CardClosed{
+			UUID:     eventUUID,
+			Time:     tx.Date(),
+			CardUUID: card.UUID(),
+			Amount:   amount,
+		})
+		if err != nil {
+			return service.Wrap(err, "Close() cannot persist event")
+		}
+		return nil
+	})
+}
+
+// update calls change with the card with UUID id within a transaction and returns the changed card.
+// It returns 404 service.ErrorResponse if the card does not exist and
+// 412 service.ErrorResponse if the card does not match ifMatch.
+func (svc *Service) update(ctx context.Context, id, ifMatch string, change func(service.Tx, *model.Card) error) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	var card *model.Card
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		var err error
+		card, err = r.GetCard(ctx, cardUUID)
+		if err == service.ErrNotFound {
+			return service.NewNotFoundErrorResponse()
+		}
+		if err != nil {
+			return service.Wrap(err, "cannot get card")
+		}
+		if err := service.CheckIfMatch(ifMatch, card.Version()); err != nil {
+			return err
+		}
+		return change(r, card)
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return newResponse(card), nil
+}
+
+// updateCard persists the status of card within r with the event returned by newEvent for the event UUID.
+func updateCard(ctx context.Context, r service.Tx, card *model.Card, newEvent func(uuid.UUID) interface{}) error {
+	eventUUID, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Errorf("cannot generate identifier; %v", err)
+	}
+	if err := r.UpdateCard(ctx, card); err != nil {
+		return service.Wrap(err, "cannot persist card")
+	}
+	if err := r.SaveEvent(ctx, newEvent(eventUUID)); err != nil {
+		return service.Wrap(err, "cannot persist event")
+	}
+	return nil
+}
+
+// newResponse returns the response with the saved card.
+func newResponse(card *model.Card) Response {
+	return Response{
+		UUID:             card.UUID().String(),
+		Currency:         string(card.Currency()),
+		Status:           string(card.Status()),
+		AvailableBalance: strconv.FormatUint(card.AvailableBalance(), 10),
+		BlockedBalance:   strconv.FormatUint(card.BlockedBalance(), 10),
+		ETag:             service.ETag(card.Version()),
+	}
+}
@@ -0,0 +1,210 @@
+// +build !integration
+
+package cardstatus_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/cardstatus"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Freeze(t *testing.T) {
+	t.Run("freezes the card and saves the event", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c}
+		now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		svc := cardstatus.New(r)
+		svc.Now = func() time.Time { return now }
+		res, err := svc.Freeze(context.Background(), c.UUID().String(), "")
+		h.MustNotErr(t, err, "got svc.Freeze() = %T, %#v, want nil", res)
+		h.MustE(t, res.Status, "frozen", "got response status %q, want %q")
+		h.MustE(t, res.ETag, `"1"`, "got response ETag %s, want %s")
+		h.MustE(t, r.Card.Status(), model.CardFrozen, "got saved card status %q, want %q")
+		e, ok := savedEvent(t, r).(event.CardFrozen)
+		h.Must(t, ok, "got saved event %T, want event.CardFrozen", r.Events[0])
+		h.MustE(t, e.CardUUID, c.UUID(), "got saved event card UUID %v, want %v")
+		h.Must(t, e.UUID != uuid.Nil, "got saved event UUID uuid.Nil, want !uuid.Nil")
+		h.Must(t, e.Time.Equal(now), "got saved event time %v, want %v", e.Time, now)
+	})
+	t.Run("freezes the card if it matches If-Match", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c}
+		_, err := cardstatus.New(r).Freeze(context.Background(), c.UUID().String(), `"0"`)
+		h.MustNotErr(t, err, "got error %v, want nil")
+		h.MustE(t, r.Card.Status(), model.CardFrozen, "got saved card status %q, want %q")
+	})
+	t.Run("returns 412 error response if the card does not match If-Match", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c}
+		_, err := cardstatus.New(r).Freeze(context.Background(), c.UUID().String(), `"1"`)
+		h.MustErrorResponse(t, err, 412)
+		h.MustE(t, r.Card.Status(), model.CardActive, "got card status %q, want %q")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("retries if the card is changed concurrently", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c}
+		u := &conflictingUnitOfWork{r, 1}
+		_, err := cardstatus.New(u).Freeze(context.Background(), c.UUID().String(), "")
+		h.MustNotErr(t, err, "got error %v, want nil")
+		h.MustE(t, u.conflicts, 0, "got %d conflicts left, want %d")
+		h.MustE(t, r.Card.Status(), model.CardFrozen, "got saved card status %q, want %q")
+		_, ok := savedEvent(t, r).(event.CardFrozen)
+		h.Must(t, ok, "got saved event %T, want event.CardFrozen", r.Events[0])
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		_, err := cardstatus.New(&h.Repository{}).Freeze(context.Background(), uuid.Must(uuid.NewV4()).String(), "")
+		h.MustErrorResponse(t, err, 404)
+	})
+	t.Run("returns 422 error response if the card is not active", func(t *testing.T) {
+		c := mustCard(t, 0)
+		h.MustNotErr(t, c.Freeze(), "c.Freeze() %v; want nil")
+		r := &h.Repository{Card: c}
+		_, err := cardstatus.New(r).Freeze(context.Background(), c.UUID().String(), "")
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c, CommitErr: errors.New("test commit failed")}
+		_, err := cardstatus.New(r).Freeze(context.Background(), c.UUID().String(), "")
+		h.MustErr(t, err, "got svc.Freeze() = cardstatus.Response, nil, want cardstatus.Response, error")
+		h.MustE(t, r.Card.Status(), model.CardActive, "got card status %q, want %q")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func TestService_Unfreeze(t *testing.T) {
+	t.Run("unfreezes the card and saves the event", func(t *testing.T) {
+		c := mustCard(t, 0)
+		h.MustNotErr(t, c.Freeze(), "c.Freeze() %v; want nil")
+		r := &h.Repository{Card: c}
+		res, err := cardstatus.New(r).Unfreeze(context.Background(), c.UUID().String(), "")
+		h.MustNotErr(t, err, "got svc.Unfreeze() = %T, %#v, want nil", res)
+		h.MustE(t, res.Status, "active", "got response status %q, want %q")
+		e, ok := savedEvent(t, r).(event.CardUnfrozen)
+		h.Must(t, ok, "got saved event %T, want event.CardUnfrozen", r.Events[0])
+		h.MustE(t, e.CardUUID, c.UUID(), "got saved event card UUID %v, want %v")
+	})
+	t.Run("returns 422 error response if the card is not frozen", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c}
+		_, err := cardstatus.New(r).Unfreeze(context.Background(), c.UUID().String(), "")
+		h.MustErrorResponse(t, err, 422)
+	})
+}
+
+func TestService_Block(t *testing.T) {
+	t.Run("blocks the card and saves the event", func(t *testing.T) {
+		c := mustCard(t, 0)
+		r := &h.Repository{Card: c}
+		res, err := cardstatus.New(r).Block(context.Background(), c.UUID().String(), "")
+		h.MustNotErr(t, err, "got svc.Block() = %T, %#v, want nil", res)
+		h.MustE(t, res.Status, "blocked", "got response status %q, want %q")
+		e, ok := savedEvent(t, r).(event.CardBlocked)
+		h.Must(t, ok, "got saved event %T, want event.CardBlocked", r.Events[0])
+		h.MustE(t, e.CardUUID, c.UUID(), "got saved event card UUID %v, want %v")
+	})
+	t.Run("returns 422 error response if the card is blocked", func(t *testing.T) {
+		c := mustCard(t, 0)
+		h.MustNotErr(t, c.Block(), "c.Block() %v; want nil")
+		r := &h.Repository{Card: c}
+		_, err := cardstatus.New(r).Block(context.Background(), c.UUID().String(), "")
+		h.MustErrorResponse(t, err, 422)
+	})
+}
+
+func TestService_Close(t *testing.T) {
+	t.Run("pays out the card, closes it and saves the event", func(t *testing.T) {
+		c := mustCard(t, 100)
+		r := &h.Repository{Card: c}
+		now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		svc := cardstatus.New(r)
+		svc.Now = func() time.Time { return now }
+		res, err := svc.Close(context.Background(), c.UUID().String(), "")
+		h.MustNotErr(t, err, "got svc.Close() = %T, %#v, want nil", res)
+		h.MustE(t, res.Status, "closed", "got response status %q, want %q")
+		h.MustE(t, res.AvailableBalance, "0", "got response availableBalance %q, want %q")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		tx := r.Transactions[0]
+		e, ok := savedEvent(t, r).(event.CardClosed)
+		h.Must(t, ok, "got saved event %T, want event.CardClosed", r.Events[0])
+		h.MustE(t, tx.EventType(), event.TypeCardClosed, "got transaction event type %q, want %q")
+		h.MustE(t, tx.EventUUID(), e.UUID, "got transaction event UUID %v != saved event UUID %v, want them equal")
+		h.MustE(t, tx.Amount(), uint64(100), "got transaction amount %v, want %v")
+		h.MustE(t, e.Amount, uint64(100), "got saved event amount %v, want %v")
+		h.Must(t, tx.Date().Equal(now), "got transaction date %v, want %v", tx.Date(), now)
+		h.Must(t, e.Time.Equal(now), "got saved event time %v, want %v", e.Time, now)
+	})
+	t.Run("returns 422 error response if the card has blocked balance", func(t *testing.T) {
+		c := mustCard(t, 100)
+		_, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(10, model.GBP), nil, time.Now())
+		h.MustNotErr(t, err, "NewAuthorizationRequest() %v; want nil")
+		r := &h.Repository{Card: c}
+		_, err = cardstatus.New(r).Close(context.Background(), c.UUID().String(), "")
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		c := mustCard(t, 100)
+		r := &h.Repository{Card: c, CommitErr: errors.New("test commit failed")}
+		_, err := cardstatus.New(r).Close(context.Background(), c.UUID().String(), "")
+		h.MustErr(t, err, "got svc.Close() = cardstatus.Response, nil, want cardstatus.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), uint64(100), "got available balance %v, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func mustCard(t *testing.T, amount uint64) *model.Card {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	if amount > 0 {
+		h.MustNotErr(t, c.LoadMoney(amount), "c.LoadMoney() %v; want nil")
+	}
+	return c
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) interface{} {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	return r.Events[0]
+}
+
+// conflictingUnitOfWork is service.UnitOfWork, which fails the first updates of cards
+// with service.ErrConcurrentModification.
+type conflictingUnitOfWork struct {
+	*h.Repository
+	conflicts int
+}
+
+func (u *conflictingUnitOfWork) WithinTx(ctx context.Context, fn func(service.Tx) error) error {
+	return u.Repository.WithinTx(ctx, func(tx service.Tx) error {
+		return fn(&conflictingTx{tx, u})
+	})
+}
+
+type conflictingTx struct {
+	service.Tx
+	u *conflictingUnitOfWork
+}
+
+func (tx *conflictingTx) UpdateCard(ctx context.Context, card *model.Card) error {
+	if tx.u.conflicts > 0 {
+		tx.u.conflicts--
+		return service.ErrConcurrentModification
+	}
+	return tx.Tx.UpdateCard(ctx, card)
+}
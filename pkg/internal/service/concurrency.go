@@ -0,0 +1,56 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRetries is the number of times an operation is retried after ErrConcurrentModification.
+const MaxRetries = 10
+
+// RetryBackoff returns the delay of Retry before the next call after attempts failed calls.
+var RetryBackoff = Jitter(ExponentialBackoff(5*time.Millisecond, 100*time.Millisecond))
+
+// Retry calls op until it does not return ErrConcurrentModification, at most MaxRetries
+// times after the first call, and waits RetryBackoff between the calls. It returns the error
+// of the last call.
+func Retry(op func() error) error {
+	err := op()
+	for i := 1; i <= MaxRetries && KindOf(err) == ErrConcurrentModification; i++ {
+		time.Sleep(RetryBackoff(i))
+		err = op()
+	}
+	return err
+}
+
+// ETag returns the entity tag of a resource with version.
+func ETag(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// CheckIfMatch returns 412 ErrorResponse if the If-Match header value ifMatch does not
+// match the entity tag of a resource with version. The empty ifMatch and "*" match any version.
+func CheckIfMatch(ifMatch string, version uint64) error {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if len(ifMatch) == 0 || ifMatch == "*" {
+		return nil
+	}
+	etag := ETag(version)
+	for _, t := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(t) == etag {
+			return nil
+		}
+	}
+	return NewPreconditionFailedErrorResponse()
+}
+
+// NewPreconditionFailedErrorResponse returns 412 Precondition Failed.
+func NewPreconditionFailedErrorResponse() ErrorResponse {
+	return ErrorResponse{
+		Title:  http.StatusText(http.StatusPreconditionFailed),
+		Status: http.StatusPreconditionFailed,
+		Detail: "The resource has changed.",
+	}
+}
@@ -0,0 +1,93 @@
+// +build !integration
+
+package service_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestRetry(t *testing.T) {
+	t.Run("retries the operation after ErrConcurrentModification", func(t *testing.T) {
+		calls := 0
+		err := service.Retry(func() error {
+			calls++
+			if calls < 3 {
+				return service.Wrap(service.ErrConcurrentModification, "foo")
+			}
+			return nil
+		})
+		h.MustNotErr(t, err, "got error %v, want nil")
+		h.MustE(t, calls, 3, "got %d calls, want %d")
+	})
+	t.Run("returns the error after MaxRetries retries", func(t *testing.T) {
+		calls := 0
+		err := service.Retry(func() error {
+			calls++
+			return service.ErrConcurrentModification
+		})
+		h.MustE(t, err, service.ErrConcurrentModification, "got error %v, want %v")
+		h.MustE(t, calls, service.MaxRetries+1, "got %d calls, want %d")
+	})
+	t.Run("waits RetryBackoff between the calls", func(t *testing.T) {
+		defer func(b func(int) time.Duration) { service.RetryBackoff = b }(service.RetryBackoff)
+		var attempts []int
+		service.RetryBackoff = func(n int) time.Duration {
+			attempts = append(attempts, n)
+			return 0
+		}
+		calls := 0
+		service.Retry(func() error {
+			calls++
+			if calls < 3 {
+				return service.ErrConcurrentModification
+			}
+			return nil
+		})
+		h.MustE(t, len(attempts), 2, "got %d backoffs, want %d")
+		h.MustE(t, attempts[1], 2, "got backoff after %d attempts, want %d")
+	})
+	t.Run("does not retry other errors", func(t *testing.T) {
+		calls := 0
+		foo := errors.New("foo")
+		err := service.Retry(func() error {
+			calls++
+			return foo
+		})
+		h.MustE(t, err, foo, "got error %v, want %v")
+		h.MustE(t, calls, 1, "got %d calls, want %d")
+	})
+}
+
+func TestETag(t *testing.T) {
+	h.MustE(t, service.ETag(42), `"42"`, "got ETag %s, want %s")
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		match   bool
+	}{
+		{"", true},
+		{"*", true},
+		{`"3"`, true},
+		{` "1", "3" `, true},
+		{`"1"`, false},
+		{`W/"3"`, false},
+		{"3", false},
+	}
+	for _, test := range tests {
+		err := service.CheckIfMatch(test.ifMatch, 3)
+		if test.match {
+			h.MustNotErr(t, err, "got CheckIfMatch(%q) error %v, want nil", test.ifMatch)
+			continue
+		}
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got CheckIfMatch(%q) error %v, want service.ErrorResponse", test.ifMatch, err)
+		h.MustE(t, res.StatusCode(), 412, "got status code %d, want %d")
+	}
+}
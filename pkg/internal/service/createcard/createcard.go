@@ -1,6 +1,7 @@
 package createcard
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"time"
@@ -9,57 +10,95 @@ import (
 
 	"github.com/sepetrov/prepaidcard/pkg/internal/event"
 	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
 )
 
+// Request is the request for creating a new card.
+// The currency is GBP and the product is model.DefaultProduct if they are not set.
+type Request struct {
+	Currency string `json:"currency"`
+	Product  string `json:"product"`
+}
+
 // Response is the response, which Service returns when a card is successfully created.
 type Response struct {
 	UUID             string `json:"uuid"`
+	Currency         string `json:"currency"`
+	Product          string `json:"product"`
+	Status           string `json:"status"`
 	AvailableBalance string `json:"availableBalance"`
 	BlockedBalance   string `json:"blockedBalance"`
+	// ETag is the entity tag of the card.
+	ETag string `json:"-"`
 }
 
 // Service is the service creating new cards.
 type Service struct {
-	saver      Saver
-	dispatcher Dispatcher
+	uow      service.UnitOfWork
+	products model.ProductLimits
 }
 
-// New returns new service creating cards.
-func New(s Saver, d Dispatcher) *Service {
-	return &Service{s, d}
+// New returns new service creating cards, which saves the cards and their events with unit of work u.
+// The cards are issued as model.DefaultProduct or the products with default limits p.
+func New(u service.UnitOfWork, p model.ProductLimits) *Service {
+	return &Service{u, p}
 }
 
-// CreateCard creates a new card.
-func (svc *Service) CreateCard() (Response, error) {
-	card, err := model.NewCard()
+// CreateCard creates a new card of the product in the currency of req.
+// It returns 422 service.ErrorResponse if the currency or the product is not supported.
+func (svc *Service) CreateCard(ctx context.Context, req Request) (Response, error) {
+	v := &validation.Validator{}
+	currency := model.GBP
+	if req.Currency != "" {
+		currency = v.Currency("currency", req.Currency)
+	}
+	product := model.DefaultProduct
+	if req.Product != "" {
+		product = req.Product
+		if !svc.products.Has(product) {
+			v.Invalid("product", "is not supported")
+		}
+	}
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	card, err := model.NewCard(currency)
 	if err != nil {
 		return Response{}, fmt.Errorf("CreateCard() cannot create new card; %v", err)
 	}
-	if err := svc.saver.SaveCard(card); err != nil {
-		return Response{}, fmt.Errorf("CreateCard() cannot persist card; %v", err)
-	}
 	id, err := uuid.NewV4()
 	if err != nil {
 		return Response{}, fmt.Errorf("CreateCard() cannot generate identifier; %v", err)
 	}
-	svc.dispatcher.DispatchCardCreated(event.CardCreated{
-		UUID:     id,
-		Time:     time.Now(),
-		CardUUID: card.UUID(),
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		if err := r.SaveCard(ctx, card); err != nil {
+			return service.Wrap(err, "CreateCard() cannot persist card")
+		}
+		if err := r.SaveCardLimits(ctx, card.UUID(), model.CardLimits{Product: product}); err != nil {
+			return service.Wrap(err, "CreateCard() cannot persist card limits")
+		}
+		err := r.SaveEvent(ctx, event.CardCreated{
+			UUID:     id,
+			Time:     time.Now(),
+			CardUUID: card.UUID(),
+			Currency: string(card.Currency()),
+		})
+		if err != nil {
+			return service.Wrap(err, "CreateCard() cannot persist event")
+		}
+		return nil
 	})
+	if err != nil {
+		return Response{}, err
+	}
 	return Response{
 		UUID:             card.UUID().String(),
+		Currency:         string(card.Currency()),
+		Product:          product,
+		Status:           string(card.Status()),
 		AvailableBalance: strconv.FormatUint(card.AvailableBalance(), 10),
 		BlockedBalance:   strconv.FormatUint(card.BlockedBalance(), 10),
+		ETag:             service.ETag(card.Version()),
 	}, nil
 }
-
-// Saver is interface for persistence of new cards.
-type Saver interface {
-	SaveCard(*model.Card) error
-}
-
-// Dispatcher is an interface for dispatching CardCreated event.
-type Dispatcher interface {
-	DispatchCardCreated(event.CardCreated)
-}
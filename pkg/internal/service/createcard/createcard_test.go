@@ -3,6 +3,7 @@
 package createcard_test
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -10,50 +11,73 @@ import (
 
 	"github.com/sepetrov/prepaidcard/pkg/internal/event"
 	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
 	"github.com/sepetrov/prepaidcard/pkg/internal/service/createcard"
 	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
 )
 
 func TestService_CreateCard(t *testing.T) {
-	t.Run("saves, dispatches and returns the same card", func(t *testing.T) {
-		s := &saver{}
-		d := &dispatcher{}
-		svc := createcard.New(s, d)
-		r, err := svc.CreateCard()
+	t.Run("saves the card with its event and returns the same card", func(t *testing.T) {
+		repo := &h.Repository{}
+		r, err := createcard.New(repo, nil).CreateCard(context.Background(), createcard.Request{})
 		h.MustNotErr(t, err, "got svc.CreateCard() = %T, %#v, want nil", r)
-		h.Must(t, d.e.UUID != uuid.Nil, "got dispatcher event UUID %q == uuid.Nil, want !uuid.Nil", d.e.UUID)
-		h.MustE(t, s.c.UUID(), d.e.CardUUID, "got saved card UUID %q != dispatched card UUID %q, want the same")
-		h.MustE(t, r.UUID, s.c.UUID().String(), "got response card UUID %q != saver card UUID %q, want them equal")
+		h.Must(t, repo.Card != nil, "got no saved card, want one")
+		h.MustE(t, len(repo.Events), 1, "got %v saved events, want %v")
+		e, ok := repo.Events[0].(event.CardCreated)
+		h.Must(t, ok, "got saved event %T, want event.CardCreated", repo.Events[0])
+		h.Must(t, e.UUID != uuid.Nil, "got event UUID %q == uuid.Nil, want !uuid.Nil", e.UUID)
+		h.MustE(t, repo.Card.UUID(), e.CardUUID, "got saved card UUID %q != event card UUID %q, want the same")
+		h.MustE(t, e.Currency, "GBP", "got event currency %q != %q, want them equal")
+		h.MustE(t, r.UUID, repo.Card.UUID().String(), "got response card UUID %q != saved card UUID %q, want them equal")
+		h.MustE(t, r.Currency, "GBP", "got response currency %q != %q; want them equal")
+		h.MustE(t, r.Status, "active", "got response status %q != %q; want them equal")
 		h.MustE(t, r.AvailableBalance, "0", "got response availableBalance %v != %q; want them equal")
 		h.MustE(t, r.BlockedBalance, "0", "got response blockedBalance %v != %q; want them equal")
 	})
-	t.Run("returns error response and error if saver returns error", func(t *testing.T) {
-		s := &saver{err: errors.New("test saver failed")}
-		d := &dispatcher{}
-		svc := createcard.New(s, d)
-		_, err := svc.CreateCard()
+	t.Run("creates the card in the requested currency", func(t *testing.T) {
+		repo := &h.Repository{}
+		r, err := createcard.New(repo, nil).CreateCard(context.Background(), createcard.Request{Currency: "jpy"})
+		h.MustNotErr(t, err, "got svc.CreateCard() = %T, %#v, want nil", r)
+		h.MustE(t, r.Currency, "JPY", "got response currency %q != %q; want them equal")
+		h.MustE(t, repo.Card.Currency(), model.JPY, "got saved card currency %q != %q; want them equal")
+	})
+	t.Run("returns 422 error response if the currency is not supported", func(t *testing.T) {
+		repo := &h.Repository{}
+		_, err := createcard.New(repo, nil).CreateCard(context.Background(), createcard.Request{Currency: "XXX"})
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+		h.Must(t, repo.Card == nil, "got saved card %v, want nil", repo.Card)
+	})
+	t.Run("saves the product of the card", func(t *testing.T) {
+		repo := &h.Repository{}
+		r, err := createcard.New(repo, model.ProductLimits{"travel": {}}).CreateCard(context.Background(), createcard.Request{Product: "travel"})
+		h.MustNotErr(t, err, "got svc.CreateCard() = %T, %#v, want nil", r)
+		h.MustE(t, r.Product, "travel", "got response product %q != %q; want them equal")
+		h.Must(t, repo.CardLimits != nil, "got no saved card limits, want them")
+		h.MustE(t, *repo.CardLimits, model.CardLimits{Product: "travel"}, "got saved card limits %+v, want %+v")
+	})
+	t.Run("issues the card with the default product", func(t *testing.T) {
+		repo := &h.Repository{}
+		r, err := createcard.New(repo, nil).CreateCard(context.Background(), createcard.Request{})
+		h.MustNotErr(t, err, "got svc.CreateCard() = %T, %#v, want nil", r)
+		h.MustE(t, r.Product, model.DefaultProduct, "got response product %q != %q; want them equal")
+	})
+	t.Run("returns 422 error response if the product is not supported", func(t *testing.T) {
+		repo := &h.Repository{}
+		_, err := createcard.New(repo, nil).CreateCard(context.Background(), createcard.Request{Product: "travel"})
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+		h.MustE(t, res.InvalidParameters[0].Name, "product", "got invalid parameter %q, want %q")
+		h.Must(t, repo.Card == nil, "got saved card %v, want nil", repo.Card)
+		h.Must(t, repo.CardLimits == nil, "got saved card limits %v, want nil", repo.CardLimits)
+	})
+	t.Run("returns error and saves nothing if the transaction cannot be committed", func(t *testing.T) {
+		repo := &h.Repository{CommitErr: errors.New("test commit failed")}
+		_, err := createcard.New(repo, nil).CreateCard(context.Background(), createcard.Request{})
 		h.MustErr(t, err, "got svc.CreateCard() = createcard.Response, nil, want createcard.Response, error")
+		h.Must(t, repo.Card == nil, "got saved card %v, want nil", repo.Card)
+		h.MustE(t, len(repo.Events), 0, "got %v saved events, want %v")
 	})
 }
-
-type saver struct {
-	c   *model.Card
-	err error
-}
-
-var _ createcard.Saver = &saver{}
-
-func (s *saver) SaveCard(c *model.Card) error {
-	s.c = c
-	return s.err
-}
-
-type dispatcher struct {
-	e event.CardCreated
-}
-
-var _ createcard.Dispatcher = &dispatcher{}
-
-func (d *dispatcher) DispatchCardCreated(e event.CardCreated) {
-	d.e = e
-}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of the repository errors. The repositories return either ErrNotFound
+// or Error with one of these kinds, so the services can tell the permanent errors
+// from the transient ones.
+var (
+	// ErrConflict is returned when a record conflicts with an existing record, e.g. it has a duplicate key.
+	ErrConflict = errors.New("record conflict")
+	// ErrUnavailable is returned when the repository is temporarily unavailable and the operation can be retried.
+	ErrUnavailable = errors.New("repository unavailable")
+	// ErrConstraint is returned when a record violates a constraint, e.g. a foreign key.
+	ErrConstraint = errors.New("constraint violation")
+	// ErrConcurrentModification is returned when a record is saved, but the stored record
+	// has changed since it was read.
+	ErrConcurrentModification = errors.New("concurrent modification")
+)
+
+// Error is an error classified by its kind, which is one of ErrNotFound, ErrConflict,
+// ErrUnavailable, ErrConstraint and ErrConcurrentModification.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// NewError returns err classified as kind.
+func NewError(kind, err error) error {
+	return &Error{kind, err}
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// KindOf returns the kind of err, or nil if err is not classified.
+func KindOf(err error) error {
+	switch err {
+	case ErrNotFound, ErrConflict, ErrUnavailable, ErrConstraint, ErrConcurrentModification:
+		return err
+	}
+	if e, ok := err.(*Error); ok {
+		return e.Kind
+	}
+	return nil
+}
+
+// Wrap returns err annotated with msg. The annotated error keeps the kind of err.
+func Wrap(err error, msg string) error {
+	wrapped := fmt.Errorf("%s; %v", msg, err)
+	if kind := KindOf(err); kind != nil {
+		return NewError(kind, wrapped)
+	}
+	return wrapped
+}
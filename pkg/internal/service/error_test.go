@@ -0,0 +1,48 @@
+// +build !integration
+
+package service_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestKindOf(t *testing.T) {
+	foo := errors.New("foo")
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"not found", service.ErrNotFound, service.ErrNotFound},
+		{"conflict", service.ErrConflict, service.ErrConflict},
+		{"classified error", service.NewError(service.ErrUnavailable, foo), service.ErrUnavailable},
+		{"other error", foo, nil},
+		{"nil", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h.MustE(t, service.KindOf(test.err), test.kind, "got kind %v, want %v")
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	t.Run("keeps the kind of the error", func(t *testing.T) {
+		err := service.Wrap(service.NewError(service.ErrConstraint, errors.New("foo")), "bar")
+		h.MustE(t, service.KindOf(err), service.ErrConstraint, "got kind %v, want %v")
+		h.MustE(t, err.Error(), "bar; foo", "got error %q, want %q")
+	})
+	t.Run("keeps ErrNotFound", func(t *testing.T) {
+		err := service.Wrap(service.ErrNotFound, "bar")
+		h.MustE(t, service.KindOf(err), service.ErrNotFound, "got kind %v, want %v")
+	})
+	t.Run("does not classify other errors", func(t *testing.T) {
+		err := service.Wrap(errors.New("foo"), "bar")
+		h.Must(t, service.KindOf(err) == nil, "got kind %v, want nil", service.KindOf(err))
+		h.MustE(t, err.Error(), "bar; foo", "got error %q, want %q")
+	})
+}
@@ -0,0 +1,164 @@
+// Package expire releases the blocked amounts of the authorization requests, which are not captured
+// or reversed before their expiry.
+package expire
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// The default settings of Sweeper.
+const (
+	DefaultInterval  = time.Minute
+	DefaultBatchSize = 100
+)
+
+// Store is interface for retrieval of the expired authorization requests and persistence of their release.
+type Store interface {
+	service.UnitOfWork
+	// ListExpiredAuthorizationRequests returns the UUIDs of at most limit authorization requests,
+	// which expire at or before now and have blocked amounts.
+	ListExpiredAuthorizationRequests(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
+}
+
+// Sweeper releases the blocked amounts of the expired authorization requests.
+type Sweeper struct {
+	store  Store
+	logger *log.Logger
+
+	// Interval is the time between the sweeps.
+	Interval time.Duration
+	// BatchSize is the maximum number of authorization requests released per sweep.
+	BatchSize int
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+// NewSweeper returns new sweeper, which releases the expired authorization requests of s
+// and logs the errors with logger.
+func NewSweeper(s Store, logger *log.Logger) *Sweeper {
+	return &Sweeper{
+		store:     s,
+		logger:    logger,
+		Interval:  DefaultInterval,
+		BatchSize: DefaultBatchSize,
+		Now:       time.Now,
+	}
+}
+
+// Run releases the expired authorization requests every Interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	for {
+		if _, err := s.Sweep(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Printf("expire: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// Sweep releases the whole blocked amounts of a batch of the expired authorization requests and
+// returns the number of the released authorization requests. An authorization request, which is captured
+// or reversed concurrently, is released only if it still has blocked amount. An authorization request,
+// which cannot be released, is logged and skipped, and Sweep returns error with all skipped requests.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	now := s.Now()
+	ids, err := s.store.ListExpiredAuthorizationRequests(ctx, now, s.BatchSize)
+	if err != nil {
+		return 0, service.Wrap(err, "Sweep() cannot list expired authorization requests")
+	}
+	released := 0
+	var failed []string
+	for _, id := range ids {
+		var ok bool
+		err := service.Retry(func() error {
+			var err error
+			ok, err = s.expire(ctx, id, now)
+			return err
+		})
+		if err != nil {
+			s.logger.Printf("expire: authorization request %s: %v", id, err)
+			failed = append(failed, id.String())
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	if len(failed) > 0 {
+		return released, fmt.Errorf("Sweep() cannot release %d of %d authorization requests: %s",
+			len(failed), len(ids), strings.Join(failed, ", "))
+	}
+	return released, nil
+}
+
+// expire releases the blocked amount of the authorization request with UUID id, which is expired at now.
+// It returns false if the authorization request is not expired or it has no blocked amount.
+func (s *Sweeper) expire(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	released := false
+	err := s.store.WithinTx(ctx, func(r service.Tx) error {
+		authReq, err := r.GetAuthorizationRequest(ctx, id)
+		if err != nil {
+			return service.Wrap(err, "Sweep() cannot get authorization request")
+		}
+		if !authReq.IsExpired(now) || authReq.OriginalBlockedAmount() == 0 {
+			return nil
+		}
+		card, err := r.GetCard(ctx, authReq.CardUUID())
+		if err != nil {
+			return service.Wrap(err, "Sweep() cannot get card")
+		}
+		blocked, originalBlocked := authReq.BlockedAmount(), authReq.OriginalBlockedAmount()
+		if err := authReq.Expire(card, now); err != nil {
+			return fmt.Errorf("Sweep() cannot expire authorization request %s; %v", id, err)
+		}
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Sweep() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, authReq, eventUUID, event.TypeAuthorizationRequestExpired, blocked, "Authorization expiry", now)
+		if err != nil {
+			return fmt.Errorf("Sweep() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "Sweep() cannot persist card")
+		}
+		if err := r.SaveAuthorizationRequest(ctx, authReq); err != nil {
+			return service.Wrap(err, "Sweep() cannot persist authorization request")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "Sweep() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.AuthorizationRequestExpired{
+			UUID:                     eventUUID,
+			Time:                     tx.Date(),
+			AuthorizationRequestUUID: authReq.UUID(),
+			CardUUID:                 card.UUID(),
+			MerchantUUID:             authReq.MerchantUUID(),
+			Amount:                   blocked,
+			OriginalAmount:           originalBlocked,
+			Currency:                 string(authReq.Currency()),
+			CardCurrency:             string(card.Currency()),
+			Rate:                     authReq.Rate().Rate(),
+			ExpiresAt:                authReq.ExpiresAt(),
+		})
+		if err != nil {
+			return service.Wrap(err, "Sweep() cannot persist event")
+		}
+		released = true
+		return nil
+	})
+	return released, err
+}
@@ -0,0 +1,143 @@
+// +build !integration
+
+package expire_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/expire"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestSweeper_Sweep(t *testing.T) {
+	t.Run("does not release the authorization request before its expiry", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		s := newSweeper(r, r.AuthorizationRequest.ExpiresAt().Add(-time.Second))
+		n, err := s.Sweep(context.Background())
+		h.MustNotErr(t, err, "got Sweep() error %v, want nil")
+		h.MustE(t, n, 0, "got %d released authorization requests, want %d")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(70), "got blocked balance %v, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("releases the remaining blocked amount after the expiry", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		expiresAt := r.AuthorizationRequest.ExpiresAt()
+		s := newSweeper(r, expiresAt)
+		n, err := s.Sweep(context.Background())
+		h.MustNotErr(t, err, "got Sweep() error %v, want nil")
+		h.MustE(t, n, 1, "got %d released authorization requests, want %d")
+		h.MustE(t, r.Card.AvailableBalance(), uint64(100), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+		h.MustE(t, r.AuthorizationRequest.OriginalBlockedAmount(), uint64(0), "got original blocked amount %v, want %v")
+		h.MustE(t, len(r.AuthorizationRequest.History()), 2, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, r.Transactions[0].EventType(), event.TypeAuthorizationRequestExpired, "got transaction event type %q, want %q")
+		h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+		e, ok := r.Events[0].(event.AuthorizationRequestExpired)
+		h.Must(t, ok, "got saved event %T, want event.AuthorizationRequestExpired", r.Events[0])
+		h.MustE(t, e.UUID, r.Transactions[0].EventUUID(), "got saved event UUID %v, want %v")
+		h.MustE(t, e.Amount, uint64(70), "got saved event amount %v, want %v")
+		h.MustE(t, e.ExpiresAt, expiresAt, "got saved event expiry %v, want %v")
+
+		n, err = s.Sweep(context.Background())
+		h.MustNotErr(t, err, "got Sweep() error %v, want nil")
+		h.MustE(t, n, 0, "got %d released authorization requests on the second sweep, want %d")
+	})
+	t.Run("releases the amount, which is not captured", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		h.MustNotErr(t, r.AuthorizationRequest.Capture(r.Card, 30, time.Now()), "got Capture() error %v, want nil")
+		s := newSweeper(r, r.AuthorizationRequest.ExpiresAt().Add(time.Hour))
+		n, err := s.Sweep(context.Background())
+		h.MustNotErr(t, err, "got Sweep() error %v, want nil")
+		h.MustE(t, n, 1, "got %d released authorization requests, want %d")
+		h.MustE(t, r.Card.AvailableBalance(), uint64(70), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+		h.MustE(t, r.AuthorizationRequest.CapturedAmount(), uint64(30), "got captured amount %v, want %v")
+	})
+	t.Run("releases the authorization request the hold period after its authorization", func(t *testing.T) {
+		now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		m, err := model.NewMerchant("Test Merchant", "5411", "GB", "GB29NWBK60161331926819")
+		h.MustNotErr(t, err, "%v")
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney() %v; want nil")
+		r := &h.Repository{Card: c, Merchant: m}
+		svc := authorize.New(r, nil, nil, nil)
+		svc.Now = func() time.Time { return now }
+		res, err := svc.Authorize(context.Background(), authorize.Request{MerchantUUID: m.UUID().String(), CardUUID: c.UUID().String(), Amount: "70", Currency: "GBP"})
+		h.MustNotErr(t, err, "got svc.Authorize() error %v, want nil")
+		expiresAt := now.Add(model.DefaultHoldPeriod)
+		h.MustE(t, res.ExpiresAt, expiresAt.Format(time.RFC3339), "got expiry %q, want %q")
+
+		n, err := newSweeper(r, expiresAt.Add(-time.Second)).Sweep(context.Background())
+		h.MustNotErr(t, err, "got Sweep() error %v, want nil")
+		h.MustE(t, n, 0, "got %d released authorization requests before the expiry, want %d")
+		n, err = newSweeper(r, expiresAt).Sweep(context.Background())
+		h.MustNotErr(t, err, "got Sweep() error %v, want nil")
+		h.MustE(t, n, 1, "got %d released authorization requests, want %d")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		r.CommitErr = errors.New("test commit failed")
+		s := newSweeper(r, r.AuthorizationRequest.ExpiresAt())
+		_, err := s.Sweep(context.Background())
+		h.MustErr(t, err, "got Sweep() nil error, want error")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(70), "got blocked balance %v, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("releases the other authorization requests if one cannot be released", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		missing := uuid.Must(uuid.NewV4())
+		ids := []uuid.UUID{uuid.Must(uuid.NewV4()), r.AuthorizationRequest.UUID(), missing}
+		var logs bytes.Buffer
+		s := expire.NewSweeper(store{r, ids}, log.New(&logs, "", 0))
+		s.Now = func() time.Time { return r.AuthorizationRequest.ExpiresAt() }
+		n, err := s.Sweep(context.Background())
+		h.MustErr(t, err, "got Sweep() nil error, want error")
+		h.Must(t, strings.Contains(err.Error(), missing.String()), "got error %q, want the failed authorization request", err)
+		h.MustE(t, n, 1, "got %d released authorization requests, want %d")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(0), "got blocked balance %v, want %v")
+		h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+		h.MustE(t, strings.Count(logs.String(), "\n"), 2, "got %d logged failures, want %d")
+	})
+}
+
+// store is expire.Store, which lists ids as the expired authorization requests of Repository.
+type store struct {
+	*h.Repository
+	ids []uuid.UUID
+}
+
+// ListExpiredAuthorizationRequests implements expire.Store.
+func (s store) ListExpiredAuthorizationRequests(context.Context, time.Time, int) ([]uuid.UUID, error) {
+	return s.ids, nil
+}
+
+func newSweeper(r *h.Repository, now time.Time) *expire.Sweeper {
+	s := expire.NewSweeper(r, log.New(ioutil.Discard, "", 0))
+	s.Now = func() time.Time { return now }
+	return s
+}
+
+func mustRepository(t *testing.T, l, b uint64) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(l), "c.LoadMoney() %v; want nil")
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(b, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Card: c, AuthorizationRequest: req}
+}
@@ -0,0 +1,62 @@
+package getcard
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Response is the response, which Service returns when a card is found.
+type Response struct {
+	UUID             string `json:"uuid"`
+	Currency         string `json:"currency"`
+	Status           string `json:"status"`
+	AvailableBalance string `json:"availableBalance"`
+	BlockedBalance   string `json:"blockedBalance"`
+	// ETag is the entity tag of the card.
+	ETag string `json:"-"`
+}
+
+// Service is the service returning card details.
+type Service struct {
+	getter Getter
+}
+
+// New returns new service returning card details.
+func New(g Getter) *Service {
+	return &Service{g}
+}
+
+// GetCard returns the card with UUID id.
+// It returns 404 service.ErrorResponse if the card does not exist.
+func (svc *Service) GetCard(ctx context.Context, id string) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	card, err := svc.getter.GetCard(ctx, cardUUID)
+	if err == service.ErrNotFound {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	if err != nil {
+		return Response{}, service.Wrap(err, "GetCard() cannot get card")
+	}
+	return Response{
+		UUID:             card.UUID().String(),
+		Currency:         string(card.Currency()),
+		Status:           string(card.Status()),
+		AvailableBalance: strconv.FormatUint(card.AvailableBalance(), 10),
+		BlockedBalance:   strconv.FormatUint(card.BlockedBalance(), 10),
+		ETag:             service.ETag(card.Version()),
+	}, nil
+}
+
+// Getter is interface for retrieval of cards.
+// It must return service.ErrNotFound if the card does not exist.
+type Getter interface {
+	GetCard(context.Context, uuid.UUID) (*model.Card, error)
+}
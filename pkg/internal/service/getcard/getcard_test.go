@@ -0,0 +1,52 @@
+// +build !integration
+
+package getcard_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/getcard"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_GetCard(t *testing.T) {
+	t.Run("returns the card", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney(100) %v; want nil")
+		svc := getcard.New(&h.Repository{Card: c})
+		r, err := svc.GetCard(context.Background(), c.UUID().String())
+		h.MustNotErr(t, err, "got svc.GetCard() = %T, %#v, want nil", r)
+		h.MustE(t, r.UUID, c.UUID().String(), "got response card UUID %q != card UUID %q, want them equal")
+		h.MustE(t, r.Status, "active", "got response status %q != %q; want them equal")
+		h.MustE(t, r.AvailableBalance, "100", "got response availableBalance %v != %q; want them equal")
+		h.MustE(t, r.BlockedBalance, "0", "got response blockedBalance %v != %q; want them equal")
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		svc := getcard.New(&h.Repository{})
+		_, err := svc.GetCard(context.Background(), uuid.Must(uuid.NewV4()).String())
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.StatusCode(), 404, "got status code %#v, want %#v")
+	})
+	t.Run("returns 404 error response if the UUID is invalid", func(t *testing.T) {
+		svc := getcard.New(&h.Repository{})
+		_, err := svc.GetCard(context.Background(), "foo")
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.StatusCode(), 404, "got status code %#v, want %#v")
+	})
+	t.Run("returns error if getter returns error", func(t *testing.T) {
+		svc := getcard.New(&h.Repository{Err: errors.New("test getter failed")})
+		_, err := svc.GetCard(context.Background(), uuid.Must(uuid.NewV4()).String())
+		h.MustErr(t, err, "got svc.GetCard() = getcard.Response, nil, want getcard.Response, error")
+		_, ok := err.(service.ErrorResponse)
+		h.Must(t, !ok, "got service.ErrorResponse, want internal error")
+	})
+}
@@ -0,0 +1,133 @@
+package increment
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Request is the request for incrementing an authorization request.
+type Request struct {
+	Amount string `json:"amount"`
+}
+
+// Response is the response, which Service returns when an authorization request is incremented.
+type Response service.AuthorizationRequest
+
+// Service is the service incrementing authorization requests.
+type Service struct {
+	uow    service.UnitOfWork
+	limits model.ProductLimits
+
+	// Now returns the current time, at which the expiry of the authorization requests and the limits of the cards
+	// are checked and the transactions are recorded.
+	Now func() time.Time
+}
+
+// New returns new service incrementing authorization requests, which saves the changes with unit of work u.
+// The increments, which exceed the limits of the cards with the default limits l of their products, are declined.
+func New(u service.UnitOfWork, l model.ProductLimits) *Service {
+	return &Service{uow: u, limits: l, Now: time.Now}
+}
+
+// Increment blocks the amount of req more for the authorization request with UUID id and restarts its hold period.
+// It returns 404 service.ErrorResponse if the authorization request does not exist,
+// 422 service.ErrorResponse if the amount cannot be blocked or it exceeds a limit of the card and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Increment(ctx context.Context, id string, req Request) (Response, error) {
+	authReqUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	v := &validation.Validator{}
+	amount := v.Amount("amount", req.Amount)
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err = service.Retry(func() error {
+		var err error
+		res, err = svc.increment(ctx, authReqUUID, amount)
+		return err
+	})
+	return res, err
+}
+
+// increment is Increment without retries.
+func (svc *Service) increment(ctx context.Context, authReqUUID uuid.UUID, amount uint64) (Response, error) {
+	var authReq *model.AuthorizationRequest
+	err := svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		var err error
+		authReq, err = r.GetAuthorizationRequest(ctx, authReqUUID)
+		if err == service.ErrNotFound {
+			return service.NewNotFoundErrorResponse()
+		}
+		if err != nil {
+			return service.Wrap(err, "Increment() cannot get authorization request")
+		}
+		card, err := r.GetCard(ctx, authReq.CardUUID())
+		if err != nil {
+			return service.Wrap(err, "Increment() cannot get card")
+		}
+		now := svc.Now()
+		blocked := authReq.BlockedAmount()
+		if err := authReq.Increment(card, amount, now); err != nil {
+			return service.NewValidationErrorResponse(
+				"The authorization request cannot be incremented.",
+				service.InvalidParameter{Name: "amount", Reason: err.Error()},
+			)
+		}
+		converted := authReq.BlockedAmount() - blocked
+		err = service.CheckLimits(ctx, r, card.UUID(), svc.limits, now, func(l model.Limits, txs []*model.Transaction) error {
+			return l.CheckIncrement(txs, converted, now)
+		})
+		if err != nil {
+			return err
+		}
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Increment() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, authReq, eventUUID, event.TypeAuthorizationRequestIncremented, converted, "Authorization increment", now)
+		if err != nil {
+			return fmt.Errorf("Increment() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "Increment() cannot persist card")
+		}
+		if err := r.SaveAuthorizationRequest(ctx, authReq); err != nil {
+			return service.Wrap(err, "Increment() cannot persist authorization request")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "Increment() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.AuthorizationRequestIncremented{
+			UUID:                     eventUUID,
+			Time:                     tx.Date(),
+			AuthorizationRequestUUID: authReq.UUID(),
+			CardUUID:                 card.UUID(),
+			MerchantUUID:             authReq.MerchantUUID(),
+			Amount:                   converted,
+			OriginalAmount:           amount,
+			Currency:                 string(authReq.Currency()),
+			CardCurrency:             string(card.Currency()),
+			Rate:                     authReq.Rate().Rate(),
+			ExpiresAt:                authReq.ExpiresAt(),
+		})
+		if err != nil {
+			return service.Wrap(err, "Increment() cannot persist event")
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return Response(service.NewAuthorizationRequest(authReq)), nil
+}
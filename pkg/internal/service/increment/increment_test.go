@@ -0,0 +1,109 @@
+// +build !integration
+
+package increment_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/increment"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Increment(t *testing.T) {
+	t.Run("blocks the amount, saves the authorization request and its event", func(t *testing.T) {
+		r := mustRepository(t, 100, 30)
+		res, err := increment.New(r, nil).Increment(context.Background(), r.AuthorizationRequest.UUID().String(), increment.Request{Amount: "20"})
+		h.MustNotErr(t, err, "got svc.Increment() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(50), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(50), "got blocked balance %v, want %v")
+		h.MustE(t, res.BlockedAmount, "50", "got response blocked amount %q, want %q")
+		h.MustE(t, len(res.History), 2, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, r.Transactions[0].EventType(), event.TypeAuthorizationRequestIncremented, "got transaction event type %q, want %q")
+		h.MustE(t, r.Transactions[0].AvailableBalance(), uint64(50), "got transaction available balance %v, want %v")
+		h.MustE(t, savedEvent(t, r).UUID, r.Transactions[0].EventUUID(), "got saved event event UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).AuthorizationRequestUUID, r.AuthorizationRequest.UUID(), "got saved event authorization request UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(20), "got saved event amount %v, want %v")
+		h.MustE(t, savedEvent(t, r).ExpiresAt, r.AuthorizationRequest.ExpiresAt(), "got saved event expiry %v, want %v")
+	})
+	t.Run("returns 404 error response if the authorization request does not exist", func(t *testing.T) {
+		r := mustRepository(t, 100, 30)
+		_, err := increment.New(r, nil).Increment(context.Background(), uuid.Must(uuid.NewV4()).String(), increment.Request{Amount: "1"})
+		res := h.MustErrorResponse(t, err, 404)
+		h.MustE(t, len(res.InvalidParameters), 0, "got %v invalid parameters, want %v")
+	})
+	t.Run("returns 422 error response with the invalid parameter if the amount cannot be blocked", func(t *testing.T) {
+		for _, a := range []string{"foo", "0", "71"} {
+			r := mustRepository(t, 100, 30)
+			_, err := increment.New(r, nil).Increment(context.Background(), r.AuthorizationRequest.UUID().String(), increment.Request{Amount: a})
+			res := h.MustErrorResponse(t, err, 422)
+			h.MustE(t, len(res.InvalidParameters), 1, "got %v invalid parameters, want %v")
+			h.MustE(t, res.InvalidParameters[0].Name, "amount", "got invalid parameter %q, want %q")
+			h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+			h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+		}
+	})
+	t.Run("returns 422 error response if the authorization request is expired at the current time", func(t *testing.T) {
+		r := mustRepository(t, 100, 30)
+		svc := increment.New(r, nil)
+		svc.Now = func() time.Time { return r.AuthorizationRequest.ExpiresAt() }
+		_, err := svc.Increment(context.Background(), r.AuthorizationRequest.UUID().String(), increment.Request{Amount: "20"})
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, r.Card.BlockedBalance(), uint64(30), "got blocked balance %v, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("returns 422 error response if the increment exceeds the daily limit of the card", func(t *testing.T) {
+		r := mustRepository(t, 100, 30)
+		r.CardLimits = &model.CardLimits{Product: model.DefaultProduct, Limits: model.Limits{Daily: 50}}
+		tx, err := model.NewTransaction(r.Card, r.AuthorizationRequest, r.AuthorizationRequest.UUID(), event.TypeAuthorizationRequestCreated, 30, "Authorization request", time.Now())
+		h.MustNotErr(t, err, "%v")
+		r.Transactions = []*model.Transaction{tx}
+		_, err = increment.New(r, nil).Increment(context.Background(), r.AuthorizationRequest.UUID().String(), increment.Request{Amount: "21"})
+		res := h.MustErrorResponse(t, err, 422)
+		h.MustE(t, res.Extensions["limit"], model.LimitDaily, "got limit %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(30), "got blocked balance %v, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+		_, err = increment.New(r, nil).Increment(context.Background(), r.AuthorizationRequest.UUID().String(), increment.Request{Amount: "20"})
+		h.MustNotErr(t, err, "got svc.Increment() error %v, want nil")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		r := mustRepository(t, 100, 30)
+		r.CommitErr = errors.New("test commit failed")
+		available, blocked := r.Card.AvailableBalance(), r.Card.BlockedBalance()
+		history, expiresAt := len(r.AuthorizationRequest.History()), r.AuthorizationRequest.ExpiresAt()
+		_, err := increment.New(r, nil).Increment(context.Background(), r.AuthorizationRequest.UUID().String(), increment.Request{Amount: "1"})
+		h.MustErr(t, err, "got svc.Increment() = increment.Response, nil, want increment.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), available, "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), blocked, "got blocked balance %v, want %v")
+		h.MustE(t, len(r.AuthorizationRequest.History()), history, "got %v snapshots, want %v")
+		h.MustE(t, r.AuthorizationRequest.ExpiresAt(), expiresAt, "got expiry %v, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func mustRepository(t *testing.T, l, b uint64) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(l), "c.LoadMoney() %v; want nil")
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(b, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Card: c, AuthorizationRequest: req}
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) event.AuthorizationRequestIncremented {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	e, ok := r.Events[0].(event.AuthorizationRequestIncremented)
+	h.Must(t, ok, "got saved event %T, want event.AuthorizationRequestIncremented", r.Events[0])
+	return e
+}
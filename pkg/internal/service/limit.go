@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+)
+
+// NewLimitExceededErrorResponse returns 422 Unprocessable Entity for a request, which exceeds limit
+// of the card. The name of the limit is rendered as member "limit".
+func NewLimitExceededErrorResponse(limit, detail string) ErrorResponse {
+	return ErrorResponse{
+		Type:       "/doc/error/limit-exceeded",
+		Title:      "Limit Exceeded",
+		Status:     http.StatusUnprocessableEntity,
+		Detail:     detail,
+		Extensions: map[string]interface{}{"limit": limit},
+	}
+}
+
+// CheckLimits calls check with the limits of the card with UUID id and its transactions, which the limits
+// are checked against at now. The limits of the card are the defaults of its product in p overridden by
+// its own limits and the cards without product have model.DefaultProduct.
+// It returns 422 ErrorResponse if check returns *model.LimitExceededError.
+func CheckLimits(ctx context.Context, r Tx, id uuid.UUID, p model.ProductLimits, now time.Time, check func(model.Limits, []*model.Transaction) error) error {
+	cl, err := r.GetCardLimits(ctx, id)
+	if err == ErrNotFound {
+		cl, err = model.CardLimits{Product: model.DefaultProduct}, nil
+	}
+	if err != nil {
+		return Wrap(err, "CheckLimits() cannot get card limits")
+	}
+	l := p.Of(cl)
+	if l == (model.Limits{}) {
+		return nil
+	}
+	txs, err := r.ListCardTransactions(ctx, id, l.Since(now))
+	if err != nil {
+		return Wrap(err, "CheckLimits() cannot list card transactions")
+	}
+	err = check(l, txs)
+	if e, ok := err.(*model.LimitExceededError); ok {
+		return NewLimitExceededErrorResponse(e.Limit, fmt.Sprintf("The %s limit of the card is exceeded.", e.Limit))
+	}
+	return err
+}
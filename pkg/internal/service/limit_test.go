@@ -0,0 +1,68 @@
+// +build !integration
+
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestNewLimitExceededErrorResponse(t *testing.T) {
+	r := service.NewLimitExceededErrorResponse(model.LimitDaily, "foo")
+	h.MustE(t, r.StatusCode(), 422, "got status code %v, want %v")
+	b, err := json.Marshal(r)
+	h.MustNotErr(t, err, "got json.Marshal() error %v, want nil")
+	want := `{"type":"/doc/error/limit-exceeded","title":"Limit Exceeded","status":422,"detail":"foo","limit":"daily"}`
+	h.MustE(t, string(b), want, "got %s, want %s")
+}
+
+func TestCheckLimits(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney() %v; want nil")
+	tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), event.TypeCardLoaded, 100, "Card load", time.Now())
+	h.MustNotErr(t, err, "%v")
+	check := func(l model.Limits, txs []*model.Transaction) error {
+		return l.CheckLoad(txs, 1, time.Now())
+	}
+
+	t.Run("checks the default limits of the product of the card", func(t *testing.T) {
+		r := &h.Repository{Card: c, Transactions: []*model.Transaction{tx}}
+		p := model.ProductLimits{model.DefaultProduct: {DailyLoad: 100}}
+		err := r.WithinTx(context.Background(), func(tx service.Tx) error {
+			return service.CheckLimits(context.Background(), tx, c.UUID(), p, time.Now(), check)
+		})
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.Type, "/doc/error/limit-exceeded", "got type %q, want %q")
+		h.MustE(t, res.Extensions["limit"], model.LimitDailyLoad, "got limit %v, want %v")
+	})
+	t.Run("checks the own limits of the card", func(t *testing.T) {
+		r := &h.Repository{Card: c, Transactions: []*model.Transaction{tx}}
+		r.CardLimits = &model.CardLimits{Product: "travel", Limits: model.Limits{DailyLoad: 101}}
+		p := model.ProductLimits{model.DefaultProduct: {DailyLoad: 100}, "travel": {DailyLoad: 50}}
+		err := r.WithinTx(context.Background(), func(tx service.Tx) error {
+			return service.CheckLimits(context.Background(), tx, c.UUID(), p, time.Now(), check)
+		})
+		h.MustNotErr(t, err, "got CheckLimits() error %v, want nil")
+	})
+	t.Run("does not list the transactions without limits", func(t *testing.T) {
+		r := &h.Repository{Card: c}
+		err := r.WithinTx(context.Background(), func(tx service.Tx) error {
+			return service.CheckLimits(context.Background(), tx, c.UUID(), nil, time.Now(), func(model.Limits, []*model.Transaction) error {
+				t.Error("got check call without limits, want none")
+				return nil
+			})
+		})
+		h.MustNotErr(t, err, "got CheckLimits() error %v, want nil")
+	})
+}
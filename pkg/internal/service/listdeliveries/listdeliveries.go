@@ -0,0 +1,143 @@
+package listdeliveries
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+)
+
+// DefaultLimit is the number of deliveries returned if the request has no limit.
+const DefaultLimit = 20
+
+// MaxLimit is the maximum number of deliveries returned per request.
+const MaxLimit = 100
+
+// Request is the request for the latest deliveries of a webhook.
+type Request struct {
+	Limit string
+}
+
+// Response is the response, which Service returns with the deliveries of a webhook.
+type Response struct {
+	Deliveries []Delivery `json:"deliveries"`
+}
+
+// Delivery is the delivery of an event returned to the client.
+type Delivery struct {
+	UUID           string `json:"uuid"`
+	EventUUID      string `json:"eventUUID"`
+	EventType      string `json:"eventType"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseStatus int    `json:"responseStatus,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	CreatedAt      string `json:"createdAt"`
+	NextAttemptAt  string `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    string `json:"deliveredAt,omitempty"`
+	// History are the attempts of the delivery from the first to the last.
+	History []Attempt `json:"history"`
+}
+
+// Attempt is an attempt to send a delivery returned to the client.
+type Attempt struct {
+	Number         int    `json:"number"`
+	Status         string `json:"status"`
+	ResponseStatus int    `json:"responseStatus,omitempty"`
+	Error          string `json:"error,omitempty"`
+	AttemptedAt    string `json:"attemptedAt"`
+}
+
+// Service is the service listing the deliveries of webhooks.
+type Service struct {
+	getter Getter
+	lister Lister
+}
+
+// New returns new service listing the deliveries of webhooks.
+func New(g Getter, l Lister) *Service {
+	return &Service{g, l}
+}
+
+// ListDeliveries returns the latest deliveries of the webhook with UUID id from the newest to the oldest
+// with their histories.
+// It returns 404 service.ErrorResponse if the webhook does not exist and
+// 422 service.ErrorResponse if the request is invalid.
+func (svc *Service) ListDeliveries(ctx context.Context, id string, req Request) (Response, error) {
+	webhookUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	limit := DefaultLimit
+	if len(req.Limit) > 0 {
+		v := &validation.Validator{}
+		if limit, err = strconv.Atoi(req.Limit); err != nil || limit < 1 || limit > MaxLimit {
+			v.Invalid("limit", fmt.Sprintf("must be integer between 1 and %d", MaxLimit))
+		}
+		if err := v.Err("The query parameters are invalid."); err != nil {
+			return Response{}, err
+		}
+	}
+	if _, err := svc.getter.GetWebhook(ctx, webhookUUID); err == service.ErrNotFound {
+		return Response{}, service.NewNotFoundErrorResponse()
+	} else if err != nil {
+		return Response{}, service.Wrap(err, "ListDeliveries() cannot get webhook")
+	}
+	deliveries, err := svc.lister.ListDeliveries(ctx, webhookUUID, limit)
+	if err != nil {
+		return Response{}, service.Wrap(err, "ListDeliveries() cannot list deliveries")
+	}
+	res := Response{Deliveries: []Delivery{}}
+	for _, d := range deliveries {
+		item := Delivery{
+			UUID:           d.UUID.String(),
+			EventUUID:      d.EventUUID.String(),
+			EventType:      d.EventType,
+			Status:         string(d.Status),
+			Attempts:       d.Attempts,
+			ResponseStatus: d.ResponseStatus,
+			LastError:      d.LastError,
+			CreatedAt:      d.CreatedAt.Format(time.RFC3339),
+		}
+		if d.Status == webhook.DeliveryPending {
+			item.NextAttemptAt = d.NextAttemptAt.Format(time.RFC3339)
+		}
+		if !d.DeliveredAt.IsZero() {
+			item.DeliveredAt = d.DeliveredAt.Format(time.RFC3339)
+		}
+		attempts, err := svc.lister.ListAttempts(ctx, d.UUID)
+		if err != nil {
+			return Response{}, service.Wrap(err, "ListDeliveries() cannot list attempts")
+		}
+		item.History = []Attempt{}
+		for _, a := range attempts {
+			item.History = append(item.History, Attempt{
+				Number:         a.Number,
+				Status:         string(a.Status),
+				ResponseStatus: a.ResponseStatus,
+				Error:          a.Error,
+				AttemptedAt:    a.AttemptedAt.Format(time.RFC3339),
+			})
+		}
+		res.Deliveries = append(res.Deliveries, item)
+	}
+	return res, nil
+}
+
+// Getter is interface for retrieval of webhooks.
+// It must return service.ErrNotFound if the webhook does not exist.
+type Getter interface {
+	GetWebhook(context.Context, uuid.UUID) (webhook.Webhook, error)
+}
+
+// Lister is interface for retrieval of the deliveries of webhooks and their histories.
+type Lister interface {
+	ListDeliveries(ctx context.Context, id uuid.UUID, limit int) ([]webhook.Delivery, error)
+	ListAttempts(ctx context.Context, id uuid.UUID) ([]webhook.Attempt, error)
+}
@@ -0,0 +1,100 @@
+// +build !integration
+
+package listdeliveries_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listdeliveries"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+)
+
+func TestService_ListDeliveries(t *testing.T) {
+	w := webhook.Webhook{UUID: uuid.Must(uuid.NewV4()), MerchantUUID: uuid.Must(uuid.NewV4())}
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRepository := func() *h.Repository {
+		r := &h.Repository{Webhooks: []webhook.Webhook{w}}
+		for i := 0; i < 3; i++ {
+			r.Deliveries = append(r.Deliveries, webhook.Delivery{
+				UUID:          uuid.Must(uuid.NewV4()),
+				WebhookUUID:   w.UUID,
+				EventUUID:     uuid.Must(uuid.NewV4()),
+				EventType:     event.TypeAuthorizationRequestCaptured,
+				Status:        webhook.DeliveryPending,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+		}
+		r.Deliveries[0].Status = webhook.DeliverySucceeded
+		r.Deliveries[0].Attempts = 1
+		r.Deliveries[0].ResponseStatus = 200
+		r.Deliveries[0].DeliveredAt = now
+		r.Attempts = []webhook.Attempt{
+			{DeliveryUUID: r.Deliveries[0].UUID, Number: 1, Status: webhook.DeliveryFailed, Error: "test timeout", AttemptedAt: now.Add(-time.Minute)},
+			{DeliveryUUID: r.Deliveries[0].UUID, Number: 2, Status: webhook.DeliverySucceeded, ResponseStatus: 200, AttemptedAt: now},
+		}
+		r.Deliveries = append(r.Deliveries, webhook.Delivery{UUID: uuid.Must(uuid.NewV4()), WebhookUUID: uuid.Must(uuid.NewV4())})
+		return r
+	}
+
+	t.Run("returns the deliveries from the newest to the oldest", func(t *testing.T) {
+		r := newRepository()
+		res, err := listdeliveries.New(r, r).ListDeliveries(context.Background(), w.UUID.String(), listdeliveries.Request{})
+		h.MustNotErr(t, err, "got svc.ListDeliveries() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Deliveries), 3, "got %d deliveries, want %d")
+		h.MustE(t, res.Deliveries[0].UUID, r.Deliveries[2].UUID.String(), "got first delivery %q, want %q")
+		h.MustE(t, res.Deliveries[0].Status, "pending", "got status %q, want %q")
+		h.MustE(t, res.Deliveries[0].NextAttemptAt, "2019-01-01T00:00:00Z", "got next attempt %q, want %q")
+		last := res.Deliveries[2]
+		h.MustE(t, last.Status, "succeeded", "got status %q, want %q")
+		h.MustE(t, last.ResponseStatus, 200, "got response status %d, want %d")
+		h.MustE(t, last.DeliveredAt, "2019-01-01T00:00:00Z", "got delivery time %q, want %q")
+		h.MustE(t, last.NextAttemptAt, "", "got next attempt %q, want %q")
+	})
+	t.Run("returns the history of each delivery", func(t *testing.T) {
+		r := newRepository()
+		res, err := listdeliveries.New(r, r).ListDeliveries(context.Background(), w.UUID.String(), listdeliveries.Request{})
+		h.MustNotErr(t, err, "got svc.ListDeliveries() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Deliveries[0].History), 0, "got %d attempts of pending delivery, want %d")
+		history := res.Deliveries[2].History
+		h.MustE(t, len(history), 2, "got %d attempts, want %d")
+		h.MustE(t, history[0].Number, 1, "got first attempt number %d, want %d")
+		h.MustE(t, history[0].Status, "failed", "got first attempt status %q, want %q")
+		h.MustE(t, history[0].Error, "test timeout", "got first attempt error %q, want %q")
+		h.MustE(t, history[0].AttemptedAt, "2018-12-31T23:59:00Z", "got first attempt time %q, want %q")
+		h.MustE(t, history[1].Status, "succeeded", "got second attempt status %q, want %q")
+		h.MustE(t, history[1].ResponseStatus, 200, "got second attempt response status %d, want %d")
+	})
+	t.Run("returns up to limit deliveries", func(t *testing.T) {
+		r := newRepository()
+		res, err := listdeliveries.New(r, r).ListDeliveries(context.Background(), w.UUID.String(), listdeliveries.Request{Limit: "2"})
+		h.MustNotErr(t, err, "got svc.ListDeliveries() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Deliveries), 2, "got %d deliveries, want %d")
+	})
+	t.Run("returns 422 error response if the limit is invalid", func(t *testing.T) {
+		r := newRepository()
+		for _, l := range []string{"0", "101", "foo"} {
+			_, err := listdeliveries.New(r, r).ListDeliveries(context.Background(), w.UUID.String(), listdeliveries.Request{Limit: l})
+			h.MustErrorResponse(t, err, 422)
+		}
+	})
+	t.Run("returns 404 error response if the webhook does not exist", func(t *testing.T) {
+		r := newRepository()
+		for _, id := range []string{"foo", uuid.Must(uuid.NewV4()).String()} {
+			_, err := listdeliveries.New(r, r).ListDeliveries(context.Background(), id, listdeliveries.Request{})
+			h.MustErrorResponse(t, err, 404)
+		}
+	})
+	t.Run("returns error if lister returns error", func(t *testing.T) {
+		r := newRepository()
+		_, err := listdeliveries.New(r, &h.Repository{Err: errors.New("test lister failed")}).ListDeliveries(context.Background(), w.UUID.String(), listdeliveries.Request{})
+		h.MustErr(t, err, "got svc.ListDeliveries() = listdeliveries.Response, nil, want listdeliveries.Response, error")
+	})
+}
@@ -0,0 +1,207 @@
+package listtransactions
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// DefaultLimit is the number of transactions returned if the request has no limit.
+const DefaultLimit = 20
+
+// MaxLimit is the maximum number of transactions returned per request.
+const MaxLimit = 100
+
+// Request is the request for a page of card transactions.
+type Request struct {
+	Cursor     string
+	Limit      string
+	From       string
+	To         string
+	EventTypes []string
+}
+
+// Response is the response, which Service returns with a page of card transactions.
+type Response struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"nextCursor,omitempty"`
+}
+
+// Transaction is the card transaction returned to the client.
+type Transaction struct {
+	UUID             string `json:"uuid"`
+	EventUUID        string `json:"eventUUID"`
+	EventType        string `json:"eventType"`
+	Date             string `json:"date"`
+	Amount           string `json:"amount"`
+	AvailableBalance string `json:"availableBalance"`
+	BlockedBalance   string `json:"blockedBalance"`
+	Description      string `json:"description"`
+}
+
+// Filter is the criteria for listing card transactions.
+// The transactions are ordered from the newest to the oldest.
+type Filter struct {
+	CardUUID uuid.UUID
+	// From is the inclusive lower bound of the transaction date, if not zero.
+	From time.Time
+	// To is the exclusive upper bound of the transaction date, if not zero.
+	To time.Time
+	// EventTypes are the allowed event types, if not empty.
+	EventTypes []string
+	// After is the position after which the transactions are listed, if not nil.
+	After *Cursor
+	// Limit is the maximum number of transactions.
+	Limit int
+}
+
+// Cursor is the position of a transaction in the list.
+type Cursor struct {
+	Date time.Time
+	UUID uuid.UUID
+}
+
+// String encodes c as an opaque string.
+func (c Cursor) String() string {
+	s := fmt.Sprintf("%d|%s", c.Date.UnixNano(), c.UUID)
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// ParseCursor decodes cursor encoded by Cursor.String.
+func ParseCursor(s string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("cannot decode cursor; %v", err)
+	}
+	parts := strings.SplitN(string(b), "|", 2)
+	if len(parts) != 2 {
+		return Cursor{}, fmt.Errorf("cannot decode cursor %q", s)
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("cannot decode cursor date; %v", err)
+	}
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("cannot decode cursor UUID; %v", err)
+	}
+	return Cursor{Date: time.Unix(0, ns).UTC(), UUID: id}, nil
+}
+
+// Service is the service listing card transactions.
+type Service struct {
+	getter Getter
+	lister Lister
+}
+
+// New returns new service listing card transactions.
+func New(g Getter, l Lister) *Service {
+	return &Service{g, l}
+}
+
+// ListTransactions returns a page of the transactions of the card with UUID id.
+// It returns 404 service.ErrorResponse if the card does not exist and
+// 422 service.ErrorResponse if the request is invalid.
+func (svc *Service) ListTransactions(ctx context.Context, id string, req Request) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	filter, err := newFilter(cardUUID, req)
+	if err != nil {
+		return Response{}, err
+	}
+	if _, err := svc.getter.GetCard(ctx, cardUUID); err == service.ErrNotFound {
+		return Response{}, service.NewNotFoundErrorResponse()
+	} else if err != nil {
+		return Response{}, service.Wrap(err, "ListTransactions() cannot get card")
+	}
+	limit := filter.Limit
+	filter.Limit++
+	txs, err := svc.lister.ListTransactions(ctx, filter)
+	if err != nil {
+		return Response{}, service.Wrap(err, "ListTransactions() cannot list transactions")
+	}
+	res := Response{Transactions: []Transaction{}}
+	if len(txs) > limit {
+		txs = txs[:limit]
+		last := txs[len(txs)-1]
+		res.NextCursor = Cursor{Date: last.Date(), UUID: last.UUID()}.String()
+	}
+	for _, tx := range txs {
+		res.Transactions = append(res.Transactions, Transaction{
+			UUID:             tx.UUID().String(),
+			EventUUID:        tx.EventUUID().String(),
+			EventType:        tx.EventType(),
+			Date:             tx.Date().Format(time.RFC3339Nano),
+			Amount:           strconv.FormatUint(tx.Amount(), 10),
+			AvailableBalance: strconv.FormatUint(tx.AvailableBalance(), 10),
+			BlockedBalance:   strconv.FormatUint(tx.BlockedBalance(), 10),
+			Description:      tx.Description(),
+		})
+	}
+	return res, nil
+}
+
+// newFilter returns the filter for the card with cardUUID from req.
+// It returns 422 service.ErrorResponse with all invalid parameters if req is invalid.
+func newFilter(cardUUID uuid.UUID, req Request) (Filter, error) {
+	filter := Filter{
+		CardUUID:   cardUUID,
+		EventTypes: req.EventTypes,
+		Limit:      DefaultLimit,
+	}
+	v := &validation.Validator{}
+	if len(req.Cursor) > 0 {
+		c, err := ParseCursor(req.Cursor)
+		if err != nil {
+			v.Invalid("cursor", "must be a cursor returned by the previous page")
+		}
+		filter.After = &c
+	}
+	if len(req.Limit) > 0 {
+		l, err := strconv.Atoi(req.Limit)
+		if err != nil || l < 1 || l > MaxLimit {
+			v.Invalid("limit", fmt.Sprintf("must be integer between 1 and %d", MaxLimit))
+		}
+		filter.Limit = l
+	}
+	if len(req.From) > 0 {
+		t, err := time.Parse(time.RFC3339, req.From)
+		if err != nil {
+			v.Invalid("from", "must be RFC 3339 date-time")
+		}
+		filter.From = t
+	}
+	if len(req.To) > 0 {
+		t, err := time.Parse(time.RFC3339, req.To)
+		if err != nil {
+			v.Invalid("to", "must be RFC 3339 date-time")
+		}
+		filter.To = t
+	}
+	if err := v.Err("The query parameters are invalid."); err != nil {
+		return Filter{}, err
+	}
+	return filter, nil
+}
+
+// Getter is interface for retrieval of cards.
+// It must return service.ErrNotFound if the card does not exist.
+type Getter interface {
+	GetCard(context.Context, uuid.UUID) (*model.Card, error)
+}
+
+// Lister is interface for retrieval of card transactions.
+type Lister interface {
+	ListTransactions(context.Context, Filter) ([]*model.Transaction, error)
+}
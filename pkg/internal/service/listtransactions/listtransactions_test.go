@@ -0,0 +1,119 @@
+// +build !integration
+
+package listtransactions_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_ListTransactions(t *testing.T) {
+	t.Run("returns the transactions from the newest to the oldest", func(t *testing.T) {
+		r := mustRepository(t, 3)
+		res, err := listtransactions.New(r, r).ListTransactions(context.Background(), r.Card.UUID().String(), listtransactions.Request{})
+		h.MustNotErr(t, err, "got svc.ListTransactions() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Transactions), 3, "got %v transactions, want %v")
+		h.MustE(t, res.Transactions[0].UUID, r.Transactions[2].UUID().String(), "got first transaction %q, want %q")
+		h.MustE(t, res.Transactions[2].UUID, r.Transactions[0].UUID().String(), "got last transaction %q, want %q")
+		h.MustE(t, res.NextCursor, "", "got next cursor %q, want %q")
+	})
+	t.Run("pages the transactions with the cursor", func(t *testing.T) {
+		r := mustRepository(t, 5)
+		svc := listtransactions.New(r, r)
+		var got []string
+		req := listtransactions.Request{Limit: "2"}
+		for i := 0; i < 5; i++ {
+			res, err := svc.ListTransactions(context.Background(), r.Card.UUID().String(), req)
+			h.MustNotErr(t, err, "got svc.ListTransactions() = %T, %#v, want nil", res)
+			for _, tx := range res.Transactions {
+				got = append(got, tx.UUID)
+			}
+			if res.NextCursor == "" {
+				break
+			}
+			req.Cursor = res.NextCursor
+		}
+		h.MustE(t, len(got), 5, "got %v transactions, want %v")
+		for i, id := range got {
+			h.MustE(t, id, r.Transactions[4-i].UUID().String(), "got transaction %q, want %q")
+		}
+	})
+	t.Run("filters the transactions by date and event type", func(t *testing.T) {
+		r := mustRepository(t, 3)
+		c := r.Card
+		req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(1, model.GBP), nil, time.Now())
+		h.MustNotErr(t, err, "%v")
+		tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "AuthorizationRequestCreated", 1, "", time.Now())
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, r.SaveAuthorizationRequest(context.Background(), c, req, tx), "%v")
+
+		svc := listtransactions.New(r, r)
+		res, err := svc.ListTransactions(context.Background(), c.UUID().String(), listtransactions.Request{EventTypes: []string{"AuthorizationRequestCreated"}})
+		h.MustNotErr(t, err, "got svc.ListTransactions() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, res.Transactions[0].UUID, tx.UUID().String(), "got transaction %q, want %q")
+
+		from := time.Now().Add(time.Hour).Format(time.RFC3339)
+		res, err = svc.ListTransactions(context.Background(), c.UUID().String(), listtransactions.Request{From: from})
+		h.MustNotErr(t, err, "got svc.ListTransactions() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Transactions), 0, "got %v transactions, want %v")
+
+		to := time.Now().Add(time.Hour).Format(time.RFC3339)
+		res, err = svc.ListTransactions(context.Background(), c.UUID().String(), listtransactions.Request{To: to})
+		h.MustNotErr(t, err, "got svc.ListTransactions() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.Transactions), 4, "got %v transactions, want %v")
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		r := mustRepository(t, 1)
+		_, err := listtransactions.New(r, r).ListTransactions(context.Background(), uuid.Must(uuid.NewV4()).String(), listtransactions.Request{})
+		h.MustErrorResponse(t, err, 404)
+	})
+	t.Run("returns 422 error response with all invalid parameters", func(t *testing.T) {
+		r := mustRepository(t, 1)
+		_, err := listtransactions.New(r, r).ListTransactions(context.Background(), r.Card.UUID().String(), listtransactions.Request{
+			Cursor: "foo",
+			Limit:  "0",
+			From:   "yesterday",
+			To:     "today",
+		})
+		res := h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(res.InvalidParameters), 4, "got %v invalid parameters, want %v")
+	})
+	t.Run("returns error if lister returns error", func(t *testing.T) {
+		r := mustRepository(t, 1)
+		_, err := listtransactions.New(r, &h.Repository{Err: errors.New("test lister failed")}).ListTransactions(context.Background(), r.Card.UUID().String(), listtransactions.Request{})
+		h.MustErr(t, err, "got svc.ListTransactions() = listtransactions.Response, nil, want listtransactions.Response, error")
+	})
+}
+
+func TestParseCursor(t *testing.T) {
+	c := listtransactions.Cursor{Date: time.Now().UTC(), UUID: uuid.Must(uuid.NewV4())}
+	res, err := listtransactions.ParseCursor(c.String())
+	h.MustNotErr(t, err, "got ParseCursor() error %v, want nil")
+	h.Must(t, res.Date.Equal(c.Date), "got date %v, want %v", res.Date, c.Date)
+	h.MustE(t, res.UUID, c.UUID, "got UUID %v, want %v")
+}
+
+// mustRepository returns repository with card, which has n load transactions.
+func mustRepository(t *testing.T, n int) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	r := &h.Repository{Card: c}
+	for i := 0; i < n; i++ {
+		h.MustNotErr(t, c.LoadMoney(10), "c.LoadMoney() %v; want nil")
+		tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "CardLoaded", 10, "", time.Now())
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, r.SaveCardTransaction(context.Background(), c, tx), "%v")
+		time.Sleep(time.Millisecond)
+	}
+	return r
+}
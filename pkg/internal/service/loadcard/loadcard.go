@@ -0,0 +1,126 @@
+package loadcard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Request is the request for loading money onto a card.
+type Request struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Response is the response, which Service returns when a card is successfully loaded.
+type Response struct {
+	UUID string `json:"uuid"`
+	// ETag is the entity tag of the loaded card.
+	ETag string `json:"-"`
+}
+
+// Service is the service loading money onto cards.
+type Service struct {
+	uow    service.UnitOfWork
+	limits model.ProductLimits
+
+	// Now returns the current time, at which the limits of the cards are checked and the transactions are recorded.
+	Now func() time.Time
+}
+
+// New returns new service loading money onto cards, which saves the changes with unit of work u.
+// The loads, which exceed the limits of the cards with the default limits l of their products, are declined.
+func New(u service.UnitOfWork, l model.ProductLimits) *Service {
+	return &Service{uow: u, limits: l, Now: time.Now}
+}
+
+// LoadCard loads the amount of req onto the card with UUID id and returns
+// the transaction UUID.
+// The card is loaded only if it matches the If-Match header value ifMatch, which is ignored if empty.
+// It returns 404 service.ErrorResponse if the card does not exist,
+// 422 service.ErrorResponse if the card cannot be loaded with the amount, the amount exceeds a limit of the card or
+// the currency of req is not the currency of the card,
+// 412 service.ErrorResponse if the card does not match ifMatch and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) LoadCard(ctx context.Context, id, ifMatch string, req Request) (Response, error) {
+	cardUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	v := &validation.Validator{}
+	money := v.Money("amount", "currency", req.Amount, req.Currency)
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err = service.Retry(func() error {
+		var err error
+		res, err = svc.loadCard(ctx, cardUUID, ifMatch, money)
+		return err
+	})
+	return res, err
+}
+
+// loadCard is LoadCard without retries.
+func (svc *Service) loadCard(ctx context.Context, cardUUID uuid.UUID, ifMatch string, money model.Money) (Response, error) {
+	var res Response
+	err := svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		card, err := r.GetCard(ctx, cardUUID)
+		if err == service.ErrNotFound {
+			return service.NewNotFoundErrorResponse()
+		}
+		if err != nil {
+			return service.Wrap(err, "LoadCard() cannot get card")
+		}
+		if err := service.CheckIfMatch(ifMatch, card.Version()); err != nil {
+			return err
+		}
+		if money.Currency() != card.Currency() {
+			return service.NewCurrencyMismatchErrorResponse(card.Currency())
+		}
+		amount := money.Amount()
+		now := svc.Now()
+		err = service.CheckLimits(ctx, r, card.UUID(), svc.limits, now, func(l model.Limits, txs []*model.Transaction) error {
+			return l.CheckLoad(txs, amount, now)
+		})
+		if err != nil {
+			return err
+		}
+		if err := card.LoadMoney(amount); err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("LoadCard() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, nil, eventUUID, event.TypeCardLoaded, amount, "Card load", now)
+		if err != nil {
+			return fmt.Errorf("LoadCard() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "LoadCard() cannot persist card")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "LoadCard() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.CardLoaded{
+			UUID:     eventUUID,
+			Time:     tx.Date(),
+			CardUUID: card.UUID(),
+			Amount:   amount,
+		})
+		if err != nil {
+			return service.Wrap(err, "LoadCard() cannot persist event")
+		}
+		res = Response{UUID: tx.UUID().String(), ETag: service.ETag(card.Version())}
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,128 @@
+// +build !integration
+
+package loadcard_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/loadcard"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_LoadCard(t *testing.T) {
+	t.Run("loads the card, saves the transaction and the event", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c}
+		svc := loadcard.New(r, nil)
+		res, err := svc.LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "1950", Currency: "GBP"})
+		h.MustNotErr(t, err, "got svc.LoadCard() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(1950), "got available balance %v, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		tx := r.Transactions[0]
+		h.MustE(t, res.UUID, tx.UUID().String(), "got response UUID %q != transaction UUID %q, want them equal")
+		h.MustE(t, res.ETag, service.ETag(1), "got response ETag %s, want %s")
+		h.MustE(t, tx.EventType(), event.TypeCardLoaded, "got transaction event type %q, want %q")
+		e := savedEvent(t, r)
+		h.MustE(t, tx.EventUUID(), e.UUID, "got transaction event UUID %v != saved event UUID %v, want them equal")
+		h.MustE(t, tx.AvailableBalance(), uint64(1950), "got transaction available balance %v, want %v")
+		h.MustE(t, e.CardUUID, c.UUID(), "got saved event card UUID %v, want %v")
+		h.MustE(t, e.Amount, uint64(1950), "got saved event amount %v, want %v")
+	})
+	t.Run("records the transaction and the event at the time of the service", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c}
+		now := time.Date(2019, time.March, 15, 23, 59, 0, 0, time.UTC)
+		svc := loadcard.New(r, nil)
+		svc.Now = func() time.Time { return now }
+		_, err = svc.LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "1", Currency: "GBP"})
+		h.MustNotErr(t, err, "got svc.LoadCard() error %v, want nil")
+		h.Must(t, r.Transactions[0].Date().Equal(now), "got transaction date %v, want %v", r.Transactions[0].Date(), now)
+		h.Must(t, savedEvent(t, r).Time.Equal(now), "got saved event time %v, want %v", savedEvent(t, r).Time, now)
+	})
+	t.Run("returns 412 error response if the card does not match If-Match", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c}
+		_, err = loadcard.New(r, nil).LoadCard(context.Background(), c.UUID().String(), `"1"`, loadcard.Request{Amount: "1", Currency: "GBP"})
+		h.MustErrorResponse(t, err, 412)
+		h.MustE(t, c.AvailableBalance(), uint64(0), "got available balance %v, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+	})
+	t.Run("returns 404 error response if the card does not exist", func(t *testing.T) {
+		svc := loadcard.New(&h.Repository{}, nil)
+		_, err := svc.LoadCard(context.Background(), uuid.Must(uuid.NewV4()).String(), "", loadcard.Request{Amount: "1", Currency: "GBP"})
+		h.MustErrorResponse(t, err, 404)
+	})
+	t.Run("returns 422 error response if the amount is invalid", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c}
+		for _, a := range []string{"", "-1", "1.5", "foo", "18446744073709551616"} {
+			_, err := loadcard.New(r, nil).LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: a, Currency: "GBP"})
+			h.MustErrorResponse(t, err, 422)
+		}
+	})
+	t.Run("returns 422 error response if the currency is invalid", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c}
+		for _, cur := range []string{"", "XXX", "EUR"} {
+			_, err := loadcard.New(r, nil).LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "1", Currency: cur})
+			h.MustErrorResponse(t, err, 422)
+		}
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+	})
+	t.Run("returns 422 error response if the card cannot be loaded", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, c.LoadMoney(math.MaxUint64), "c.LoadMoney(math.MaxUint64) %v; want nil")
+		r := &h.Repository{Card: c}
+		_, err = loadcard.New(r, nil).LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "1", Currency: "GBP"})
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+	t.Run("returns 422 error response if the load exceeds the daily load limit of the card", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c}
+		svc := loadcard.New(r, model.ProductLimits{model.DefaultProduct: {DailyLoad: 100}})
+		_, err = svc.LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "60", Currency: "GBP"})
+		h.MustNotErr(t, err, "got svc.LoadCard() error %v, want nil")
+		_, err = svc.LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "41", Currency: "GBP"})
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, err.(service.ErrorResponse).Extensions["limit"], model.LimitDailyLoad, "got limit %v, want %v")
+		h.MustE(t, r.Card.AvailableBalance(), uint64(60), "got available balance %v, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c, CommitErr: errors.New("test commit failed")}
+		_, err = loadcard.New(r, nil).LoadCard(context.Background(), c.UUID().String(), "", loadcard.Request{Amount: "1", Currency: "GBP"})
+		h.MustErr(t, err, "got svc.LoadCard() = loadcard.Response, nil, want loadcard.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), uint64(0), "got available balance %v, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) event.CardLoaded {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	e, ok := r.Events[0].(event.CardLoaded)
+	h.Must(t, ok, "got saved event %T, want event.CardLoaded", r.Events[0])
+	return e
+}
@@ -0,0 +1,191 @@
+package merchant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// The maximum lengths of the details of the merchants.
+const (
+	MaxNameLength              = 255
+	MaxSettlementAccountLength = 64
+)
+
+// Request is the request for registration or update of a merchant.
+type Request struct {
+	Name string `json:"name"`
+	// Category is the merchant category code, e.g. "7011" for hotels.
+	Category string `json:"category"`
+	// Country is the ISO 3166-1 alpha-2 country code.
+	Country string `json:"country"`
+	// SettlementAccount is the account, to which the payments of the merchant are settled, e.g. IBAN.
+	SettlementAccount string `json:"settlementAccount"`
+	// Status is "active", "suspended" or "closed". New merchants are active and the status of
+	// the updated merchants is not changed if it is empty.
+	Status string `json:"status"`
+}
+
+// Response is the response, which Service returns with the details of a merchant.
+type Response struct {
+	UUID              string `json:"uuid"`
+	Name              string `json:"name"`
+	Category          string `json:"category"`
+	Country           string `json:"country"`
+	SettlementAccount string `json:"settlementAccount"`
+	Status            string `json:"status"`
+}
+
+// Service is the service registering, returning and updating merchants.
+type Service struct {
+	uow service.UnitOfWork
+}
+
+// New returns new service registering, returning and updating merchants, which saves the merchants with unit of work u.
+func New(u service.UnitOfWork) *Service {
+	return &Service{u}
+}
+
+// CreateMerchant registers the merchant of req and returns it.
+// It returns 422 service.ErrorResponse if the request is invalid.
+func (svc *Service) CreateMerchant(ctx context.Context, req Request) (Response, error) {
+	country, status, err := validate(req)
+	if err != nil {
+		return Response{}, err
+	}
+	m, err := model.NewMerchant(req.Name, req.Category, country, req.SettlementAccount)
+	if err != nil {
+		return Response{}, service.NewValidationErrorResponse(err.Error())
+	}
+	if status != "" {
+		if err := m.SetStatus(status); err != nil {
+			return Response{}, service.NewValidationErrorResponse(err.Error())
+		}
+	}
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		if err := r.SaveMerchant(ctx, m); err != nil {
+			return service.Wrap(err, "CreateMerchant() cannot persist merchant")
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return newResponse(m), nil
+}
+
+// GetMerchant returns the merchant with UUID id.
+// It returns 404 service.ErrorResponse if the merchant does not exist.
+func (svc *Service) GetMerchant(ctx context.Context, id string) (Response, error) {
+	merchantUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	var res Response
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		m, err := getMerchant(ctx, r, merchantUUID)
+		if err != nil {
+			return err
+		}
+		res = newResponse(m)
+		return nil
+	})
+	return res, err
+}
+
+// UpdateMerchant replaces the details of the merchant with UUID id with the details of req and returns it.
+// It returns 404 service.ErrorResponse if the merchant does not exist and
+// 422 service.ErrorResponse if the request is invalid or the merchant is closed.
+func (svc *Service) UpdateMerchant(ctx context.Context, id string, req Request) (Response, error) {
+	merchantUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	country, status, err := validate(req)
+	if err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err = svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		m, err := getMerchant(ctx, r, merchantUUID)
+		if err != nil {
+			return err
+		}
+		if err := m.Update(req.Name, req.Category, country, req.SettlementAccount); err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		if status != "" {
+			if err := m.SetStatus(status); err != nil {
+				return service.NewValidationErrorResponse(err.Error())
+			}
+		}
+		if err := r.SaveMerchant(ctx, m); err != nil {
+			return service.Wrap(err, "UpdateMerchant() cannot persist merchant")
+		}
+		res = newResponse(m)
+		return nil
+	})
+	return res, err
+}
+
+// validate returns the uppercase country code and the status of req, which is empty if it is not set.
+// It returns 422 service.ErrorResponse with all invalid parameters of req.
+func validate(req Request) (string, model.MerchantStatus, error) {
+	v := &validation.Validator{}
+	switch {
+	case req.Name == "":
+		v.Invalid("name", "must not be empty")
+	case len(req.Name) > MaxNameLength:
+		v.Invalid("name", fmt.Sprintf("must be at most %d characters", MaxNameLength))
+	}
+	if !model.IsMerchantCategory(req.Category) {
+		v.Invalid("category", "must be 4-digit merchant category code")
+	}
+	country := strings.ToUpper(req.Country)
+	if !model.IsCountry(country) {
+		v.Invalid("country", "must be ISO 3166-1 alpha-2 code")
+	}
+	switch {
+	case req.SettlementAccount == "":
+		v.Invalid("settlementAccount", "must not be empty")
+	case len(req.SettlementAccount) > MaxSettlementAccountLength:
+		v.Invalid("settlementAccount", fmt.Sprintf("must be at most %d characters", MaxSettlementAccountLength))
+	}
+	var status model.MerchantStatus
+	if req.Status != "" {
+		var err error
+		if status, err = model.ParseMerchantStatus(req.Status); err != nil {
+			v.Invalid("status", "must be active, suspended or closed")
+		}
+	}
+	return country, status, v.Err("The request body is invalid.")
+}
+
+// getMerchant returns the merchant with UUID id or 404 service.ErrorResponse if it does not exist.
+func getMerchant(ctx context.Context, r service.Tx, id uuid.UUID) (*model.Merchant, error) {
+	m, err := r.GetMerchant(ctx, id)
+	if err == service.ErrNotFound {
+		return nil, service.NewNotFoundErrorResponse()
+	}
+	if err != nil {
+		return nil, service.Wrap(err, "cannot get merchant")
+	}
+	return m, nil
+}
+
+func newResponse(m *model.Merchant) Response {
+	return Response{
+		UUID:              m.UUID().String(),
+		Name:              m.Name(),
+		Category:          m.Category(),
+		Country:           m.Country(),
+		SettlementAccount: m.SettlementAccount(),
+		Status:            string(m.Status()),
+	}
+}
@@ -0,0 +1,110 @@
+// +build !integration
+
+package merchant_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/merchant"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+var hotel = merchant.Request{Name: "Grand Hotel", Category: "7011", Country: "gb", SettlementAccount: "GB29NWBK60161331926819"}
+
+func TestService_CreateMerchant(t *testing.T) {
+	t.Run("saves active merchant", func(t *testing.T) {
+		r := &h.Repository{}
+		res, err := merchant.New(r).CreateMerchant(context.Background(), hotel)
+		h.MustNotErr(t, err, "got svc.CreateMerchant() = %T, %#v, want nil", res)
+		h.Must(t, r.Merchant != nil, "got no saved merchant, want one")
+		h.MustE(t, res.UUID, r.Merchant.UUID().String(), "got response UUID %q, want %q")
+		h.MustE(t, res.Country, "GB", "got response country %q, want %q")
+		h.MustE(t, res.Status, "active", "got response status %q, want %q")
+		h.MustE(t, r.Merchant.Category(), "7011", "got saved category %q, want %q")
+	})
+	t.Run("saves merchant with the status of the request", func(t *testing.T) {
+		r := &h.Repository{}
+		req := hotel
+		req.Status = "suspended"
+		_, err := merchant.New(r).CreateMerchant(context.Background(), req)
+		h.MustNotErr(t, err, "got svc.CreateMerchant() error %v, want nil")
+		h.MustE(t, r.Merchant.Status(), model.MerchantSuspended, "got saved status %v, want %v")
+	})
+	t.Run("returns 422 error response with all invalid parameters", func(t *testing.T) {
+		r := &h.Repository{}
+		req := merchant.Request{Category: "hotel", Country: "GBR", Status: "deleted"}
+		_, err := merchant.New(r).CreateMerchant(context.Background(), req)
+		res := h.MustErrorResponse(t, err, 422)
+		h.MustE(t, len(res.InvalidParameters), 5, "got %d invalid parameters, want %d")
+		for i, name := range []string{"name", "category", "country", "settlementAccount", "status"} {
+			h.MustE(t, res.InvalidParameters[i].Name, name, "got invalid parameter %q, want %q")
+		}
+		h.Must(t, r.Merchant == nil, "got saved merchant, want none")
+	})
+	t.Run("does not save the merchant if the transaction cannot be committed", func(t *testing.T) {
+		r := &h.Repository{CommitErr: errors.New("test commit failed")}
+		_, err := merchant.New(r).CreateMerchant(context.Background(), hotel)
+		h.MustErr(t, err, "got svc.CreateMerchant() error nil, want error")
+		h.Must(t, r.Merchant == nil, "got saved merchant, want none")
+	})
+}
+
+func TestService_GetMerchant(t *testing.T) {
+	t.Run("returns the merchant", func(t *testing.T) {
+		r := mustRepository(t)
+		res, err := merchant.New(r).GetMerchant(context.Background(), r.Merchant.UUID().String())
+		h.MustNotErr(t, err, "got svc.GetMerchant() = %T, %#v, want nil", res)
+		h.MustE(t, res.Name, "Grand Hotel", "got name %q, want %q")
+	})
+	t.Run("returns 404 error response if the merchant does not exist", func(t *testing.T) {
+		for _, id := range []string{"foo", uuid.Must(uuid.NewV4()).String()} {
+			_, err := merchant.New(mustRepository(t)).GetMerchant(context.Background(), id)
+			h.MustErrorResponse(t, err, 404)
+		}
+	})
+}
+
+func TestService_UpdateMerchant(t *testing.T) {
+	t.Run("saves the details and the status of the merchant", func(t *testing.T) {
+		r := mustRepository(t)
+		req := merchant.Request{Name: "Car Rental", Category: "7512", Country: "IE", SettlementAccount: "IE29AIBK93115212345678", Status: "suspended"}
+		res, err := merchant.New(r).UpdateMerchant(context.Background(), r.Merchant.UUID().String(), req)
+		h.MustNotErr(t, err, "got svc.UpdateMerchant() = %T, %#v, want nil", res)
+		h.MustE(t, r.Merchant.Name(), "Car Rental", "got saved name %q, want %q")
+		h.MustE(t, r.Merchant.Category(), "7512", "got saved category %q, want %q")
+		h.MustE(t, r.Merchant.Status(), model.MerchantSuspended, "got saved status %v, want %v")
+		h.MustE(t, res.Status, "suspended", "got response status %q, want %q")
+	})
+	t.Run("keeps the status of the merchant if the status is empty", func(t *testing.T) {
+		r := mustRepository(t)
+		h.MustNotErr(t, r.Merchant.SetStatus(model.MerchantSuspended), "%v")
+		_, err := merchant.New(r).UpdateMerchant(context.Background(), r.Merchant.UUID().String(), hotel)
+		h.MustNotErr(t, err, "got svc.UpdateMerchant() error %v, want nil")
+		h.MustE(t, r.Merchant.Status(), model.MerchantSuspended, "got saved status %v, want %v")
+	})
+	t.Run("returns 422 error response if the merchant is closed", func(t *testing.T) {
+		r := mustRepository(t)
+		h.MustNotErr(t, r.Merchant.SetStatus(model.MerchantClosed), "%v")
+		req := hotel
+		req.Status = "active"
+		_, err := merchant.New(r).UpdateMerchant(context.Background(), r.Merchant.UUID().String(), req)
+		h.MustErrorResponse(t, err, 422)
+		h.MustE(t, r.Merchant.Status(), model.MerchantClosed, "got saved status %v, want %v")
+	})
+	t.Run("returns 404 error response if the merchant does not exist", func(t *testing.T) {
+		_, err := merchant.New(mustRepository(t)).UpdateMerchant(context.Background(), uuid.Must(uuid.NewV4()).String(), hotel)
+		h.MustErrorResponse(t, err, 404)
+	})
+}
+
+func mustRepository(t *testing.T) *h.Repository {
+	t.Helper()
+	m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Merchant: m}
+}
@@ -0,0 +1,121 @@
+package refund
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Request is the request for refunding a captured transaction.
+type Request struct {
+	Amount string `json:"amount"`
+}
+
+// Response is the response, which Service returns when a transaction is refunded.
+type Response service.AuthorizationRequest
+
+// Service is the service refunding captured transactions.
+type Service struct {
+	uow service.UnitOfWork
+
+	// Now returns the current time, at which the authorization requests are refunded and the transactions are recorded.
+	Now func() time.Time
+}
+
+// New returns new service refunding captured transactions, which saves the changes with unit of work u.
+func New(u service.UnitOfWork) *Service {
+	return &Service{uow: u, Now: time.Now}
+}
+
+// Refund returns the amount of req from the authorization request with UUID id to the card.
+// It returns 404 service.ErrorResponse if the authorization request does not exist,
+// 422 service.ErrorResponse if the amount cannot be refunded and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Refund(ctx context.Context, id string, req Request) (Response, error) {
+	authReqUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	v := &validation.Validator{}
+	amount := v.Amount("amount", req.Amount)
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err = service.Retry(func() error {
+		var err error
+		res, err = svc.refund(ctx, authReqUUID, amount)
+		return err
+	})
+	return res, err
+}
+
+// refund is Refund without retries.
+func (svc *Service) refund(ctx context.Context, authReqUUID uuid.UUID, amount uint64) (Response, error) {
+	var authReq *model.AuthorizationRequest
+	err := svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		var err error
+		authReq, err = r.GetAuthorizationRequest(ctx, authReqUUID)
+		if err == service.ErrNotFound {
+			return service.NewNotFoundErrorResponse()
+		}
+		if err != nil {
+			return service.Wrap(err, "Refund() cannot get authorization request")
+		}
+		card, err := r.GetCard(ctx, authReq.CardUUID())
+		if err != nil {
+			return service.Wrap(err, "Refund() cannot get card")
+		}
+		refunded := authReq.RefundedAmount()
+		now := svc.Now()
+		if err := authReq.Refund(card, amount, now); err != nil {
+			return service.NewValidationErrorResponse(err.Error())
+		}
+		converted := authReq.RefundedAmount() - refunded
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Refund() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, authReq, eventUUID, event.TypeAuthorizationRequestRefunded, converted, "Authorization refund", now)
+		if err != nil {
+			return fmt.Errorf("Refund() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "Refund() cannot persist card")
+		}
+		if err := r.SaveAuthorizationRequest(ctx, authReq); err != nil {
+			return service.Wrap(err, "Refund() cannot persist authorization request")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "Refund() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.AuthorizationRequestRefunded{
+			UUID:                     eventUUID,
+			Time:                     tx.Date(),
+			AuthorizationRequestUUID: authReq.UUID(),
+			CardUUID:                 card.UUID(),
+			MerchantUUID:             authReq.MerchantUUID(),
+			Amount:                   converted,
+			OriginalAmount:           amount,
+			Currency:                 string(authReq.Currency()),
+			CardCurrency:             string(card.Currency()),
+			Rate:                     authReq.Rate().Rate(),
+			ExpiresAt:                authReq.ExpiresAt(),
+		})
+		if err != nil {
+			return service.Wrap(err, "Refund() cannot persist event")
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return Response(service.NewAuthorizationRequest(authReq)), nil
+}
@@ -0,0 +1,83 @@
+// +build !integration
+
+package refund_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/refund"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Refund(t *testing.T) {
+	t.Run("refunds the amount, saves the authorization request and its event", func(t *testing.T) {
+		r := mustRepository(t, 100, 70, 50)
+		res, err := refund.New(r).Refund(context.Background(), r.AuthorizationRequest.UUID().String(), refund.Request{Amount: "40"})
+		h.MustNotErr(t, err, "got svc.Refund() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(70), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(20), "got blocked balance %v, want %v")
+		h.MustE(t, res.CapturedAmount, "50", "got response captured amount %q, want %q")
+		h.MustE(t, res.RefundedAmount, "40", "got response refunded amount %q, want %q")
+		h.MustE(t, len(res.History), 3, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, r.Transactions[0].EventType(), event.TypeAuthorizationRequestRefunded, "got transaction event type %q, want %q")
+		h.MustE(t, r.Transactions[0].AvailableBalance(), uint64(70), "got transaction available balance %v, want %v")
+		h.MustE(t, savedEvent(t, r).UUID, r.Transactions[0].EventUUID(), "got saved event event UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).AuthorizationRequestUUID, r.AuthorizationRequest.UUID(), "got saved event authorization request UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(40), "got saved event amount %v, want %v")
+	})
+	t.Run("returns 404 error response if the authorization request does not exist", func(t *testing.T) {
+		r := mustRepository(t, 100, 70, 50)
+		_, err := refund.New(r).Refund(context.Background(), uuid.Must(uuid.NewV4()).String(), refund.Request{Amount: "1"})
+		h.MustErrorResponse(t, err, 404)
+	})
+	t.Run("returns 422 error response if the amount cannot be refunded", func(t *testing.T) {
+		for _, a := range []string{"foo", "0", "51"} {
+			r := mustRepository(t, 100, 70, 50)
+			_, err := refund.New(r).Refund(context.Background(), r.AuthorizationRequest.UUID().String(), refund.Request{Amount: a})
+			h.MustErrorResponse(t, err, 422)
+			h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+			h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+		}
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		r := mustRepository(t, 100, 70, 50)
+		r.CommitErr = errors.New("test commit failed")
+		available, blocked := r.Card.AvailableBalance(), r.Card.BlockedBalance()
+		history := len(r.AuthorizationRequest.History())
+		_, err := refund.New(r).Refund(context.Background(), r.AuthorizationRequest.UUID().String(), refund.Request{Amount: "1"})
+		h.MustErr(t, err, "got svc.Refund() = refund.Response, nil, want refund.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), available, "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), blocked, "got blocked balance %v, want %v")
+		h.MustE(t, len(r.AuthorizationRequest.History()), history, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func mustRepository(t *testing.T, l, b, c uint64) *h.Repository {
+	t.Helper()
+	card, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, card.LoadMoney(l), "card.LoadMoney() %v; want nil")
+	req, err := model.NewAuthorizationRequest(card, uuid.Must(uuid.NewV4()), model.NewMoney(b, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, req.Capture(card, c, time.Now()), "req.Capture() %v; want nil")
+	return &h.Repository{Card: card, AuthorizationRequest: req}
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) event.AuthorizationRequestRefunded {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	e, ok := r.Events[0].(event.AuthorizationRequestRefunded)
+	h.Must(t, ok, "got saved event %T, want event.AuthorizationRequestRefunded", r.Events[0])
+	return e
+}
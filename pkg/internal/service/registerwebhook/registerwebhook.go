@@ -0,0 +1,99 @@
+package registerwebhook
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+)
+
+// MinSecretLength is the minimum length of the secrets of the webhooks.
+const MinSecretLength = 16
+
+// Request is the request for registration of a webhook sent by a merchant.
+type Request struct {
+	MerchantUUID string `json:"merchantUUID"`
+	URL          string `json:"url"`
+	// Secret is the key of the signatures of the deliveries. It is not returned in the responses.
+	Secret string `json:"secret"`
+	// EventTypes are the types of the delivered events. All webhook.EventTypes are delivered if empty.
+	EventTypes []string `json:"eventTypes"`
+}
+
+// Response is the response, which Service returns when a webhook is registered.
+type Response struct {
+	UUID         string   `json:"uuid"`
+	MerchantUUID string   `json:"merchantUUID"`
+	URL          string   `json:"url"`
+	EventTypes   []string `json:"eventTypes"`
+	CreatedAt    string   `json:"createdAt"`
+}
+
+// Service is the service registering webhooks of merchants.
+type Service struct {
+	saver Saver
+}
+
+// New returns new service registering webhooks of merchants, which saves the webhooks with s.
+func New(s Saver) *Service {
+	return &Service{s}
+}
+
+// RegisterWebhook registers the webhook of req and returns it.
+// It returns 422 service.ErrorResponse if the request is invalid.
+func (svc *Service) RegisterWebhook(ctx context.Context, req Request) (Response, error) {
+	v := &validation.Validator{}
+	merchantUUID := v.UUID("merchantUUID", req.MerchantUUID)
+	if u, err := url.Parse(req.URL); err != nil || u.Scheme != "https" || len(u.Hostname()) == 0 {
+		v.Invalid("url", "must be absolute HTTPS URL")
+	}
+	if len(req.Secret) < MinSecretLength {
+		v.Invalid("secret", fmt.Sprintf("must be at least %d characters", MinSecretLength))
+	}
+	for i, t := range req.EventTypes {
+		if !webhook.IsEventType(t) {
+			v.Invalid(fmt.Sprintf("eventTypes[%d]", i), "must be one of "+strings.Join(webhook.EventTypes, ", "))
+		}
+	}
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return Response{}, fmt.Errorf("RegisterWebhook() cannot generate UUID; %v", err)
+	}
+	w := webhook.Webhook{
+		UUID:         id,
+		MerchantUUID: merchantUUID,
+		URL:          req.URL,
+		Secret:       req.Secret,
+		EventTypes:   req.EventTypes,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := svc.saver.SaveWebhook(ctx, w); err != nil {
+		return Response{}, service.Wrap(err, "RegisterWebhook() cannot save webhook")
+	}
+	eventTypes := w.EventTypes
+	if len(eventTypes) == 0 {
+		eventTypes = webhook.EventTypes
+	}
+	return Response{
+		UUID:         w.UUID.String(),
+		MerchantUUID: w.MerchantUUID.String(),
+		URL:          w.URL,
+		EventTypes:   eventTypes,
+		CreatedAt:    w.CreatedAt.Format(time.RFC3339),
+	}, nil
+}
+
+// Saver is interface for persistence of webhooks.
+type Saver interface {
+	SaveWebhook(context.Context, webhook.Webhook) error
+}
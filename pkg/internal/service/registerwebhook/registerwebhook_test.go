@@ -0,0 +1,80 @@
+// +build !integration
+
+package registerwebhook_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/registerwebhook"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+)
+
+func TestService_RegisterWebhook(t *testing.T) {
+	merchant := uuid.Must(uuid.NewV4())
+	valid := registerwebhook.Request{
+		MerchantUUID: merchant.String(),
+		URL:          "https://example.com/webhook",
+		Secret:       "0123456789abcdef",
+		EventTypes:   []string{event.TypeAuthorizationRequestCaptured},
+	}
+
+	t.Run("saves the webhook and returns it without the secret", func(t *testing.T) {
+		r := &h.Repository{}
+		res, err := registerwebhook.New(r).RegisterWebhook(context.Background(), valid)
+		h.MustNotErr(t, err, "got svc.RegisterWebhook() = %T, %#v, want nil", res)
+		h.MustE(t, len(r.Webhooks), 1, "got %d saved webhooks, want %d")
+		w := r.Webhooks[0]
+		h.MustE(t, res.UUID, w.UUID.String(), "got response UUID %q, want %q")
+		h.MustE(t, w.MerchantUUID, merchant, "got merchant UUID %v, want %v")
+		h.MustE(t, w.URL, valid.URL, "got URL %q, want %q")
+		h.MustE(t, w.Secret, valid.Secret, "got secret %q, want %q")
+		h.MustE(t, len(res.EventTypes), 1, "got %d event types, want %d")
+		h.MustE(t, res.EventTypes[0], event.TypeAuthorizationRequestCaptured, "got event type %q, want %q")
+	})
+	t.Run("subscribes to all event types by default", func(t *testing.T) {
+		req := valid
+		req.EventTypes = nil
+		res, err := registerwebhook.New(&h.Repository{}).RegisterWebhook(context.Background(), req)
+		h.MustNotErr(t, err, "got svc.RegisterWebhook() = %T, %#v, want nil", res)
+		h.MustE(t, len(res.EventTypes), len(webhook.EventTypes), "got %d event types, want %d")
+	})
+	t.Run("returns 422 error response with the invalid parameters", func(t *testing.T) {
+		r := &h.Repository{}
+		_, err := registerwebhook.New(r).RegisterWebhook(context.Background(), registerwebhook.Request{
+			MerchantUUID: "foo",
+			URL:          "ftp://example.com",
+			Secret:       "short",
+			EventTypes:   []string{event.TypeCardCreated},
+		})
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.StatusCode(), 422, "got status code %#v, want %#v")
+		h.MustE(t, len(res.InvalidParameters), 4, "got %d invalid parameters, want %d")
+		h.MustE(t, res.InvalidParameters[3].Name, "eventTypes[0]", "got invalid parameter %q, want %q")
+		h.MustE(t, len(r.Webhooks), 0, "got %d saved webhooks, want %d")
+	})
+	t.Run("returns 422 error response if the URL is not absolute HTTPS URL", func(t *testing.T) {
+		for _, u := range []string{"http://example.com/webhook", "https:///webhook", "https://:443/webhook", "/webhook", "example.com"} {
+			r := &h.Repository{}
+			req := valid
+			req.URL = u
+			_, err := registerwebhook.New(r).RegisterWebhook(context.Background(), req)
+			res, ok := err.(service.ErrorResponse)
+			h.Must(t, ok, "got error %#v for URL %q, want service.ErrorResponse", err, u)
+			h.MustE(t, len(res.InvalidParameters), 1, "got %d invalid parameters, want %d")
+			h.MustE(t, res.InvalidParameters[0].Name, "url", "got invalid parameter %q, want %q")
+			h.MustE(t, len(r.Webhooks), 0, "got %d saved webhooks, want %d")
+		}
+	})
+	t.Run("returns error if saver returns error", func(t *testing.T) {
+		_, err := registerwebhook.New(&h.Repository{Err: errors.New("test saver failed")}).RegisterWebhook(context.Background(), valid)
+		h.MustErr(t, err, "got svc.RegisterWebhook() = registerwebhook.Response, nil, want registerwebhook.Response, error")
+	})
+}
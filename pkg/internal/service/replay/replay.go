@@ -0,0 +1,120 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/eventsource"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Response is the result of the replay.
+type Response struct {
+	// Cards is the number of the cards rebuilt from their events.
+	Cards int
+	// Changes are the cards, which differed from the cards rebuilt from their events and were replaced.
+	Changes []string
+	// Migrated are the cards, which had no events and were migrated to their streams.
+	Migrated []string
+	// Failures are the cards, which cannot be rebuilt from their events.
+	Failures []string
+}
+
+// OK reports whether all cards are rebuilt from their events.
+func (res Response) OK() bool {
+	return len(res.Failures) == 0
+}
+
+// Service is the service rebuilding the card projection from the events of the cards.
+type Service struct {
+	store Store
+}
+
+// New returns new service rebuilding the card projection of s.
+func New(s Store) *Service {
+	return &Service{s}
+}
+
+// Replay folds the events of each card and replaces the stored card if it differs from the folded card.
+// The cards, which streams cannot be folded, e.g. because an event does not match its hash, are reported
+// as failures in the response. The stored cards without events are migrated to their streams.
+func (svc *Service) Replay(ctx context.Context) (Response, error) {
+	ids, err := svc.store.ListAggregates(ctx, eventsource.AggregateCard)
+	if err != nil {
+		return Response{}, service.Wrap(err, "Replay() cannot list cards with events")
+	}
+	cards, err := svc.store.ListCards(ctx)
+	if err != nil {
+		return Response{}, service.Wrap(err, "Replay() cannot list cards")
+	}
+	stored := make(map[uuid.UUID]*model.Card, len(cards))
+	for _, c := range cards {
+		stored[c.UUID()] = c
+	}
+
+	res := Response{}
+	for _, id := range ids {
+		old, ok := stored[id]
+		delete(stored, id)
+		card, err := eventsource.LoadCard(ctx, svc.store, svc.store.EventKey(), id)
+		if err != nil {
+			res.Failures = append(res.Failures, fmt.Sprintf("card %s: %v", id, err))
+			continue
+		}
+		res.Cards++
+		change := ""
+		switch {
+		case !ok:
+			change = fmt.Sprintf("card %s: the card is missing", id)
+		case !equal(old, card):
+			change = fmt.Sprintf("card %s: %s, want %s", id, describe(old), describe(card))
+		default:
+			continue
+		}
+		if err := svc.store.ReplaceCard(ctx, card); err != nil {
+			return res, service.Wrap(err, "Replay() cannot replace card")
+		}
+		res.Changes = append(res.Changes, change)
+	}
+	for _, c := range cards {
+		if _, ok := stored[c.UUID()]; !ok {
+			continue
+		}
+		if err := eventsource.MigrateCard(ctx, svc.store, svc.store.EventKey(), c, time.Now()); err != nil {
+			return res, service.Wrap(err, "Replay() cannot migrate card")
+		}
+		res.Migrated = append(res.Migrated, fmt.Sprintf("card %s: the card has no events", c.UUID()))
+	}
+	return res, nil
+}
+
+// equal reports whether cards a and b have the same state.
+func equal(a, b *model.Card) bool {
+	return a.Currency() == b.Currency() &&
+		a.Status() == b.Status() &&
+		a.AvailableBalance() == b.AvailableBalance() &&
+		a.BlockedBalance() == b.BlockedBalance() &&
+		a.Version() == b.Version()
+}
+
+// describe returns the state of card c.
+func describe(c *model.Card) string {
+	return fmt.Sprintf("%s %s available %d blocked %d version %d", c.Status(), c.Currency(), c.AvailableBalance(), c.BlockedBalance(), c.Version())
+}
+
+// Store is interface for retrieval of the events of the cards and persistence of the card projection.
+type Store interface {
+	eventsource.Store
+	// ListAggregates returns the UUIDs of the aggregates of aggregateType, which have events.
+	ListAggregates(ctx context.Context, aggregateType string) ([]uuid.UUID, error)
+	// ListCards returns all stored cards.
+	ListCards(context.Context) ([]*model.Card, error)
+	// ReplaceCard saves card with its version, replacing the stored card if it exists.
+	ReplaceCard(context.Context, *model.Card) error
+	// EventKey returns the key of the hashes of the events and the signatures of the snapshots.
+	EventKey() []byte
+}
@@ -0,0 +1,101 @@
+// +build !integration
+
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/eventsource"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/replay"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Replay(t *testing.T) {
+	t.Run("replaces the card, which differs from its events", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c, Key: []byte("secret")}
+		mustAppend(t, r,
+			event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: c.UUID(), Currency: "GBP"},
+			event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: c.UUID(), Amount: 100},
+		)
+		res, err := replay.New(r).Replay(context.Background())
+		h.MustNotErr(t, err, "got Replay() error %v, want nil")
+		h.Must(t, res.OK(), "got failures %v, want none", res.Failures)
+		h.MustE(t, res.Cards, 1, "got %d replayed cards, want %d")
+		h.MustE(t, len(res.Changes), 1, "got %d changes, want %d")
+		h.Must(t, strings.Contains(res.Changes[0], c.UUID().String()), "got change %q, want the card UUID", res.Changes[0])
+		h.MustE(t, r.Card.AvailableBalance(), uint64(100), "got available balance %v, want %v")
+		h.MustE(t, r.Card.Version(), uint64(2), "got version %v, want %v")
+
+		res, err = replay.New(r).Replay(context.Background())
+		h.MustNotErr(t, err, "got Replay() error %v, want nil")
+		h.MustE(t, len(res.Changes), 0, "got %d changes of the replayed card, want %d")
+	})
+	t.Run("reports the cards, which cannot be rebuilt", func(t *testing.T) {
+		other := uuid.Must(uuid.NewV4())
+		r := &h.Repository{}
+		mustAppend(t, r,
+			event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: other, Currency: "GBP"},
+			event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), CardUUID: other, Amount: 100},
+		)
+		r.Records[1].Message.Payload = []byte(`{"amount":"1000"}`)
+		res, err := replay.New(r).Replay(context.Background())
+		h.MustNotErr(t, err, "got Replay() error %v, want nil")
+		h.Must(t, !res.OK(), "got no failures, want failures")
+		h.MustE(t, len(res.Failures), 1, "got %d failures, want %d")
+		h.Must(t, strings.Contains(res.Failures[0], other.String()), "got failure %q, want the tampered card", res.Failures[0])
+		h.Must(t, r.Card == nil, "got replaced card %v, want none", r.Card)
+	})
+	t.Run("reports the cards, which events are hashed with another key", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r := &h.Repository{Card: c, Key: []byte("secret")}
+		mustAppend(t, r, event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: c.UUID(), Currency: "GBP"})
+		r.Key = []byte("other secret")
+		res, err := replay.New(r).Replay(context.Background())
+		h.MustNotErr(t, err, "got Replay() error %v, want nil")
+		h.MustE(t, len(res.Failures), 1, "got %d failures, want %d")
+		h.MustE(t, len(res.Migrated), 0, "got %d migrated cards, want %d")
+	})
+	t.Run("migrates the cards, which have no events", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, c.LoadMoney(100), "%v")
+		c.Saved()
+		r := &h.Repository{Card: c}
+		res, err := replay.New(r).Replay(context.Background())
+		h.MustNotErr(t, err, "got Replay() error %v, want nil")
+		h.Must(t, res.OK(), "got failures %v, want none", res.Failures)
+		h.MustE(t, len(res.Migrated), 1, "got %d migrated cards, want %d")
+		h.Must(t, strings.Contains(res.Migrated[0], c.UUID().String()), "got migrated card %q, want the card UUID", res.Migrated[0])
+
+		res, err = replay.New(r).Replay(context.Background())
+		h.MustNotErr(t, err, "got Replay() error %v, want nil")
+		h.MustE(t, res.Cards, 1, "got %d replayed cards, want %d")
+		h.MustE(t, len(res.Migrated), 0, "got %d migrated cards of the replayed card, want %d")
+		h.MustE(t, len(res.Changes), 0, "got %d changes of the migrated card, want %d")
+	})
+	t.Run("returns error if the store returns error", func(t *testing.T) {
+		r := &h.Repository{Err: errors.New("test store failed")}
+		_, err := replay.New(r).Replay(context.Background())
+		h.MustErr(t, err, "got Replay() nil error, want error")
+	})
+}
+
+func mustAppend(t *testing.T, r *h.Repository, events ...interface{}) {
+	t.Helper()
+	for _, e := range events {
+		m, err := event.Marshal(e)
+		h.MustNotErr(t, err, "got Marshal() error %v, want nil")
+		_, err = eventsource.Append(context.Background(), r, r.Key, m)
+		h.MustNotErr(t, err, "got Append() error %v, want nil")
+	}
+}
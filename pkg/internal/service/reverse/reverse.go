@@ -0,0 +1,124 @@
+package reverse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+// Request is the request for reversing an authorization request.
+type Request struct {
+	Amount string `json:"amount"`
+}
+
+// Response is the response, which Service returns when an authorization request is reversed.
+type Response service.AuthorizationRequest
+
+// Service is the service reversing authorization requests.
+type Service struct {
+	uow service.UnitOfWork
+
+	// Now returns the current time, at which the authorization requests are reversed and the transactions are recorded.
+	Now func() time.Time
+}
+
+// New returns new service reversing authorization requests, which saves the changes with unit of work u.
+func New(u service.UnitOfWork) *Service {
+	return &Service{uow: u, Now: time.Now}
+}
+
+// Reverse releases the amount of req from the blocked amount of the authorization request with UUID id.
+// It returns 404 service.ErrorResponse if the authorization request does not exist,
+// 422 service.ErrorResponse if the amount cannot be reversed and
+// service.ErrConcurrentModification if the card is still changed concurrently after the retries.
+func (svc *Service) Reverse(ctx context.Context, id string, req Request) (Response, error) {
+	authReqUUID, err := uuid.FromString(id)
+	if err != nil {
+		return Response{}, service.NewNotFoundErrorResponse()
+	}
+	v := &validation.Validator{}
+	amount := v.Amount("amount", req.Amount)
+	if err := v.Err("The request body is invalid."); err != nil {
+		return Response{}, err
+	}
+	var res Response
+	err = service.Retry(func() error {
+		var err error
+		res, err = svc.reverse(ctx, authReqUUID, amount)
+		return err
+	})
+	return res, err
+}
+
+// reverse is Reverse without retries.
+func (svc *Service) reverse(ctx context.Context, authReqUUID uuid.UUID, amount uint64) (Response, error) {
+	var authReq *model.AuthorizationRequest
+	err := svc.uow.WithinTx(ctx, func(r service.Tx) error {
+		var err error
+		authReq, err = r.GetAuthorizationRequest(ctx, authReqUUID)
+		if err == service.ErrNotFound {
+			return service.NewNotFoundErrorResponse()
+		}
+		if err != nil {
+			return service.Wrap(err, "Reverse() cannot get authorization request")
+		}
+		card, err := r.GetCard(ctx, authReq.CardUUID())
+		if err != nil {
+			return service.Wrap(err, "Reverse() cannot get card")
+		}
+		blocked := authReq.BlockedAmount()
+		now := svc.Now()
+		if err := authReq.Reverse(card, amount, now); err != nil {
+			return service.NewValidationErrorResponse(
+				"The authorization request cannot be reversed.",
+				service.InvalidParameter{Name: "amount", Reason: err.Error()},
+			)
+		}
+		converted := blocked - authReq.BlockedAmount()
+		eventUUID, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Reverse() cannot generate identifier; %v", err)
+		}
+		tx, err := model.NewTransaction(card, authReq, eventUUID, event.TypeAuthorizationRequestReversed, converted, "Authorization reversal", now)
+		if err != nil {
+			return fmt.Errorf("Reverse() cannot create transaction; %v", err)
+		}
+		if err := r.UpdateCard(ctx, card); err != nil {
+			return service.Wrap(err, "Reverse() cannot persist card")
+		}
+		if err := r.SaveAuthorizationRequest(ctx, authReq); err != nil {
+			return service.Wrap(err, "Reverse() cannot persist authorization request")
+		}
+		if err := r.SaveTransaction(ctx, tx); err != nil {
+			return service.Wrap(err, "Reverse() cannot persist transaction")
+		}
+		err = r.SaveEvent(ctx, event.AuthorizationRequestReversed{
+			UUID:                     eventUUID,
+			Time:                     tx.Date(),
+			AuthorizationRequestUUID: authReq.UUID(),
+			CardUUID:                 card.UUID(),
+			MerchantUUID:             authReq.MerchantUUID(),
+			Amount:                   converted,
+			OriginalAmount:           amount,
+			Currency:                 string(authReq.Currency()),
+			CardCurrency:             string(card.Currency()),
+			Rate:                     authReq.Rate().Rate(),
+			ExpiresAt:                authReq.ExpiresAt(),
+		})
+		if err != nil {
+			return service.Wrap(err, "Reverse() cannot persist event")
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return Response(service.NewAuthorizationRequest(authReq)), nil
+}
@@ -0,0 +1,106 @@
+// +build !integration
+
+package reverse_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/reverse"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_Reverse(t *testing.T) {
+	t.Run("releases the amount, saves the authorization request and its event", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		res, err := reverse.New(r).Reverse(context.Background(), r.AuthorizationRequest.UUID().String(), reverse.Request{Amount: "50"})
+		h.MustNotErr(t, err, "got svc.Reverse() = %T, %#v, want nil", res)
+		h.MustE(t, r.Card.AvailableBalance(), uint64(80), "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), uint64(20), "got blocked balance %v, want %v")
+		h.MustE(t, res.BlockedAmount, "20", "got response blocked amount %q, want %q")
+		h.MustE(t, len(res.History), 2, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 1, "got %v transactions, want %v")
+		h.MustE(t, r.Transactions[0].EventType(), event.TypeAuthorizationRequestReversed, "got transaction event type %q, want %q")
+		h.MustE(t, r.Transactions[0].AvailableBalance(), uint64(80), "got transaction available balance %v, want %v")
+		h.MustE(t, savedEvent(t, r).UUID, r.Transactions[0].EventUUID(), "got saved event event UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).AuthorizationRequestUUID, r.AuthorizationRequest.UUID(), "got saved event authorization request UUID %v, want %v")
+		h.MustE(t, savedEvent(t, r).Amount, uint64(50), "got saved event amount %v, want %v")
+	})
+	t.Run("releases the amount within the limits of the card at the time of the service", func(t *testing.T) {
+		now := time.Now().UTC().Add(48 * time.Hour)
+		m, err := model.NewMerchant("Test Merchant", "5411", "GB", "GB29NWBK60161331926819")
+		h.MustNotErr(t, err, "%v")
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		h.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney() %v; want nil")
+		r := &h.Repository{Card: c, Merchant: m}
+		auth := authorize.New(r, nil, nil, model.ProductLimits{model.DefaultProduct: {Daily: 100}})
+		auth.Now = func() time.Time { return now }
+		rev := reverse.New(r)
+		rev.Now = func() time.Time { return now }
+		req := authorize.Request{MerchantUUID: m.UUID().String(), CardUUID: c.UUID().String(), Amount: "100", Currency: "GBP"}
+		res, err := auth.Authorize(context.Background(), req)
+		h.MustNotErr(t, err, "got svc.Authorize() error %v, want nil")
+		_, err = rev.Reverse(context.Background(), res.UUID, reverse.Request{Amount: "100"})
+		h.MustNotErr(t, err, "got svc.Reverse() error %v, want nil")
+		h.Must(t, r.Transactions[1].Date().Equal(now), "got reversal date %v, want %v", r.Transactions[1].Date(), now)
+		_, err = auth.Authorize(context.Background(), req)
+		h.MustNotErr(t, err, "got svc.Authorize() error %v after the reversal, want nil")
+	})
+	t.Run("returns 404 error response if the authorization request does not exist", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		_, err := reverse.New(r).Reverse(context.Background(), uuid.Must(uuid.NewV4()).String(), reverse.Request{Amount: "1"})
+		res := h.MustErrorResponse(t, err, 404)
+		h.MustE(t, len(res.InvalidParameters), 0, "got %v invalid parameters, want %v")
+	})
+	t.Run("returns 422 error response with the invalid parameter if the amount cannot be reversed", func(t *testing.T) {
+		for _, a := range []string{"foo", "0", "71"} {
+			r := mustRepository(t, 100, 70)
+			_, err := reverse.New(r).Reverse(context.Background(), r.AuthorizationRequest.UUID().String(), reverse.Request{Amount: a})
+			res := h.MustErrorResponse(t, err, 422)
+			h.MustE(t, len(res.InvalidParameters), 1, "got %v invalid parameters, want %v")
+			h.MustE(t, res.InvalidParameters[0].Name, "amount", "got invalid parameter %q, want %q")
+			h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+			h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+		}
+	})
+	t.Run("rolls back the changes if the transaction cannot be committed", func(t *testing.T) {
+		r := mustRepository(t, 100, 70)
+		r.CommitErr = errors.New("test commit failed")
+		available, blocked := r.Card.AvailableBalance(), r.Card.BlockedBalance()
+		history := len(r.AuthorizationRequest.History())
+		_, err := reverse.New(r).Reverse(context.Background(), r.AuthorizationRequest.UUID().String(), reverse.Request{Amount: "1"})
+		h.MustErr(t, err, "got svc.Reverse() = reverse.Response, nil, want reverse.Response, error")
+		h.MustE(t, r.Card.AvailableBalance(), available, "got available balance %v, want %v")
+		h.MustE(t, r.Card.BlockedBalance(), blocked, "got blocked balance %v, want %v")
+		h.MustE(t, len(r.AuthorizationRequest.History()), history, "got %v snapshots, want %v")
+		h.MustE(t, len(r.Transactions), 0, "got %v transactions, want %v")
+		h.MustE(t, len(r.Events), 0, "got %v saved events, want %v")
+	})
+}
+
+func mustRepository(t *testing.T, l, b uint64) *h.Repository {
+	t.Helper()
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(l), "c.LoadMoney() %v; want nil")
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(b, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+	return &h.Repository{Card: c, AuthorizationRequest: req}
+}
+
+// savedEvent returns the only event saved in r.
+func savedEvent(t *testing.T, r *h.Repository) event.AuthorizationRequestReversed {
+	t.Helper()
+	h.MustE(t, len(r.Events), 1, "got %v saved events, want %v")
+	e, ok := r.Events[0].(event.AuthorizationRequestReversed)
+	h.Must(t, ok, "got saved event %T, want event.AuthorizationRequestReversed", r.Events[0])
+	return e
+}
@@ -2,12 +2,19 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
 )
 
 const errContentType = "application/problem+json"
 const errStatusCode = http.StatusInternalServerError
 
+// ErrNotFound is returned when the expected record(s) can not be found.
+var ErrNotFound = errors.New("record not found")
+
 // NewInternalServerErrorResponse returns 500 Internal Server Error.
 func NewInternalServerErrorResponse() ErrorResponse {
 	return ErrorResponse{
@@ -16,6 +23,40 @@ func NewInternalServerErrorResponse() ErrorResponse {
 	}
 }
 
+// NewNotFoundErrorResponse returns 404 Not Found.
+func NewNotFoundErrorResponse() ErrorResponse {
+	return ErrorResponse{
+		Title:  http.StatusText(http.StatusNotFound),
+		Status: http.StatusNotFound,
+	}
+}
+
+// NewValidationErrorResponse returns 422 Unprocessable Entity with detail and the invalid parameters params.
+func NewValidationErrorResponse(detail string, params ...InvalidParameter) ErrorResponse {
+	return ErrorResponse{
+		Type:              "/doc/error/validation",
+		Title:             "Validation Error",
+		Status:            http.StatusUnprocessableEntity,
+		Detail:            detail,
+		InvalidParameters: params,
+	}
+}
+
+// NewCurrencyMismatchErrorResponse returns 422 Unprocessable Entity for a request,
+// which currency is not the currency of the card.
+func NewCurrencyMismatchErrorResponse(currency model.Currency) ErrorResponse {
+	return NewValidationErrorResponse(
+		"currency does not match the card currency",
+		InvalidParameter{Name: "currency", Reason: fmt.Sprintf("must be %s", currency)},
+	)
+}
+
+// InvalidParameter describes why a request parameter is invalid.
+type InvalidParameter struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
 // StatusCoder is used to set response status code.
 type StatusCoder interface {
 	StatusCode() int
@@ -29,11 +70,30 @@ type Headerer interface {
 // ErrorResponse represents a business level error. It is returned to the client.
 // This response must not include sensitive information. For more information
 // about error response see https://tools.ietf.org/html/rfc7807#section-3.1
+//
+// Extensions are rendered as additional members of the problem details. The
+// extensions with the names of the standard members or "invalidParameters" are ignored.
+// Header is sent with the response in addition to the Content-Type header.
 type ErrorResponse struct {
-	Type   string `json:"-"`
-	Title  string `json:"-"`
-	Status int    `json:"-"`
-	Detail string `json:"-"`
+	Type     string `json:"-"`
+	Title    string `json:"-"`
+	Status   int    `json:"-"`
+	Detail   string `json:"-"`
+	Instance string `json:"-"`
+
+	InvalidParameters []InvalidParameter     `json:"-"`
+	Extensions        map[string]interface{} `json:"-"`
+	Header            http.Header            `json:"-"`
+}
+
+// reservedMembers are the members of ErrorResponse, which cannot be overridden by extensions.
+var reservedMembers = map[string]bool{
+	"type":              true,
+	"title":             true,
+	"status":            true,
+	"detail":            true,
+	"instance":          true,
+	"invalidParameters": true,
 }
 
 var _ StatusCoder = &ErrorResponse{}
@@ -52,6 +112,9 @@ func (r *ErrorResponse) StatusCode() int {
 // Headers implements Headerer.
 func (r *ErrorResponse) Headers() http.Header {
 	h := http.Header{}
+	for k, v := range r.Header {
+		h[k] = v
+	}
 	h.Set("Content-Type", errContentType)
 	return h
 }
@@ -75,15 +138,38 @@ func (r ErrorResponse) Error() string {
 
 // MarshalJSON implements json.Marshaller.
 func (r ErrorResponse) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Type   string `json:"type,omitempty"`
-		Title  string `json:"title"`
-		Status int    `json:"status,omitempty"`
-		Detail string `json:"detail,omitempty"`
+	j, err := json.Marshal(struct {
+		Type     string `json:"type,omitempty"`
+		Title    string `json:"title"`
+		Status   int    `json:"status,omitempty"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+
+		InvalidParameters []InvalidParameter `json:"invalidParameters,omitempty"`
 	}{
 		r.Type,
 		r.String(),
 		r.StatusCode(),
 		r.Detail,
+		r.Instance,
+		r.InvalidParameters,
 	})
+	if err != nil {
+		return nil, err
+	}
+	ext := map[string]interface{}{}
+	for k, v := range r.Extensions {
+		if !reservedMembers[k] {
+			ext[k] = v
+		}
+	}
+	if len(ext) == 0 {
+		return j, nil
+	}
+	e, err := json.Marshal(ext)
+	if err != nil {
+		return nil, err
+	}
+	// Merge the objects {...} and {...} into {...,...}.
+	return append(append(j[:len(j)-1], ','), e[1:]...), nil
 }
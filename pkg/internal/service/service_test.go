@@ -6,7 +6,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
 	"github.com/sepetrov/prepaidcard/pkg/internal/service"
 	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
 )
@@ -21,6 +25,64 @@ func TestNewInternalServerErrorResponse(t *testing.T) {
 	})
 }
 
+func TestNewNotFoundErrorResponse(t *testing.T) {
+	r := service.NewNotFoundErrorResponse()
+	t.Run("sets status code 404", func(t *testing.T) {
+		h.MustE(t, r.StatusCode(), 404, "got status code %#v, want %#v")
+	})
+	t.Run("sets title Not Found", func(t *testing.T) {
+		h.MustE(t, r.Title, http.StatusText(404), "got title %q, want %q")
+	})
+}
+
+func TestNewValidationErrorResponse(t *testing.T) {
+	r := service.NewValidationErrorResponse("foo")
+	t.Run("sets status code 422", func(t *testing.T) {
+		h.MustE(t, r.StatusCode(), 422, "got status code %#v, want %#v")
+	})
+	t.Run("sets detail", func(t *testing.T) {
+		h.MustE(t, r.Detail, "foo", "got detail %q, want %q")
+	})
+	t.Run("renders invalid parameters", func(t *testing.T) {
+		r := service.NewValidationErrorResponse("foo", service.InvalidParameter{Name: "amount", Reason: "must be unsigned integer"})
+		got, err := r.MarshalJSON()
+		h.MustNotErr(t, err, "got JSON-encoding error; %v")
+		want := `{"type":"/doc/error/validation","title":"Validation Error","status":422,"detail":"foo",` +
+			`"invalidParameters":[{"name":"amount","reason":"must be unsigned integer"}]}`
+		h.MustE(t, string(got), want, "got %s, want %s")
+	})
+}
+
+func TestErrorResponse_MarshalJSON(t *testing.T) {
+	t.Run("renders the extensions as members", func(t *testing.T) {
+		r := service.ErrorResponse{
+			Title:      "Foo",
+			Status:     409,
+			Instance:   "/foo",
+			Extensions: map[string]interface{}{"balance": "30", "accounts": []string{"/a", "/b"}},
+		}
+		got, err := r.MarshalJSON()
+		h.MustNotErr(t, err, "got JSON-encoding error; %v")
+		want := `{"title":"Foo","status":409,"instance":"/foo","accounts":["/a","/b"],"balance":"30"}`
+		h.MustE(t, string(got), want, "got %s, want %s")
+	})
+	t.Run("ignores the extensions with the names of the standard members", func(t *testing.T) {
+		r := service.ErrorResponse{
+			Title:      "Foo",
+			Status:     409,
+			Extensions: map[string]interface{}{"status": 200, "title": "Bar", "invalidParameters": nil},
+		}
+		got, err := r.MarshalJSON()
+		h.MustNotErr(t, err, "got JSON-encoding error; %v")
+		h.MustE(t, string(got), `{"title":"Foo","status":409}`, "got %s, want %s")
+	})
+	t.Run("returns error if the extension cannot be encoded", func(t *testing.T) {
+		r := service.ErrorResponse{Extensions: map[string]interface{}{"foo": func() {}}}
+		_, err := r.MarshalJSON()
+		h.MustErr(t, err, "got nil error, want error")
+	})
+}
+
 func TestErrorResponse_StatusCode(t *testing.T) {
 	t.Run("default status code of StatusCoder interface is 500", func(t *testing.T) {
 		r := service.ErrorResponse{}
@@ -33,6 +95,11 @@ func TestErrorResponse_Headers(t *testing.T) {
 		r := service.ErrorResponse{}
 		h.MustE(t, r.Headers().Get("content-type"), "application/problem+json", "got Content-Type: %#v, want %#v")
 	})
+	t.Run("adds header", func(t *testing.T) {
+		r := service.ErrorResponse{Header: http.Header{"Retry-After": []string{"5"}}}
+		h.MustE(t, r.Headers().Get("Retry-After"), "5", "got Retry-After: %#v, want %#v")
+		h.MustE(t, r.Headers().Get("content-type"), "application/problem+json", "got Content-Type: %#v, want %#v")
+	})
 }
 
 func TestErrorResponse_String(t *testing.T) {
@@ -69,4 +136,46 @@ func TestErrorResponse_Body(t *testing.T) {
 
 		h.MustE(t, string(got), string(want), "got %s != %s, want them equal")
 	})
+	t.Run("must include instance if not empty", func(t *testing.T) {
+		r := service.NewNotFoundErrorResponse()
+		r.Instance = "/api/card/foo"
+		d := struct {
+			Title    string `json:"title"`
+			Status   int    `json:"status"`
+			Instance string `json:"instance"`
+		}{
+			http.StatusText(404),
+			404,
+			"/api/card/foo",
+		}
+
+		got, err := r.MarshalJSON()
+		h.MustNotErr(t, err, "got JSON-encoding error; %v")
+
+		want, err := json.Marshal(d)
+		h.MustNotErr(t, err, "got JSON-encoding error; %v")
+
+		h.MustE(t, string(got), string(want), "got %s != %s, want them equal")
+	})
+}
+
+func TestNewAuthorizationRequest(t *testing.T) {
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(100), "%v")
+	m := uuid.Must(uuid.NewV4())
+	req, err := model.NewAuthorizationRequest(c, m, model.NewMoney(70, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "%v")
+
+	r := service.NewAuthorizationRequest(req)
+	h.MustE(t, r.UUID, req.UUID().String(), "got UUID %q, want %q")
+	h.MustE(t, r.CardUUID, c.UUID().String(), "got card UUID %q, want %q")
+	h.MustE(t, r.MerchantUUID, m.String(), "got merchant UUID %q, want %q")
+	h.MustE(t, r.BlockedAmount, "70", "got blocked amount %q, want %q")
+	h.MustE(t, r.CapturedAmount, "0", "got captured amount %q, want %q")
+	h.MustE(t, r.RefundedAmount, "0", "got refunded amount %q, want %q")
+	h.MustE(t, r.ExpiresAt, req.ExpiresAt().Format(time.RFC3339), "got expiry %q, want %q")
+	h.MustE(t, len(r.History), 1, "got %v snapshots, want %v")
+	h.MustE(t, r.History[0].UUID, req.History()[0].UUID().String(), "got snapshot UUID %q, want %q")
+	h.MustE(t, r.History[0].BlockedAmount, "70", "got snapshot blocked amount %q, want %q")
 }
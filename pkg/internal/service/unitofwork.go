@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+)
+
+// UnitOfWork is interface for atomic changes of several aggregates.
+type UnitOfWork interface {
+	// WithinTx calls fn with the repositories of a new transaction. The transaction is
+	// committed if fn returns nil and it is rolled back otherwise. WithinTx returns the error of fn
+	// or the error of the commit.
+	WithinTx(ctx context.Context, fn func(Tx) error) error
+}
+
+// Tx is interface for retrieval and persistence of aggregates within a transaction.
+// The changes are visible outside the transaction only after it is committed.
+type Tx interface {
+	// GetCard returns the card with the UUID or ErrNotFound if the card does not exist.
+	GetCard(context.Context, uuid.UUID) (*model.Card, error)
+	// GetAuthorizationRequest returns the authorization request with the UUID
+	// or ErrNotFound if the authorization request does not exist.
+	GetAuthorizationRequest(context.Context, uuid.UUID) (*model.AuthorizationRequest, error)
+	// SaveCard saves new card.
+	SaveCard(context.Context, *model.Card) error
+	// UpdateCard saves the status and the balances of the card. It returns ErrConcurrentModification
+	// if the stored card has changed since the card was read.
+	UpdateCard(context.Context, *model.Card) error
+	// SaveAuthorizationRequest saves the authorization request with its history.
+	SaveAuthorizationRequest(context.Context, *model.AuthorizationRequest) error
+	// SaveTransaction saves new transaction with its ledger postings.
+	SaveTransaction(context.Context, *model.Transaction) error
+	// GetCardLimits returns the product and the own limits of the card with the UUID
+	// or ErrNotFound if they are not set.
+	GetCardLimits(context.Context, uuid.UUID) (model.CardLimits, error)
+	// SaveCardLimits saves the product and the own limits of the card with the UUID.
+	SaveCardLimits(context.Context, uuid.UUID, model.CardLimits) error
+	// ListCardTransactions returns the transactions of the card with the UUID since the date
+	// from the oldest to the newest.
+	ListCardTransactions(ctx context.Context, card uuid.UUID, since time.Time) ([]*model.Transaction, error)
+	// GetCardMerchantCategories returns the merchant category restrictions of the card with the UUID,
+	// which are empty if they are not set.
+	GetCardMerchantCategories(context.Context, uuid.UUID) (model.MerchantCategories, error)
+	// SaveCardMerchantCategories replaces the merchant category restrictions of the card with the UUID.
+	SaveCardMerchantCategories(context.Context, uuid.UUID, model.MerchantCategories) error
+	// GetMerchant returns the merchant with the UUID or ErrNotFound if the merchant does not exist.
+	GetMerchant(context.Context, uuid.UUID) (*model.Merchant, error)
+	// SaveMerchant saves new or changed merchant.
+	SaveMerchant(context.Context, *model.Merchant) error
+	// SaveEvent saves the event in the outbox, from which it is published after the transaction
+	// is committed. The event must be one of the events of package event.
+	SaveEvent(context.Context, interface{}) error
+}
@@ -0,0 +1,115 @@
+package verifyledger
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Balance is the sum of the debit and the credit postings of a ledger account.
+type Balance struct {
+	Account model.Account
+	Debit   *big.Int
+	Credit  *big.Int
+}
+
+// Response is the result of the ledger verification.
+type Response struct {
+	Accounts      int
+	Cards         int
+	Discrepancies []string
+}
+
+// OK reports whether the ledger has no discrepancies.
+func (res Response) OK() bool {
+	return len(res.Discrepancies) == 0
+}
+
+// Service is the service verifying the ledger.
+type Service struct {
+	reader Reader
+}
+
+// New returns new service verifying the ledger.
+func New(r Reader) *Service {
+	return &Service{r}
+}
+
+// VerifyLedger verifies that the sum of all ledger postings in each currency is
+// zero and that the balances of each card match its ledger accounts.
+// The discrepancies are returned in the response.
+func (svc *Service) VerifyLedger(ctx context.Context) (Response, error) {
+	balances, err := svc.reader.LedgerBalances(ctx)
+	if err != nil {
+		return Response{}, service.Wrap(err, "VerifyLedger() cannot get ledger balances")
+	}
+	cards, err := svc.reader.ListCards(ctx)
+	if err != nil {
+		return Response{}, service.Wrap(err, "VerifyLedger() cannot list cards")
+	}
+
+	res := Response{Accounts: len(balances), Cards: len(cards)}
+	currencies := []model.Currency{}
+	debits, credits := map[model.Currency]*big.Int{}, map[model.Currency]*big.Int{}
+	accounts := make(map[model.Account]*big.Int, len(balances))
+	for _, b := range balances {
+		c := b.Account.Currency()
+		if _, ok := debits[c]; !ok {
+			debits[c], credits[c] = new(big.Int), new(big.Int)
+			currencies = append(currencies, c)
+		}
+		debits[c].Add(debits[c], b.Debit)
+		credits[c].Add(credits[c], b.Credit)
+		accounts[b.Account] = new(big.Int).Sub(b.Credit, b.Debit)
+	}
+	for _, c := range currencies {
+		if debits[c].Cmp(credits[c]) != 0 {
+			res.Discrepancies = append(res.Discrepancies, fmt.Sprintf("the %s ledger is not balanced: debit %s, credit %s", c, debits[c], credits[c]))
+		}
+	}
+
+	known := make(map[uuid.UUID]model.Currency, len(cards))
+	for _, card := range cards {
+		known[card.UUID()] = card.Currency()
+		res.Discrepancies = append(res.Discrepancies, verifyAccount(accounts, model.AccountCardAvailable, card, card.AvailableBalance())...)
+		res.Discrepancies = append(res.Discrepancies, verifyAccount(accounts, model.AccountCardBlocked, card, card.BlockedBalance())...)
+	}
+	for _, b := range balances {
+		t := b.Account.Type()
+		if t != model.AccountCardAvailable && t != model.AccountCardBlocked {
+			continue
+		}
+		currency, ok := known[b.Account.OwnerUUID()]
+		if !ok {
+			res.Discrepancies = append(res.Discrepancies, fmt.Sprintf("account %s of card %s: the card does not exist", t, b.Account.OwnerUUID()))
+		} else if currency != b.Account.Currency() {
+			res.Discrepancies = append(res.Discrepancies, fmt.Sprintf("account %s of card %s: currency %s, card currency %s", t, b.Account.OwnerUUID(), b.Account.Currency(), currency))
+		}
+	}
+	return res, nil
+}
+
+// verifyAccount returns the discrepancy between the ledger account of accountType
+// owned by card and the card balance.
+func verifyAccount(accounts map[model.Account]*big.Int, accountType model.AccountType, card *model.Card, balance uint64) []string {
+	want := new(big.Int).SetUint64(balance)
+	got, ok := accounts[model.NewAccount(accountType, card.UUID(), card.Currency())]
+	if !ok {
+		got = new(big.Int)
+	}
+	if got.Cmp(want) != 0 {
+		return []string{fmt.Sprintf("account %s of card %s: ledger balance %s, card balance %s", accountType, card.UUID(), got, want)}
+	}
+	return nil
+}
+
+// Reader is interface for retrieval of the ledger balances and the cards.
+type Reader interface {
+	LedgerBalances(context.Context) ([]Balance, error)
+	ListCards(context.Context) ([]*model.Card, error)
+}
@@ -0,0 +1,93 @@
+// +build !integration
+
+package verifyledger_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/verifyledger"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+)
+
+func TestService_VerifyLedger(t *testing.T) {
+	t.Run("verifies the ledger", func(t *testing.T) {
+		r := mustRepository(t)
+		res, err := verifyledger.New(r).VerifyLedger(context.Background())
+		h.MustNotErr(t, err, "svc.VerifyLedger(context.Background()) = %v; want nil")
+		h.Must(t, res.OK(), "got discrepancies %v; want none", res.Discrepancies)
+		h.MustE(t, res.Cards, 1, "got %d cards; want %d")
+		h.MustE(t, res.Accounts, 4, "got %d accounts; want %d")
+	})
+	t.Run("reports card balance, which does not match the ledger", func(t *testing.T) {
+		r := mustRepository(t)
+		h.MustNotErr(t, r.Card.LoadMoney(5), "c.LoadMoney(5) %v; want nil")
+		res, err := verifyledger.New(r).VerifyLedger(context.Background())
+		h.MustNotErr(t, err, "svc.VerifyLedger(context.Background()) = %v; want nil")
+		h.MustE(t, len(res.Discrepancies), 1, "got %d discrepancies; want %d")
+	})
+	t.Run("reports account of unknown card", func(t *testing.T) {
+		r := mustRepository(t)
+		c, err := model.NewCard(model.GBP)
+		h.MustNotErr(t, err, "%v")
+		r.Card = c
+		res, err := verifyledger.New(r).VerifyLedger(context.Background())
+		h.MustNotErr(t, err, "svc.VerifyLedger(context.Background()) = %v; want nil")
+		h.MustE(t, len(res.Discrepancies), 2, "got %d discrepancies; want %d")
+	})
+	t.Run("reports unbalanced ledger", func(t *testing.T) {
+		card := uuid.Must(uuid.NewV4())
+		svc := verifyledger.New(&reader{
+			balances: []verifyledger.Balance{
+				{Account: model.NewAccount(model.AccountBankFloat, uuid.Nil, model.GBP), Debit: big.NewInt(10), Credit: big.NewInt(0)},
+				{Account: model.NewAccount(model.AccountCardAvailable, card, model.GBP), Debit: big.NewInt(0), Credit: big.NewInt(9)},
+			},
+		})
+		res, err := svc.VerifyLedger(context.Background())
+		h.MustNotErr(t, err, "svc.VerifyLedger(context.Background()) = %v; want nil")
+		h.Must(t, !res.OK(), "got no discrepancies; want unbalanced ledger")
+	})
+	t.Run("returns error if reader returns error", func(t *testing.T) {
+		svc := verifyledger.New(&h.Repository{Err: errors.New("test reader failed")})
+		_, err := svc.VerifyLedger(context.Background())
+		h.MustErr(t, err, "got svc.VerifyLedger(context.Background()) = verifyledger.Response, nil, want verifyledger.Response, error")
+	})
+}
+
+// mustRepository returns repository with a card, which is loaded and has authorization request.
+func mustRepository(t *testing.T) *h.Repository {
+	t.Helper()
+	r := &h.Repository{}
+	c, err := model.NewCard(model.GBP)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, c.LoadMoney(100), "c.LoadMoney(100) %v; want nil")
+	tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "Foo", 100, "", time.Now())
+	h.MustNotErr(t, err, "NewTransaction() %v; want nil")
+	h.MustNotErr(t, r.SaveCardTransaction(context.Background(), c, tx), "r.SaveCardTransaction() %v; want nil")
+	req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(30, model.GBP), nil, time.Now())
+	h.MustNotErr(t, err, "NewAuthorizationRequest() %v; want nil")
+	h.MustNotErr(t, req.Capture(c, 10, time.Now()), "req.Capture(c, 10) %v; want nil")
+	tx, err = model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), "Bar", 30, "", time.Now())
+	h.MustNotErr(t, err, "NewTransaction() %v; want nil")
+	h.MustNotErr(t, r.SaveAuthorizationRequest(context.Background(), c, req, tx), "r.SaveAuthorizationRequest() %v; want nil")
+	return r
+}
+
+type reader struct {
+	balances []verifyledger.Balance
+	cards    []*model.Card
+}
+
+func (r *reader) LedgerBalances(context.Context) ([]verifyledger.Balance, error) {
+	return r.balances, nil
+}
+
+func (r *reader) ListCards(context.Context) ([]*model.Card, error) {
+	return r.cards, nil
+}
@@ -1,21 +1,698 @@
 package testing
 
 import (
+	"context"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/eventsource"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
 	"github.com/sepetrov/prepaidcard/pkg/internal/model"
-	"github.com/sepetrov/prepaidcard/pkg/internal/service/createcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/expire"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/getcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/replay"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/verifyledger"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
 )
 
 // Repository is a test helper, which implaments interfaces for interaction
 // with the model.
 type Repository struct {
-	Card *model.Card
-	Err  error
+	Card                 *model.Card
+	AuthorizationRequest *model.AuthorizationRequest
+	Transactions         []*model.Transaction
+	APIKeys              []auth.APIKey
+	// CardLimits are the product and the own limits of Card, if they are set.
+	CardLimits *model.CardLimits
+	// MerchantCategories are the merchant category restrictions of Card, if they are set.
+	MerchantCategories *model.MerchantCategories
+	Merchant           *model.Merchant
+	// Events are the events saved in the outbox.
+	Events     []interface{}
+	Webhooks   []webhook.Webhook
+	Deliveries []webhook.Delivery
+	// Attempts are the histories of Deliveries.
+	Attempts []webhook.Attempt
+	// Records are the events appended to the streams of the aggregates.
+	Records   []eventsource.Record
+	Snapshots []eventsource.Snapshot
+	// Key is the key of the hashes of the events returned by EventKey.
+	Key []byte
+	Err error
+	// CommitErr is returned by WithinTx instead of committing the transaction.
+	CommitErr error
+
+	idempotency *middleware.MemoryIdempotencyStore
+}
+
+var _ getcard.Getter = &Repository{}
+var _ listtransactions.Lister = &Repository{}
+var _ verifyledger.Reader = &Repository{}
+var _ middleware.IdempotencyStore = &Repository{}
+var _ auth.KeyStore = &Repository{}
+var _ service.UnitOfWork = &Repository{}
+var _ webhook.Store = &Repository{}
+var _ replay.Store = &Repository{}
+var _ expire.Store = &Repository{}
+
+// SaveCard saves new card.
+func (r *Repository) SaveCard(_ context.Context, card *model.Card) error {
+	r.Card = card
+	if r.Err != nil {
+		return r.Err
+	}
+	card.Saved()
+	return nil
+}
+
+// GetCard implements getcard.Getter.
+func (r *Repository) GetCard(_ context.Context, id uuid.UUID) (*model.Card, error) {
+	if r.Err != nil {
+		return &model.Card{}, r.Err
+	}
+	if r.Card == nil || r.Card.UUID() != id {
+		return &model.Card{}, service.ErrNotFound
+	}
+	return r.Card, nil
+}
+
+// UpdateCard saves the status and the balances of card.
+func (r *Repository) UpdateCard(_ context.Context, card *model.Card) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.Card = card
+	card.Saved()
+	return nil
+}
+
+// SaveCardTransaction saves card and transaction tx.
+func (r *Repository) SaveCardTransaction(_ context.Context, card *model.Card, tx *model.Transaction) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.Card = card
+	r.Transactions = append(r.Transactions, tx)
+	card.Saved()
+	return nil
+}
+
+// SaveAuthorizationRequest saves card, authorization request req and transaction tx.
+func (r *Repository) SaveAuthorizationRequest(_ context.Context, card *model.Card, req *model.AuthorizationRequest, tx *model.Transaction) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.Card = card
+	r.AuthorizationRequest = req
+	r.Transactions = append(r.Transactions, tx)
+	card.Saved()
+	return nil
+}
+
+// GetAuthorizationRequest implements service.Tx.
+func (r *Repository) GetAuthorizationRequest(_ context.Context, id uuid.UUID) (*model.AuthorizationRequest, error) {
+	if r.Err != nil {
+		return &model.AuthorizationRequest{}, r.Err
+	}
+	if r.AuthorizationRequest == nil || r.AuthorizationRequest.UUID() != id {
+		return &model.AuthorizationRequest{}, service.ErrNotFound
+	}
+	return r.AuthorizationRequest, nil
+}
+
+// ListExpiredAuthorizationRequests implements expire.Store.
+func (r *Repository) ListExpiredAuthorizationRequests(_ context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	req := r.AuthorizationRequest
+	if req == nil || limit < 1 || !req.IsExpired(now) || req.OriginalBlockedAmount() == 0 {
+		return nil, nil
+	}
+	return []uuid.UUID{req.UUID()}, nil
+}
+
+// WithinTx implements service.UnitOfWork. The card and the authorization request are copied
+// when they are read within the transaction, and the changes are applied to r only if fn returns nil
+// and CommitErr is nil.
+func (r *Repository) WithinTx(_ context.Context, fn func(service.Tx) error) error {
+	tx := &memoryTx{r: r}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if r.CommitErr != nil {
+		return r.CommitErr
+	}
+	if tx.card != nil {
+		r.Card = tx.card
+	}
+	if tx.authReq != nil {
+		r.AuthorizationRequest = tx.authReq
+	}
+	if tx.cardLimits != nil {
+		r.CardLimits = tx.cardLimits
+	}
+	if tx.merchantCategories != nil {
+		r.MerchantCategories = tx.merchantCategories
+	}
+	if tx.merchant != nil {
+		r.Merchant = tx.merchant
+	}
+	r.Transactions = append(r.Transactions, tx.transactions...)
+	r.Events = append(r.Events, tx.events...)
+	return nil
+}
+
+// memoryTx implements service.Tx with the changes of a transaction of Repository.
+type memoryTx struct {
+	r            *Repository
+	card         *model.Card
+	authReq      *model.AuthorizationRequest
+	cardLimits   *model.CardLimits
+	transactions []*model.Transaction
+	events       []interface{}
+
+	merchantCategories *model.MerchantCategories
+	merchant           *model.Merchant
+}
+
+func (tx *memoryTx) GetCard(ctx context.Context, id uuid.UUID) (*model.Card, error) {
+	if tx.card != nil && tx.card.UUID() == id {
+		return tx.card, nil
+	}
+	card, err := tx.r.GetCard(ctx, id)
+	if err != nil {
+		return card, err
+	}
+	tx.card = model.CardFromData(card)
+	return tx.card, nil
+}
+
+func (tx *memoryTx) GetAuthorizationRequest(ctx context.Context, id uuid.UUID) (*model.AuthorizationRequest, error) {
+	if tx.authReq != nil && tx.authReq.UUID() == id {
+		return tx.authReq, nil
+	}
+	req, err := tx.r.GetAuthorizationRequest(ctx, id)
+	if err != nil {
+		return req, err
+	}
+	tx.authReq = model.AuthorizationRequestFromData(req)
+	return tx.authReq, nil
+}
+
+func (tx *memoryTx) SaveCard(_ context.Context, card *model.Card) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.card = card
+	card.Saved()
+	return nil
+}
+
+func (tx *memoryTx) UpdateCard(_ context.Context, card *model.Card) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.card = card
+	card.Saved()
+	return nil
+}
+
+func (tx *memoryTx) SaveAuthorizationRequest(_ context.Context, req *model.AuthorizationRequest) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.authReq = req
+	return nil
+}
+
+func (tx *memoryTx) SaveTransaction(_ context.Context, t *model.Transaction) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.transactions = append(tx.transactions, t)
+	return nil
+}
+
+func (tx *memoryTx) GetCardLimits(_ context.Context, id uuid.UUID) (model.CardLimits, error) {
+	if tx.r.Err != nil {
+		return model.CardLimits{}, tx.r.Err
+	}
+	if tx.cardLimits != nil {
+		return *tx.cardLimits, nil
+	}
+	if tx.r.CardLimits == nil || tx.r.Card == nil || tx.r.Card.UUID() != id {
+		return model.CardLimits{}, service.ErrNotFound
+	}
+	return *tx.r.CardLimits, nil
+}
+
+func (tx *memoryTx) SaveCardLimits(_ context.Context, _ uuid.UUID, l model.CardLimits) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.cardLimits = &l
+	return nil
+}
+
+func (tx *memoryTx) ListCardTransactions(_ context.Context, id uuid.UUID, since time.Time) ([]*model.Transaction, error) {
+	if tx.r.Err != nil {
+		return nil, tx.r.Err
+	}
+	txs := []*model.Transaction{}
+	for _, t := range tx.r.Transactions {
+		if t.CardUUID() == id && !t.Date().Before(since) {
+			txs = append(txs, t)
+		}
+	}
+	return txs, nil
+}
+
+func (tx *memoryTx) GetCardMerchantCategories(_ context.Context, id uuid.UUID) (model.MerchantCategories, error) {
+	if tx.r.Err != nil {
+		return model.MerchantCategories{}, tx.r.Err
+	}
+	if tx.merchantCategories != nil {
+		return *tx.merchantCategories, nil
+	}
+	if tx.r.MerchantCategories == nil || tx.r.Card == nil || tx.r.Card.UUID() != id {
+		return model.MerchantCategories{}, nil
+	}
+	return *tx.r.MerchantCategories, nil
+}
+
+func (tx *memoryTx) SaveCardMerchantCategories(_ context.Context, _ uuid.UUID, c model.MerchantCategories) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.merchantCategories = &c
+	return nil
+}
+
+func (tx *memoryTx) GetMerchant(_ context.Context, id uuid.UUID) (*model.Merchant, error) {
+	if tx.r.Err != nil {
+		return &model.Merchant{}, tx.r.Err
+	}
+	if tx.merchant != nil && tx.merchant.UUID() == id {
+		return tx.merchant, nil
+	}
+	if tx.r.Merchant == nil || tx.r.Merchant.UUID() != id {
+		return &model.Merchant{}, service.ErrNotFound
+	}
+	tx.merchant = model.MerchantFromData(tx.r.Merchant)
+	return tx.merchant, nil
+}
+
+func (tx *memoryTx) SaveMerchant(_ context.Context, m *model.Merchant) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	tx.merchant = m
+	return nil
+}
+
+func (tx *memoryTx) SaveEvent(_ context.Context, e interface{}) error {
+	if tx.r.Err != nil {
+		return tx.r.Err
+	}
+	if _, err := event.Marshal(e); err != nil {
+		return err
+	}
+	tx.events = append(tx.events, e)
+	return nil
 }
 
-var _ createcard.Saver = &Repository{}
+// ListTransactions implements listtransactions.Lister.
+func (r *Repository) ListTransactions(_ context.Context, f listtransactions.Filter) ([]*model.Transaction, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	txs := []*model.Transaction{}
+	for _, tx := range r.Transactions {
+		if tx.CardUUID() != f.CardUUID {
+			continue
+		}
+		if !f.From.IsZero() && tx.Date().Before(f.From) {
+			continue
+		}
+		if !f.To.IsZero() && !tx.Date().Before(f.To) {
+			continue
+		}
+		if len(f.EventTypes) > 0 && !contains(f.EventTypes, tx.EventType()) {
+			continue
+		}
+		if f.After != nil && !isBefore(tx, f.After.Date, f.After.UUID) {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		return isBefore(txs[j], txs[i].Date(), txs[i].UUID())
+	})
+	if len(txs) > f.Limit {
+		txs = txs[:f.Limit]
+	}
+	return txs, nil
+}
+
+// LedgerBalances implements verifyledger.Reader.
+func (r *Repository) LedgerBalances(_ context.Context) ([]verifyledger.Balance, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	balances := []verifyledger.Balance{}
+	index := map[model.Account]int{}
+	for _, tx := range r.Transactions {
+		for _, p := range tx.Postings() {
+			i, ok := index[p.Account()]
+			if !ok {
+				i = len(balances)
+				index[p.Account()] = i
+				balances = append(balances, verifyledger.Balance{Account: p.Account(), Debit: new(big.Int), Credit: new(big.Int)})
+			}
+			amount := new(big.Int).SetUint64(p.Amount())
+			if p.Direction() == model.Debit {
+				balances[i].Debit.Add(balances[i].Debit, amount)
+			} else {
+				balances[i].Credit.Add(balances[i].Credit, amount)
+			}
+		}
+	}
+	return balances, nil
+}
+
+// ListCards implements verifyledger.Reader.
+func (r *Repository) ListCards(_ context.Context) ([]*model.Card, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.Card == nil {
+		return []*model.Card{}, nil
+	}
+	return []*model.Card{r.Card}, nil
+}
+
+// isBefore reports whether tx is ordered before the position of date and id.
+func isBefore(tx *model.Transaction, date time.Time, id uuid.UUID) bool {
+	if tx.Date().Equal(date) {
+		return tx.UUID().String() < id.String()
+	}
+	return tx.Date().Before(date)
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// BeginIdempotentRequest implements middleware.IdempotencyStore.
+func (r *Repository) BeginIdempotentRequest(ctx context.Context, caller, key, fingerprint string, staleBefore time.Time) (middleware.IdempotentRequest, bool, error) {
+	return r.idempotencyStore().BeginIdempotentRequest(ctx, caller, key, fingerprint, staleBefore)
+}
+
+// CompleteIdempotentRequest implements middleware.IdempotencyStore.
+func (r *Repository) CompleteIdempotentRequest(ctx context.Context, caller, key string, req middleware.IdempotentRequest) error {
+	return r.idempotencyStore().CompleteIdempotentRequest(ctx, caller, key, req)
+}
+
+// ReleaseIdempotentRequest implements middleware.IdempotencyStore.
+func (r *Repository) ReleaseIdempotentRequest(ctx context.Context, caller, key string) error {
+	return r.idempotencyStore().ReleaseIdempotentRequest(ctx, caller, key)
+}
+
+func (r *Repository) idempotencyStore() *middleware.MemoryIdempotencyStore {
+	if r.idempotency == nil {
+		r.idempotency = middleware.NewMemoryIdempotencyStore()
+	}
+	return r.idempotency
+}
+
+// SaveAPIKey implements auth.KeyStore.
+func (r *Repository) SaveAPIKey(_ context.Context, key auth.APIKey) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.APIKeys = append(r.APIKeys, key)
+	return nil
+}
+
+// GetAPIKey implements auth.KeyStore.
+func (r *Repository) GetAPIKey(_ context.Context, hash string) (auth.APIKey, error) {
+	if r.Err != nil {
+		return auth.APIKey{}, r.Err
+	}
+	for _, k := range r.APIKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return auth.APIKey{}, service.ErrNotFound
+}
+
+// ListAPIKeys implements auth.KeyStore.
+func (r *Repository) ListAPIKeys(_ context.Context) ([]auth.APIKey, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return r.APIKeys, nil
+}
+
+// DeleteAPIKey implements auth.KeyStore.
+func (r *Repository) DeleteAPIKey(_ context.Context, id uuid.UUID) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for i, k := range r.APIKeys {
+		if k.UUID == id {
+			r.APIKeys = append(r.APIKeys[:i], r.APIKeys[i+1:]...)
+			return nil
+		}
+	}
+	return service.ErrNotFound
+}
+
+// SaveWebhook implements webhook.Store.
+func (r *Repository) SaveWebhook(_ context.Context, w webhook.Webhook) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.Webhooks = append(r.Webhooks, w)
+	return nil
+}
+
+// GetWebhook implements webhook.Store.
+func (r *Repository) GetWebhook(_ context.Context, id uuid.UUID) (webhook.Webhook, error) {
+	if r.Err != nil {
+		return webhook.Webhook{}, r.Err
+	}
+	for _, w := range r.Webhooks {
+		if w.UUID == id {
+			return w, nil
+		}
+	}
+	return webhook.Webhook{}, service.ErrNotFound
+}
+
+// ListWebhooks implements webhook.Store.
+func (r *Repository) ListWebhooks(_ context.Context, merchantUUID uuid.UUID) ([]webhook.Webhook, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	webhooks := []webhook.Webhook{}
+	for _, w := range r.Webhooks {
+		if w.MerchantUUID == merchantUUID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+// SaveDelivery implements webhook.Store.
+func (r *Repository) SaveDelivery(_ context.Context, d webhook.Delivery) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for _, saved := range r.Deliveries {
+		if saved.WebhookUUID == d.WebhookUUID && saved.EventUUID == d.EventUUID {
+			return nil
+		}
+	}
+	r.Deliveries = append(r.Deliveries, d)
+	return nil
+}
+
+// UpdateDelivery implements webhook.Store.
+func (r *Repository) UpdateDelivery(_ context.Context, d webhook.Delivery, a webhook.Attempt) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for i, saved := range r.Deliveries {
+		if saved.UUID == d.UUID {
+			r.Deliveries[i] = d
+			r.Attempts = append(r.Attempts, a)
+			return nil
+		}
+	}
+	return service.ErrNotFound
+}
+
+// ListAttempts implements webhook.Store.
+func (r *Repository) ListAttempts(_ context.Context, id uuid.UUID) ([]webhook.Attempt, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	attempts := []webhook.Attempt{}
+	for _, a := range r.Attempts {
+		if a.DeliveryUUID == id {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts, nil
+}
+
+// ListDeliveries implements webhook.Store.
+func (r *Repository) ListDeliveries(_ context.Context, id uuid.UUID, limit int) ([]webhook.Delivery, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	deliveries := []webhook.Delivery{}
+	for i := len(r.Deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if r.Deliveries[i].WebhookUUID == id {
+			deliveries = append(deliveries, r.Deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// PendingDeliveries implements webhook.Store.
+func (r *Repository) PendingDeliveries(_ context.Context, now time.Time, limit int) ([]webhook.PendingDelivery, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	deliveries := []webhook.PendingDelivery{}
+	for _, d := range r.Deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status != webhook.DeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		for _, w := range r.Webhooks {
+			if w.UUID == d.WebhookUUID {
+				deliveries = append(deliveries, webhook.PendingDelivery{Delivery: d, URL: w.URL, Secret: w.Secret})
+			}
+		}
+	}
+	return deliveries, nil
+}
+
+// AppendRecord implements eventsource.Store.
+func (r *Repository) AppendRecord(_ context.Context, rec eventsource.Record) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for _, saved := range r.Records {
+		if saved.AggregateUUID == rec.AggregateUUID && saved.Sequence == rec.Sequence {
+			return service.ErrConcurrentModification
+		}
+	}
+	r.Records = append(r.Records, rec)
+	return nil
+}
+
+// LastRecord implements eventsource.Store.
+func (r *Repository) LastRecord(_ context.Context, id uuid.UUID) (eventsource.Record, error) {
+	if r.Err != nil {
+		return eventsource.Record{}, r.Err
+	}
+	last := eventsource.Record{}
+	for _, rec := range r.Records {
+		if rec.AggregateUUID == id && rec.Sequence > last.Sequence {
+			last = rec
+		}
+	}
+	if last.Sequence == 0 {
+		return eventsource.Record{}, service.ErrNotFound
+	}
+	return last, nil
+}
+
+// ListRecords implements eventsource.Store.
+func (r *Repository) ListRecords(_ context.Context, id uuid.UUID, after uint64) ([]eventsource.Record, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	records := []eventsource.Record{}
+	for _, rec := range r.Records {
+		if rec.AggregateUUID == id && rec.Sequence > after {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Sequence < records[j].Sequence })
+	return records, nil
+}
+
+// SaveSnapshot implements eventsource.Store.
+func (r *Repository) SaveSnapshot(_ context.Context, s eventsource.Snapshot) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	r.Snapshots = append(r.Snapshots, s)
+	return nil
+}
+
+// LatestSnapshot implements eventsource.Store.
+func (r *Repository) LatestSnapshot(_ context.Context, id uuid.UUID) (eventsource.Snapshot, error) {
+	if r.Err != nil {
+		return eventsource.Snapshot{}, r.Err
+	}
+	latest := eventsource.Snapshot{}
+	for _, s := range r.Snapshots {
+		if s.AggregateUUID == id && s.Sequence > latest.Sequence {
+			latest = s
+		}
+	}
+	if latest.Sequence == 0 {
+		return eventsource.Snapshot{}, service.ErrNotFound
+	}
+	return latest, nil
+}
+
+// ListAggregates implements replay.Store.
+func (r *Repository) ListAggregates(_ context.Context, aggregateType string) ([]uuid.UUID, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	ids := []uuid.UUID{}
+	for _, rec := range r.Records {
+		if rec.AggregateType == aggregateType && (rec.Sequence == 1 || rec.Message.Type == eventsource.TypeMigrated) {
+			ids = append(ids, rec.AggregateUUID)
+		}
+	}
+	return ids, nil
+}
+
+// EventKey implements replay.Store.
+func (r *Repository) EventKey() []byte {
+	return r.Key
+}
 
-// SaveCard implements createcard.Saver.
-func (r *Repository) SaveCard(card *model.Card) error {
+// ReplaceCard implements replay.Store.
+func (r *Repository) ReplaceCard(_ context.Context, card *model.Card) error {
+	if r.Err != nil {
+		return r.Err
+	}
 	r.Card = card
-	return r.Err
+	return nil
 }
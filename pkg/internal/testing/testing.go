@@ -1,7 +1,11 @@
 // Package testing has test helper functions.
 package testing
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
 
 // Must is a test helper, which interrupts test t and printfs s with args if ok == false.
 func Must(t *testing.T, ok bool, s string, args ...interface{}) {
@@ -39,3 +43,13 @@ func MustNotErr(t *testing.T, err error, s string, args ...interface{}) {
 		t.Fatalf(s, args...)
 	}
 }
+
+// MustErrorResponse is a test helper, which interrupts test t if err is not service.ErrorResponse
+// with status code code. It returns the error response.
+func MustErrorResponse(t *testing.T, err error, code int) service.ErrorResponse {
+	t.Helper()
+	res, ok := err.(service.ErrorResponse)
+	Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+	MustE(t, res.StatusCode(), code, "got status code %#v, want %#v")
+	return res
+}
@@ -0,0 +1,158 @@
+// Package validation validates requests and reports all invalid parameters
+// of a request with a single 422 service.ErrorResponse.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Validator accumulates the invalid parameters of a request.
+// The zero value is ready to use.
+type Validator struct {
+	params []service.InvalidParameter
+}
+
+// Invalid adds parameter name, which is invalid for reason.
+func (v *Validator) Invalid(name, reason string) {
+	v.params = append(v.params, service.InvalidParameter{Name: name, Reason: reason})
+}
+
+// Valid returns true if no invalid parameters are added.
+func (v *Validator) Valid() bool {
+	return len(v.params) == 0
+}
+
+// Err returns 422 service.ErrorResponse with detail and the invalid parameters.
+// It returns nil if no invalid parameters are added.
+func (v *Validator) Err(detail string) error {
+	if v.Valid() {
+		return nil
+	}
+	return service.NewValidationErrorResponse(detail, v.params...)
+}
+
+// UUID returns the UUID of parameter name with value s.
+func (v *Validator) UUID(name, s string) uuid.UUID {
+	id, err := uuid.FromString(s)
+	if err != nil {
+		v.Invalid(name, "must be UUID")
+		return uuid.Nil
+	}
+	return id
+}
+
+// Amount returns the amount in minor units of parameter name with value s,
+// which is unsigned integer encoded as string.
+func (v *Validator) Amount(name, s string) uint64 {
+	a, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		v.Invalid(name, "must be unsigned integer")
+		return 0
+	}
+	return a
+}
+
+// Currency returns the supported currency of parameter name with ISO 4217 code s.
+func (v *Validator) Currency(name, s string) model.Currency {
+	if len(s) == 0 {
+		v.Invalid(name, "must be ISO 4217 code")
+		return ""
+	}
+	c, err := model.ParseCurrency(s)
+	if err != nil {
+		v.Invalid(name, "is not supported")
+		return ""
+	}
+	return c
+}
+
+// Money returns the money with amount and currency of parameters amountName and currencyName.
+func (v *Validator) Money(amountName, currencyName, amount, currency string) model.Money {
+	a := v.Amount(amountName, amount)
+	c := v.Currency(currencyName, currency)
+	return model.NewMoney(a, c)
+}
+
+// Decode decodes the JSON object in r into req, which must be a pointer to struct.
+// It returns 422 service.ErrorResponse if r is not a single JSON object,
+// the object has fields, which req does not have, or the fields have invalid types.
+func Decode(r io.Reader, req interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(req)
+	if err == nil {
+		if _, err := dec.Token(); err != io.EOF {
+			return service.NewValidationErrorResponse("request body must be a single JSON object")
+		}
+		return nil
+	}
+	v := &Validator{}
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		if len(e.Field) == 0 {
+			return service.NewValidationErrorResponse("request body must be a valid JSON object")
+		}
+		v.Invalid(e.Field, "must be "+jsonType(e.Type))
+	default:
+		name, ok := unknownField(err)
+		if !ok {
+			return service.NewValidationErrorResponse("request body must be a valid JSON object")
+		}
+		v.Invalid(name, "is not supported")
+	}
+	return v.Err("The request body is invalid.")
+}
+
+// DecodeOptional is like Decode, but it does not return error if r is empty.
+func DecodeOptional(r io.Reader, req interface{}) error {
+	if r == nil {
+		return nil
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("DecodeOptional() cannot read request body; %v", err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	return Decode(bytes.NewReader(b), req)
+}
+
+// unknownField returns the field name of err of json.Decoder, which disallows unknown fields.
+func unknownField(err error) (string, bool) {
+	const prefix = "json: unknown field "
+	if !strings.HasPrefix(err.Error(), prefix) {
+		return "", false
+	}
+	name, err := strconv.Unquote(strings.TrimPrefix(err.Error(), prefix))
+	return name, err == nil
+}
+
+// jsonType returns the name of the JSON type of values of Go type t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
@@ -0,0 +1,108 @@
+// +build !integration
+
+package validation_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/internal/validation"
+)
+
+func TestValidator(t *testing.T) {
+	t.Run("returns the parsed values of valid parameters", func(t *testing.T) {
+		id := uuid.Must(uuid.NewV4())
+		v := &validation.Validator{}
+		h.MustE(t, v.UUID("uuid", id.String()), id, "got UUID %v, want %v")
+		h.MustE(t, v.Amount("amount", "18446744073709551615"), uint64(18446744073709551615), "got amount %v, want %v")
+		h.MustE(t, v.Currency("currency", "eur"), model.EUR, "got currency %q, want %q")
+		h.MustE(t, v.Money("amount", "currency", "100", "JPY"), model.NewMoney(100, model.JPY), "got money %v, want %v")
+		h.Must(t, v.Valid(), "got invalid, want valid")
+		h.MustNotErr(t, v.Err("foo"), "got v.Err() %v; want nil")
+	})
+	t.Run("accumulates the invalid parameters", func(t *testing.T) {
+		v := &validation.Validator{}
+		v.UUID("merchantUUID", "foo")
+		v.Money("amount", "currency", "-1", "XXX")
+		v.Amount("limit", "18446744073709551616")
+		v.Currency("other", "")
+		v.Invalid("cursor", "must be cursor")
+
+		err := v.Err("The request body is invalid.")
+		res, ok := err.(service.ErrorResponse)
+		h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+		h.MustE(t, res.StatusCode(), 422, "got status code %d, want %d")
+		h.MustE(t, res.Detail, "The request body is invalid.", "got detail %q, want %q")
+		want := []service.InvalidParameter{
+			{Name: "merchantUUID", Reason: "must be UUID"},
+			{Name: "amount", Reason: "must be unsigned integer"},
+			{Name: "currency", Reason: "is not supported"},
+			{Name: "limit", Reason: "must be unsigned integer"},
+			{Name: "other", Reason: "must be ISO 4217 code"},
+			{Name: "cursor", Reason: "must be cursor"},
+		}
+		h.Must(t, reflect.DeepEqual(res.InvalidParameters, want), "got invalid parameters %v, want %v", res.InvalidParameters, want)
+	})
+}
+
+func TestDecode(t *testing.T) {
+	type request struct {
+		Amount string   `json:"amount"`
+		Types  []string `json:"types"`
+	}
+	t.Run("decodes the JSON object", func(t *testing.T) {
+		req := request{}
+		h.MustNotErr(t, validation.Decode(strings.NewReader(` {"amount":"10","types":["a"]} `), &req), "got validation.Decode() %v; want nil")
+		h.MustE(t, req.Amount, "10", "got amount %q, want %q")
+	})
+	t.Run("returns 422 with the invalid parameter", func(t *testing.T) {
+		for _, tc := range []struct {
+			body   string
+			name   string
+			reason string
+		}{
+			{`{"amount":10}`, "amount", "must be string"},
+			{`{"types":"a"}`, "types", "must be array"},
+			{`{"amount":"10","foo":1}`, "foo", "is not supported"},
+		} {
+			err := validation.Decode(strings.NewReader(tc.body), &request{})
+			res, ok := err.(service.ErrorResponse)
+			h.Must(t, ok, "got error %#v, want service.ErrorResponse", err)
+			h.MustE(t, res.StatusCode(), 422, "got status code %d, want %d")
+			h.MustE(t, len(res.InvalidParameters), 1, "got %d invalid parameters, want %d")
+			h.MustE(t, res.InvalidParameters[0], service.InvalidParameter{Name: tc.name, Reason: tc.reason}, "got invalid parameter %v, want %v")
+		}
+	})
+	t.Run("returns 422 if the body is not a single JSON object", func(t *testing.T) {
+		for _, body := range []string{"", "foo", "[]", `"amount"`, `{"amount":"1"} {}`, `{"amount":"1"`} {
+			err := validation.Decode(strings.NewReader(body), &request{})
+			res, ok := err.(service.ErrorResponse)
+			h.Must(t, ok, "got error %#v for body %q, want service.ErrorResponse", err, body)
+			h.MustE(t, res.StatusCode(), 422, "got status code %d, want %d")
+		}
+	})
+}
+
+func TestDecodeOptional(t *testing.T) {
+	t.Run("ignores empty body", func(t *testing.T) {
+		req := struct {
+			Currency string `json:"currency"`
+		}{}
+		h.MustNotErr(t, validation.DecodeOptional(strings.NewReader(" \n"), &req), "got validation.DecodeOptional() %v; want nil")
+		h.MustNotErr(t, validation.DecodeOptional(nil, &req), "got validation.DecodeOptional() %v; want nil")
+	})
+	t.Run("decodes body strictly", func(t *testing.T) {
+		req := struct {
+			Currency string `json:"currency"`
+		}{}
+		h.MustNotErr(t, validation.DecodeOptional(strings.NewReader(`{"currency":"EUR"}`), &req), "got validation.DecodeOptional() %v; want nil")
+		h.MustE(t, req.Currency, "EUR", "got currency %q, want %q")
+		h.MustErr(t, validation.DecodeOptional(strings.NewReader(`{"foo":"EUR"}`), &req), "got validation.DecodeOptional() nil; want error")
+	})
+}
@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Dispatcher schedules the deliveries of the events to the webhooks of their merchants.
+// It has the method of outbox.Publisher, so it can publish the events of the outbox.
+type Dispatcher struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewDispatcher returns new dispatcher, which saves the deliveries in s.
+func NewDispatcher(s Store) *Dispatcher {
+	return &Dispatcher{s, time.Now}
+}
+
+// Publish schedules the delivery of the event of m to each webhook of its merchant, which subscribes
+// to its type. The events, which are not events of merchants, are ignored. The deliveries are
+// scheduled once per webhook, so m can be published again.
+func (d *Dispatcher) Publish(ctx context.Context, m event.Message) error {
+	if !IsEventType(m.Type) {
+		return nil
+	}
+	e, err := event.Unmarshal(m)
+	if err != nil {
+		return fmt.Errorf("Publish() cannot unmarshal event; %v", err)
+	}
+	merchantUUID, ok := merchantOf(e)
+	if !ok {
+		return nil
+	}
+	webhooks, err := d.store.ListWebhooks(ctx, merchantUUID)
+	if err != nil {
+		return fmt.Errorf("Publish() cannot list webhooks; %v", err)
+	}
+	var body []byte
+	for _, w := range webhooks {
+		if !w.Subscribes(m.Type) {
+			continue
+		}
+		if body == nil {
+			if body, err = newPayload(m); err != nil {
+				return fmt.Errorf("Publish() %v", err)
+			}
+		}
+		id, err := uuid.NewV4()
+		if err != nil {
+			return fmt.Errorf("Publish() cannot generate delivery UUID; %v", err)
+		}
+		now := d.now()
+		err = d.store.SaveDelivery(ctx, Delivery{
+			UUID:          id,
+			WebhookUUID:   w.UUID,
+			EventUUID:     m.UUID,
+			EventType:     m.Type,
+			Payload:       body,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return fmt.Errorf("Publish() cannot save delivery; %v", err)
+		}
+	}
+	return nil
+}
+
+// The default settings of Sender.
+const (
+	DefaultInterval    = time.Second
+	DefaultBatchSize   = 20
+	DefaultMaxAttempts = 8
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Sender sends the pending deliveries to the endpoints of their webhooks.
+type Sender struct {
+	store  Store
+	client *http.Client
+	logger *log.Logger
+
+	// Interval is the time between the polls of the pending deliveries.
+	Interval time.Duration
+	// BatchSize is the maximum number of deliveries sent per poll.
+	BatchSize int
+	// MaxAttempts is the number of attempts to send a delivery before it fails.
+	MaxAttempts int
+	// Backoff returns the delay before the next attempt after the number of failed attempts.
+	Backoff func(attempts int) time.Duration
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+// NewSender returns new sender, which sends the deliveries of s with client c and logs the errors with logger.
+// If c is nil, the requests time out after DefaultTimeout and the redirects are not followed, so a delivery cannot
+// be redirected to another host, and it fails with the 3xx status code.
+func NewSender(s Store, c *http.Client, logger *log.Logger) *Sender {
+	if c == nil {
+		c = &http.Client{
+			Timeout: DefaultTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return &Sender{
+		store:       s,
+		client:      c,
+		logger:      logger,
+		Interval:    DefaultInterval,
+		BatchSize:   DefaultBatchSize,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     service.ExponentialBackoff(time.Minute, 6*time.Hour),
+		Now:         time.Now,
+	}
+}
+
+// Run sends the pending deliveries every Interval until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	for {
+		if _, err := s.SendPending(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Printf("webhook: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// SendPending sends a batch of the pending deliveries, which are due, and returns the number of
+// the successful deliveries. The failed deliveries are retried after a backoff until MaxAttempts attempts.
+func (s *Sender) SendPending(ctx context.Context) (int, error) {
+	deliveries, err := s.store.PendingDeliveries(ctx, s.Now(), s.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	succeeded := 0
+	for _, d := range deliveries {
+		res, a := s.attempt(ctx, d)
+		if err := s.store.UpdateDelivery(ctx, res, a); err != nil {
+			return succeeded, err
+		}
+		if res.Status == DeliverySucceeded {
+			succeeded++
+		}
+	}
+	return succeeded, nil
+}
+
+// attempt sends d and returns d with the result of the attempt and the attempt.
+func (s *Sender) attempt(ctx context.Context, d PendingDelivery) (Delivery, Attempt) {
+	res := d.Delivery
+	res.Attempts++
+	a := Attempt{DeliveryUUID: d.UUID, Number: res.Attempts, AttemptedAt: s.Now()}
+	status, err := s.send(ctx, d)
+	res.ResponseStatus, a.ResponseStatus = status, status
+	if err == nil {
+		res.Status, a.Status = DeliverySucceeded, DeliverySucceeded
+		res.LastError = ""
+		res.DeliveredAt = s.Now()
+		return res, a
+	}
+	res.LastError, a.Error = err.Error(), err.Error()
+	a.Status = DeliveryFailed
+	if res.Attempts >= s.MaxAttempts {
+		s.logger.Printf("webhook: cannot deliver %s %s to %s after %d attempts: %v", d.EventType, d.EventUUID, d.URL, res.Attempts, err)
+		res.Status = DeliveryFailed
+		return res, a
+	}
+	res.NextAttemptAt = s.Now().Add(s.Backoff(res.Attempts))
+	return res, a
+}
+
+// send sends the request of d and returns the status code of the response.
+// It returns error if the request fails or the status code is not 2xx.
+func (s *Sender) send(ctx context.Context, d PendingDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("cannot create request; %v", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, d.UUID.String())
+	req.Header.Set(HeaderEventType, d.EventType)
+	req.Header.Set(HeaderSignature, Sign(d.Secret, s.Now(), d.Payload))
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("cannot send request; %v", err)
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("got status code %d, want 2xx", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
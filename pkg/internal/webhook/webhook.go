@@ -0,0 +1,213 @@
+// Package webhook delivers the events of the merchants to the endpoints, which they register.
+//
+// The events are matched to the webhooks of their merchants when they are published from the outbox,
+// and a delivery is scheduled for each matching webhook. The deliveries are sent as signed HTTP POST
+// requests and the failed deliveries are retried with exponential backoff.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+)
+
+// EventTypes are the types of the events, which are delivered to the webhooks of the merchants.
+var EventTypes = []string{
+	event.TypeAuthorizationRequestCreated,
+	event.TypeAuthorizationRequestIncremented,
+	event.TypeAuthorizationRequestReversed,
+	event.TypeAuthorizationRequestCaptured,
+	event.TypeAuthorizationRequestRefunded,
+	event.TypeAuthorizationRequestExpired,
+}
+
+// Webhook is an endpoint registered by a merchant.
+type Webhook struct {
+	UUID         uuid.UUID
+	MerchantUUID uuid.UUID
+	URL          string
+	// Secret is the key of the signatures of the deliveries.
+	Secret string
+	// EventTypes are the types of the delivered events. All EventTypes are delivered if empty.
+	EventTypes []string
+	CreatedAt  time.Time
+}
+
+// Subscribes reports whether the events of type t are delivered to w.
+func (w Webhook) Subscribes(t string) bool {
+	if len(w.EventTypes) == 0 {
+		return IsEventType(t)
+	}
+	for _, s := range w.EventTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEventType reports whether the events of type t can be delivered to webhooks.
+func IsEventType(t string) bool {
+	for _, s := range EventTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus is the status of a delivery.
+type DeliveryStatus string
+
+// The statuses of the deliveries.
+const (
+	// DeliveryPending is the status of a delivery, which is not sent yet or it is retried.
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySucceeded is the status of a delivery, which the endpoint accepted with 2xx status code.
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryFailed is the status of a delivery, which is not retried after the last failed attempt.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Delivery is the delivery of an event to a webhook.
+type Delivery struct {
+	UUID        uuid.UUID
+	WebhookUUID uuid.UUID
+	EventUUID   uuid.UUID
+	EventType   string
+	// Payload is the body of the requests.
+	Payload []byte
+	Status  DeliveryStatus
+	// Attempts is the number of the attempts to send the delivery.
+	Attempts int
+	// ResponseStatus is the status code of the response to the last attempt or 0 if there was no response.
+	ResponseStatus int
+	// LastError is the reason of the last failed attempt.
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	// DeliveredAt is the time of the successful attempt or zero if the delivery has not succeeded.
+	DeliveredAt time.Time
+}
+
+// Attempt is an attempt to send a delivery, which is recorded in the history of the delivery.
+type Attempt struct {
+	DeliveryUUID uuid.UUID
+	// Number is the number of the attempt starting with 1.
+	Number int
+	// Status is DeliverySucceeded if the endpoint accepted the attempt, otherwise DeliveryFailed.
+	Status DeliveryStatus
+	// ResponseStatus is the status code of the response or 0 if there was no response.
+	ResponseStatus int
+	// Error is the reason of the failed attempt.
+	Error       string
+	AttemptedAt time.Time
+}
+
+// PendingDelivery is a delivery, which is due, with the endpoint of its webhook.
+type PendingDelivery struct {
+	Delivery
+	URL    string
+	Secret string
+}
+
+// Store is interface for persistence of webhooks and their deliveries.
+type Store interface {
+	// SaveWebhook persists w.
+	SaveWebhook(ctx context.Context, w Webhook) error
+	// GetWebhook returns the webhook with UUID id. It must return service.ErrNotFound if the webhook does not exist.
+	GetWebhook(ctx context.Context, id uuid.UUID) (Webhook, error)
+	// ListWebhooks returns the webhooks of the merchant with UUID merchantUUID.
+	ListWebhooks(ctx context.Context, merchantUUID uuid.UUID) ([]Webhook, error)
+	// SaveDelivery persists new delivery d. It must ignore d if the event of d is already
+	// scheduled for delivery to the webhook of d.
+	SaveDelivery(ctx context.Context, d Delivery) error
+	// UpdateDelivery saves the status and the attempts of d and appends a, the last attempt of d,
+	// to the history of d.
+	UpdateDelivery(ctx context.Context, d Delivery, a Attempt) error
+	// ListDeliveries returns up to limit deliveries of the webhook with UUID id from the newest to the oldest.
+	ListDeliveries(ctx context.Context, id uuid.UUID, limit int) ([]Delivery, error)
+	// ListAttempts returns the history of the delivery with UUID id from the first to the last attempt.
+	ListAttempts(ctx context.Context, id uuid.UUID) ([]Attempt, error)
+	// PendingDeliveries returns up to limit pending deliveries, which are due at now, from the oldest to the newest.
+	PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]PendingDelivery, error)
+}
+
+// The headers of the delivery requests.
+const (
+	// HeaderID is the header with the UUID of the delivery, which is the same for its retries.
+	HeaderID = "Webhook-Id"
+	// HeaderEventType is the header with the type of the delivered event.
+	HeaderEventType = "Webhook-Event-Type"
+	// HeaderSignature is the header with the timestamp and the signature of the request,
+	// e.g. "t=1546300800,v1=5257a8...".
+	HeaderSignature = "Webhook-Signature"
+)
+
+// Sign returns the value of HeaderSignature for payload sent at t, which is signed with secret.
+// The signature is hex-encoded HMAC-SHA256 of the Unix timestamp of t, ".", and payload.
+func Sign(secret string, t time.Time, payload []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, signature(secret, ts, payload))
+}
+
+func signature(secret, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// payload is the body of the delivery requests.
+type payload struct {
+	UUID          uuid.UUID       `json:"uuid"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schemaVersion"`
+	Time          time.Time       `json:"time"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// newPayload returns the body of the delivery requests of the event of m.
+func newPayload(m event.Message) ([]byte, error) {
+	b, err := json.Marshal(payload{
+		UUID:          m.UUID,
+		Type:          m.Type,
+		SchemaVersion: m.SchemaVersion,
+		Time:          m.Time,
+		Data:          json.RawMessage(m.Payload),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode payload of %s; %v", m.Type, err)
+	}
+	return b, nil
+}
+
+// merchantOf returns the UUID of the merchant of event e.
+// It returns false if e is not an event of a merchant.
+func merchantOf(e interface{}) (uuid.UUID, bool) {
+	switch e := e.(type) {
+	case event.AuthorizationRequestCreated:
+		return e.MerchantUUID, true
+	case event.AuthorizationRequestIncremented:
+		return e.MerchantUUID, true
+	case event.AuthorizationRequestReversed:
+		return e.MerchantUUID, true
+	case event.AuthorizationRequestCaptured:
+		return e.MerchantUUID, true
+	case event.AuthorizationRequestRefunded:
+		return e.MerchantUUID, true
+	case event.AuthorizationRequestExpired:
+		return e.MerchantUUID, true
+	}
+	return uuid.Nil, false
+}
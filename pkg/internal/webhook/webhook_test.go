@@ -0,0 +1,222 @@
+// +build !integration
+
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+)
+
+func TestSign(t *testing.T) {
+	got := webhook.Sign("secret", time.Unix(1546300800, 0), []byte(`{"foo":"bar"}`))
+	h.MustE(t, got, "t=1546300800,v1=0b06fc696818b4e3720c01b255411fe74178cfa92961eff7c2ef77b83e5d2cb6", "got signature %q, want %q")
+	h.Must(t, got != webhook.Sign("other", time.Unix(1546300800, 0), []byte(`{"foo":"bar"}`)), "got the same signature with different secret")
+	h.Must(t, got != webhook.Sign("secret", time.Unix(1546300801, 0), []byte(`{"foo":"bar"}`)), "got the same signature at different time")
+	h.Must(t, got != webhook.Sign("secret", time.Unix(1546300800, 0), []byte(`{"foo":"baz"}`)), "got the same signature of different payload")
+}
+
+func TestWebhook_Subscribes(t *testing.T) {
+	all := webhook.Webhook{}
+	for _, typ := range webhook.EventTypes {
+		h.Must(t, all.Subscribes(typ), "got Subscribes(%q) = false, want true", typ)
+	}
+	h.Must(t, !all.Subscribes(event.TypeCardCreated), "got Subscribes(%q) = true, want false", event.TypeCardCreated)
+	captured := webhook.Webhook{EventTypes: []string{event.TypeAuthorizationRequestCaptured}}
+	h.Must(t, captured.Subscribes(event.TypeAuthorizationRequestCaptured), "got Subscribes() = false for subscribed type, want true")
+	h.Must(t, !captured.Subscribes(event.TypeAuthorizationRequestRefunded), "got Subscribes() = true for other type, want false")
+}
+
+func TestDispatcher_Publish(t *testing.T) {
+	merchant := uuid.Must(uuid.NewV4())
+	newRepository := func() *h.Repository {
+		return &h.Repository{Webhooks: []webhook.Webhook{
+			{UUID: uuid.Must(uuid.NewV4()), MerchantUUID: merchant, URL: "http://example.com/all"},
+			{UUID: uuid.Must(uuid.NewV4()), MerchantUUID: merchant, URL: "http://example.com/refunds", EventTypes: []string{event.TypeAuthorizationRequestRefunded}},
+			{UUID: uuid.Must(uuid.NewV4()), MerchantUUID: uuid.Must(uuid.NewV4()), URL: "http://example.com/other"},
+		}}
+	}
+	e := event.AuthorizationRequestCaptured{
+		UUID:                     uuid.Must(uuid.NewV4()),
+		Time:                     time.Now().UTC(),
+		AuthorizationRequestUUID: uuid.Must(uuid.NewV4()),
+		CardUUID:                 uuid.Must(uuid.NewV4()),
+		MerchantUUID:             merchant,
+		Amount:                   100,
+	}
+
+	t.Run("schedules the deliveries to the subscribed webhooks of the merchant", func(t *testing.T) {
+		r := newRepository()
+		m := mustMarshal(t, e)
+		h.MustNotErr(t, webhook.NewDispatcher(r).Publish(context.Background(), m), "got Publish() error %v, want nil")
+		h.MustE(t, len(r.Deliveries), 1, "got %d deliveries, want %d")
+		d := r.Deliveries[0]
+		h.MustE(t, d.WebhookUUID, r.Webhooks[0].UUID, "got webhook UUID %v, want %v")
+		h.MustE(t, d.EventUUID, e.UUID, "got event UUID %v, want %v")
+		h.MustE(t, d.EventType, event.TypeAuthorizationRequestCaptured, "got event type %q, want %q")
+		h.MustE(t, d.Status, webhook.DeliveryPending, "got status %q, want %q")
+		body := struct {
+			UUID uuid.UUID                          `json:"uuid"`
+			Type string                             `json:"type"`
+			Data event.AuthorizationRequestCaptured `json:"data"`
+		}{}
+		h.MustNotErr(t, json.Unmarshal(d.Payload, &body), "got payload error %v, want nil")
+		h.MustE(t, body.UUID, e.UUID, "got payload UUID %v, want %v")
+		h.MustE(t, body.Type, event.TypeAuthorizationRequestCaptured, "got payload type %q, want %q")
+		h.MustE(t, body.Data.AuthorizationRequestUUID, e.AuthorizationRequestUUID, "got payload authorization request UUID %v, want %v")
+	})
+	t.Run("schedules the deliveries once if the event is published again", func(t *testing.T) {
+		r := newRepository()
+		d := webhook.NewDispatcher(r)
+		m := mustMarshal(t, e)
+		h.MustNotErr(t, d.Publish(context.Background(), m), "got Publish() error %v, want nil")
+		h.MustNotErr(t, d.Publish(context.Background(), m), "got Publish() error %v, want nil")
+		h.MustE(t, len(r.Deliveries), 1, "got %d deliveries, want %d")
+	})
+	t.Run("ignores the events of cards", func(t *testing.T) {
+		r := newRepository()
+		m := mustMarshal(t, event.CardCreated{UUID: uuid.Must(uuid.NewV4()), CardUUID: uuid.Must(uuid.NewV4())})
+		h.MustNotErr(t, webhook.NewDispatcher(r).Publish(context.Background(), m), "got Publish() error %v, want nil")
+		h.MustE(t, len(r.Deliveries), 0, "got %d deliveries, want %d")
+	})
+	t.Run("returns error if the store returns error", func(t *testing.T) {
+		r := newRepository()
+		r.Err = errors.New("test store failed")
+		err := webhook.NewDispatcher(r).Publish(context.Background(), mustMarshal(t, e))
+		h.MustErr(t, err, "got Publish() nil error, want error")
+	})
+}
+
+func TestSender_SendPending(t *testing.T) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	logger := log.New(ioutil.Discard, "", 0)
+	newRepository := func(url string, attempts int) *h.Repository {
+		w := webhook.Webhook{UUID: uuid.Must(uuid.NewV4()), URL: url, Secret: "0123456789abcdef"}
+		return &h.Repository{
+			Webhooks: []webhook.Webhook{w},
+			Deliveries: []webhook.Delivery{{
+				UUID:          uuid.Must(uuid.NewV4()),
+				WebhookUUID:   w.UUID,
+				EventUUID:     uuid.Must(uuid.NewV4()),
+				EventType:     event.TypeAuthorizationRequestCaptured,
+				Payload:       []byte(`{"foo":"bar"}`),
+				Status:        webhook.DeliveryPending,
+				Attempts:      attempts,
+				NextAttemptAt: now,
+			}},
+		}
+	}
+
+	t.Run("sends the signed delivery and records the response status", func(t *testing.T) {
+		var req *http.Request
+		var body []byte
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req = r
+			body, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer srv.Close()
+		r := newRepository(srv.URL, 0)
+		s := webhook.NewSender(r, nil, logger)
+		s.Now = func() time.Time { return now }
+		n, err := s.SendPending(context.Background())
+		h.MustNotErr(t, err, "got SendPending() error %v, want nil")
+		h.MustE(t, n, 1, "got %d successful deliveries, want %d")
+		d := r.Deliveries[0]
+		h.MustE(t, req.Method, http.MethodPost, "got method %q, want %q")
+		h.MustE(t, string(body), `{"foo":"bar"}`, "got body %q, want %q")
+		h.MustE(t, req.Header.Get(webhook.HeaderID), d.UUID.String(), "got delivery ID %q, want %q")
+		h.MustE(t, req.Header.Get(webhook.HeaderEventType), d.EventType, "got event type %q, want %q")
+		h.MustE(t, req.Header.Get(webhook.HeaderSignature), webhook.Sign("0123456789abcdef", now, body), "got signature %q, want %q")
+		h.MustE(t, d.Status, webhook.DeliverySucceeded, "got status %q, want %q")
+		h.MustE(t, d.Attempts, 1, "got %d attempts, want %d")
+		h.MustE(t, d.ResponseStatus, http.StatusAccepted, "got response status %d, want %d")
+		h.Must(t, d.DeliveredAt.Equal(now), "got delivery time %v, want %v", d.DeliveredAt, now)
+		h.MustE(t, len(r.Attempts), 1, "got %d attempts in the history, want %d")
+		a := r.Attempts[0]
+		h.MustE(t, a.DeliveryUUID, d.UUID, "got attempt of delivery %v, want %v")
+		h.MustE(t, a.Number, 1, "got attempt number %d, want %d")
+		h.MustE(t, a.Status, webhook.DeliverySucceeded, "got attempt status %q, want %q")
+		h.MustE(t, a.ResponseStatus, http.StatusAccepted, "got attempt response status %d, want %d")
+		h.Must(t, a.AttemptedAt.Equal(now), "got attempt time %v, want %v", a.AttemptedAt, now)
+	})
+	t.Run("retries the delivery after backoff if the endpoint fails", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer srv.Close()
+		r := newRepository(srv.URL, 1)
+		s := webhook.NewSender(r, nil, logger)
+		s.Now = func() time.Time { return now }
+		s.Backoff = func(attempts int) time.Duration { return time.Duration(attempts) * time.Minute }
+		n, err := s.SendPending(context.Background())
+		h.MustNotErr(t, err, "got SendPending() error %v, want nil")
+		h.MustE(t, n, 0, "got %d successful deliveries, want %d")
+		d := r.Deliveries[0]
+		h.MustE(t, d.Status, webhook.DeliveryPending, "got status %q, want %q")
+		h.MustE(t, d.Attempts, 2, "got %d attempts, want %d")
+		h.MustE(t, d.ResponseStatus, http.StatusInternalServerError, "got response status %d, want %d")
+		h.Must(t, strings.Contains(d.LastError, "500"), "got last error %q, want status code", d.LastError)
+		h.Must(t, d.NextAttemptAt.Equal(now.Add(2*time.Minute)), "got next attempt %v, want %v", d.NextAttemptAt, now.Add(2*time.Minute))
+		h.MustE(t, len(r.Attempts), 1, "got %d attempts in the history, want %d")
+		h.MustE(t, r.Attempts[0].Number, 2, "got attempt number %d, want %d")
+		h.MustE(t, r.Attempts[0].Status, webhook.DeliveryFailed, "got attempt status %q, want %q")
+		h.MustE(t, r.Attempts[0].ResponseStatus, http.StatusInternalServerError, "got attempt response status %d, want %d")
+		h.MustE(t, r.Attempts[0].Error, d.LastError, "got attempt error %q, want %q")
+
+		_, err = s.SendPending(context.Background())
+		h.MustNotErr(t, err, "got SendPending() error %v, want nil")
+		h.MustE(t, r.Deliveries[0].Attempts, 2, "got %d attempts before the backoff, want %d")
+	})
+	t.Run("does not follow redirects", func(t *testing.T) {
+		var redirected bool
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			redirected = true
+		}))
+		defer target.Close()
+		srv := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+		defer srv.Close()
+		r := newRepository(srv.URL, 0)
+		n, err := webhook.NewSender(r, nil, logger).SendPending(context.Background())
+		h.MustNotErr(t, err, "got SendPending() error %v, want nil")
+		h.MustE(t, n, 0, "got %d successful deliveries, want %d")
+		h.Must(t, !redirected, "got redirected delivery, want none")
+		d := r.Deliveries[0]
+		h.MustE(t, d.Status, webhook.DeliveryPending, "got status %q, want %q")
+		h.MustE(t, d.ResponseStatus, http.StatusTemporaryRedirect, "got response status %d, want %d")
+	})
+	t.Run("fails the delivery after the last attempt", func(t *testing.T) {
+		r := newRepository("http://127.0.0.1:1", webhook.DefaultMaxAttempts-1)
+		s := webhook.NewSender(r, nil, logger)
+		_, err := s.SendPending(context.Background())
+		h.MustNotErr(t, err, "got SendPending() error %v, want nil")
+		d := r.Deliveries[0]
+		h.MustE(t, d.Status, webhook.DeliveryFailed, "got status %q, want %q")
+		h.MustE(t, d.Attempts, webhook.DefaultMaxAttempts, "got %d attempts, want %d")
+		h.MustE(t, d.ResponseStatus, 0, "got response status %d, want %d")
+		h.Must(t, len(d.LastError) > 0, "got empty last error, want the reason")
+		h.MustE(t, len(r.Attempts), 1, "got %d attempts in the history, want %d")
+		h.MustE(t, r.Attempts[0].Status, webhook.DeliveryFailed, "got attempt status %q, want %q")
+		h.MustE(t, r.Attempts[0].ResponseStatus, 0, "got attempt response status %d, want %d")
+	})
+}
+
+func mustMarshal(t *testing.T, e interface{}) event.Message {
+	t.Helper()
+	m, err := event.Marshal(e)
+	h.MustNotErr(t, err, "got Marshal() error %v, want nil")
+	return m
+}
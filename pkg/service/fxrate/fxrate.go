@@ -0,0 +1,76 @@
+// Package fxrate provides exchange rates for conversion of authorization requests.
+package fxrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+)
+
+type pair struct {
+	from model.Currency
+	to   model.Currency
+}
+
+// Table is a static table of exchange rates, which is suitable for tests and offline use.
+// When a currency pair is not in the table, the inverse of the opposite pair is used.
+type Table struct {
+	rates map[pair]model.FXRate
+}
+
+// Ensure Table implements model.FXRateProvider.
+var _ model.FXRateProvider = &Table{}
+
+// New returns new table of rates. The keys of rates are currency pairs like "EUR/GBP" and
+// the values are decimal numbers like "0.8523", which means 1 EUR is 0.8523 GBP.
+func New(rates map[string]string) (*Table, error) {
+	t := &Table{make(map[pair]model.FXRate, len(rates))}
+	for k, v := range rates {
+		codes := strings.Split(k, "/")
+		if len(codes) != 2 {
+			return &Table{}, fmt.Errorf("currency pair %q must be formatted as FROM/TO", k)
+		}
+		from, err := model.ParseCurrency(codes[0])
+		if err != nil {
+			return &Table{}, fmt.Errorf("invalid currency pair %q: %v", k, err)
+		}
+		to, err := model.ParseCurrency(codes[1])
+		if err != nil {
+			return &Table{}, fmt.Errorf("invalid currency pair %q: %v", k, err)
+		}
+		rate, err := model.NewFXRate(from, to, v)
+		if err != nil {
+			return &Table{}, fmt.Errorf("invalid rate of currency pair %q: %v", k, err)
+		}
+		t.rates[pair{from, to}] = rate
+	}
+	return t, nil
+}
+
+// Load returns new table of the rates in the JSON file with path.
+// The file has an object with the format of the rates of New, e.g. {"EUR/GBP": "0.8523"}.
+func Load(path string) (*Table, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &Table{}, fmt.Errorf("cannot read rates: %v", err)
+	}
+	rates := map[string]string{}
+	if err := json.Unmarshal(b, &rates); err != nil {
+		return &Table{}, fmt.Errorf("cannot decode rates: %v", err)
+	}
+	return New(rates)
+}
+
+// FXRate implements model.FXRateProvider.
+func (t *Table) FXRate(from, to model.Currency) (model.FXRate, error) {
+	if rate, ok := t.rates[pair{from, to}]; ok {
+		return rate, nil
+	}
+	if rate, ok := t.rates[pair{to, from}]; ok {
+		return rate.Inverse()
+	}
+	return model.FXRate{}, fmt.Errorf("rate %s/%s not found", from, to)
+}
@@ -0,0 +1,67 @@
+// +build !integration
+
+package fxrate_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/service/fxrate"
+)
+
+func TestTable_FXRate(t *testing.T) {
+	tbl, err := fxrate.New(map[string]string{"EUR/GBP": "0.8", "usd/jpy": "150.25"})
+	h.MustNotErr(t, err, "got fxrate.New() error %v, want nil")
+	for _, tc := range []struct {
+		from, to model.Currency
+		want     string
+	}{
+		{model.EUR, model.GBP, "0.8"},
+		{model.GBP, model.EUR, "1.25"},
+		{model.USD, model.JPY, "150.25"},
+		{model.JPY, model.USD, "0.006655574"},
+	} {
+		rate, err := tbl.FXRate(tc.from, tc.to)
+		h.MustNotErr(t, err, "got FXRate() error %v, want nil")
+		h.MustE(t, rate.From(), tc.from, "got from %v, want %v")
+		h.MustE(t, rate.To(), tc.to, "got to %v, want %v")
+		h.MustE(t, rate.Rate(), tc.want, "got rate %q, want %q")
+	}
+	_, err = tbl.FXRate(model.CHF, model.GBP)
+	h.MustErr(t, err, "got FXRate() nil error for unknown pair, want error")
+}
+
+func TestNew(t *testing.T) {
+	for _, rates := range []map[string]string{
+		{"EURGBP": "0.8"},
+		{"EUR/XXX": "0.8"},
+		{"XXX/GBP": "0.8"},
+		{"EUR/GBP": "foo"},
+		{"EUR/GBP": "0"},
+		{"EUR/GBP": "-1"},
+	} {
+		_, err := fxrate.New(rates)
+		h.MustErr(t, err, "got fxrate.New() nil error for %v, want error", rates)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	f, err := ioutil.TempFile("", "fxrate")
+	h.MustNotErr(t, err, "%v")
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"EUR/GBP": "0.8523"}`)
+	h.MustNotErr(t, err, "%v")
+	h.MustNotErr(t, f.Close(), "%v")
+
+	tbl, err := fxrate.Load(f.Name())
+	h.MustNotErr(t, err, "got fxrate.Load() error %v, want nil")
+	rate, err := tbl.FXRate(model.EUR, model.GBP)
+	h.MustNotErr(t, err, "got FXRate() error %v, want nil")
+	h.MustE(t, rate.Rate(), "0.8523", "got rate %q, want %q")
+
+	_, err = fxrate.Load(f.Name() + ".missing")
+	h.MustErr(t, err, "got fxrate.Load() nil error for missing file, want error")
+}
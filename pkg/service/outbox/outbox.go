@@ -0,0 +1,164 @@
+// Package outbox contains the relay, which publishes the events saved in the outbox.
+//
+// The events are saved in the outbox in the same database transaction as the changes,
+// which caused them, so an event is published if and only if its changes are committed.
+// The events are published at least once, so the subscribers must tolerate duplicates,
+// e.g. by ignoring the events with known UUIDs. The events, which cannot be published
+// after MaxAttempts attempts, are moved to dead-letter state and they are no longer retried.
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// Message is a serialized event with its type name and schema version.
+type Message = event.Message
+
+// PendingMessage is a message, which is not published yet.
+type PendingMessage struct {
+	Message
+	// Attempts is the number of the failed attempts to publish the message.
+	Attempts int
+}
+
+// Publisher is interface for publishing messages, e.g. to a message broker.
+type Publisher interface {
+	Publish(context.Context, Message) error
+}
+
+// PublisherFunc is an adapter to allow the use of ordinary functions as Publisher.
+type PublisherFunc func(context.Context, Message) error
+
+// Publish calls f(ctx, m).
+func (f PublisherFunc) Publish(ctx context.Context, m Message) error {
+	return f(ctx, m)
+}
+
+// MultiPublisher returns publisher, which publishes the messages with each of publishers in order.
+// It stops at the first error, so the message is published again with all publishers.
+func MultiPublisher(publishers ...Publisher) Publisher {
+	return PublisherFunc(func(ctx context.Context, m Message) error {
+		for _, p := range publishers {
+			if err := p.Publish(ctx, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Store is interface for the messages of the outbox.
+type Store interface {
+	// PendingMessages returns up to limit pending messages, which are due at now, in the order they were saved.
+	PendingMessages(ctx context.Context, now time.Time, limit int) ([]PendingMessage, error)
+	// MarkMessageSent marks the message with the UUID as published.
+	MarkMessageSent(ctx context.Context, id uuid.UUID, at time.Time) error
+	// MarkMessageFailed records a failed attempt to publish the message with the UUID
+	// and postpones the next attempt until retryAt.
+	MarkMessageFailed(ctx context.Context, id uuid.UUID, retryAt time.Time, reason string) error
+	// MarkMessageDead records the last failed attempt to publish the message with the UUID
+	// and moves it to dead-letter state.
+	MarkMessageDead(ctx context.Context, id uuid.UUID, reason string) error
+}
+
+// The default settings of Relay.
+const (
+	DefaultInterval    = time.Second
+	DefaultBatchSize   = 100
+	DefaultMaxAttempts = 10
+)
+
+// Relay publishes the pending messages of the outbox.
+type Relay struct {
+	store     Store
+	publisher Publisher
+	logger    *log.Logger
+
+	// Interval is the time between the polls of the outbox.
+	Interval time.Duration
+	// BatchSize is the maximum number of messages published per poll.
+	BatchSize int
+	// MaxAttempts is the number of attempts to publish a message before it is moved to dead-letter state.
+	MaxAttempts int
+	// Backoff returns the delay before the next attempt after the number of failed attempts.
+	Backoff func(attempts int) time.Duration
+	// Now returns the current time.
+	Now func() time.Time
+}
+
+// NewRelay returns new relay, which publishes the messages of s with p and logs the errors with logger.
+func NewRelay(s Store, p Publisher, logger *log.Logger) *Relay {
+	return &Relay{
+		store:       s,
+		publisher:   p,
+		logger:      logger,
+		Interval:    DefaultInterval,
+		BatchSize:   DefaultBatchSize,
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     service.ExponentialBackoff(time.Second, time.Hour),
+		Now:         time.Now,
+	}
+}
+
+// Run relays the pending messages every Interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		if _, err := r.RelayPending(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Printf("outbox: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// RelayPending publishes a batch of the pending messages, which are due, and returns the number of
+// the published messages. The failed messages are retried after a backoff or moved to dead-letter state
+// after MaxAttempts attempts.
+func (r *Relay) RelayPending(ctx context.Context) (int, error) {
+	messages, err := r.store.PendingMessages(ctx, r.Now(), r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	published := 0
+	for _, m := range messages {
+		if err := r.publisher.Publish(ctx, m.Message); err != nil {
+			if err := r.fail(ctx, m, err); err != nil {
+				return published, err
+			}
+			continue
+		}
+		if err := r.store.MarkMessageSent(ctx, m.UUID, r.Now()); err != nil {
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// fail records the failed attempt to publish m with error err.
+func (r *Relay) fail(ctx context.Context, m PendingMessage, err error) error {
+	attempts := m.Attempts + 1
+	if attempts >= r.MaxAttempts {
+		r.logger.Printf("outbox: cannot publish %s %s after %d attempts, moving it to dead-letter state: %v", m.Type, m.UUID, attempts, err)
+		return r.store.MarkMessageDead(ctx, m.UUID, err.Error())
+	}
+	return r.store.MarkMessageFailed(ctx, m.UUID, r.Now().Add(r.Backoff(attempts)), err.Error())
+}
+
+// LogPublisher returns publisher, which writes the messages to logger.
+func LogPublisher(logger *log.Logger) Publisher {
+	return PublisherFunc(func(_ context.Context, m Message) error {
+		logger.Printf("event %s %s v%d: %s", m.Type, m.UUID, m.SchemaVersion, m.Payload)
+		return nil
+	})
+}
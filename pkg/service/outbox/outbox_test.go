@@ -0,0 +1,120 @@
+// +build !integration
+
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	h "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/service/outbox"
+)
+
+func TestRelay_RelayPending(t *testing.T) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	logger := log.New(ioutil.Discard, "", 0)
+
+	t.Run("publishes the messages and marks them sent", func(t *testing.T) {
+		s := newStore(2, 0)
+		published := []uuid.UUID{}
+		r := outbox.NewRelay(s, outbox.PublisherFunc(func(_ context.Context, m outbox.Message) error {
+			published = append(published, m.UUID)
+			return nil
+		}), logger)
+		r.Now = func() time.Time { return now }
+		n, err := r.RelayPending(context.Background())
+		h.MustNotErr(t, err, "got RelayPending() error %v, want nil")
+		h.MustE(t, n, 2, "got %d published messages, want %d")
+		h.MustE(t, len(published), 2, "got %d published messages, want %d")
+		h.MustE(t, published[0], s.messages[0].UUID, "got first published message %v, want %v")
+		h.MustE(t, len(s.sent), 2, "got %d sent messages, want %d")
+		h.Must(t, s.sent[s.messages[1].UUID].Equal(now), "got sent time %v, want %v", s.sent[s.messages[1].UUID], now)
+	})
+	t.Run("postpones the message after a failed attempt", func(t *testing.T) {
+		s := newStore(1, 2)
+		r := outbox.NewRelay(s, outbox.PublisherFunc(func(context.Context, outbox.Message) error {
+			return errors.New("test publish failed")
+		}), logger)
+		r.Now = func() time.Time { return now }
+		r.Backoff = func(attempts int) time.Duration { return time.Duration(attempts) * time.Minute }
+		n, err := r.RelayPending(context.Background())
+		h.MustNotErr(t, err, "got RelayPending() error %v, want nil")
+		h.MustE(t, n, 0, "got %d published messages, want %d")
+		retryAt, ok := s.failed[s.messages[0].UUID]
+		h.Must(t, ok, "got no failed message, want one")
+		h.Must(t, retryAt.Equal(now.Add(3*time.Minute)), "got retry time %v, want %v", retryAt, now.Add(3*time.Minute))
+		h.MustE(t, len(s.dead), 0, "got %d dead messages, want %d")
+	})
+	t.Run("moves the message to dead-letter state after the last attempt", func(t *testing.T) {
+		s := newStore(1, outbox.DefaultMaxAttempts-1)
+		r := outbox.NewRelay(s, outbox.PublisherFunc(func(context.Context, outbox.Message) error {
+			return errors.New("test publish failed")
+		}), logger)
+		_, err := r.RelayPending(context.Background())
+		h.MustNotErr(t, err, "got RelayPending() error %v, want nil")
+		h.MustE(t, s.dead[s.messages[0].UUID], "test publish failed", "got dead-letter reason %q, want %q")
+		h.MustE(t, len(s.failed), 0, "got %d failed messages, want %d")
+	})
+	t.Run("returns error if the store returns error", func(t *testing.T) {
+		s := newStore(1, 0)
+		s.err = errors.New("test store failed")
+		r := outbox.NewRelay(s, outbox.LogPublisher(logger), logger)
+		_, err := r.RelayPending(context.Background())
+		h.MustErr(t, err, "got RelayPending() nil error, want error")
+	})
+}
+
+// store is outbox.Store, which records the changes of the messages.
+type store struct {
+	messages []outbox.PendingMessage
+	sent     map[uuid.UUID]time.Time
+	failed   map[uuid.UUID]time.Time
+	dead     map[uuid.UUID]string
+	err      error
+}
+
+func newStore(n, attempts int) *store {
+	s := &store{
+		sent:   map[uuid.UUID]time.Time{},
+		failed: map[uuid.UUID]time.Time{},
+		dead:   map[uuid.UUID]string{},
+	}
+	for i := 0; i < n; i++ {
+		m := outbox.PendingMessage{Attempts: attempts}
+		m.UUID = uuid.Must(uuid.NewV4())
+		m.Type = "CardCreated"
+		s.messages = append(s.messages, m)
+	}
+	return s
+}
+
+func (s *store) PendingMessages(_ context.Context, _ time.Time, limit int) ([]outbox.PendingMessage, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(s.messages) > limit {
+		return s.messages[:limit], nil
+	}
+	return s.messages, nil
+}
+
+func (s *store) MarkMessageSent(_ context.Context, id uuid.UUID, at time.Time) error {
+	s.sent[id] = at
+	return nil
+}
+
+func (s *store) MarkMessageFailed(_ context.Context, id uuid.UUID, retryAt time.Time, _ string) error {
+	s.failed[id] = retryAt
+	return nil
+}
+
+func (s *store) MarkMessageDead(_ context.Context, id uuid.UUID, reason string) error {
+	s.dead[id] = reason
+	return nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+)
+
+// The errors returned by Repository. The errors other than ErrNotFound are
+// service.Error, which kind is one of them.
+var (
+	ErrConflict    = service.ErrConflict
+	ErrUnavailable = service.ErrUnavailable
+	ErrConstraint  = service.ErrConstraint
+)
+
+// The MySQL server error numbers, see https://dev.mysql.com/doc/refman/5.7/en/server-error-reference.html
+const (
+	erConCountError             = 1040
+	erBadNullError              = 1048
+	erServerShutdown            = 1053
+	erDupEntry                  = 1062
+	erHostIsBlocked             = 1129
+	erTooManyUserConnections    = 1203
+	erLockWaitTimeout           = 1205
+	erLockDeadlock              = 1213
+	erNoReferencedRow           = 1216
+	erRowIsReferenced           = 1217
+	erWarnDataOutOfRange        = 1264
+	erOptionPreventsStatement   = 1290
+	erTruncatedWrongValue       = 1292
+	erDataTooLong               = 1406
+	erRowIsReferenced2          = 1451
+	erNoReferencedRow2          = 1452
+	erDupEntryWithKeyName       = 1586
+	erReadOnlyMode              = 1836
+	erLockNowaitOrSkipLockedErr = 3572
+	erCheckConstraintViolated   = 3819
+)
+
+// kinds are the kinds of the errors with the MySQL error numbers.
+var kinds = map[uint16]error{
+	erConCountError:             ErrUnavailable,
+	erServerShutdown:            ErrUnavailable,
+	erHostIsBlocked:             ErrUnavailable,
+	erTooManyUserConnections:    ErrUnavailable,
+	erLockWaitTimeout:           ErrUnavailable,
+	erLockDeadlock:              ErrUnavailable,
+	erLockNowaitOrSkipLockedErr: ErrUnavailable,
+	erOptionPreventsStatement:   ErrUnavailable,
+	erReadOnlyMode:              ErrUnavailable,
+	erDupEntry:                  ErrConflict,
+	erDupEntryWithKeyName:       ErrConflict,
+	erNoReferencedRow:           ErrConstraint,
+	erRowIsReferenced:           ErrConstraint,
+	erRowIsReferenced2:          ErrConstraint,
+	erNoReferencedRow2:          ErrConstraint,
+	erBadNullError:              ErrConstraint,
+	erWarnDataOutOfRange:        ErrConstraint,
+	erTruncatedWrongValue:       ErrConstraint,
+	erDataTooLong:               ErrConstraint,
+	erCheckConstraintViolated:   ErrConstraint,
+}
+
+// classify returns the kind of err or nil if err cannot be classified.
+func classify(err error) error {
+	switch err {
+	case sql.ErrNoRows:
+		return ErrNotFound
+	case driver.ErrBadConn, sql.ErrConnDone, mysql.ErrInvalidConn:
+		return ErrUnavailable
+	}
+	switch err := err.(type) {
+	case *mysql.MySQLError:
+		return kinds[err.Number]
+	case net.Error:
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// newError returns err annotated with msg. The returned error is service.Error
+// if err can be classified.
+func newError(msg string, err error) error {
+	wrapped := fmt.Errorf("%s: %v", msg, err)
+	if kind := classify(err); kind != nil {
+		return service.NewError(kind, wrapped)
+	}
+	return wrapped
+}
@@ -0,0 +1,126 @@
+// +build !integration
+
+package repository_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofrs/uuid"
+
+	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	assert "github.com/sepetrov/prepaidcard/pkg/internal/testing"
+	"github.com/sepetrov/prepaidcard/pkg/service/repository"
+)
+
+func init() {
+	sql.Register("fakemysql", fakeDriver{})
+}
+
+func TestRepository_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  string
+		kind error
+	}{
+		{"duplicate entry", "1062", repository.ErrConflict},
+		{"duplicate entry with key name", "1586", repository.ErrConflict},
+		{"foreign key of child row", "1452", repository.ErrConstraint},
+		{"foreign key of parent row", "1451", repository.ErrConstraint},
+		{"column cannot be null", "1048", repository.ErrConstraint},
+		{"data too long", "1406", repository.ErrConstraint},
+		{"check constraint", "3819", repository.ErrConstraint},
+		{"too many connections", "1040", repository.ErrUnavailable},
+		{"server shutdown", "1053", repository.ErrUnavailable},
+		{"lock wait timeout", "1205", repository.ErrUnavailable},
+		{"deadlock", "1213", repository.ErrUnavailable},
+		{"read only", "1290", repository.ErrUnavailable},
+		{"bad connection", "badconn", repository.ErrUnavailable},
+		{"invalid connection", "invalidconn", repository.ErrUnavailable},
+		{"unknown error", "1064", nil},
+	}
+	card, err := model.NewCard(model.GBP)
+	assert.MustNotErr(t, err, "cannot create card: %v")
+	tx, err := model.NewTransaction(card, nil, uuid.Must(uuid.NewV4()), "test", 0, "test", time.Now())
+	assert.MustNotErr(t, err, "cannot create transaction: %v")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, err := sql.Open("fakemysql", test.dsn)
+			assert.MustNotErr(t, err, "cannot open database: %v")
+			defer db.Close()
+			r := repository.New(db)
+			ctx := context.Background()
+
+			calls := map[string]func() error{
+				"SaveCard": func() error {
+					return r.SaveCard(ctx, card)
+				},
+				"GetCard": func() error {
+					_, err := r.GetCard(ctx, card.UUID())
+					return err
+				},
+				"WithinTx": func() error {
+					return r.WithinTx(ctx, func(t service.Tx) error {
+						if err := t.UpdateCard(ctx, card); err != nil {
+							return err
+						}
+						return t.SaveTransaction(ctx, tx)
+					})
+				},
+				"ListCards": func() error {
+					_, err := r.ListCards(ctx)
+					return err
+				},
+			}
+			for name, call := range calls {
+				err := call()
+				assert.MustErr(t, err, "%s() got nil error, want error", name)
+				assert.MustE(t, service.KindOf(err), test.kind, "%s() got error kind %v, want %v", name)
+			}
+		})
+	}
+}
+
+// fakeDriver is a database driver, which fails all statements with the error
+// in the data source name. The name is a MySQL error number, "badconn" or "invalidconn".
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	switch name {
+	case "badconn":
+		return fakeConn{driver.ErrBadConn}, nil
+	case "invalidconn":
+		return fakeConn{mysql.ErrInvalidConn}, nil
+	}
+	n, err := strconv.ParseUint(name, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return fakeConn{&mysql.MySQLError{Number: uint16(n), Message: "fake error"}}, nil
+}
+
+type fakeConn struct {
+	err error
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (fakeConn) Close() error                          { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return c, nil }
+func (fakeConn) Commit() error                         { return nil }
+func (fakeConn) Rollback() error                       { return nil }
+
+type fakeStmt struct {
+	err error
+}
+
+func (fakeStmt) Close() error                                 { return nil }
+func (fakeStmt) NumInput() int                                { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, s.err }
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return nil, s.err }
@@ -2,40 +2,232 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"encoding/json"
 	"fmt"
-	"log"
+	"math/big"
+	"strings"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/gofrs/uuid"
 
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/eventsource"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
 	"github.com/sepetrov/prepaidcard/pkg/internal/model"
-	"github.com/sepetrov/prepaidcard/pkg/internal/service/createcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/getcard"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/replay"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/verifyledger"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
+	"github.com/sepetrov/prepaidcard/pkg/service/outbox"
 )
 
-const sqlInsertCard = "INSERT INTO card (uuid, available_balance, blocked_balance) VALUES (?, ?, ?)"
-const sqlSelectCard = "SELECT uuid, available_balance, blocked_balance FROM card WHERE uuid = ? LIMIT 1"
+const sqlInsertCard = "INSERT INTO card (uuid, currency, status, available_balance, blocked_balance, version) VALUES (?, ?, ?, ?, ?, ?)"
+const sqlSelectCard = "SELECT uuid, currency, status, available_balance, blocked_balance, version FROM card WHERE uuid = ? LIMIT 1"
+const sqlSelectCards = "SELECT uuid, currency, status, available_balance, blocked_balance, version FROM card"
+const sqlSelectCardUUID = "SELECT uuid FROM card WHERE uuid = ? LIMIT 1"
+const sqlUpdateCard = "UPDATE card SET status = ?, available_balance = ?, blocked_balance = ?, version = ? WHERE uuid = ? AND version = ?"
+const sqlInsertTransaction = "INSERT INTO card_transaction " +
+	"(uuid, card_uuid, event_uuid, event_type, authorization_request_uuid, date, amount, available_balance, blocked_balance, description) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+const sqlInsertLedgerPosting = "INSERT INTO ledger_posting " +
+	"(transaction_uuid, position, account_type, account_owner_uuid, currency, direction, amount) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?)"
+const sqlSelectLedgerBalances = "SELECT account_type, account_owner_uuid, currency, " +
+	"SUM(IF(direction = 'debit', amount, 0)), SUM(IF(direction = 'credit', amount, 0)) " +
+	"FROM ledger_posting GROUP BY account_type, account_owner_uuid, currency"
+const sqlSelectTransactions = "SELECT uuid, card_uuid, event_uuid, event_type, authorization_request_uuid, date, amount, " +
+	"available_balance, blocked_balance, description " +
+	"FROM card_transaction WHERE card_uuid = ?"
+const sqlSelectTransactionsSince = sqlSelectTransactions + " AND date >= ? ORDER BY date, uuid"
+const sqlSaveCardLimits = "INSERT INTO card_limits " +
+	"(card_uuid, product, per_transaction, daily, monthly, authorizations, authorization_window, daily_load) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?) " +
+	"ON DUPLICATE KEY UPDATE product = VALUES(product), per_transaction = VALUES(per_transaction), " +
+	"daily = VALUES(daily), monthly = VALUES(monthly), authorizations = VALUES(authorizations), " +
+	"authorization_window = VALUES(authorization_window), daily_load = VALUES(daily_load)"
+const sqlSelectCardLimits = "SELECT product, per_transaction, daily, monthly, authorizations, authorization_window, daily_load " +
+	"FROM card_limits WHERE card_uuid = ?"
+const sqlSelectCardMerchantCategories = "SELECT category, allowed FROM card_merchant_category WHERE card_uuid = ? ORDER BY category"
+const sqlDeleteCardMerchantCategories = "DELETE FROM card_merchant_category WHERE card_uuid = ?"
+const sqlInsertCardMerchantCategory = "INSERT INTO card_merchant_category (card_uuid, category, allowed) VALUES (?, ?, ?)"
+const sqlSaveMerchant = "INSERT INTO merchant (uuid, name, category, country, status, settlement_account) " +
+	"VALUES (?, ?, ?, ?, ?, ?) " +
+	"ON DUPLICATE KEY UPDATE name = VALUES(name), category = VALUES(category), country = VALUES(country), " +
+	"status = VALUES(status), settlement_account = VALUES(settlement_account)"
+const sqlSelectMerchant = "SELECT uuid, name, category, country, status, settlement_account FROM merchant WHERE uuid = ? LIMIT 1"
+const sqlSaveAuthorizationRequest = "INSERT INTO authorization_request " +
+	"(uuid, card_uuid, merchant_uuid, currency, card_currency, rate, original_amount, converted_amount, " +
+	"blocked_amount, captured_amount, refunded_amount, " +
+	"original_blocked_amount, original_captured_amount, original_refunded_amount, expires_at) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+	"ON DUPLICATE KEY UPDATE original_amount = VALUES(original_amount), converted_amount = VALUES(converted_amount), " +
+	"blocked_amount = VALUES(blocked_amount), captured_amount = VALUES(captured_amount), " +
+	"refunded_amount = VALUES(refunded_amount), " +
+	"original_blocked_amount = VALUES(original_blocked_amount), " +
+	"original_captured_amount = VALUES(original_captured_amount), " +
+	"original_refunded_amount = VALUES(original_refunded_amount), " +
+	"expires_at = VALUES(expires_at)"
+const sqlSelectAuthorizationRequest = "SELECT uuid, card_uuid, merchant_uuid, currency, card_currency, rate, " +
+	"original_amount, converted_amount, blocked_amount, captured_amount, refunded_amount, " +
+	"original_blocked_amount, original_captured_amount, original_refunded_amount, expires_at " +
+	"FROM authorization_request WHERE uuid = ? LIMIT 1"
+const sqlSelectExpiredAuthorizationRequests = "SELECT uuid FROM authorization_request " +
+	"WHERE expires_at <= ? AND original_blocked_amount > 0 ORDER BY expires_at LIMIT ?"
+const sqlSaveAuthorizationRequestSnapshot = "INSERT INTO authorization_request_snapshot " +
+	"(uuid, authorization_request_uuid, position, currency, card_currency, rate, " +
+	"blocked_amount, captured_amount, refunded_amount, " +
+	"original_blocked_amount, original_captured_amount, original_refunded_amount, created_at) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+	"ON DUPLICATE KEY UPDATE uuid = uuid"
+const sqlSelectAuthorizationRequestSnapshots = "SELECT uuid, currency, card_currency, rate, " +
+	"blocked_amount, captured_amount, refunded_amount, " +
+	"original_blocked_amount, original_captured_amount, original_refunded_amount, created_at " +
+	"FROM authorization_request_snapshot WHERE authorization_request_uuid = ? ORDER BY position"
+const sqlInsertIdempotentRequest = "INSERT IGNORE INTO idempotent_request " +
+	"(caller, idempotency_key, fingerprint, completed, status_code, header, body, created_at) " +
+	"VALUES (?, ?, ?, FALSE, 0, '{}', '', ?)"
+const sqlTakeOverIdempotentRequest = "UPDATE idempotent_request SET created_at = ? " +
+	"WHERE caller = ? AND idempotency_key = ? AND fingerprint = ? AND completed = FALSE AND created_at < ?"
+const sqlSelectIdempotentRequest = "SELECT fingerprint, completed, status_code, header, body, created_at " +
+	"FROM idempotent_request WHERE caller = ? AND idempotency_key = ? LIMIT 1"
+const sqlUpdateIdempotentRequest = "UPDATE idempotent_request SET completed = TRUE, status_code = ?, header = ?, body = ? " +
+	"WHERE caller = ? AND idempotency_key = ?"
+const sqlDeleteIdempotentRequest = "DELETE FROM idempotent_request WHERE caller = ? AND idempotency_key = ?"
+const sqlInsertAPIKey = "INSERT INTO api_key (uuid, hash, role, subject, created_at) VALUES (?, ?, ?, ?, ?)"
+const sqlSelectAPIKey = "SELECT uuid, hash, role, subject, created_at FROM api_key WHERE hash = ? LIMIT 1"
+const sqlSelectAPIKeys = "SELECT uuid, hash, role, subject, created_at FROM api_key ORDER BY created_at"
+const sqlDeleteAPIKey = "DELETE FROM api_key WHERE uuid = ?"
+const sqlInsertOutboxMessage = "INSERT INTO outbox_message " +
+	"(uuid, event_type, schema_version, occurred_at, payload, status, attempts, next_attempt_at, last_error, created_at) " +
+	"VALUES (?, ?, ?, ?, ?, 'pending', 0, ?, '', ?)"
+const sqlSelectPendingOutboxMessages = "SELECT uuid, event_type, schema_version, occurred_at, payload, attempts " +
+	"FROM outbox_message WHERE status = 'pending' AND next_attempt_at <= ? ORDER BY id LIMIT ?"
+const sqlUpdateOutboxMessageSent = "UPDATE outbox_message SET status = 'sent', sent_at = ? WHERE uuid = ?"
+const sqlUpdateOutboxMessageFailed = "UPDATE outbox_message SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? " +
+	"WHERE uuid = ?"
+const sqlUpdateOutboxMessageDead = "UPDATE outbox_message SET status = 'dead', attempts = attempts + 1, last_error = ? " +
+	"WHERE uuid = ?"
+const sqlInsertWebhook = "INSERT INTO webhook (uuid, merchant_uuid, url, secret, event_types, created_at) VALUES (?, ?, ?, ?, ?, ?)"
+const sqlSelectWebhook = "SELECT uuid, merchant_uuid, url, secret, event_types, created_at FROM webhook WHERE uuid = ?"
+const sqlSelectMerchantWebhooks = "SELECT uuid, merchant_uuid, url, secret, event_types, created_at FROM webhook " +
+	"WHERE merchant_uuid = ? ORDER BY created_at"
+const sqlInsertWebhookDelivery = "INSERT INTO webhook_delivery " +
+	"(uuid, webhook_uuid, event_uuid, event_type, payload, status, attempts, response_status, last_error, next_attempt_at, created_at) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE id = id"
+const sqlUpdateWebhookDelivery = "UPDATE webhook_delivery " +
+	"SET status = ?, attempts = ?, response_status = ?, last_error = ?, next_attempt_at = ?, delivered_at = ? WHERE uuid = ?"
+const sqlInsertWebhookDeliveryAttempt = "INSERT INTO webhook_delivery_attempt " +
+	"(delivery_uuid, number, status, response_status, error, attempted_at) VALUES (?, ?, ?, ?, ?, ?)"
+const sqlSelectWebhookDeliveryAttempts = "SELECT delivery_uuid, number, status, response_status, error, attempted_at " +
+	"FROM webhook_delivery_attempt WHERE delivery_uuid = ? ORDER BY number"
+const sqlSelectWebhookDeliveries = "SELECT uuid, webhook_uuid, event_uuid, event_type, payload, status, attempts, response_status, " +
+	"last_error, next_attempt_at, created_at, delivered_at FROM webhook_delivery WHERE webhook_uuid = ? ORDER BY id DESC LIMIT ?"
+const sqlSelectPendingWebhookDeliveries = "SELECT d.uuid, d.webhook_uuid, d.event_uuid, d.event_type, d.payload, d.status, " +
+	"d.attempts, d.response_status, d.last_error, d.next_attempt_at, d.created_at, d.delivered_at, w.url, w.secret " +
+	"FROM webhook_delivery d JOIN webhook w ON w.uuid = d.webhook_uuid " +
+	"WHERE d.status = 'pending' AND d.next_attempt_at <= ? ORDER BY d.id LIMIT ?"
+const sqlReplaceCard = "INSERT INTO card (uuid, currency, status, available_balance, blocked_balance, version) VALUES (?, ?, ?, ?, ?, ?) " +
+	"ON DUPLICATE KEY UPDATE currency = VALUES(currency), status = VALUES(status), available_balance = VALUES(available_balance), " +
+	"blocked_balance = VALUES(blocked_balance), version = VALUES(version)"
+const sqlInsertAggregateEvent = "INSERT INTO aggregate_event " +
+	"(aggregate_uuid, sequence, aggregate_type, event_uuid, event_type, schema_version, occurred_at, payload, hash) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+const sqlSelectLastAggregateEvent = "SELECT aggregate_uuid, sequence, aggregate_type, event_uuid, event_type, schema_version, " +
+	"occurred_at, payload, hash FROM aggregate_event WHERE aggregate_uuid = ? ORDER BY sequence DESC LIMIT 1"
+const sqlSelectAggregateEvents = "SELECT aggregate_uuid, sequence, aggregate_type, event_uuid, event_type, schema_version, " +
+	"occurred_at, payload, hash FROM aggregate_event WHERE aggregate_uuid = ? AND sequence > ? ORDER BY sequence"
+const sqlSelectAggregates = "SELECT aggregate_uuid FROM aggregate_event " +
+	"WHERE aggregate_type = ? AND (sequence = 1 OR event_type = ?) ORDER BY occurred_at"
+const sqlInsertAggregateSnapshot = "INSERT INTO aggregate_snapshot (aggregate_uuid, sequence, hash, state, signature, created_at) " +
+	"VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE sequence = sequence"
+const sqlSelectAggregateSnapshot = "SELECT aggregate_uuid, sequence, hash, state, signature FROM aggregate_snapshot " +
+	"WHERE aggregate_uuid = ? ORDER BY sequence DESC LIMIT 1"
 
 // ErrNotFound is returned when the expected record(s) can not be found.
-var ErrNotFound = errors.New("record not found")
+var ErrNotFound = service.ErrNotFound
+
+// ErrConcurrentModification is returned when a card is saved, but the stored card has changed since it was read.
+var ErrConcurrentModification = service.ErrConcurrentModification
 
 // Repository is a service, which provides interface with persistence layer.
+//
+// The events saved within the transactions are appended to the streams of the aggregates,
+// which they change, in table aggregate_event. If the repository is event-sourced, the cards
+// and the authorization requests are rebuilt from their events and the tables card and
+// authorization_request are only their projections. The cards and the authorization requests,
+// which were created before their events were recorded, are read from their tables and they are
+// migrated to their streams when they are read within a transaction. The hashes of the events
+// and the signatures of the snapshots are keyed with the event key.
 type Repository struct {
-	db *sql.DB
+	db           *sql.DB
+	eventSourced bool
+	eventKey     []byte
+}
+
+// querier is *sql.DB or *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Option configures a repository.
+type Option func(*Repository)
+
+// EventSourcedOption returns new option, with which the cards and the authorization requests
+// are rebuilt from their events instead of selected from their projections.
+func EventSourcedOption() Option {
+	return func(r *Repository) {
+		r.eventSourced = true
+	}
+}
+
+// EventKeyOption returns new option, with which the hashes of the events and the signatures
+// of the snapshots are keyed with key.
+func EventKeyOption(key []byte) Option {
+	return func(r *Repository) {
+		r.eventKey = key
+	}
+}
+
+// EventKey returns the key of the hashes of the events and the signatures of the snapshots.
+func (r *Repository) EventKey() []byte {
+	return r.eventKey
 }
 
 // New returns new repository for db.
-func New(db *sql.DB) *Repository {
-	return &Repository{db}
+func New(db *sql.DB, options ...Option) *Repository {
+	r := &Repository{db: db}
+	for _, o := range options {
+		o(r)
+	}
+	return r
 }
 
-var _ createcard.Saver = &Repository{}
+var _ getcard.Getter = &Repository{}
+var _ listtransactions.Lister = &Repository{}
+var _ verifyledger.Reader = &Repository{}
+var _ middleware.IdempotencyStore = &Repository{}
+var _ auth.KeyStore = &Repository{}
+var _ service.UnitOfWork = &Repository{}
+var _ outbox.Store = &Repository{}
+var _ webhook.Store = &Repository{}
+var _ replay.Store = &Repository{}
 
 // card represents card data
 type card struct {
 	uuid             uuid.UUID
+	currency         string
+	status           string
 	availableBalance uint64
 	blockedBalance   uint64
+	version          uint64
 }
 
 // Ensure card implements model.CardData.
@@ -46,6 +238,16 @@ func (c card) UUID() uuid.UUID {
 	return c.uuid
 }
 
+// Currency returns the currency.
+func (c card) Currency() model.Currency {
+	return model.Currency(c.currency)
+}
+
+// Status returns the status.
+func (c card) Status() model.CardStatus {
+	return model.CardStatus(c.status)
+}
+
 // AvailableBalance returns the available balance.
 func (c card) AvailableBalance() uint64 {
 	return c.availableBalance
@@ -56,29 +258,1389 @@ func (c card) BlockedBalance() uint64 {
 	return c.blockedBalance
 }
 
-// SaveCard persists new card.
-func (r *Repository) SaveCard(card *model.Card) error {
-	stmt, err := r.db.Prepare(sqlInsertCard)
+// Version returns the version.
+func (c card) Version() uint64 {
+	return c.version
+}
+
+// merchant represents merchant data.
+type merchant struct {
+	uuid              uuid.UUID
+	name              string
+	category          string
+	country           string
+	status            string
+	settlementAccount string
+}
+
+// Ensure merchant implements model.MerchantData.
+var _ model.MerchantData = &merchant{}
+
+// UUID returns the UUID.
+func (m merchant) UUID() uuid.UUID {
+	return m.uuid
+}
+
+// Name returns the name.
+func (m merchant) Name() string {
+	return m.name
+}
+
+// Category returns the merchant category code.
+func (m merchant) Category() string {
+	return m.category
+}
+
+// Country returns the country code.
+func (m merchant) Country() string {
+	return m.country
+}
+
+// Status returns the status.
+func (m merchant) Status() model.MerchantStatus {
+	return model.MerchantStatus(m.status)
+}
+
+// SettlementAccount returns the settlement account.
+func (m merchant) SettlementAccount() string {
+	return m.settlementAccount
+}
+
+// authorizationRequest represents authorization request data.
+type authorizationRequest struct {
+	uuid                   uuid.UUID
+	cardUUID               uuid.UUID
+	merchantUUID           uuid.UUID
+	rate                   model.FXRate
+	originalAmount         uint64
+	convertedAmount        uint64
+	blockedAmount          uint64
+	capturedAmount         uint64
+	refundedAmount         uint64
+	originalBlockedAmount  uint64
+	originalCapturedAmount uint64
+	originalRefundedAmount uint64
+	expiresAt              time.Time
+	history                []model.AuthorizationRequestSnapshot
+}
+
+// Ensure authorizationRequest implements model.AuthorizationRequestData.
+var _ model.AuthorizationRequestData = &authorizationRequest{}
+
+// UUID returns the UUID.
+func (r authorizationRequest) UUID() uuid.UUID {
+	return r.uuid
+}
+
+// CardUUID returns the card UUID.
+func (r authorizationRequest) CardUUID() uuid.UUID {
+	return r.cardUUID
+}
+
+// MerchantUUID returns the merchant UUID.
+func (r authorizationRequest) MerchantUUID() uuid.UUID {
+	return r.merchantUUID
+}
+
+// Rate returns the exchange rate.
+func (r authorizationRequest) Rate() model.FXRate {
+	return r.rate
+}
+
+// OriginalAmount returns the requested amount in the currency of the authorization.
+func (r authorizationRequest) OriginalAmount() uint64 {
+	return r.originalAmount
+}
+
+// ConvertedAmount returns the requested amount in the currency of the card.
+func (r authorizationRequest) ConvertedAmount() uint64 {
+	return r.convertedAmount
+}
+
+// BlockedAmount returns the blocked amount.
+func (r authorizationRequest) BlockedAmount() uint64 {
+	return r.blockedAmount
+}
+
+// CapturedAmount returns the captured amount.
+func (r authorizationRequest) CapturedAmount() uint64 {
+	return r.capturedAmount
+}
+
+// RefundedAmount returns the refunded amount.
+func (r authorizationRequest) RefundedAmount() uint64 {
+	return r.refundedAmount
+}
+
+// OriginalBlockedAmount returns the blocked amount in the currency of the authorization.
+func (r authorizationRequest) OriginalBlockedAmount() uint64 {
+	return r.originalBlockedAmount
+}
+
+// OriginalCapturedAmount returns the captured amount in the currency of the authorization.
+func (r authorizationRequest) OriginalCapturedAmount() uint64 {
+	return r.originalCapturedAmount
+}
+
+// OriginalRefundedAmount returns the refunded amount in the currency of the authorization.
+func (r authorizationRequest) OriginalRefundedAmount() uint64 {
+	return r.originalRefundedAmount
+}
+
+// ExpiresAt returns the expiry.
+func (r authorizationRequest) ExpiresAt() time.Time {
+	return r.expiresAt
+}
+
+// History returns the log of changes.
+func (r authorizationRequest) History() []model.AuthorizationRequestSnapshot {
+	return r.history
+}
+
+// authorizationRequestSnapshot represents authorization request snapshot data.
+type authorizationRequestSnapshot struct {
+	uuid                   uuid.UUID
+	rate                   model.FXRate
+	blockedAmount          uint64
+	capturedAmount         uint64
+	refundedAmount         uint64
+	originalBlockedAmount  uint64
+	originalCapturedAmount uint64
+	originalRefundedAmount uint64
+	createdAt              time.Time
+}
+
+// Ensure authorizationRequestSnapshot implements model.AuthorizationRequestSnapshotData.
+var _ model.AuthorizationRequestSnapshotData = &authorizationRequestSnapshot{}
+
+// UUID returns the UUID.
+func (s authorizationRequestSnapshot) UUID() uuid.UUID {
+	return s.uuid
+}
+
+// Rate returns the exchange rate.
+func (s authorizationRequestSnapshot) Rate() model.FXRate {
+	return s.rate
+}
+
+// BlockedAmount returns the blocked amount.
+func (s authorizationRequestSnapshot) BlockedAmount() uint64 {
+	return s.blockedAmount
+}
+
+// CapturedAmount returns the captured amount.
+func (s authorizationRequestSnapshot) CapturedAmount() uint64 {
+	return s.capturedAmount
+}
+
+// RefundedAmount returns the refunded amount.
+func (s authorizationRequestSnapshot) RefundedAmount() uint64 {
+	return s.refundedAmount
+}
+
+// OriginalBlockedAmount returns the blocked amount in the currency of the authorization.
+func (s authorizationRequestSnapshot) OriginalBlockedAmount() uint64 {
+	return s.originalBlockedAmount
+}
+
+// OriginalCapturedAmount returns the captured amount in the currency of the authorization.
+func (s authorizationRequestSnapshot) OriginalCapturedAmount() uint64 {
+	return s.originalCapturedAmount
+}
+
+// OriginalRefundedAmount returns the refunded amount in the currency of the authorization.
+func (s authorizationRequestSnapshot) OriginalRefundedAmount() uint64 {
+	return s.originalRefundedAmount
+}
+
+// CreatedAt returns the time when the snapshot was taken.
+func (s authorizationRequestSnapshot) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// transaction represents transaction data.
+type transaction struct {
+	uuid             uuid.UUID
+	cardUUID         uuid.UUID
+	eventUUID        uuid.UUID
+	eventType        string
+	authReqUUID      uuid.UUID
+	date             time.Time
+	amount           uint64
+	availableBalance uint64
+	blockedBalance   uint64
+	description      string
+}
+
+// Ensure transaction implements model.TransactionData.
+var _ model.TransactionData = &transaction{}
+
+// UUID returns the UUID.
+func (t transaction) UUID() uuid.UUID {
+	return t.uuid
+}
+
+// CardUUID returns the card UUID.
+func (t transaction) CardUUID() uuid.UUID {
+	return t.cardUUID
+}
+
+// EventUUID returns the event UUID.
+func (t transaction) EventUUID() uuid.UUID {
+	return t.eventUUID
+}
+
+// EventType returns the event type.
+func (t transaction) EventType() string {
+	return t.eventType
+}
+
+// AuthorizationRequestUUID returns the authorization request UUID.
+func (t transaction) AuthorizationRequestUUID() uuid.UUID {
+	return t.authReqUUID
+}
+
+// Date returns the time of the transaction.
+func (t transaction) Date() time.Time {
+	return t.date
+}
+
+// Amount returns the amount.
+func (t transaction) Amount() uint64 {
+	return t.amount
+}
+
+// AvailableBalance returns the available balance.
+func (t transaction) AvailableBalance() uint64 {
+	return t.availableBalance
+}
+
+// BlockedBalance returns the blocked balance.
+func (t transaction) BlockedBalance() uint64 {
+	return t.blockedBalance
+}
+
+// Description returns the description.
+func (t transaction) Description() string {
+	return t.description
+}
+
+// SaveCard persists new card with its next version.
+func (r *Repository) SaveCard(ctx context.Context, card *model.Card) error {
+	return insertCard(ctx, r.db, card)
+}
+
+// insertCard inserts new card with its next version with q.
+func insertCard(ctx context.Context, q querier, card *model.Card) error {
+	_, err := q.ExecContext(ctx,
+		sqlInsertCard,
+		card.UUID(),
+		string(card.Currency()),
+		string(card.Status()),
+		card.AvailableBalance(),
+		card.BlockedBalance(),
+		card.NextVersion(),
+	)
 	if err != nil {
-		return fmt.Errorf("cannot prepare statement to save card: %v", err)
-	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(card.UUID(), card.AvailableBalance(), card.BlockedBalance()); err != nil {
-		log.Fatal(err)
+		return newError("cannot save card", err)
 	}
+	card.Saved()
 	return nil
 }
 
 // GetCard returns the card with uuid.
-func (r *Repository) GetCard(uuid uuid.UUID) (*model.Card, error) {
+func (r *Repository) GetCard(ctx context.Context, uuid uuid.UUID) (*model.Card, error) {
+	if r.eventSourced {
+		card, err := eventsource.LoadCard(ctx, eventStore{r.db}, r.eventKey, uuid)
+		if err != ErrNotFound {
+			return card, err
+		}
+	}
+	return getCard(ctx, r.db, uuid)
+}
+
+// getCard selects the card with uuid with q.
+func getCard(ctx context.Context, q querier, uuid uuid.UUID) (*model.Card, error) {
 	data := card{}
-	row := r.db.QueryRow(sqlSelectCard, uuid.String())
-	err := row.Scan(&data.uuid, &data.availableBalance, &data.blockedBalance)
+	row := q.QueryRowContext(ctx, sqlSelectCard, uuid.String())
+	err := row.Scan(&data.uuid, &data.currency, &data.status, &data.availableBalance, &data.blockedBalance, &data.version)
 	if err == sql.ErrNoRows {
 		return &model.Card{}, ErrNotFound
 	}
 	if err != nil {
-		return &model.Card{}, fmt.Errorf("got error, want one row: %v", err)
+		return &model.Card{}, newError("got error, want one row", err)
 	}
 	return model.CardFromData(data), nil
 }
+
+// WithinTx implements service.UnitOfWork with a database transaction.
+func (r *Repository) WithinTx(ctx context.Context, fn func(service.Tx) error) error {
+	dbTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return newError("cannot begin transaction", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			dbTx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(&txRepository{dbTx: dbTx, eventSourced: r.eventSourced, eventKey: r.eventKey, versions: map[uuid.UUID]uint64{}}); err != nil {
+		dbTx.Rollback()
+		return err
+	}
+	if err := dbTx.Commit(); err != nil {
+		return newError("cannot commit transaction", err)
+	}
+	return nil
+}
+
+// txRepository implements service.Tx with a database transaction.
+type txRepository struct {
+	dbTx         *sql.Tx
+	eventSourced bool
+	eventKey     []byte
+	// versions are the versions of the cards saved within the transaction, which must be
+	// the sequence numbers of the events of the cards saved within the transaction.
+	versions map[uuid.UUID]uint64
+}
+
+var _ service.Tx = &txRepository{}
+
+// GetCard implements service.Tx. The card, which has no events, is migrated to its stream.
+func (t *txRepository) GetCard(ctx context.Context, uuid uuid.UUID) (*model.Card, error) {
+	s := eventStore{t.dbTx}
+	if t.eventSourced {
+		card, err := eventsource.LoadCard(ctx, s, t.eventKey, uuid)
+		if err != ErrNotFound {
+			return card, err
+		}
+	}
+	card, err := getCard(ctx, t.dbTx, uuid)
+	if err != nil {
+		return card, err
+	}
+	if _, err := s.LastRecord(ctx, uuid); err != ErrNotFound {
+		return card, err
+	}
+	return card, eventsource.MigrateCard(ctx, s, t.eventKey, card, time.Now())
+}
+
+// GetAuthorizationRequest implements service.Tx. The authorization request, which has no events,
+// is migrated to its stream.
+func (t *txRepository) GetAuthorizationRequest(ctx context.Context, uuid uuid.UUID) (*model.AuthorizationRequest, error) {
+	s := eventStore{t.dbTx}
+	if t.eventSourced {
+		req, err := eventsource.LoadAuthorizationRequest(ctx, s, t.eventKey, uuid)
+		if err != ErrNotFound {
+			return req, err
+		}
+	}
+	req, err := getAuthorizationRequest(ctx, t.dbTx, uuid)
+	if err != nil {
+		return req, err
+	}
+	if _, err := s.LastRecord(ctx, uuid); err != ErrNotFound {
+		return req, err
+	}
+	return req, eventsource.MigrateAuthorizationRequest(ctx, s, t.eventKey, req, time.Now())
+}
+
+// SaveCard implements service.Tx.
+func (t *txRepository) SaveCard(ctx context.Context, card *model.Card) error {
+	if err := insertCard(ctx, t.dbTx, card); err != nil {
+		return err
+	}
+	t.versions[card.UUID()] = card.Version()
+	return nil
+}
+
+// UpdateCard implements service.Tx. The card has its next version once it is updated,
+// so it must be discarded if the transaction is rolled back.
+func (t *txRepository) UpdateCard(ctx context.Context, card *model.Card) error {
+	if err := updateCard(ctx, t.dbTx, card); err != nil {
+		return err
+	}
+	card.Saved()
+	t.versions[card.UUID()] = card.Version()
+	return nil
+}
+
+// SaveAuthorizationRequest implements service.Tx.
+func (t *txRepository) SaveAuthorizationRequest(ctx context.Context, req *model.AuthorizationRequest) error {
+	return saveAuthorizationRequest(ctx, t.dbTx, req)
+}
+
+// SaveTransaction implements service.Tx.
+func (t *txRepository) SaveTransaction(ctx context.Context, tx *model.Transaction) error {
+	return insertTransaction(ctx, t.dbTx, tx)
+}
+
+// GetCardLimits implements service.Tx.
+func (t *txRepository) GetCardLimits(ctx context.Context, uuid uuid.UUID) (model.CardLimits, error) {
+	return getCardLimits(ctx, t.dbTx, uuid)
+}
+
+// SaveCardLimits implements service.Tx.
+func (t *txRepository) SaveCardLimits(ctx context.Context, uuid uuid.UUID, l model.CardLimits) error {
+	_, err := t.dbTx.ExecContext(ctx,
+		sqlSaveCardLimits,
+		uuid,
+		l.Product,
+		l.Limits.PerTransaction,
+		l.Limits.Daily,
+		l.Limits.Monthly,
+		l.Limits.Authorizations,
+		int64(l.Limits.AuthorizationWindow),
+		l.Limits.DailyLoad,
+	)
+	if err != nil {
+		return newError("cannot save card limits", err)
+	}
+	return nil
+}
+
+// ListCardTransactions implements service.Tx.
+func (t *txRepository) ListCardTransactions(ctx context.Context, uuid uuid.UUID, since time.Time) ([]*model.Transaction, error) {
+	return queryTransactions(ctx, t.dbTx, sqlSelectTransactionsSince, uuid.String(), since)
+}
+
+// GetCardMerchantCategories implements service.Tx.
+func (t *txRepository) GetCardMerchantCategories(ctx context.Context, uuid uuid.UUID) (model.MerchantCategories, error) {
+	c := model.MerchantCategories{}
+	rows, err := t.dbTx.QueryContext(ctx, sqlSelectCardMerchantCategories, uuid.String())
+	if err != nil {
+		return c, newError("cannot select card merchant categories", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		var allowed bool
+		if err := rows.Scan(&category, &allowed); err != nil {
+			return model.MerchantCategories{}, newError("cannot scan card merchant category", err)
+		}
+		if allowed {
+			c.Allowed = append(c.Allowed, category)
+		} else {
+			c.Blocked = append(c.Blocked, category)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return model.MerchantCategories{}, newError("cannot select card merchant categories", err)
+	}
+	return c, nil
+}
+
+// SaveCardMerchantCategories implements service.Tx.
+func (t *txRepository) SaveCardMerchantCategories(ctx context.Context, uuid uuid.UUID, c model.MerchantCategories) error {
+	if _, err := t.dbTx.ExecContext(ctx, sqlDeleteCardMerchantCategories, uuid.String()); err != nil {
+		return newError("cannot delete card merchant categories", err)
+	}
+	for _, category := range c.Allowed {
+		if _, err := t.dbTx.ExecContext(ctx, sqlInsertCardMerchantCategory, uuid.String(), category, true); err != nil {
+			return newError("cannot insert card merchant category", err)
+		}
+	}
+	for _, category := range c.Blocked {
+		if _, err := t.dbTx.ExecContext(ctx, sqlInsertCardMerchantCategory, uuid.String(), category, false); err != nil {
+			return newError("cannot insert card merchant category", err)
+		}
+	}
+	return nil
+}
+
+// GetMerchant implements service.Tx.
+func (t *txRepository) GetMerchant(ctx context.Context, uuid uuid.UUID) (*model.Merchant, error) {
+	data := merchant{}
+	row := t.dbTx.QueryRowContext(ctx, sqlSelectMerchant, uuid.String())
+	err := row.Scan(&data.uuid, &data.name, &data.category, &data.country, &data.status, &data.settlementAccount)
+	if err == sql.ErrNoRows {
+		return &model.Merchant{}, ErrNotFound
+	}
+	if err != nil {
+		return &model.Merchant{}, newError("got error, want one row", err)
+	}
+	return model.MerchantFromData(data), nil
+}
+
+// SaveMerchant implements service.Tx.
+func (t *txRepository) SaveMerchant(ctx context.Context, m *model.Merchant) error {
+	_, err := t.dbTx.ExecContext(ctx,
+		sqlSaveMerchant,
+		m.UUID(),
+		m.Name(),
+		m.Category(),
+		m.Country(),
+		string(m.Status()),
+		m.SettlementAccount(),
+	)
+	if err != nil {
+		return newError("cannot save merchant", err)
+	}
+	return nil
+}
+
+// SaveEvent implements service.Tx. The event is also appended to the streams of the aggregates, which it changes,
+// and it returns error if its sequence number in the stream of a card saved within the transaction is not the version
+// of the card, so the version of a card is always the sequence number of its last event.
+func (t *txRepository) SaveEvent(ctx context.Context, e interface{}) error {
+	m, err := event.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if _, err := t.dbTx.ExecContext(ctx, sqlInsertOutboxMessage, m.UUID, m.Type, m.SchemaVersion, m.Time, m.Payload, now, now); err != nil {
+		return newError("cannot insert outbox message", err)
+	}
+	records, err := eventsource.Append(ctx, eventStore{t.dbTx}, t.eventKey, m)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		v, ok := t.versions[r.AggregateUUID]
+		if ok && r.AggregateType == eventsource.AggregateCard && r.Sequence != v {
+			return fmt.Errorf("SaveEvent() event %d of card %s does not match the card version %d", r.Sequence, r.AggregateUUID, v)
+		}
+	}
+	return nil
+}
+
+// updateCard updates the status and the balances of card within dbTx.
+// It returns ErrConcurrentModification if the stored card does not have the version of card.
+func updateCard(ctx context.Context, dbTx *sql.Tx, card *model.Card) error {
+	res, err := dbTx.ExecContext(ctx,
+		sqlUpdateCard,
+		string(card.Status()),
+		card.AvailableBalance(),
+		card.BlockedBalance(),
+		card.NextVersion(),
+		card.UUID(),
+		card.Version(),
+	)
+	if err != nil {
+		return newError("cannot update card", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return newError("cannot update card", err)
+	}
+	if n == 0 {
+		err := dbTx.QueryRowContext(ctx, sqlSelectCardUUID, card.UUID()).Scan(new(string))
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return newError("cannot select card", err)
+		}
+		return ErrConcurrentModification
+	}
+	return nil
+}
+
+// insertTransaction inserts tx within dbTx.
+func insertTransaction(ctx context.Context, dbTx *sql.Tx, tx *model.Transaction) error {
+	_, err := dbTx.ExecContext(ctx,
+		sqlInsertTransaction,
+		tx.UUID(),
+		tx.CardUUID(),
+		tx.EventUUID(),
+		tx.EventType(),
+		uuid.NullUUID{UUID: tx.AuthorizationRequestUUID(), Valid: tx.AuthorizationRequestUUID() != uuid.Nil},
+		tx.Date(),
+		tx.Amount(),
+		tx.AvailableBalance(),
+		tx.BlockedBalance(),
+		tx.Description(),
+	)
+	if err != nil {
+		return newError("cannot insert transaction", err)
+	}
+	for i, p := range tx.Postings() {
+		_, err := dbTx.ExecContext(ctx,
+			sqlInsertLedgerPosting,
+			tx.UUID(),
+			i,
+			string(p.Account().Type()),
+			p.Account().OwnerUUID(),
+			string(p.Account().Currency()),
+			string(p.Direction()),
+			p.Amount(),
+		)
+		if err != nil {
+			return newError("cannot insert ledger posting", err)
+		}
+	}
+	return nil
+}
+
+// GetAuthorizationRequest returns the authorization request with uuid.
+func (r *Repository) GetAuthorizationRequest(ctx context.Context, uuid uuid.UUID) (*model.AuthorizationRequest, error) {
+	if r.eventSourced {
+		req, err := eventsource.LoadAuthorizationRequest(ctx, eventStore{r.db}, r.eventKey, uuid)
+		if err != ErrNotFound {
+			return req, err
+		}
+	}
+	return getAuthorizationRequest(ctx, r.db, uuid)
+}
+
+// getCardLimits selects the limits of the card with uuid with q.
+func getCardLimits(ctx context.Context, q querier, uuid uuid.UUID) (model.CardLimits, error) {
+	l := model.CardLimits{}
+	var window int64
+	row := q.QueryRowContext(ctx, sqlSelectCardLimits, uuid.String())
+	err := row.Scan(
+		&l.Product,
+		&l.Limits.PerTransaction,
+		&l.Limits.Daily,
+		&l.Limits.Monthly,
+		&l.Limits.Authorizations,
+		&window,
+		&l.Limits.DailyLoad,
+	)
+	if err == sql.ErrNoRows {
+		return model.CardLimits{}, ErrNotFound
+	}
+	if err != nil {
+		return model.CardLimits{}, newError("got error, want one row", err)
+	}
+	l.Limits.AuthorizationWindow = time.Duration(window)
+	return l, nil
+}
+
+// getAuthorizationRequest selects the authorization request with uuid and its history with q.
+func getAuthorizationRequest(ctx context.Context, q querier, uuid uuid.UUID) (*model.AuthorizationRequest, error) {
+	data := authorizationRequest{}
+	var currency, cardCurrency, rate string
+	row := q.QueryRowContext(ctx, sqlSelectAuthorizationRequest, uuid.String())
+	err := row.Scan(
+		&data.uuid,
+		&data.cardUUID,
+		&data.merchantUUID,
+		&currency,
+		&cardCurrency,
+		&rate,
+		&data.originalAmount,
+		&data.convertedAmount,
+		&data.blockedAmount,
+		&data.capturedAmount,
+		&data.refundedAmount,
+		&data.originalBlockedAmount,
+		&data.originalCapturedAmount,
+		&data.originalRefundedAmount,
+		&data.expiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return &model.AuthorizationRequest{}, ErrNotFound
+	}
+	if err != nil {
+		return &model.AuthorizationRequest{}, newError("got error, want one row", err)
+	}
+	if data.rate, err = model.NewFXRate(model.Currency(currency), model.Currency(cardCurrency), rate); err != nil {
+		return &model.AuthorizationRequest{}, fmt.Errorf("cannot parse authorization request rate: %v", err)
+	}
+	rows, err := q.QueryContext(ctx, sqlSelectAuthorizationRequestSnapshots, uuid.String())
+	if err != nil {
+		return &model.AuthorizationRequest{}, newError("cannot select authorization request snapshots", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := authorizationRequestSnapshot{}
+		err := rows.Scan(
+			&s.uuid,
+			&currency,
+			&cardCurrency,
+			&rate,
+			&s.blockedAmount,
+			&s.capturedAmount,
+			&s.refundedAmount,
+			&s.originalBlockedAmount,
+			&s.originalCapturedAmount,
+			&s.originalRefundedAmount,
+			&s.createdAt,
+		)
+		if err != nil {
+			return &model.AuthorizationRequest{}, newError("cannot scan authorization request snapshot", err)
+		}
+		if s.rate, err = model.NewFXRate(model.Currency(currency), model.Currency(cardCurrency), rate); err != nil {
+			return &model.AuthorizationRequest{}, fmt.Errorf("cannot parse authorization request snapshot rate: %v", err)
+		}
+		data.history = append(data.history, model.AuthorizationRequestSnapshotFromData(s))
+	}
+	if err := rows.Err(); err != nil {
+		return &model.AuthorizationRequest{}, newError("cannot select authorization request snapshots", err)
+	}
+	return model.AuthorizationRequestFromData(data), nil
+}
+
+// ListExpiredAuthorizationRequests returns the UUIDs of at most limit authorization requests, which expire
+// at or before now and have blocked amounts, from the earliest to the latest expiry.
+func (r *Repository) ListExpiredAuthorizationRequests(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectExpiredAuthorizationRequests, now, limit)
+	if err != nil {
+		return nil, newError("cannot select expired authorization requests", err)
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, newError("cannot scan expired authorization request", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select expired authorization requests", err)
+	}
+	return ids, nil
+}
+
+// saveAuthorizationRequest inserts or updates req and inserts its new snapshots within dbTx.
+func saveAuthorizationRequest(ctx context.Context, dbTx *sql.Tx, req *model.AuthorizationRequest) error {
+	_, err := dbTx.ExecContext(ctx,
+		sqlSaveAuthorizationRequest,
+		req.UUID(),
+		req.CardUUID(),
+		req.MerchantUUID(),
+		string(req.Rate().From()),
+		string(req.Rate().To()),
+		req.Rate().Rate(),
+		req.OriginalAmount(),
+		req.ConvertedAmount(),
+		req.BlockedAmount(),
+		req.CapturedAmount(),
+		req.RefundedAmount(),
+		req.OriginalBlockedAmount(),
+		req.OriginalCapturedAmount(),
+		req.OriginalRefundedAmount(),
+		req.ExpiresAt(),
+	)
+	if err != nil {
+		return newError("cannot save authorization request", err)
+	}
+	for i, s := range req.History() {
+		_, err := dbTx.ExecContext(ctx,
+			sqlSaveAuthorizationRequestSnapshot,
+			s.UUID(),
+			req.UUID(),
+			i,
+			string(s.Rate().From()),
+			string(s.Rate().To()),
+			s.Rate().Rate(),
+			s.BlockedAmount(),
+			s.CapturedAmount(),
+			s.RefundedAmount(),
+			s.OriginalBlockedAmount(),
+			s.OriginalCapturedAmount(),
+			s.OriginalRefundedAmount(),
+			s.CreatedAt(),
+		)
+		if err != nil {
+			return newError("cannot save authorization request snapshot", err)
+		}
+	}
+	return nil
+}
+
+// ListTransactions returns the card transactions matching filter f from the newest to the oldest.
+func (r *Repository) ListTransactions(ctx context.Context, f listtransactions.Filter) ([]*model.Transaction, error) {
+	query := sqlSelectTransactions
+	args := []interface{}{f.CardUUID.String()}
+	if !f.From.IsZero() {
+		query += " AND date >= ?"
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		query += " AND date < ?"
+		args = append(args, f.To)
+	}
+	if len(f.EventTypes) > 0 {
+		query += " AND event_type IN (?" + strings.Repeat(", ?", len(f.EventTypes)-1) + ")"
+		for _, t := range f.EventTypes {
+			args = append(args, t)
+		}
+	}
+	if f.After != nil {
+		query += " AND (date < ? OR (date = ? AND uuid < ?))"
+		args = append(args, f.After.Date, f.After.Date, f.After.UUID.String())
+	}
+	query += " ORDER BY date DESC, uuid DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	return queryTransactions(ctx, r.db, query, args...)
+}
+
+// queryTransactions selects the transactions with query and args with q.
+func queryTransactions(ctx context.Context, q querier, query string, args ...interface{}) ([]*model.Transaction, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, newError("cannot select transactions", err)
+	}
+	defer rows.Close()
+	txs := []*model.Transaction{}
+	for rows.Next() {
+		data := transaction{}
+		var authReqUUID uuid.NullUUID
+		err := rows.Scan(
+			&data.uuid,
+			&data.cardUUID,
+			&data.eventUUID,
+			&data.eventType,
+			&authReqUUID,
+			&data.date,
+			&data.amount,
+			&data.availableBalance,
+			&data.blockedBalance,
+			&data.description,
+		)
+		if err != nil {
+			return nil, newError("cannot scan transaction", err)
+		}
+		data.authReqUUID = authReqUUID.UUID
+		txs = append(txs, model.TransactionFromData(data))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select transactions", err)
+	}
+	return txs, nil
+}
+
+// LedgerBalances returns the sums of the debit and the credit postings of each ledger account.
+func (r *Repository) LedgerBalances(ctx context.Context) ([]verifyledger.Balance, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectLedgerBalances)
+	if err != nil {
+		return nil, newError("cannot select ledger balances", err)
+	}
+	defer rows.Close()
+	balances := []verifyledger.Balance{}
+	for rows.Next() {
+		var (
+			accountType   string
+			owner         uuid.UUID
+			currency      string
+			debit, credit string
+		)
+		if err := rows.Scan(&accountType, &owner, &currency, &debit, &credit); err != nil {
+			return nil, newError("cannot scan ledger balance", err)
+		}
+		b := verifyledger.Balance{Account: model.NewAccount(model.AccountType(accountType), owner, model.Currency(currency))}
+		var ok bool
+		if b.Debit, ok = new(big.Int).SetString(debit, 10); !ok {
+			return nil, fmt.Errorf("cannot parse debit %q of ledger account", debit)
+		}
+		if b.Credit, ok = new(big.Int).SetString(credit, 10); !ok {
+			return nil, fmt.Errorf("cannot parse credit %q of ledger account", credit)
+		}
+		balances = append(balances, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select ledger balances", err)
+	}
+	return balances, nil
+}
+
+// ListCards returns all cards.
+func (r *Repository) ListCards(ctx context.Context) ([]*model.Card, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectCards)
+	if err != nil {
+		return nil, newError("cannot select cards", err)
+	}
+	defer rows.Close()
+	cards := []*model.Card{}
+	for rows.Next() {
+		data := card{}
+		if err := rows.Scan(&data.uuid, &data.currency, &data.status, &data.availableBalance, &data.blockedBalance, &data.version); err != nil {
+			return nil, newError("cannot scan card", err)
+		}
+		cards = append(cards, model.CardFromData(data))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select cards", err)
+	}
+	return cards, nil
+}
+
+// BeginIdempotentRequest implements middleware.IdempotencyStore.
+// The stale reservation is taken over by updating its creation time, so only one retry takes it over.
+func (r *Repository) BeginIdempotentRequest(ctx context.Context, caller, key, fingerprint string, staleBefore time.Time) (middleware.IdempotentRequest, bool, error) {
+	now := time.Now()
+	res, err := r.db.ExecContext(ctx, sqlInsertIdempotentRequest, caller, key, fingerprint, now)
+	if err != nil {
+		return middleware.IdempotentRequest{}, false, newError("cannot insert idempotent request", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return middleware.IdempotentRequest{}, false, newError("cannot insert idempotent request", err)
+	}
+	if n == 0 {
+		res, err = r.db.ExecContext(ctx, sqlTakeOverIdempotentRequest, now, caller, key, fingerprint, staleBefore)
+		if err != nil {
+			return middleware.IdempotentRequest{}, false, newError("cannot take over idempotent request", err)
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return middleware.IdempotentRequest{}, false, newError("cannot take over idempotent request", err)
+		}
+	}
+	if n == 1 {
+		return middleware.IdempotentRequest{Fingerprint: fingerprint, CreatedAt: now}, true, nil
+	}
+	req := middleware.IdempotentRequest{}
+	var header string
+	row := r.db.QueryRowContext(ctx, sqlSelectIdempotentRequest, caller, key)
+	if err := row.Scan(&req.Fingerprint, &req.Completed, &req.StatusCode, &header, &req.Body, &req.CreatedAt); err != nil {
+		return middleware.IdempotentRequest{}, false, newError("got error, want one row", err)
+	}
+	if err := json.Unmarshal([]byte(header), &req.Header); err != nil {
+		return middleware.IdempotentRequest{}, false, fmt.Errorf("cannot decode idempotent request header: %v", err)
+	}
+	return req, false, nil
+}
+
+// CompleteIdempotentRequest implements middleware.IdempotencyStore.
+func (r *Repository) CompleteIdempotentRequest(ctx context.Context, caller, key string, req middleware.IdempotentRequest) error {
+	header, err := json.Marshal(req.Header)
+	if err != nil {
+		return fmt.Errorf("cannot encode idempotent request header: %v", err)
+	}
+	if _, err := r.db.ExecContext(ctx, sqlUpdateIdempotentRequest, req.StatusCode, string(header), req.Body, caller, key); err != nil {
+		return newError("cannot update idempotent request", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotentRequest implements middleware.IdempotencyStore.
+func (r *Repository) ReleaseIdempotentRequest(ctx context.Context, caller, key string) error {
+	if _, err := r.db.ExecContext(ctx, sqlDeleteIdempotentRequest, caller, key); err != nil {
+		return newError("cannot delete idempotent request", err)
+	}
+	return nil
+}
+
+// SaveAPIKey implements auth.KeyStore.
+func (r *Repository) SaveAPIKey(ctx context.Context, key auth.APIKey) error {
+	_, err := r.db.ExecContext(ctx, sqlInsertAPIKey, key.UUID, key.Hash, string(key.Principal.Role), key.Principal.Subject, key.CreatedAt)
+	if err != nil {
+		return newError("cannot insert API key", err)
+	}
+	return nil
+}
+
+// GetAPIKey implements auth.KeyStore.
+func (r *Repository) GetAPIKey(ctx context.Context, hash string) (auth.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, sqlSelectAPIKey, hash))
+	if err == sql.ErrNoRows {
+		return auth.APIKey{}, ErrNotFound
+	}
+	if err != nil {
+		return auth.APIKey{}, newError("got error, want one row", err)
+	}
+	return key, nil
+}
+
+// ListAPIKeys implements auth.KeyStore.
+func (r *Repository) ListAPIKeys(ctx context.Context) ([]auth.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectAPIKeys)
+	if err != nil {
+		return nil, newError("cannot select API keys", err)
+	}
+	defer rows.Close()
+	keys := []auth.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, newError("cannot scan API key", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select API keys", err)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey implements auth.KeyStore.
+func (r *Repository) DeleteAPIKey(ctx context.Context, id uuid.UUID) error {
+	res, err := r.db.ExecContext(ctx, sqlDeleteAPIKey, id)
+	if err != nil {
+		return newError("cannot delete API key", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return newError("cannot delete API key", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PendingMessages implements outbox.Store.
+func (r *Repository) PendingMessages(ctx context.Context, now time.Time, limit int) ([]outbox.PendingMessage, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectPendingOutboxMessages, now, limit)
+	if err != nil {
+		return nil, newError("cannot select outbox messages", err)
+	}
+	defer rows.Close()
+	messages := []outbox.PendingMessage{}
+	for rows.Next() {
+		m := outbox.PendingMessage{}
+		if err := rows.Scan(&m.UUID, &m.Type, &m.SchemaVersion, &m.Time, &m.Payload, &m.Attempts); err != nil {
+			return nil, newError("cannot scan outbox message", err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select outbox messages", err)
+	}
+	return messages, nil
+}
+
+// MarkMessageSent implements outbox.Store.
+func (r *Repository) MarkMessageSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	if _, err := r.db.ExecContext(ctx, sqlUpdateOutboxMessageSent, at, id); err != nil {
+		return newError("cannot update outbox message", err)
+	}
+	return nil
+}
+
+// MarkMessageFailed implements outbox.Store.
+func (r *Repository) MarkMessageFailed(ctx context.Context, id uuid.UUID, retryAt time.Time, reason string) error {
+	if _, err := r.db.ExecContext(ctx, sqlUpdateOutboxMessageFailed, retryAt, reason, id); err != nil {
+		return newError("cannot update outbox message", err)
+	}
+	return nil
+}
+
+// MarkMessageDead implements outbox.Store.
+func (r *Repository) MarkMessageDead(ctx context.Context, id uuid.UUID, reason string) error {
+	if _, err := r.db.ExecContext(ctx, sqlUpdateOutboxMessageDead, reason, id); err != nil {
+		return newError("cannot update outbox message", err)
+	}
+	return nil
+}
+
+// SaveWebhook implements webhook.Store.
+func (r *Repository) SaveWebhook(ctx context.Context, w webhook.Webhook) error {
+	_, err := r.db.ExecContext(ctx, sqlInsertWebhook, w.UUID, w.MerchantUUID, w.URL, w.Secret, strings.Join(w.EventTypes, ","), w.CreatedAt)
+	if err != nil {
+		return newError("cannot insert webhook", err)
+	}
+	return nil
+}
+
+// GetWebhook implements webhook.Store.
+func (r *Repository) GetWebhook(ctx context.Context, id uuid.UUID) (webhook.Webhook, error) {
+	w, err := scanWebhook(r.db.QueryRowContext(ctx, sqlSelectWebhook, id))
+	if err == sql.ErrNoRows {
+		return webhook.Webhook{}, ErrNotFound
+	}
+	if err != nil {
+		return webhook.Webhook{}, newError("got error, want one row", err)
+	}
+	return w, nil
+}
+
+// ListWebhooks implements webhook.Store.
+func (r *Repository) ListWebhooks(ctx context.Context, merchantUUID uuid.UUID) ([]webhook.Webhook, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectMerchantWebhooks, merchantUUID)
+	if err != nil {
+		return nil, newError("cannot select webhooks", err)
+	}
+	defer rows.Close()
+	webhooks := []webhook.Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, newError("cannot scan webhook", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select webhooks", err)
+	}
+	return webhooks, nil
+}
+
+// SaveDelivery implements webhook.Store.
+func (r *Repository) SaveDelivery(ctx context.Context, d webhook.Delivery) error {
+	_, err := r.db.ExecContext(ctx, sqlInsertWebhookDelivery, d.UUID, d.WebhookUUID, d.EventUUID, d.EventType, d.Payload,
+		string(d.Status), d.Attempts, d.ResponseStatus, d.LastError, d.NextAttemptAt, d.CreatedAt)
+	if err != nil {
+		return newError("cannot insert webhook delivery", err)
+	}
+	return nil
+}
+
+// UpdateDelivery implements webhook.Store. The delivery is updated and the attempt is inserted
+// in the same database transaction.
+func (r *Repository) UpdateDelivery(ctx context.Context, d webhook.Delivery, a webhook.Attempt) error {
+	dbTx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return newError("cannot begin transaction", err)
+	}
+	deliveredAt := mysql.NullTime{Time: d.DeliveredAt, Valid: !d.DeliveredAt.IsZero()}
+	_, err = dbTx.ExecContext(ctx, sqlUpdateWebhookDelivery, string(d.Status), d.Attempts, d.ResponseStatus, d.LastError,
+		d.NextAttemptAt, deliveredAt, d.UUID)
+	if err != nil {
+		dbTx.Rollback()
+		return newError("cannot update webhook delivery", err)
+	}
+	_, err = dbTx.ExecContext(ctx, sqlInsertWebhookDeliveryAttempt, a.DeliveryUUID, a.Number, string(a.Status),
+		a.ResponseStatus, a.Error, a.AttemptedAt)
+	if err != nil {
+		dbTx.Rollback()
+		return newError("cannot insert webhook delivery attempt", err)
+	}
+	if err := dbTx.Commit(); err != nil {
+		return newError("cannot commit transaction", err)
+	}
+	return nil
+}
+
+// ListAttempts implements webhook.Store.
+func (r *Repository) ListAttempts(ctx context.Context, id uuid.UUID) ([]webhook.Attempt, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectWebhookDeliveryAttempts, id)
+	if err != nil {
+		return nil, newError("cannot select webhook delivery attempts", err)
+	}
+	defer rows.Close()
+	attempts := []webhook.Attempt{}
+	for rows.Next() {
+		a := webhook.Attempt{}
+		var status string
+		if err := rows.Scan(&a.DeliveryUUID, &a.Number, &status, &a.ResponseStatus, &a.Error, &a.AttemptedAt); err != nil {
+			return nil, newError("cannot scan webhook delivery attempt", err)
+		}
+		a.Status = webhook.DeliveryStatus(status)
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select webhook delivery attempts", err)
+	}
+	return attempts, nil
+}
+
+// ListDeliveries implements webhook.Store.
+func (r *Repository) ListDeliveries(ctx context.Context, id uuid.UUID, limit int) ([]webhook.Delivery, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectWebhookDeliveries, id, limit)
+	if err != nil {
+		return nil, newError("cannot select webhook deliveries", err)
+	}
+	defer rows.Close()
+	deliveries := []webhook.Delivery{}
+	for rows.Next() {
+		d := webhook.Delivery{}
+		if err := scanDelivery(rows, &d); err != nil {
+			return nil, newError("cannot scan webhook delivery", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select webhook deliveries", err)
+	}
+	return deliveries, nil
+}
+
+// PendingDeliveries implements webhook.Store.
+func (r *Repository) PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]webhook.PendingDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectPendingWebhookDeliveries, now, limit)
+	if err != nil {
+		return nil, newError("cannot select webhook deliveries", err)
+	}
+	defer rows.Close()
+	deliveries := []webhook.PendingDelivery{}
+	for rows.Next() {
+		d := webhook.PendingDelivery{}
+		if err := scanDelivery(rows, &d.Delivery, &d.URL, &d.Secret); err != nil {
+			return nil, newError("cannot scan webhook delivery", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select webhook deliveries", err)
+	}
+	return deliveries, nil
+}
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (webhook.Webhook, error) {
+	w := webhook.Webhook{}
+	var eventTypes string
+	if err := row.Scan(&w.UUID, &w.MerchantUUID, &w.URL, &w.Secret, &eventTypes, &w.CreatedAt); err != nil {
+		return webhook.Webhook{}, err
+	}
+	if len(eventTypes) > 0 {
+		w.EventTypes = strings.Split(eventTypes, ",")
+	}
+	return w, nil
+}
+
+// scanDelivery scans the columns of the delivery into d and the following columns into dest.
+func scanDelivery(row interface{ Scan(...interface{}) error }, d *webhook.Delivery, dest ...interface{}) error {
+	var status string
+	var deliveredAt mysql.NullTime
+	err := row.Scan(append([]interface{}{&d.UUID, &d.WebhookUUID, &d.EventUUID, &d.EventType, &d.Payload, &status, &d.Attempts,
+		&d.ResponseStatus, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &deliveredAt}, dest...)...)
+	if err != nil {
+		return err
+	}
+	d.Status = webhook.DeliveryStatus(status)
+	if deliveredAt.Valid {
+		d.DeliveredAt = deliveredAt.Time
+	}
+	return nil
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (auth.APIKey, error) {
+	key := auth.APIKey{}
+	var role string
+	if err := row.Scan(&key.UUID, &key.Hash, &role, &key.Principal.Subject, &key.CreatedAt); err != nil {
+		return auth.APIKey{}, err
+	}
+	key.Principal.Role = auth.Role(role)
+	return key, nil
+}
+
+// ReplaceCard implements replay.Store.
+func (r *Repository) ReplaceCard(ctx context.Context, card *model.Card) error {
+	_, err := r.db.ExecContext(ctx,
+		sqlReplaceCard,
+		card.UUID(),
+		string(card.Currency()),
+		string(card.Status()),
+		card.AvailableBalance(),
+		card.BlockedBalance(),
+		card.Version(),
+	)
+	if err != nil {
+		return newError("cannot replace card", err)
+	}
+	return nil
+}
+
+// ListAggregates implements replay.Store.
+func (r *Repository) ListAggregates(ctx context.Context, aggregateType string) ([]uuid.UUID, error) {
+	rows, err := r.db.QueryContext(ctx, sqlSelectAggregates, aggregateType, eventsource.TypeMigrated)
+	if err != nil {
+		return nil, newError("cannot select aggregates", err)
+	}
+	defer rows.Close()
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, newError("cannot scan aggregate", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select aggregates", err)
+	}
+	return ids, nil
+}
+
+// AppendRecord implements eventsource.Store.
+func (r *Repository) AppendRecord(ctx context.Context, rec eventsource.Record) error {
+	return eventStore{r.db}.AppendRecord(ctx, rec)
+}
+
+// LastRecord implements eventsource.Store.
+func (r *Repository) LastRecord(ctx context.Context, id uuid.UUID) (eventsource.Record, error) {
+	return eventStore{r.db}.LastRecord(ctx, id)
+}
+
+// ListRecords implements eventsource.Store.
+func (r *Repository) ListRecords(ctx context.Context, id uuid.UUID, after uint64) ([]eventsource.Record, error) {
+	return eventStore{r.db}.ListRecords(ctx, id, after)
+}
+
+// SaveSnapshot implements eventsource.Store.
+func (r *Repository) SaveSnapshot(ctx context.Context, s eventsource.Snapshot) error {
+	return eventStore{r.db}.SaveSnapshot(ctx, s)
+}
+
+// LatestSnapshot implements eventsource.Store.
+func (r *Repository) LatestSnapshot(ctx context.Context, id uuid.UUID) (eventsource.Snapshot, error) {
+	return eventStore{r.db}.LatestSnapshot(ctx, id)
+}
+
+// eventStore implements eventsource.Store with q.
+type eventStore struct {
+	q querier
+}
+
+// AppendRecord implements eventsource.Store.
+func (s eventStore) AppendRecord(ctx context.Context, r eventsource.Record) error {
+	m := r.Message
+	_, err := s.q.ExecContext(ctx, sqlInsertAggregateEvent, r.AggregateUUID, r.Sequence, r.AggregateType,
+		m.UUID, m.Type, m.SchemaVersion, m.Time, m.Payload, r.Hash)
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == erDupEntry {
+		return ErrConcurrentModification
+	}
+	if err != nil {
+		return newError("cannot insert aggregate event", err)
+	}
+	return nil
+}
+
+// LastRecord implements eventsource.Store.
+func (s eventStore) LastRecord(ctx context.Context, id uuid.UUID) (eventsource.Record, error) {
+	r := eventsource.Record{}
+	err := scanRecord(s.q.QueryRowContext(ctx, sqlSelectLastAggregateEvent, id), &r)
+	if err == sql.ErrNoRows {
+		return eventsource.Record{}, ErrNotFound
+	}
+	if err != nil {
+		return eventsource.Record{}, newError("got error, want one row", err)
+	}
+	return r, nil
+}
+
+// ListRecords implements eventsource.Store.
+func (s eventStore) ListRecords(ctx context.Context, id uuid.UUID, after uint64) ([]eventsource.Record, error) {
+	rows, err := s.q.QueryContext(ctx, sqlSelectAggregateEvents, id, after)
+	if err != nil {
+		return nil, newError("cannot select aggregate events", err)
+	}
+	defer rows.Close()
+	records := []eventsource.Record{}
+	for rows.Next() {
+		r := eventsource.Record{}
+		if err := scanRecord(rows, &r); err != nil {
+			return nil, newError("cannot scan aggregate event", err)
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newError("cannot select aggregate events", err)
+	}
+	return records, nil
+}
+
+// SaveSnapshot implements eventsource.Store.
+func (s eventStore) SaveSnapshot(ctx context.Context, snapshot eventsource.Snapshot) error {
+	_, err := s.q.ExecContext(ctx, sqlInsertAggregateSnapshot, snapshot.AggregateUUID, snapshot.Sequence, snapshot.Hash,
+		snapshot.State, snapshot.Signature, time.Now())
+	if err != nil {
+		return newError("cannot insert aggregate snapshot", err)
+	}
+	return nil
+}
+
+// LatestSnapshot implements eventsource.Store.
+func (s eventStore) LatestSnapshot(ctx context.Context, id uuid.UUID) (eventsource.Snapshot, error) {
+	snapshot := eventsource.Snapshot{}
+	err := s.q.QueryRowContext(ctx, sqlSelectAggregateSnapshot, id).
+		Scan(&snapshot.AggregateUUID, &snapshot.Sequence, &snapshot.Hash, &snapshot.State, &snapshot.Signature)
+	if err == sql.ErrNoRows {
+		return eventsource.Snapshot{}, ErrNotFound
+	}
+	if err != nil {
+		return eventsource.Snapshot{}, newError("got error, want one row", err)
+	}
+	return snapshot, nil
+}
+
+func scanRecord(row interface{ Scan(...interface{}) error }, r *eventsource.Record) error {
+	m := &r.Message
+	return row.Scan(&r.AggregateUUID, &r.Sequence, &r.AggregateType, &m.UUID, &m.Type, &m.SchemaVersion, &m.Time, &m.Payload, &r.Hash)
+}
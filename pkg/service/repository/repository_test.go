@@ -3,24 +3,55 @@
 package repository_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gofrs/uuid"
 
+	"github.com/sepetrov/prepaidcard/pkg/internal/auth"
+	"github.com/sepetrov/prepaidcard/pkg/internal/event"
+	"github.com/sepetrov/prepaidcard/pkg/internal/eventsource"
+	"github.com/sepetrov/prepaidcard/pkg/internal/handler/middleware"
 	"github.com/sepetrov/prepaidcard/pkg/internal/model"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/authorize"
+	"github.com/sepetrov/prepaidcard/pkg/internal/service/listtransactions"
+	"github.com/sepetrov/prepaidcard/pkg/internal/webhook"
 	"github.com/sepetrov/prepaidcard/pkg/service/repository"
 )
 
 const sqlInsertCard = "INSERT INTO card (uuid, available_balance, blocked_balance) VALUES (?, ?, ?)"
 const sqlSelectCardWithUUID = "SELECT uuid, available_balance, blocked_balance FROM card WHERE uuid = ?"
 const sqlDeleteCard = "DELETE FROM card"
+const sqlDeleteCardLimits = "DELETE FROM card_limits"
+const sqlDeleteCardMerchantCategory = "DELETE FROM card_merchant_category"
+const sqlDeleteMerchant = "DELETE FROM merchant"
+const sqlSelectTransactionWithUUID = "SELECT card_uuid, event_uuid, event_type, amount, available_balance, blocked_balance FROM card_transaction WHERE uuid = ?"
+const sqlDeleteTransaction = "DELETE FROM card_transaction"
+const sqlSelectLedgerPostings = "SELECT account_type, direction, amount FROM ledger_posting WHERE transaction_uuid = ? ORDER BY position"
+const sqlDeleteLedgerPosting = "DELETE FROM ledger_posting"
+const sqlDeleteAuthorizationRequestSnapshot = "DELETE FROM authorization_request_snapshot"
+const sqlDeleteAuthorizationRequest = "DELETE FROM authorization_request"
+const sqlDeleteIdempotentRequest = "DELETE FROM idempotent_request"
+const sqlDeleteAPIKey = "DELETE FROM api_key"
+const sqlCountOutboxMessages = "SELECT COUNT(*) FROM outbox_message WHERE event_type = ?"
+const sqlDeleteOutboxMessage = "DELETE FROM outbox_message"
+const sqlDeleteWebhook = "DELETE FROM webhook"
+const sqlDeleteWebhookDelivery = "DELETE FROM webhook_delivery"
+const sqlDeleteWebhookDeliveryAttempt = "DELETE FROM webhook_delivery_attempt"
+const sqlDeleteAggregateEvent = "DELETE FROM aggregate_event"
+const sqlDeleteAggregateSnapshot = "DELETE FROM aggregate_snapshot"
 
 var dsn = fmt.Sprintf(
-	"%s:%s@tcp(%s:%s)/%s",
+	"%s:%s@tcp(%s:%s)/%s?parseTime=true",
 	os.Getenv("TEST_DB_USER"),
 	os.Getenv("TEST_DB_PASSWORD"),
 	os.Getenv("TEST_DB_HOST"),
@@ -32,12 +63,12 @@ func TestSaveCard(t *testing.T) {
 	db := db(t)
 	defer db.Close()
 
-	card, err := model.NewCard()
+	card, err := model.NewCard(model.GBP)
 	if err != nil {
 		t.Fatalf("cannot create new card: %v", err)
 	}
 	repo := repository.New(db)
-	if err := repo.SaveCard(card); err != nil {
+	if err := repo.SaveCard(context.Background(), card); err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
@@ -70,7 +101,7 @@ func TestGetCard(t *testing.T) {
 	db := db(t)
 	defer db.Close()
 	t.Run("returns ErrNotFound", func(t *testing.T) {
-		card, err := model.NewCard()
+		card, err := model.NewCard(model.GBP)
 		if err != nil {
 			t.Fatalf("cannot create new card: %v", err)
 		}
@@ -89,7 +120,7 @@ func TestGetCard(t *testing.T) {
 			}
 		}()
 
-		card, err = repo.GetCard(uuid.Must(uuid.NewV4()))
+		card, err = repo.GetCard(context.Background(), uuid.Must(uuid.NewV4()))
 		if err != repository.ErrNotFound {
 			t.Fatalf("got error %v, want ErrNotFound", err)
 		}
@@ -103,7 +134,7 @@ func TestGetCard(t *testing.T) {
 		defer stmt.Close()
 
 		// insert first card
-		card, err := model.NewCard()
+		card, err := model.NewCard(model.GBP)
 		if err != nil {
 			t.Fatalf("cannot create new card: %v", err)
 		}
@@ -117,7 +148,7 @@ func TestGetCard(t *testing.T) {
 		}()
 
 		// insert second card
-		card, err = model.NewCard()
+		card, err = model.NewCard(model.GBP)
 		if err != nil {
 			t.Fatalf("cannot create new card: %v", err)
 		}
@@ -125,13 +156,19 @@ func TestGetCard(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		res, err := repo.GetCard(card.UUID())
+		res, err := repo.GetCard(context.Background(), card.UUID())
 		if err != nil {
 			t.Fatalf("got error %v, want nil", err)
 		}
 		if res.UUID() != card.UUID() {
 			t.Errorf("got uuid %q, want %q", res.UUID().String(), card.UUID().String())
 		}
+		if res.Currency() != model.GBP {
+			t.Errorf("got currency %q, want %q", res.Currency(), model.GBP)
+		}
+		if res.Status() != model.CardActive {
+			t.Errorf("got status %q, want %q", res.Status(), model.CardActive)
+		}
 		if res.AvailableBalance() != card.AvailableBalance() {
 			t.Errorf("got available_balance %d, want %d", res.AvailableBalance(), card.AvailableBalance())
 		}
@@ -141,6 +178,1452 @@ func TestGetCard(t *testing.T) {
 	})
 }
 
+func TestUpdateCard(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := db.Exec(sqlDeleteCard); err != nil {
+			t.Fatalf("cannot delete test card: %v", err)
+		}
+	}()
+
+	t.Run("returns ErrNotFound", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		if err != nil {
+			t.Fatalf("cannot create new card: %v", err)
+		}
+		err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+			return r.UpdateCard(context.Background(), c)
+		})
+		if err != repository.ErrNotFound {
+			t.Fatalf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("updates card status", func(t *testing.T) {
+		if err := card.Freeze(); err != nil {
+			t.Fatalf("cannot freeze card: %v", err)
+		}
+		err := repo.WithinTx(context.Background(), func(r service.Tx) error {
+			return r.UpdateCard(context.Background(), card)
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		res, err := repo.GetCard(context.Background(), card.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if res.Status() != model.CardFrozen {
+			t.Errorf("got status %q, want %q", res.Status(), model.CardFrozen)
+		}
+		if res.Version() != card.Version() {
+			t.Errorf("got version %d, want %d", res.Version(), card.Version())
+		}
+	})
+	t.Run("returns ErrConcurrentModification if the card has changed", func(t *testing.T) {
+		c1, err := repo.GetCard(context.Background(), card.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		c2, err := repo.GetCard(context.Background(), card.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if err := c1.Unfreeze(); err != nil {
+			t.Fatalf("cannot unfreeze card: %v", err)
+		}
+		err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+			return r.UpdateCard(context.Background(), c1)
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if err := c2.Block(); err != nil {
+			t.Fatalf("cannot block card: %v", err)
+		}
+		err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+			return r.UpdateCard(context.Background(), c2)
+		})
+		if err != repository.ErrConcurrentModification {
+			t.Fatalf("got error %v, want ErrConcurrentModification", err)
+		}
+	})
+}
+
+func TestSaveTransaction(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := db.Exec(sqlDeleteLedgerPosting); err != nil {
+			t.Fatalf("cannot delete test ledger postings: %v", err)
+		}
+		if _, err := db.Exec(sqlDeleteTransaction); err != nil {
+			t.Fatalf("cannot delete test transaction: %v", err)
+		}
+		if _, err := db.Exec(sqlDeleteCard); err != nil {
+			t.Fatalf("cannot delete test card: %v", err)
+		}
+	}()
+
+	if err := card.LoadMoney(1950); err != nil {
+		t.Fatalf("cannot load card: %v", err)
+	}
+	tx, err := model.NewTransaction(card, nil, uuid.Must(uuid.NewV4()), "CardLoaded", 1950, "Card load", time.Now())
+	if err != nil {
+		t.Fatalf("cannot create new transaction: %v", err)
+	}
+	err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+		if err := r.UpdateCard(context.Background(), card); err != nil {
+			return err
+		}
+		return r.SaveTransaction(context.Background(), tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := repo.GetCard(context.Background(), card.UUID())
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	if res.AvailableBalance() != 1950 {
+		t.Errorf("got available_balance %d, want %d", res.AvailableBalance(), 1950)
+	}
+
+	txRes := struct {
+		cardUUID         string
+		eventUUID        string
+		eventType        string
+		amount           uint64
+		availableBalance uint64
+		blockedBalance   uint64
+	}{}
+	row := db.QueryRow(sqlSelectTransactionWithUUID, tx.UUID())
+	if err := row.Scan(&txRes.cardUUID, &txRes.eventUUID, &txRes.eventType, &txRes.amount, &txRes.availableBalance, &txRes.blockedBalance); err != nil {
+		t.Fatalf("got error, want one row: %v", err)
+	}
+	if txRes.cardUUID != card.UUID().String() {
+		t.Errorf("got card_uuid %q, want %q", txRes.cardUUID, card.UUID().String())
+	}
+	if txRes.eventUUID != tx.EventUUID().String() {
+		t.Errorf("got event_uuid %q, want %q", txRes.eventUUID, tx.EventUUID().String())
+	}
+	if txRes.eventType != tx.EventType() {
+		t.Errorf("got event_type %q, want %q", txRes.eventType, tx.EventType())
+	}
+	if txRes.amount != 1950 || txRes.availableBalance != 1950 || txRes.blockedBalance != 0 {
+		t.Errorf("got amount %d, available_balance %d, blocked_balance %d, want 1950, 1950, 0", txRes.amount, txRes.availableBalance, txRes.blockedBalance)
+	}
+
+	rows, err := db.Query(sqlSelectLedgerPostings, tx.UUID())
+	if err != nil {
+		t.Fatalf("cannot select ledger postings: %v", err)
+	}
+	defer rows.Close()
+	postings := []string{}
+	for rows.Next() {
+		var accountType, direction string
+		var amount uint64
+		if err := rows.Scan(&accountType, &direction, &amount); err != nil {
+			t.Fatalf("cannot scan ledger posting: %v", err)
+		}
+		postings = append(postings, fmt.Sprintf("%s %s %d", accountType, direction, amount))
+	}
+	want := []string{"bank_float debit 1950", "card_available credit 1950"}
+	if fmt.Sprint(postings) != fmt.Sprint(want) {
+		t.Errorf("got ledger postings %v, want %v", postings, want)
+	}
+}
+
+func TestCardLimits(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, q := range []string{
+			sqlDeleteLedgerPosting,
+			sqlDeleteTransaction,
+			sqlDeleteCardLimits,
+			sqlDeleteCard,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	t.Run("returns ErrNotFound", func(t *testing.T) {
+		ctx := context.Background()
+		err := repo.WithinTx(ctx, func(r service.Tx) error {
+			_, err := r.GetCardLimits(ctx, card.UUID())
+			return err
+		})
+		if err != repository.ErrNotFound {
+			t.Errorf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("saves and returns the card limits", func(t *testing.T) {
+		ctx := context.Background()
+		for _, want := range []model.CardLimits{
+			{Product: "travel", Limits: model.Limits{Daily: 500, Authorizations: 5, AuthorizationWindow: time.Hour}},
+			{Product: model.DefaultProduct, Limits: model.Limits{PerTransaction: 100, Monthly: 1000, DailyLoad: 2000}},
+		} {
+			var got model.CardLimits
+			err := repo.WithinTx(ctx, func(r service.Tx) error {
+				if err := r.SaveCardLimits(ctx, card.UUID(), want); err != nil {
+					return err
+				}
+				var err error
+				got, err = r.GetCardLimits(ctx, card.UUID())
+				return err
+			})
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if got != want {
+				t.Errorf("got card limits %+v, want %+v", got, want)
+			}
+		}
+	})
+	t.Run("returns the card transactions since the date", func(t *testing.T) {
+		ctx := context.Background()
+		since := time.Now().Add(-time.Second)
+		var want []uuid.UUID
+		for i := 0; i < 2; i++ {
+			if err := card.LoadMoney(100); err != nil {
+				t.Fatalf("cannot load card: %v", err)
+			}
+			tx, err := model.NewTransaction(card, nil, uuid.Must(uuid.NewV4()), event.TypeCardLoaded, 100, "Card load", time.Now())
+			if err != nil {
+				t.Fatalf("cannot create new transaction: %v", err)
+			}
+			err = repo.WithinTx(ctx, func(r service.Tx) error {
+				if err := r.UpdateCard(ctx, card); err != nil {
+					return err
+				}
+				return r.SaveTransaction(ctx, tx)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, tx.UUID())
+		}
+		for _, tc := range []struct {
+			since time.Time
+			want  []uuid.UUID
+		}{
+			{since, want},
+			{time.Now().Add(time.Hour), nil},
+		} {
+			got := map[uuid.UUID]bool{}
+			err := repo.WithinTx(ctx, func(r service.Tx) error {
+				txs, err := r.ListCardTransactions(ctx, card.UUID(), tc.since)
+				for _, tx := range txs {
+					got[tx.UUID()] = true
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Errorf("got %d transactions since %v, want %d", len(got), tc.since, len(tc.want))
+			}
+			for _, id := range tc.want {
+				if !got[id] {
+					t.Errorf("got no transaction %v since %v, want it", id, tc.since)
+				}
+			}
+		}
+	})
+}
+
+func TestMerchant(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, q := range []string{sqlDeleteCardMerchantCategory, sqlDeleteCard, sqlDeleteMerchant} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	t.Run("saves and returns the merchant", func(t *testing.T) {
+		ctx := context.Background()
+		m, err := model.NewMerchant("Grand Hotel", "7011", "GB", "GB29NWBK60161331926819")
+		if err != nil {
+			t.Fatalf("cannot create new merchant: %v", err)
+		}
+		for _, change := range []func() error{
+			func() error { return nil },
+			func() error { return m.Update("Car Rental", "7512", "IE", "IE29AIBK93115212345678") },
+			func() error { return m.SetStatus(model.MerchantSuspended) },
+		} {
+			if err := change(); err != nil {
+				t.Fatalf("cannot change merchant: %v", err)
+			}
+			var got *model.Merchant
+			err := repo.WithinTx(ctx, func(r service.Tx) error {
+				if err := r.SaveMerchant(ctx, m); err != nil {
+					return err
+				}
+				var err error
+				got, err = r.GetMerchant(ctx, m.UUID())
+				return err
+			})
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, m) {
+				t.Errorf("got merchant %+v, want %+v", got, m)
+			}
+		}
+	})
+	t.Run("returns ErrNotFound", func(t *testing.T) {
+		ctx := context.Background()
+		err := repo.WithinTx(ctx, func(r service.Tx) error {
+			_, err := r.GetMerchant(ctx, uuid.Must(uuid.NewV4()))
+			return err
+		})
+		if err != repository.ErrNotFound {
+			t.Errorf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("replaces and returns the merchant categories of the card", func(t *testing.T) {
+		ctx := context.Background()
+		for _, want := range []model.MerchantCategories{
+			{},
+			{Allowed: []string{"5411", "5812"}, Blocked: []string{"7995"}},
+			{Blocked: []string{"5933"}},
+		} {
+			var got model.MerchantCategories
+			err := repo.WithinTx(ctx, func(r service.Tx) error {
+				if err := r.SaveCardMerchantCategories(ctx, card.UUID(), want); err != nil {
+					return err
+				}
+				var err error
+				got, err = r.GetCardMerchantCategories(ctx, card.UUID())
+				return err
+			})
+			if err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+			if fmt.Sprint(got.Allowed, got.Blocked) != fmt.Sprint(want.Allowed, want.Blocked) {
+				t.Errorf("got merchant categories %+v, want %+v", got, want)
+			}
+		}
+	})
+}
+
+func TestSaveAuthorizationRequest(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, q := range []string{
+			sqlDeleteLedgerPosting,
+			sqlDeleteTransaction,
+			sqlDeleteAuthorizationRequestSnapshot,
+			sqlDeleteAuthorizationRequest,
+			sqlDeleteCard,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	if err := card.LoadMoney(100); err != nil {
+		t.Fatalf("cannot load card: %v", err)
+	}
+	req, err := model.NewAuthorizationRequest(card, uuid.Must(uuid.NewV4()), model.NewMoney(70, model.GBP), nil, time.Now())
+	if err != nil {
+		t.Fatalf("cannot create authorization request: %v", err)
+	}
+	tx, err := model.NewTransaction(card, req, uuid.Must(uuid.NewV4()), "AuthorizationRequestCreated", 70, "Authorization request", time.Now())
+	if err != nil {
+		t.Fatalf("cannot create new transaction: %v", err)
+	}
+	err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+		if err := r.UpdateCard(context.Background(), card); err != nil {
+			return err
+		}
+		if err := r.SaveAuthorizationRequest(context.Background(), req); err != nil {
+			return err
+		}
+		return r.SaveTransaction(context.Background(), tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Reverse(card, 20, time.Now()); err != nil {
+		t.Fatalf("cannot reverse authorization request: %v", err)
+	}
+	tx, err = model.NewTransaction(card, req, uuid.Must(uuid.NewV4()), "AuthorizationRequestReversed", 20, "Authorization reversal", time.Now())
+	if err != nil {
+		t.Fatalf("cannot create new transaction: %v", err)
+	}
+	err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+		if err := r.UpdateCard(context.Background(), card); err != nil {
+			return err
+		}
+		if err := r.SaveAuthorizationRequest(context.Background(), req); err != nil {
+			return err
+		}
+		return r.SaveTransaction(context.Background(), tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("returns ErrNotFound", func(t *testing.T) {
+		if _, err := repo.GetAuthorizationRequest(context.Background(), uuid.Must(uuid.NewV4())); err != repository.ErrNotFound {
+			t.Fatalf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("returns authorization request with history", func(t *testing.T) {
+		res, err := repo.GetAuthorizationRequest(context.Background(), req.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if res.UUID() != req.UUID() || res.CardUUID() != req.CardUUID() || res.MerchantUUID() != req.MerchantUUID() {
+			t.Errorf("got authorization request %+v, want %+v", res, req)
+		}
+		if res.BlockedAmount() != 50 || res.CapturedAmount() != 0 || res.RefundedAmount() != 0 {
+			t.Errorf("got amounts %d, %d, %d, want 50, 0, 0", res.BlockedAmount(), res.CapturedAmount(), res.RefundedAmount())
+		}
+		if res.Rate().Rate() != "1" || res.Currency() != model.GBP || res.Rate().To() != model.GBP {
+			t.Errorf("got rate %s/%s %s, want GBP/GBP 1", res.Rate().From(), res.Rate().To(), res.Rate().Rate())
+		}
+		if res.OriginalAmount() != 70 || res.ConvertedAmount() != 70 || res.OriginalBlockedAmount() != 50 {
+			t.Errorf("got original amounts %d, %d, %d, want 70, 70, 50", res.OriginalAmount(), res.ConvertedAmount(), res.OriginalBlockedAmount())
+		}
+		if !res.ExpiresAt().Equal(req.ExpiresAt().Truncate(time.Microsecond)) {
+			t.Errorf("got expiry %v, want %v", res.ExpiresAt(), req.ExpiresAt())
+		}
+		if len(res.History()) != len(req.History()) {
+			t.Fatalf("got %d snapshots, want %d", len(res.History()), len(req.History()))
+		}
+		for i, s := range res.History() {
+			want := req.History()[i]
+			if s.UUID() != want.UUID() || s.BlockedAmount() != want.BlockedAmount() ||
+				s.OriginalBlockedAmount() != want.OriginalBlockedAmount() || s.Rate().Rate() != want.Rate().Rate() ||
+				!s.CreatedAt().Equal(want.CreatedAt().Truncate(time.Microsecond)) {
+				t.Errorf("got snapshot %d %+v, want %+v", i, s, want)
+			}
+		}
+		c, err := repo.GetCard(context.Background(), card.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if c.AvailableBalance() != 50 || c.BlockedBalance() != 50 {
+			t.Errorf("got card balances %d, %d, want 50, 50", c.AvailableBalance(), c.BlockedBalance())
+		}
+	})
+	t.Run("returns the transactions with the authorization request UUID", func(t *testing.T) {
+		err := repo.WithinTx(context.Background(), func(r service.Tx) error {
+			txs, err := r.ListCardTransactions(context.Background(), card.UUID(), time.Time{})
+			if len(txs) != 2 {
+				t.Errorf("got %d transactions, want 2", len(txs))
+			}
+			for _, tx := range txs {
+				if tx.AuthorizationRequestUUID() != req.UUID() {
+					t.Errorf("got transaction authorization request UUID %v, want %v", tx.AuthorizationRequestUUID(), req.UUID())
+				}
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+	})
+	t.Run("returns expired authorization requests", func(t *testing.T) {
+		ids, err := repo.ListExpiredAuthorizationRequests(context.Background(), req.ExpiresAt().Add(-time.Second), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(ids) != 0 {
+			t.Errorf("got expired authorization requests %v before the expiry, want none", ids)
+		}
+		ids, err = repo.ListExpiredAuthorizationRequests(context.Background(), req.ExpiresAt(), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(ids) != 1 || ids[0] != req.UUID() {
+			t.Errorf("got expired authorization requests %v, want [%v]", ids, req.UUID())
+		}
+	})
+	t.Run("returns ledger balances", func(t *testing.T) {
+		balances, err := repo.LedgerBalances(context.Background())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		got := map[model.Account]string{}
+		for _, b := range balances {
+			got[b.Account] = fmt.Sprintf("%s/%s", b.Debit, b.Credit)
+		}
+		want := map[model.Account]string{
+			model.NewAccount(model.AccountBankFloat, uuid.Nil, model.GBP):        "100/0",
+			model.NewAccount(model.AccountCardAvailable, card.UUID(), model.GBP): "70/120",
+			model.NewAccount(model.AccountCardBlocked, card.UUID(), model.GBP):   "20/70",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got ledger balances %v, want %v", got, want)
+		}
+	})
+	t.Run("returns cards", func(t *testing.T) {
+		cards, err := repo.ListCards(context.Background())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(cards) != 1 || cards[0].UUID() != card.UUID() {
+			t.Errorf("got cards %+v, want [%+v]", cards, card)
+		}
+	})
+}
+
+func TestSaveAuthorizationRequest_concurrency(t *testing.T) {
+	const balance, requests = 50, 200
+
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	if err := card.LoadMoney(balance); err != nil {
+		t.Fatalf("cannot load card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, q := range []string{
+			sqlDeleteLedgerPosting,
+			sqlDeleteTransaction,
+			sqlDeleteAuthorizationRequestSnapshot,
+			sqlDeleteAuthorizationRequest,
+			sqlDeleteCard,
+			sqlDeleteMerchant,
+			sqlDeleteOutboxMessage,
+			sqlDeleteAggregateEvent,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+	merchant, err := model.NewMerchant("Test Merchant", "5411", "GB", "GB29NWBK60161331926819")
+	if err != nil {
+		t.Fatalf("cannot create new merchant: %v", err)
+	}
+	err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+		return r.SaveMerchant(context.Background(), merchant)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := authorize.New(repo, nil, nil, nil)
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.Authorize(context.Background(), authorize.Request{
+				MerchantUUID: merchant.UUID().String(),
+				CardUUID:     card.UUID().String(),
+				Amount:       "1",
+				Currency:     "GBP",
+			})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var authorized uint64
+	for err := range errs {
+		if err == nil {
+			authorized++
+			continue
+		}
+		if res, ok := err.(service.ErrorResponse); ok && res.StatusCode() == 422 {
+			continue
+		}
+		if kind := service.KindOf(err); kind != service.ErrConcurrentModification && kind != service.ErrUnavailable {
+			t.Fatalf("got error %v, want nil, 422 or concurrent modification", err)
+		}
+	}
+	if authorized > balance {
+		t.Fatalf("got %d authorizations, want at most %d", authorized, balance)
+	}
+	res, err := repo.GetCard(context.Background(), card.UUID())
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	if res.BlockedBalance() != authorized {
+		t.Errorf("got blocked balance %d, want %d", res.BlockedBalance(), authorized)
+	}
+	if res.AvailableBalance() != balance-authorized {
+		t.Errorf("got available balance %d, want %d", res.AvailableBalance(), balance-authorized)
+	}
+	var events uint64
+	if err := db.QueryRow(sqlCountOutboxMessages, event.TypeAuthorizationRequestCreated).Scan(&events); err != nil {
+		t.Fatalf("cannot count outbox messages: %v", err)
+	}
+	if events != authorized {
+		t.Errorf("got %d saved events, want %d", events, authorized)
+	}
+}
+
+func TestWithinTx(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, q := range []string{
+			sqlDeleteLedgerPosting,
+			sqlDeleteTransaction,
+			sqlDeleteAuthorizationRequestSnapshot,
+			sqlDeleteAuthorizationRequest,
+			sqlDeleteCard,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	t.Run("commits the changes if the function returns nil", func(t *testing.T) {
+		ctx := context.Background()
+		err := repo.WithinTx(ctx, func(r service.Tx) error {
+			c, err := r.GetCard(ctx, card.UUID())
+			if err != nil {
+				return err
+			}
+			if err := c.LoadMoney(100); err != nil {
+				return err
+			}
+			tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), event.TypeCardLoaded, 100, "Card load", time.Now())
+			if err != nil {
+				return err
+			}
+			if err := r.UpdateCard(ctx, c); err != nil {
+				return err
+			}
+			return r.SaveTransaction(ctx, tx)
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		c, err := repo.GetCard(ctx, card.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if c.AvailableBalance() != 100 || c.Version() != 2 {
+			t.Errorf("got available balance %d and version %d, want 100 and 2", c.AvailableBalance(), c.Version())
+		}
+	})
+	t.Run("rolls back the changes if the function returns error", func(t *testing.T) {
+		ctx := context.Background()
+		fail := errors.New("test failed")
+		var req *model.AuthorizationRequest
+		err := repo.WithinTx(ctx, func(r service.Tx) error {
+			c, err := r.GetCard(ctx, card.UUID())
+			if err != nil {
+				return err
+			}
+			req, err = model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(30, model.GBP), nil, time.Now())
+			if err != nil {
+				return err
+			}
+			tx, err := model.NewTransaction(c, nil, uuid.Must(uuid.NewV4()), event.TypeAuthorizationRequestCreated, 30, "Authorization request", time.Now())
+			if err != nil {
+				return err
+			}
+			if err := r.UpdateCard(ctx, c); err != nil {
+				return err
+			}
+			if err := r.SaveAuthorizationRequest(ctx, req); err != nil {
+				return err
+			}
+			if err := r.SaveTransaction(ctx, tx); err != nil {
+				return err
+			}
+			if _, err := r.GetAuthorizationRequest(ctx, req.UUID()); err != nil {
+				return err
+			}
+			return fail
+		})
+		if err != fail {
+			t.Fatalf("got error %v, want %v", err, fail)
+		}
+		c, err := repo.GetCard(ctx, card.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if c.AvailableBalance() != 100 || c.BlockedBalance() != 0 || c.Version() != 2 {
+			t.Errorf("got card balances %d, %d and version %d, want 100, 0 and 2", c.AvailableBalance(), c.BlockedBalance(), c.Version())
+		}
+		if _, err := repo.GetAuthorizationRequest(ctx, req.UUID()); err != repository.ErrNotFound {
+			t.Errorf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("saves the requested amounts of the incremented authorization request", func(t *testing.T) {
+		ctx := context.Background()
+		var id uuid.UUID
+		err := repo.WithinTx(ctx, func(r service.Tx) error {
+			c, err := r.GetCard(ctx, card.UUID())
+			if err != nil {
+				return err
+			}
+			req, err := model.NewAuthorizationRequest(c, uuid.Must(uuid.NewV4()), model.NewMoney(30, model.GBP), nil, time.Now())
+			if err != nil {
+				return err
+			}
+			id = req.UUID()
+			if err := r.UpdateCard(ctx, c); err != nil {
+				return err
+			}
+			return r.SaveAuthorizationRequest(ctx, req)
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		err = repo.WithinTx(ctx, func(r service.Tx) error {
+			c, err := r.GetCard(ctx, card.UUID())
+			if err != nil {
+				return err
+			}
+			req, err := r.GetAuthorizationRequest(ctx, id)
+			if err != nil {
+				return err
+			}
+			if err := req.Increment(c, 20, time.Now()); err != nil {
+				return err
+			}
+			if err := r.UpdateCard(ctx, c); err != nil {
+				return err
+			}
+			return r.SaveAuthorizationRequest(ctx, req)
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		res, err := repo.GetAuthorizationRequest(ctx, id)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if res.OriginalAmount() != 50 || res.ConvertedAmount() != 50 || res.BlockedAmount() != 50 {
+			t.Errorf("got amounts %d, %d, %d, want 50, 50, 50", res.OriginalAmount(), res.ConvertedAmount(), res.BlockedAmount())
+		}
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+	defer func() {
+		for _, q := range []string{sqlDeleteOutboxMessage, sqlDeleteAggregateEvent} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	repo := repository.New(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	e := event.CardLoaded{
+		UUID:     uuid.Must(uuid.NewV4()),
+		Time:     now,
+		CardUUID: uuid.Must(uuid.NewV4()),
+		Amount:   100,
+	}
+	err := repo.WithinTx(ctx, func(r service.Tx) error {
+		return r.SaveEvent(ctx, e)
+	})
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+	err = repo.WithinTx(ctx, func(r service.Tx) error {
+		if err := r.SaveEvent(ctx, event.CardFrozen{UUID: uuid.Must(uuid.NewV4()), Time: now}); err != nil {
+			return err
+		}
+		return errors.New("test failed")
+	})
+	if err == nil {
+		t.Fatal("got error nil, want error")
+	}
+
+	t.Run("returns the committed events", func(t *testing.T) {
+		messages, err := repo.PendingMessages(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(messages) != 1 {
+			t.Fatalf("got %d pending messages, want 1", len(messages))
+		}
+		if messages[0].Attempts != 0 {
+			t.Errorf("got %d attempts, want 0", messages[0].Attempts)
+		}
+		res, err := event.Unmarshal(messages[0].Message)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if got, ok := res.(event.CardLoaded); !ok || got.UUID != e.UUID || got.CardUUID != e.CardUUID || got.Amount != e.Amount || !got.Time.Equal(e.Time) {
+			t.Errorf("got event %#v, want %#v", res, e)
+		}
+	})
+	t.Run("postpones the failed message", func(t *testing.T) {
+		retryAt := time.Now().Add(time.Hour)
+		if err := repo.MarkMessageFailed(ctx, e.UUID, retryAt, "test failed"); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		messages, err := repo.PendingMessages(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(messages) != 0 {
+			t.Fatalf("got %d pending messages, want 0", len(messages))
+		}
+		messages, err = repo.PendingMessages(ctx, retryAt.Add(time.Second), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(messages) != 1 || messages[0].Attempts != 1 {
+			t.Fatalf("got pending messages %+v, want 1 message with 1 attempt", messages)
+		}
+	})
+	t.Run("does not return the sent message", func(t *testing.T) {
+		if err := repo.MarkMessageSent(ctx, e.UUID, time.Now()); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		messages, err := repo.PendingMessages(ctx, time.Now().Add(2*time.Hour), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(messages) != 0 {
+			t.Errorf("got %d pending messages, want 0", len(messages))
+		}
+	})
+	t.Run("does not return the dead message", func(t *testing.T) {
+		id := uuid.Must(uuid.NewV4())
+		err := repo.WithinTx(ctx, func(r service.Tx) error {
+			return r.SaveEvent(ctx, event.CardBlocked{UUID: id, Time: now})
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if err := repo.MarkMessageDead(ctx, id, "test failed"); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		messages, err := repo.PendingMessages(ctx, time.Now(), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(messages) != 0 {
+			t.Errorf("got %d pending messages, want 0", len(messages))
+		}
+	})
+}
+
+func TestEventSourcing(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+	defer func() {
+		for _, q := range []string{sqlDeleteOutboxMessage, sqlDeleteAggregateSnapshot, sqlDeleteAggregateEvent, sqlDeleteCard} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	repo := repository.New(db, repository.EventSourcedOption(), repository.EventKeyOption([]byte("secret")))
+	card := uuid.Must(uuid.NewV4())
+	err := repo.WithinTx(ctx, func(r service.Tx) error {
+		for _, e := range []interface{}{
+			event.CardCreated{UUID: uuid.Must(uuid.NewV4()), Time: time.Now(), CardUUID: card, Currency: "GBP"},
+			event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), Time: time.Now(), CardUUID: card, Amount: 100},
+		} {
+			if err := r.SaveEvent(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	t.Run("folds the card from its events", func(t *testing.T) {
+		c, err := repo.GetCard(ctx, card)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if c.Currency() != model.GBP || c.AvailableBalance() != 100 || c.Version() != 2 {
+			t.Errorf("got card %s %d version %d, want GBP 100 version 2", c.Currency(), c.AvailableBalance(), c.Version())
+		}
+	})
+	t.Run("returns ErrNotFound if the card has no events", func(t *testing.T) {
+		if _, err := repo.GetCard(ctx, uuid.Must(uuid.NewV4())); err != repository.ErrNotFound {
+			t.Errorf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("migrates the card, which has no events", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		if err != nil {
+			t.Fatalf("cannot create new card: %v", err)
+		}
+		if _, err := db.Exec(sqlInsertCard, c.UUID(), 70, 30); err != nil {
+			t.Fatal(err)
+		}
+		res, err := repo.GetCard(ctx, c.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want the card of the table", err)
+		}
+		if res.AvailableBalance() != 70 || res.Version() != 1 {
+			t.Errorf("got available balance %d and version %d, want 70 and 1", res.AvailableBalance(), res.Version())
+		}
+		err = repo.WithinTx(ctx, func(r service.Tx) error {
+			_, err := r.GetCard(ctx, c.UUID())
+			return err
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		last, err := repo.LastRecord(ctx, c.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want the migration event", err)
+		}
+		if last.Sequence != 1 || last.Message.Type != eventsource.TypeMigrated {
+			t.Errorf("got last event %d %s, want 1 %s", last.Sequence, last.Message.Type, eventsource.TypeMigrated)
+		}
+		res, err = eventsource.LoadCard(ctx, repo, repo.EventKey(), c.UUID())
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if res.AvailableBalance() != 70 || res.BlockedBalance() != 30 || res.Version() != 1 {
+			t.Errorf("got card %d %d version %d, want 70 30 version 1", res.AvailableBalance(), res.BlockedBalance(), res.Version())
+		}
+	})
+	t.Run("returns ErrConcurrentModification if the sequence number exists", func(t *testing.T) {
+		last, err := repo.LastRecord(ctx, card)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if last.Sequence != 2 {
+			t.Fatalf("got last sequence %d, want 2", last.Sequence)
+		}
+		last.Message.UUID = uuid.Must(uuid.NewV4())
+		if err := repo.AppendRecord(ctx, last); service.KindOf(err) != service.ErrConcurrentModification {
+			t.Errorf("got error %v, want concurrent modification", err)
+		}
+	})
+	t.Run("returns error if the event does not match the version of the saved card", func(t *testing.T) {
+		c, err := model.NewCard(model.GBP)
+		if err != nil {
+			t.Fatalf("cannot create new card: %v", err)
+		}
+		err = repo.WithinTx(ctx, func(r service.Tx) error {
+			if err := r.SaveCard(ctx, c); err != nil {
+				return err
+			}
+			for _, e := range []interface{}{
+				event.CardCreated{UUID: uuid.Must(uuid.NewV4()), Time: time.Now(), CardUUID: c.UUID(), Currency: "GBP"},
+				event.CardLoaded{UUID: uuid.Must(uuid.NewV4()), Time: time.Now(), CardUUID: c.UUID(), Amount: 100},
+			} {
+				if err := r.SaveEvent(ctx, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err == nil {
+			t.Fatal("got error nil, want error")
+		}
+		if _, err := repo.LastRecord(ctx, c.UUID()); err != repository.ErrNotFound {
+			t.Errorf("got error %v, want ErrNotFound", err)
+		}
+	})
+	t.Run("returns the chained events", func(t *testing.T) {
+		records, err := repo.ListRecords(ctx, card, 0)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(records) != 2 || records[1].Sequence != 2 || records[1].Message.Type != event.TypeCardLoaded {
+			t.Fatalf("got records %+v, want CardCreated and CardLoaded", records)
+		}
+		if records[1].Hash != eventsource.Hash(repo.EventKey(), records[0].Hash, records[1]) {
+			t.Errorf("got hash %q, which does not match the record", records[1].Hash)
+		}
+	})
+	t.Run("replaces the card projection", func(t *testing.T) {
+		ids, err := repo.ListAggregates(ctx, eventsource.AggregateCard)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		found := false
+		for _, id := range ids {
+			found = found || id == card
+		}
+		if !found {
+			t.Fatalf("got cards %v, want %v", ids, card)
+		}
+		c, err := repo.GetCard(ctx, card)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := repo.ReplaceCard(ctx, c); err != nil {
+				t.Fatalf("got error %v, want nil", err)
+			}
+		}
+		res, err := repository.New(db).GetCard(ctx, card)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if res.AvailableBalance() != 100 || res.Version() != 2 {
+			t.Errorf("got available balance %d and version %d, want 100 and 2", res.AvailableBalance(), res.Version())
+		}
+	})
+}
+
+func TestWebhook(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+	defer func() {
+		for _, q := range []string{sqlDeleteWebhookDeliveryAttempt, sqlDeleteWebhookDelivery, sqlDeleteWebhook} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("cannot delete test data: %v", err)
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	repo := repository.New(db)
+	now := time.Now().UTC().Truncate(time.Second)
+	w := webhook.Webhook{
+		UUID:         uuid.Must(uuid.NewV4()),
+		MerchantUUID: uuid.Must(uuid.NewV4()),
+		URL:          "https://example.com/webhook",
+		Secret:       "0123456789abcdef",
+		EventTypes:   []string{event.TypeAuthorizationRequestCaptured, event.TypeAuthorizationRequestRefunded},
+		CreatedAt:    now,
+	}
+	if err := repo.SaveWebhook(ctx, w); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	t.Run("returns the webhook", func(t *testing.T) {
+		got, err := repo.GetWebhook(ctx, w.UUID)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if !got.CreatedAt.Equal(w.CreatedAt) {
+			t.Errorf("got created at %v, want %v", got.CreatedAt, w.CreatedAt)
+		}
+		got.CreatedAt = w.CreatedAt
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("got webhook %+v, want %+v", got, w)
+		}
+	})
+	t.Run("returns ErrNotFound if the webhook does not exist", func(t *testing.T) {
+		if _, err := repo.GetWebhook(ctx, uuid.Must(uuid.NewV4())); err != service.ErrNotFound {
+			t.Errorf("got error %v, want %v", err, service.ErrNotFound)
+		}
+	})
+	t.Run("lists the webhooks of the merchant", func(t *testing.T) {
+		webhooks, err := repo.ListWebhooks(ctx, w.MerchantUUID)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(webhooks) != 1 || webhooks[0].UUID != w.UUID {
+			t.Errorf("got webhooks %+v, want %v", webhooks, w.UUID)
+		}
+		webhooks, err = repo.ListWebhooks(ctx, uuid.Must(uuid.NewV4()))
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(webhooks) != 0 {
+			t.Errorf("got %d webhooks of other merchant, want 0", len(webhooks))
+		}
+	})
+
+	d := webhook.Delivery{
+		UUID:          uuid.Must(uuid.NewV4()),
+		WebhookUUID:   w.UUID,
+		EventUUID:     uuid.Must(uuid.NewV4()),
+		EventType:     event.TypeAuthorizationRequestCaptured,
+		Payload:       []byte(`{"foo":"bar"}`),
+		Status:        webhook.DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if err := repo.SaveDelivery(ctx, d); err != nil {
+		t.Fatalf("got error %v, want nil", err)
+	}
+
+	t.Run("ignores the delivery of the same event to the same webhook", func(t *testing.T) {
+		again := d
+		again.UUID = uuid.Must(uuid.NewV4())
+		if err := repo.SaveDelivery(ctx, again); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		deliveries, err := repo.ListDeliveries(ctx, w.UUID, 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(deliveries) != 1 || deliveries[0].UUID != d.UUID {
+			t.Errorf("got deliveries %+v, want %v", deliveries, d.UUID)
+		}
+	})
+	t.Run("returns the pending deliveries with their endpoints", func(t *testing.T) {
+		pending, err := repo.PendingDeliveries(ctx, now, 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(pending) != 1 {
+			t.Fatalf("got %d pending deliveries, want 1", len(pending))
+		}
+		if pending[0].UUID != d.UUID || pending[0].URL != w.URL || pending[0].Secret != w.Secret || string(pending[0].Payload) != string(d.Payload) {
+			t.Errorf("got pending delivery %+v, want %+v of %+v", pending[0], d, w)
+		}
+		pending, err = repo.PendingDeliveries(ctx, now.Add(-time.Second), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("got %d pending deliveries before they are due, want 0", len(pending))
+		}
+	})
+	t.Run("updates the delivery and appends the attempts to its history", func(t *testing.T) {
+		failed := d
+		failed.Attempts = 1
+		failed.ResponseStatus = 500
+		failed.LastError = "endpoint responded with status 500"
+		first := webhook.Attempt{DeliveryUUID: d.UUID, Number: 1, Status: webhook.DeliveryFailed, ResponseStatus: 500,
+			Error: failed.LastError, AttemptedAt: now}
+		if err := repo.UpdateDelivery(ctx, failed, first); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		sent := d
+		sent.Status = webhook.DeliverySucceeded
+		sent.Attempts = 2
+		sent.ResponseStatus = 202
+		sent.LastError = ""
+		sent.DeliveredAt = now.Add(time.Minute)
+		second := webhook.Attempt{DeliveryUUID: d.UUID, Number: 2, Status: webhook.DeliverySucceeded, ResponseStatus: 202,
+			AttemptedAt: sent.DeliveredAt}
+		if err := repo.UpdateDelivery(ctx, sent, second); err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		attempts, err := repo.ListAttempts(ctx, d.UUID)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(attempts) != 2 {
+			t.Fatalf("got %d attempts, want 2", len(attempts))
+		}
+		for i, want := range []webhook.Attempt{first, second} {
+			got := attempts[i]
+			if got.DeliveryUUID != want.DeliveryUUID || got.Number != want.Number || got.Status != want.Status ||
+				got.ResponseStatus != want.ResponseStatus || got.Error != want.Error || !got.AttemptedAt.Equal(want.AttemptedAt) {
+				t.Errorf("got attempt %+v, want %+v", got, want)
+			}
+		}
+		deliveries, err := repo.ListDeliveries(ctx, w.UUID, 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(deliveries) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(deliveries))
+		}
+		got := deliveries[0]
+		if got.Status != sent.Status || got.Attempts != sent.Attempts || got.ResponseStatus != sent.ResponseStatus || !got.DeliveredAt.Equal(sent.DeliveredAt) {
+			t.Errorf("got delivery %+v, want %+v", got, sent)
+		}
+		pending, err := repo.PendingDeliveries(ctx, now.Add(time.Hour), 10)
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("got %d pending deliveries, want 0", len(pending))
+		}
+	})
+}
+
+func TestIdempotentRequest(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+	defer func() {
+		if _, err := db.Exec(sqlDeleteIdempotentRequest); err != nil {
+			t.Fatalf("cannot delete test data: %v", err)
+		}
+	}()
+
+	repo := repository.New(db)
+	stale := time.Now().Add(-time.Minute)
+	if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "key", "fingerprint", stale); err != nil || !ok {
+		t.Fatalf("got %v, %v, want true, nil", ok, err)
+	}
+	t.Run("returns the request in progress", func(t *testing.T) {
+		req, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "key", "other", stale)
+		if err != nil || ok {
+			t.Fatalf("got %v, %v, want false, nil", ok, err)
+		}
+		if req.Fingerprint != "fingerprint" || req.Completed {
+			t.Errorf("got request %+v, want fingerprint %q in progress", req, "fingerprint")
+		}
+	})
+	t.Run("scopes the keys to the caller", func(t *testing.T) {
+		if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "other", "key", "fingerprint", stale); err != nil || !ok {
+			t.Fatalf("got %v, %v, want true, nil", ok, err)
+		}
+	})
+	t.Run("returns the completed request", func(t *testing.T) {
+		want := middleware.IdempotentRequest{
+			Fingerprint: "fingerprint",
+			Completed:   true,
+			StatusCode:  201,
+			Header:      map[string][]string{"Content-Type": {"application/json"}},
+			Body:        []byte(`{"uuid":"foo"}`),
+		}
+		if err := repo.CompleteIdempotentRequest(context.Background(), "caller", "key", want); err != nil {
+			t.Fatal(err)
+		}
+		req, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "key", "fingerprint", stale)
+		if err != nil || ok {
+			t.Fatalf("got %v, %v, want false, nil", ok, err)
+		}
+		want.CreatedAt = req.CreatedAt
+		if !reflect.DeepEqual(req, want) {
+			t.Errorf("got request %+v, want %+v", req, want)
+		}
+	})
+	t.Run("takes over the key of the request in progress since before the stale time", func(t *testing.T) {
+		if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "stale", "fingerprint", stale); err != nil || !ok {
+			t.Fatalf("got %v, %v, want true, nil", ok, err)
+		}
+		now := time.Now().Add(time.Second)
+		if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "stale", "other", now); err != nil || ok {
+			t.Fatalf("got %v, %v of the different request, want false, nil", ok, err)
+		}
+		if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "stale", "fingerprint", now); err != nil || !ok {
+			t.Fatalf("got %v, %v, want true, nil", ok, err)
+		}
+		if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "stale", "fingerprint", stale); err != nil || ok {
+			t.Fatalf("got %v, %v of the taken over request, want false, nil", ok, err)
+		}
+	})
+	t.Run("releases the key", func(t *testing.T) {
+		if err := repo.ReleaseIdempotentRequest(context.Background(), "caller", "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := repo.BeginIdempotentRequest(context.Background(), "caller", "key", "fingerprint", stale); err != nil || !ok {
+			t.Fatalf("got %v, %v, want true, nil", ok, err)
+		}
+	})
+}
+
+func TestAPIKey(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+	defer func() {
+		if _, err := db.Exec(sqlDeleteAPIKey); err != nil {
+			t.Fatalf("cannot delete test data: %v", err)
+		}
+	}()
+
+	repo := repository.New(db)
+	key, want, err := auth.NewAPIKey(auth.Principal{Role: auth.RoleMerchant, Subject: uuid.Must(uuid.NewV4())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveAPIKey(context.Background(), want); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	t.Run("returns the API key with the hash", func(t *testing.T) {
+		got, err := repo.GetAPIKey(context.Background(), auth.HashAPIKey(key))
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if got.UUID != want.UUID || got.Hash != want.Hash || got.Principal != want.Principal {
+			t.Errorf("got API key %+v, want %+v", got, want)
+		}
+		if !got.CreatedAt.Equal(want.CreatedAt.Truncate(time.Microsecond)) {
+			t.Errorf("got created at %v, want %v", got.CreatedAt, want.CreatedAt)
+		}
+	})
+	t.Run("returns ErrNotFound if the API key does not exist", func(t *testing.T) {
+		if _, err := repo.GetAPIKey(context.Background(), auth.HashAPIKey("foo")); err != repository.ErrNotFound {
+			t.Errorf("got %v, want %v", err, repository.ErrNotFound)
+		}
+	})
+	t.Run("lists the API keys", func(t *testing.T) {
+		keys, err := repo.ListAPIKeys(context.Background())
+		if err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if len(keys) != 1 || keys[0].UUID != want.UUID {
+			t.Errorf("got API keys %+v, want %v", keys, want.UUID)
+		}
+	})
+	t.Run("deletes the API key", func(t *testing.T) {
+		if err := repo.DeleteAPIKey(context.Background(), want.UUID); err != nil {
+			t.Fatalf("got %v, want nil", err)
+		}
+		if err := repo.DeleteAPIKey(context.Background(), want.UUID); err != repository.ErrNotFound {
+			t.Errorf("got %v, want %v", err, repository.ErrNotFound)
+		}
+	})
+}
+
+func TestListTransactions(t *testing.T) {
+	db := db(t)
+	defer db.Close()
+
+	card, err := model.NewCard(model.GBP)
+	if err != nil {
+		t.Fatalf("cannot create new card: %v", err)
+	}
+	repo := repository.New(db)
+	if err := repo.SaveCard(context.Background(), card); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if _, err := db.Exec(sqlDeleteLedgerPosting); err != nil {
+			t.Fatalf("cannot delete test ledger postings: %v", err)
+		}
+		if _, err := db.Exec(sqlDeleteTransaction); err != nil {
+			t.Fatalf("cannot delete test transaction: %v", err)
+		}
+		if _, err := db.Exec(sqlDeleteCard); err != nil {
+			t.Fatalf("cannot delete test card: %v", err)
+		}
+	}()
+
+	var txs []*model.Transaction
+	for _, eventType := range []string{"CardLoaded", "AuthorizationRequestCreated", "CardLoaded"} {
+		if err := card.LoadMoney(10); err != nil {
+			t.Fatalf("cannot load card: %v", err)
+		}
+		tx, err := model.NewTransaction(card, nil, uuid.Must(uuid.NewV4()), eventType, 10, "", time.Now())
+		if err != nil {
+			t.Fatalf("cannot create new transaction: %v", err)
+		}
+		err = repo.WithinTx(context.Background(), func(r service.Tx) error {
+			if err := r.UpdateCard(context.Background(), card); err != nil {
+				return err
+			}
+			return r.SaveTransaction(context.Background(), tx)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Run("returns the transactions from the newest to the oldest", func(t *testing.T) {
+		res, err := repo.ListTransactions(context.Background(), listtransactions.Filter{CardUUID: card.UUID(), Limit: 10})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(res) != 3 {
+			t.Fatalf("got %d transactions, want 3", len(res))
+		}
+		for i, tx := range res {
+			if tx.UUID() != txs[2-i].UUID() {
+				t.Errorf("got transaction %d %v, want %v", i, tx.UUID(), txs[2-i].UUID())
+			}
+		}
+	})
+	t.Run("returns the transactions after the cursor", func(t *testing.T) {
+		res, err := repo.ListTransactions(context.Background(), listtransactions.Filter{
+			CardUUID: card.UUID(),
+			After:    &listtransactions.Cursor{Date: txs[2].Date().Truncate(time.Microsecond), UUID: txs[2].UUID()},
+			Limit:    1,
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(res) != 1 || res[0].UUID() != txs[1].UUID() {
+			t.Fatalf("got transactions %v, want %v", res, txs[1])
+		}
+	})
+	t.Run("returns the transactions with event type", func(t *testing.T) {
+		res, err := repo.ListTransactions(context.Background(), listtransactions.Filter{
+			CardUUID:   card.UUID(),
+			EventTypes: []string{"AuthorizationRequestCreated"},
+			Limit:      10,
+		})
+		if err != nil {
+			t.Fatalf("got error %v, want nil", err)
+		}
+		if len(res) != 1 || res[0].UUID() != txs[1].UUID() {
+			t.Fatalf("got transactions %v, want %v", res, txs[1])
+		}
+	})
+}
+
 func db(t *testing.T) *sql.DB {
 	t.Helper()
 	db, err := sql.Open("mysql", dsn)